@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"mastercard-backend/internal/config"
 	"mastercard-backend/internal/database"
@@ -26,8 +28,14 @@ func main() {
 	if err := database.Connect(); err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-
-	// Initialize query service (Gemini client)
+	// Write the queued audit entries before exiting
+	defer services.CloseAuditLog()
+	// Archive old audit entries when AUDIT_RETENTION_DAYS is set
+	services.StartAuditRetention()
+	// Load revoked access tokens for the auth middleware
+	services.StartTokenDenylist()
+
+	// Initialize query service (LLM provider selected by LLM_PROVIDER)
 	queryService, err := services.NewQueryService()
 	if err != nil {
 		log.Fatalf("Failed to initialize query service: %v", err)
@@ -54,6 +62,24 @@ func main() {
 		})
 	})
 
+	// LLM provider health check. It is public, so the provider's error
+	// (which may name hosts, models or keys) only goes to the log
+	app.Get("/health/llm", func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := queryService.Health(ctx); err != nil {
+			log.Printf("LLM provider %s is unavailable: %v", queryService.ProviderName(), err)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"status": "unavailable",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"status": "ok",
+		})
+	})
+
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler()
 	queryHandler := handlers.NewQueryHandler(queryService)
@@ -68,7 +94,10 @@ func main() {
 		{
 			auth.Post("/register", authHandler.Register)
 			auth.Post("/login", authHandler.Login)
+			auth.Post("/login/mfa", authHandler.LoginMFA)
+			auth.Post("/login/mfa/enroll", authHandler.LoginMFAEnroll)
 			auth.Post("/refresh", authHandler.RefreshToken)
+			auth.Post("/logout", authHandler.Logout)
 		}
 	}
 
@@ -77,11 +106,29 @@ func main() {
 	{
 		// User profile
 		protected.Get("/auth/profile", authHandler.GetProfile)
+		protected.Post("/auth/logout-all", authHandler.LogoutAll)
+		protected.Get("/auth/sessions", authHandler.GetSessions)
+		protected.Post("/auth/mfa/enroll", authHandler.EnrollMFA)
+		protected.Post("/auth/mfa/verify", authHandler.VerifyMFA)
+		protected.Post("/auth/mfa/disable", authHandler.DisableMFA)
+		protected.Post("/auth/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+		protected.Delete("/auth/sessions/:id", authHandler.TerminateSession)
 
 		// Query routes
 		queries := protected.Group("/query")
 		{
 			queries.Post("", queryHandler.ExecuteQuery)
+			queries.Post("/stream", queryHandler.StreamQuery)
+			queries.Get("/jobs/:id", queryHandler.GetQueryJob)
+			queries.Delete("/jobs/:id", queryHandler.CancelQueryJob)
+		}
+
+		// Message routes
+		messages := protected.Group("/messages")
+		{
+			messages.Get("/:id/results", queryHandler.GetMessageResults)
+			messages.Post("/:id/confirm", queryHandler.ConfirmMessage)
+			messages.Get("/:id/export", middleware.RequirePermission("queries", "export"), queryHandler.ExportMessage)
 		}
 
 		// Conversation routes
@@ -94,6 +141,7 @@ func main() {
 			conversations.Put("/:id", conversationHandler.UpdateConversation)
 			conversations.Delete("/:id", conversationHandler.DeleteConversation)
 			conversations.Post("/:id/branch", conversationHandler.CreateBranch)
+			conversations.Get("/:id/report", conversationHandler.GetConversationReport)
 		}
 
 		// Admin routes (Manager and Admin access)
@@ -106,12 +154,33 @@ func main() {
 			
 			// Audit logs (Manager and Admin can view)
 			admin.Get("/audit-logs", adminHandler.GetAuditLogs)
+			admin.Get("/audit-logs/export", adminHandler.ExportAuditLogs)
+			
+			admin.Get("/audit-logs/verify", middleware.RequireRole("admin"), adminHandler.VerifyAuditLogs)
+			admin.Post("/audit-logs/archives", middleware.RequireRole("admin"), adminHandler.StartAuditArchive)
+			admin.Get("/audit-logs/archives", middleware.RequireRole("admin"), adminHandler.GetAuditArchives)
+			admin.Get("/audit-logs/archives/:id", middleware.RequireRole("admin"), adminHandler.GetAuditArchive)
 			
 			// Metrics (Manager and Admin can view)
 			admin.Get("/metrics", adminHandler.GetMetrics)
 			
 			// User deletion (Admin only)
 			admin.Delete("/users/:id", middleware.RequireRole("admin"), adminHandler.DeleteUser)
+
+			// Sessions of any user (Admin only)
+			admin.Get("/users/:id/sessions", middleware.RequireRole("admin"), adminHandler.GetUserSessions)
+			admin.Delete("/users/:id/sessions", middleware.RequireRole("admin"), adminHandler.TerminateUserSessions)
+			admin.Delete("/users/:id/sessions/:sessionId", middleware.RequireRole("admin"), adminHandler.TerminateUserSession)
+
+			// MFA (Admin only)
+			admin.Delete("/users/:id/mfa", middleware.RequireRole("admin"), adminHandler.ResetUserMFA)
+			admin.Get("/roles", middleware.RequireRole("admin"), adminHandler.GetRoles)
+			admin.Put("/roles/:id", middleware.RequireRole("admin"), adminHandler.UpdateRole)
+
+			// Login lockouts (Admin only)
+			admin.Post("/users/:id/unlock", middleware.RequireRole("admin"), adminHandler.UnlockUser)
+			admin.Get("/lockouts", middleware.RequireRole("admin"), adminHandler.GetLockouts)
+			admin.Delete("/lockouts/:id", middleware.RequireRole("admin"), adminHandler.DeleteLockout)
 		}
 	}
 
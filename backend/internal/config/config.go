@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
 	"time"
@@ -16,6 +18,9 @@ type Config struct {
 	DBPassword string
 	DBName     string
 	DBSSLMode  string
+	// Least-privilege role used to execute generated SQL
+	QueryDBUser     string
+	QueryDBPassword string
 
 	// Application
 	AppName string
@@ -27,6 +32,16 @@ type Config struct {
 	JWTSecret            string
 	JWTAccessTokenExpiry time.Duration
 	JWTRefreshTokenExpiry time.Duration
+	// How often revoked access tokens are read from other instances
+	TokenDenylistSyncInterval time.Duration
+
+	// MFA: TOTP issuer shown by authenticator apps (APP_NAME when unset),
+	// key of the stored secrets (JWT_SECRET when unset), and how long and
+	// how many codes a login challenge accepts
+	MFAIssuer          string
+	MFAEncryptionKey   string
+	MFAChallengeExpiry time.Duration
+	MFAMaxAttempts     int
 
 	// Google Gemini
 	GeminiAPIKey    string
@@ -34,6 +49,24 @@ type Config struct {
 	GeminiTemperature float64
 	GeminiMaxTokens int
 
+	// LLM Provider
+	LLMProvider       string
+	LLMTemperature    float64
+	LLMMaxTokens      int
+	LLMRequestTimeout time.Duration
+
+	// OpenAI-compatible API
+	OpenAIBaseURL string
+	OpenAIAPIKey  string
+	OpenAIModel   string
+
+	// Ollama
+	OllamaBaseURL string
+	OllamaModel   string
+
+	// Fake provider (offline tests and demos)
+	LLMFakeFixtures string
+
 	// CORS
 	CORSAllowedOrigins string
 	CORSAllowedMethods string
@@ -47,6 +80,49 @@ type Config struct {
 	QueryTimeoutSeconds int
 	MaxResultRows       int
 	ExportMaxRows       int
+	// QueryAllowedTables caps which tables generated SQL may read, for every role
+	QueryAllowedTables string
+	// Per-role EXPLAIN thresholds as "role=limit" lists, 0 disables a limit
+	QueryMaxCost string
+	QueryMaxRows string
+	// QueryCostAction is "confirm" or "reject" for queries over the thresholds
+	QueryCostAction string
+	// QueryMaxAttempts bounds how often failing SQL is sent back to the LLM for correction
+	QueryMaxAttempts int
+	// Asynchronous query jobs: worker pool size, queue bound and how long
+	// finished jobs stay available
+	QueryWorkers   int
+	QueryQueueSize int
+	QueryJobTTL    time.Duration
+	// QueryChartLLM lets the LLM refine the recommended chart of a result
+	QueryChartLLM bool
+
+	// Audit Logging
+	// AuditQueueSize bounds the audit entries waiting for the background writer
+	AuditQueueSize int
+	// AuditQueueTimeout is how long a request waits for room in a full queue
+	// before its entry is dropped
+	AuditQueueTimeout time.Duration
+	// Audit sinks receiving a copy of every entry, each disabled when empty:
+	// RFC 5424 syslog ("udp" or "tcp" to host:port), a JSON lines file and
+	// an HTTP webhook with an optional bearer token
+	AuditSyslogNetwork string
+	AuditSyslogAddress string
+	AuditFilePath      string
+	AuditWebhookURL    string
+	AuditWebhookToken  string
+	// Entries buffered per sink, and how often a failed send is retried
+	// with exponential backoff from AuditSinkRetryDelay
+	AuditSinkBufferSize int
+	AuditSinkMaxRetries int
+	AuditSinkRetryDelay time.Duration
+	AuditSinkTimeout    time.Duration
+	// Retention: entries older than AuditRetentionDays (0 keeps them in the
+	// table) are moved to compressed monthly JSONL files in AuditArchiveDir
+	// every AuditArchiveInterval
+	AuditRetentionDays   int
+	AuditArchiveDir      string
+	AuditArchiveInterval time.Duration
 
 	// Logging
 	LogLevel  string
@@ -54,7 +130,17 @@ type Config struct {
 
 	// Security
 	BCryptCost          int
+	// Sessions without requests for SessionTimeoutMinutes end (0 disables)
 	SessionTimeoutMinutes int
+	// How often an instance checks a session and records its last request
+	SessionTouchInterval time.Duration
+	// Failed logins block an account or IP for LoginBackoffBase, doubling
+	// with each failure; LoginMaxAttempts (LoginIPMaxAttempts) failures lock
+	// it for LoginLockoutDuration
+	LoginMaxAttempts     int
+	LoginIPMaxAttempts   int
+	LoginBackoffBase     time.Duration
+	LoginLockoutDuration time.Duration
 }
 
 var AppConfig *Config
@@ -71,6 +157,8 @@ func Load() error {
 		DBPassword: getEnv("DB_PASSWORD", "mastercard_pass"),
 		DBName:     getEnv("DB_NAME", "mastercard_db"),
 		DBSSLMode:  getEnv("DB_SSLMODE", "disable"),
+		QueryDBUser:     getEnv("QUERY_DB_USER", "mastercard_query"),
+		QueryDBPassword: getEnv("QUERY_DB_PASSWORD", "mastercard_query_pass"),
 
 		// Application
 		AppName: getEnv("APP_NAME", "Mastercard NLP-to-SQL Platform"),
@@ -82,6 +170,13 @@ func Load() error {
 		JWTSecret:            getEnv("JWT_SECRET", "your-super-secret-jwt-key-change-this-in-production"),
 		JWTAccessTokenExpiry:  parseDuration(getEnv("JWT_ACCESS_TOKEN_EXPIRY", "15m")),
 		JWTRefreshTokenExpiry: parseDuration(getEnv("JWT_REFRESH_TOKEN_EXPIRY", "168h")),
+		TokenDenylistSyncInterval: parseDuration(getEnv("TOKEN_DENYLIST_SYNC_INTERVAL", "10s")),
+
+		// MFA
+		MFAIssuer:          getEnv("MFA_ISSUER", ""),
+		MFAEncryptionKey:   getEnv("MFA_ENCRYPTION_KEY", ""),
+		MFAChallengeExpiry: parseDuration(getEnv("MFA_CHALLENGE_EXPIRY", "5m")),
+		MFAMaxAttempts:     parseInt(getEnv("MFA_MAX_ATTEMPTS", "5")),
 
 		// Google Gemini
 		GeminiAPIKey:     getEnv("GEMINI_API_KEY", ""),
@@ -89,6 +184,24 @@ func Load() error {
 		GeminiTemperature: parseFloat(getEnv("GEMINI_TEMPERATURE", "0.1")),
 		GeminiMaxTokens:  parseInt(getEnv("GEMINI_MAX_TOKENS", "2048")),
 
+		// LLM Provider (gemini, openai, ollama, fake)
+		LLMProvider:       getEnv("LLM_PROVIDER", "gemini"),
+		LLMTemperature:    parseFloat(getEnv("LLM_TEMPERATURE", "0.1")),
+		LLMMaxTokens:      parseInt(getEnv("LLM_MAX_TOKENS", "2048")),
+		LLMRequestTimeout: parseDuration(getEnv("LLM_REQUEST_TIMEOUT", "60s")),
+
+		// OpenAI-compatible API
+		OpenAIBaseURL: getEnv("OPENAI_BASE_URL", "https://api.openai.com/v1"),
+		OpenAIAPIKey:  getEnv("OPENAI_API_KEY", ""),
+		OpenAIModel:   getEnv("OPENAI_MODEL", "gpt-4o-mini"),
+
+		// Ollama
+		OllamaBaseURL: getEnv("OLLAMA_BASE_URL", "http://localhost:11434"),
+		OllamaModel:   getEnv("OLLAMA_MODEL", "llama3"),
+
+		// Fake provider (empty uses the built-in fixtures)
+		LLMFakeFixtures: getEnv("LLM_FAKE_FIXTURES", ""),
+
 		// CORS
 		CORSAllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:5173,http://localhost:3000"),
 		CORSAllowedMethods: getEnv("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS"),
@@ -102,6 +215,31 @@ func Load() error {
 		QueryTimeoutSeconds: parseInt(getEnv("QUERY_TIMEOUT_SECONDS", "30")),
 		MaxResultRows:       parseInt(getEnv("MAX_RESULT_ROWS", "10000")),
 		ExportMaxRows:       parseInt(getEnv("EXPORT_MAX_ROWS", "100000")),
+		QueryAllowedTables:  getEnv("QUERY_ALLOWED_TABLES", "transactions"),
+		QueryMaxCost:        getEnv("QUERY_MAX_COST", "analyzer=500000,manager=2000000,admin=0"),
+		QueryMaxRows:        getEnv("QUERY_MAX_ROWS", "analyzer=10000000,manager=50000000,admin=0"),
+		QueryCostAction:     getEnv("QUERY_COST_ACTION", "confirm"),
+		QueryMaxAttempts:    parseInt(getEnv("QUERY_MAX_ATTEMPTS", "3")),
+		QueryWorkers:        parseInt(getEnv("QUERY_WORKERS", "4")),
+		QueryQueueSize:      parseInt(getEnv("QUERY_QUEUE_SIZE", "100")),
+		QueryJobTTL:         parseDuration(getEnv("QUERY_JOB_TTL", "1h")),
+		QueryChartLLM:       getEnv("QUERY_CHART_LLM", "false") == "true",
+
+		// Audit Logging
+		AuditQueueSize:       parseInt(getEnv("AUDIT_QUEUE_SIZE", "1000")),
+		AuditQueueTimeout:    parseDuration(getEnv("AUDIT_QUEUE_TIMEOUT", "1s")),
+		AuditSyslogNetwork:   getEnv("AUDIT_SYSLOG_NETWORK", "udp"),
+		AuditSyslogAddress:   getEnv("AUDIT_SYSLOG_ADDRESS", ""),
+		AuditFilePath:        getEnv("AUDIT_FILE_PATH", ""),
+		AuditWebhookURL:      getEnv("AUDIT_WEBHOOK_URL", ""),
+		AuditWebhookToken:    getEnv("AUDIT_WEBHOOK_TOKEN", ""),
+		AuditSinkBufferSize:  parseInt(getEnv("AUDIT_SINK_BUFFER_SIZE", "1000")),
+		AuditSinkMaxRetries:  parseInt(getEnv("AUDIT_SINK_MAX_RETRIES", "5")),
+		AuditSinkRetryDelay:  parseDuration(getEnv("AUDIT_SINK_RETRY_DELAY", "1s")),
+		AuditSinkTimeout:     parseDuration(getEnv("AUDIT_SINK_TIMEOUT", "5s")),
+		AuditRetentionDays:   parseInt(getEnv("AUDIT_RETENTION_DAYS", "0")),
+		AuditArchiveDir:      getEnv("AUDIT_ARCHIVE_DIR", "archive/audit"),
+		AuditArchiveInterval: parseDuration(getEnv("AUDIT_ARCHIVE_INTERVAL", "24h")),
 
 		// Logging
 		LogLevel:  getEnv("LOG_LEVEL", "debug"),
@@ -110,6 +248,22 @@ func Load() error {
 		// Security
 		BCryptCost:          parseInt(getEnv("BCRYPT_COST", "12")),
 		SessionTimeoutMinutes: parseInt(getEnv("SESSION_TIMEOUT_MINUTES", "60")),
+		SessionTouchInterval:  parseDuration(getEnv("SESSION_TOUCH_INTERVAL", "10s")),
+		LoginMaxAttempts:      parseInt(getEnv("LOGIN_MAX_ATTEMPTS", "5")),
+		LoginIPMaxAttempts:    parseInt(getEnv("LOGIN_IP_MAX_ATTEMPTS", "20")),
+		LoginBackoffBase:      parseDuration(getEnv("LOGIN_BACKOFF_BASE", "1s")),
+		LoginLockoutDuration:  parseDuration(getEnv("LOGIN_LOCKOUT_DURATION", "15m")),
+	}
+
+	// TOTP secrets fall back to being encrypted with JWT_SECRET, so a leaked
+	// signing key would also expose them
+	if AppConfig.MFAEncryptionKey == "" {
+		if AppConfig.AppEnv == "production" {
+			return errors.New("MFA_ENCRYPTION_KEY is required in production")
+		}
+		fmt.Printf("Warning: MFA_ENCRYPTION_KEY is not set, TOTP secrets are encrypted with JWT_SECRET\n")
+	} else if AppConfig.MFAEncryptionKey == AppConfig.JWTSecret {
+		fmt.Printf("Warning: MFA_ENCRYPTION_KEY is the same as JWT_SECRET\n")
 	}
 
 	return nil
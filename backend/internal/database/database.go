@@ -3,6 +3,7 @@ package database
 import (
 	"fmt"
 	"log"
+	"strconv"
 
 	"mastercard-backend/internal/config"
 	"mastercard-backend/internal/models"
@@ -14,6 +15,10 @@ import (
 
 var DB *gorm.DB
 
+// QueryDB is a separate pool logged in as the least-privilege role that
+// executes LLM-generated SQL
+var QueryDB *gorm.DB
+
 // Connect initializes the database connection
 func Connect() error {
 	// --- START DEBUGGING ---
@@ -28,15 +33,7 @@ func Connect() error {
 	log.Println("---------------------------------")
 	// --- END DEBUGGING ---
 
-	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
-		config.AppConfig.DBHost,
-		config.AppConfig.DBUser,
-		config.AppConfig.DBPassword,
-		config.AppConfig.DBName,
-		config.AppConfig.DBPort,
-		config.AppConfig.DBSSLMode,
-	)
+	dsn := buildDSN(config.AppConfig.DBUser, config.AppConfig.DBPassword)
 
 	var err error
 	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
@@ -50,6 +47,10 @@ func Connect() error {
 
 	log.Println("Database connected successfully")
 
+	if err := connectQueryDB(); err != nil {
+		return err
+	}
+
 	// Auto-migration is disabled. Schema changes should be handled by SQL migration files
 	// in the /migrations directory. This prevents conflicts with manually defined policies and types.
 	// if err := autoMigrate(); err != nil {
@@ -59,6 +60,36 @@ func Connect() error {
 	return nil
 }
 
+// connectQueryDB opens the pool used for generated SQL. The role only has
+// SELECT on the data tables (see migration 010), so even SQL that slips past
+// the validator cannot write or read sensitive tables.
+func connectQueryDB() error {
+	dsn := buildDSN(config.AppConfig.QueryDBUser, config.AppConfig.QueryDBPassword)
+
+	var err error
+	QueryDB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Warn),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect query role %q: %w", config.AppConfig.QueryDBUser, err)
+	}
+
+	log.Printf("Query database pool connected as %s", config.AppConfig.QueryDBUser)
+	return nil
+}
+
+func buildDSN(user, password string) string {
+	return fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
+		config.AppConfig.DBHost,
+		user,
+		password,
+		config.AppConfig.DBName,
+		config.AppConfig.DBPort,
+		config.AppConfig.DBSSLMode,
+	)
+}
+
 // autoMigrate runs GORM auto-migration
 func autoMigrate() error {
 	return DB.AutoMigrate(
@@ -69,18 +100,27 @@ func autoMigrate() error {
 		&models.Conversation{},
 		&models.Message{},
 		&models.AuditLog{},
+		&models.AuditArchiveRun{},
+		&models.RefreshToken{},
+		&models.Session{},
+		&models.MFARecoveryCode{},
+		&models.MFAChallenge{},
+		&models.LoginThrottle{},
+		&models.RevokedToken{},
 	)
 }
 
-// SetCurrentUserID sets the current user ID for RLS policies
-// This should be called before executing queries that need RLS
-func SetCurrentUserID(userID uint) error {
-	return DB.Exec("SET app.current_user_id = ?", userID).Error
-}
-
-// ClearCurrentUserID clears the current user ID setting
-func ClearCurrentUserID() error {
-	return DB.Exec("RESET app.current_user_id").Error
+// WithUser runs fn inside a transaction bound to userID for the RLS
+// policies. app.current_user_id is set with SET LOCAL semantics, so it only
+// applies to this transaction's connection and is cleared on commit or
+// rollback; every query of the unit of work must use tx, not DB.
+func WithUser(userID uint, fn func(tx *gorm.DB) error) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT set_config('app.current_user_id', ?, true)", strconv.FormatUint(uint64(userID), 10)).Error; err != nil {
+			return fmt.Errorf("failed to set current user: %w", err)
+		}
+		return fn(tx)
+	})
 }
 
 // GetDB returns the database instance
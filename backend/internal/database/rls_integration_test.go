@@ -0,0 +1,256 @@
+//go:build integration
+
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// These tests run against the migrated database of the DB_* settings, as
+// the backend connects to it:
+//
+//	go test -tags integration ./internal/database/
+//
+// The connecting role must not be a superuser or have BYPASSRLS, which
+// would skip the policies under test.
+
+// connectTestDB opens DB with a pool of two connections, so concurrent
+// transactions of different users reuse the same connections
+func connectTestDB(t *testing.T) {
+	t.Helper()
+	if err := config.Load(); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	db, err := gorm.Open(postgres.Open(buildDSN(config.AppConfig.DBUser, config.AppConfig.DBPassword)), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(2)
+	sqlDB.SetMaxIdleConns(2)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	var bypass bool
+	if err := db.Raw("SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user").Scan(&bypass).Error; err != nil {
+		t.Fatalf("failed to check role: %v", err)
+	}
+	if bypass {
+		t.Skipf("role %s bypasses RLS", config.AppConfig.DBUser)
+	}
+
+	DB = db
+}
+
+// createRLSUser creates a user without a role, so without
+// conversations.read_all, and a conversation with messages of their own
+func createRLSUser(t *testing.T, name string, messages int) (models.User, models.Conversation) {
+	t.Helper()
+	user := models.User{
+		Email:        fmt.Sprintf("rls-%s-%d@example.test", name, time.Now().UnixNano()),
+		PasswordHash: "x",
+		FullName:     "RLS " + name,
+		IsActive:     true,
+	}
+	if err := DB.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	// Conversations and messages go with the user
+	t.Cleanup(func() { DB.Delete(&models.User{}, user.ID) })
+
+	title := "rls " + name
+	conversation := models.Conversation{UserID: user.ID, Title: &title}
+	err := WithUser(user.ID, func(tx *gorm.DB) error {
+		if err := tx.Omit("User").Create(&conversation).Error; err != nil {
+			return err
+		}
+		for i := 0; i < messages; i++ {
+			message := models.Message{ConversationID: conversation.ID, UserMessage: fmt.Sprintf("%s question %d", name, i)}
+			if err := tx.Omit("Conversation").Create(&message).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create conversation of %s: %v", name, err)
+	}
+	return user, conversation
+}
+
+// checkVisible asserts that tx sees exactly the conversation of a user and
+// its messages among those of the test
+func checkVisible(tx *gorm.DB, userID uint, own models.Conversation, messages int, conversationIDs []uint) error {
+	var current string
+	if err := tx.Raw("SELECT current_setting('app.current_user_id', true)").Scan(&current).Error; err != nil {
+		return err
+	}
+	if current != strconv.FormatUint(uint64(userID), 10) {
+		return fmt.Errorf("app.current_user_id is %q, want %d", current, userID)
+	}
+
+	var conversations []models.Conversation
+	if err := tx.Where("id IN ?", conversationIDs).Find(&conversations).Error; err != nil {
+		return err
+	}
+	if len(conversations) != 1 || conversations[0].ID != own.ID {
+		return fmt.Errorf("user %d sees %d conversations, want only %d", userID, len(conversations), own.ID)
+	}
+
+	var visible []models.Message
+	if err := tx.Where("conversation_id IN ?", conversationIDs).Find(&visible).Error; err != nil {
+		return err
+	}
+	if len(visible) != messages {
+		return fmt.Errorf("user %d sees %d messages, want %d", userID, len(visible), messages)
+	}
+	for _, message := range visible {
+		if message.ConversationID != own.ID {
+			return fmt.Errorf("user %d sees message %d of conversation %d", userID, message.ID, message.ConversationID)
+		}
+	}
+	return nil
+}
+
+// checkNoneVisible asserts that a query outside WithUser sees none of the
+// test conversations, i.e. no user id was left on a pooled connection
+func checkNoneVisible(t *testing.T, conversationIDs []uint) {
+	t.Helper()
+	var count int64
+	if err := DB.Model(&models.Conversation{}).Where("id IN ?", conversationIDs).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count conversations: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("%d conversations visible without a current user", count)
+	}
+}
+
+func TestWithUserIsolatesInterleavedTransactions(t *testing.T) {
+	connectTestDB(t)
+	alice, aliceConversation := createRLSUser(t, "alice", 2)
+	bob, bobConversation := createRLSUser(t, "bob", 3)
+	ids := []uint{aliceConversation.ID, bobConversation.ID}
+
+	// Alice's transaction stays open while Bob's runs in full on the other
+	// connection, then checks its view again
+	aliceStarted := make(chan struct{})
+	var startOnce sync.Once
+	start := func() { startOnce.Do(func() { close(aliceStarted) }) }
+	bobDone := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// Bob must not wait forever when Alice fails early
+		defer start()
+		err := WithUser(alice.ID, func(tx *gorm.DB) error {
+			if err := checkVisible(tx, alice.ID, aliceConversation, 2, ids); err != nil {
+				return err
+			}
+			start()
+			<-bobDone
+			return checkVisible(tx, alice.ID, aliceConversation, 2, ids)
+		})
+		if err != nil {
+			t.Errorf("alice: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer close(bobDone)
+		<-aliceStarted
+		err := WithUser(bob.ID, func(tx *gorm.DB) error {
+			return checkVisible(tx, bob.ID, bobConversation, 3, ids)
+		})
+		if err != nil {
+			t.Errorf("bob: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	checkNoneVisible(t, ids)
+}
+
+func TestWithUserIsolatesConcurrentUsersOnPooledConnections(t *testing.T) {
+	connectTestDB(t)
+	alice, aliceConversation := createRLSUser(t, "alice", 2)
+	bob, bobConversation := createRLSUser(t, "bob", 3)
+	ids := []uint{aliceConversation.ID, bobConversation.ID}
+
+	users := []struct {
+		user         models.User
+		conversation models.Conversation
+		messages     int
+	}{
+		{alice, aliceConversation, 2},
+		{bob, bobConversation, 3},
+	}
+
+	// More goroutines than connections: every connection serves both users
+	const workers, iterations = 4, 25
+	var wg sync.WaitGroup
+	for _, u := range users {
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(user models.User, conversation models.Conversation, messages int) {
+				defer wg.Done()
+				for j := 0; j < iterations; j++ {
+					err := WithUser(user.ID, func(tx *gorm.DB) error {
+						return checkVisible(tx, user.ID, conversation, messages, ids)
+					})
+					if err != nil {
+						t.Errorf("user %d: %v", user.ID, err)
+						return
+					}
+				}
+			}(u.user, u.conversation, u.messages)
+		}
+	}
+	wg.Wait()
+
+	checkNoneVisible(t, ids)
+}
+
+func TestWithUserRejectsWritesToOtherUsersConversations(t *testing.T) {
+	connectTestDB(t)
+	alice, _ := createRLSUser(t, "alice", 0)
+	_, bobConversation := createRLSUser(t, "bob", 1)
+
+	err := WithUser(alice.ID, func(tx *gorm.DB) error {
+		message := models.Message{ConversationID: bobConversation.ID, UserMessage: "injected"}
+		return tx.Omit("Conversation").Create(&message).Error
+	})
+	if err == nil {
+		t.Error("alice added a message to bob's conversation")
+	}
+
+	err = WithUser(alice.ID, func(tx *gorm.DB) error {
+		result := tx.Model(&models.Conversation{}).Where("id = ?", bobConversation.ID).Update("title", "taken")
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 0 {
+			return fmt.Errorf("updated %d of bob's conversations", result.RowsAffected)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("alice: %v", err)
+	}
+}
@@ -1,7 +1,13 @@
 package handlers
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"mastercard-backend/internal/database"
 	"mastercard-backend/internal/models"
@@ -10,6 +16,7 @@ import (
 	"mastercard-backend/internal/utils"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type AdminHandler struct {
@@ -85,6 +92,7 @@ func (h *AdminHandler) CreateUser(c *fiber.Ctx) error {
 
 	// Check if user can manage users (manager or admin)
 	if !middleware.CanManageUsers(user) && !middleware.IsAdmin(user) {
+		h.audit(c, user, "create_user", "users", services.AuditDenied, "create user", "insufficient permissions")
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"error": "Insufficient permissions to create users",
 		})
@@ -107,6 +115,7 @@ func (h *AdminHandler) CreateUser(c *fiber.Ctx) error {
 	// Check if email already exists
 	var existingUser models.User
 	if err := database.DB.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+		h.audit(c, user, "create_user", "users", services.AuditError, "create user "+req.Email, "email already exists")
 		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
 			"error": "User with this email already exists",
 		})
@@ -130,12 +139,14 @@ func (h *AdminHandler) CreateUser(c *fiber.Ctx) error {
 	}
 
 	if err := database.DB.Create(&newUser).Error; err != nil {
+		h.audit(c, user, "create_user", "users", services.AuditError, "create user "+req.Email, err.Error())
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create user",
 		})
 	}
 
 	database.DB.Preload("Role").First(&newUser, newUser.ID)
+	h.audit(c, user, "create_user", "users", services.AuditSuccess, fmt.Sprintf("create user %d %s, role_id=%s", newUser.ID, newUser.Email, formatRoleID(newUser.RoleID)), "")
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"user": newUser,
@@ -148,6 +159,10 @@ type UpdateUserRequest struct {
 	FullName *string `json:"full_name,omitempty"`
 	RoleID   *uint   `json:"role_id,omitempty"`
 	IsActive *bool   `json:"is_active,omitempty"`
+	// Password resets the user's password (admin only)
+	Password *string `json:"password,omitempty"`
+	// LegalHold exempts the user's audit entries from archival (admin only)
+	LegalHold *bool `json:"legal_hold,omitempty"`
 }
 
 // UpdateUser updates a user (manager and admin only)
@@ -161,6 +176,7 @@ func (h *AdminHandler) UpdateUser(c *fiber.Ctx) error {
 
 	// Check if user can manage users (manager or admin)
 	if !middleware.CanManageUsers(user) && !middleware.IsAdmin(user) {
+		h.audit(c, user, "update_user", "users", services.AuditDenied, "update user "+c.Params("id"), "insufficient permissions")
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"error": "Insufficient permissions to update users",
 		})
@@ -187,41 +203,112 @@ func (h *AdminHandler) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	// Update fields if provided
+	if req.LegalHold != nil && !middleware.IsAdmin(user) {
+		h.audit(c, user, "update_user", "users", services.AuditDenied, "update user "+c.Params("id")+": legal_hold", "insufficient permissions")
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only admins can change legal holds",
+		})
+	}
+
+	// A manager resetting an admin's password could log in as them
+	if req.Password != nil && !middleware.IsAdmin(user) {
+		h.audit(c, user, "update_user", "users", services.AuditDenied, "update user "+c.Params("id")+": password", "insufficient permissions")
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only admins can reset passwords",
+		})
+	}
+
+	// Update fields if provided, noting the changes for the audit log and
+	// whether the user's tokens must be revoked
+	var changes []string
+	var revokeAccess, revokeSessions string
 	if req.Email != nil {
 		// Check if email already exists for another user
 		var existingUser models.User
 		if err := database.DB.Where("email = ? AND id != ?", *req.Email, userID).First(&existingUser).Error; err == nil {
+			h.audit(c, user, "update_user", "users", services.AuditError, fmt.Sprintf("update user %d: email %s -> %s", targetUser.ID, targetUser.Email, *req.Email), "email already in use")
 			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
 				"error": "Email already in use",
 			})
 		}
+		changes = append(changes, fmt.Sprintf("email %s -> %s", targetUser.Email, *req.Email))
 		targetUser.Email = *req.Email
 	}
 	if req.FullName != nil {
+		changes = append(changes, "full_name")
 		targetUser.FullName = *req.FullName
 	}
 	if req.RoleID != nil {
 		// Verify role exists
 		var role models.Role
 		if err := database.DB.First(&role, *req.RoleID).Error; err != nil {
+			h.audit(c, user, "update_user", "users", services.AuditError, fmt.Sprintf("update user %d: role_id %s -> %d", targetUser.ID, formatRoleID(targetUser.RoleID), *req.RoleID), "invalid role ID")
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error": "Invalid role ID",
 			})
 		}
+		if targetUser.RoleID == nil || *targetUser.RoleID != *req.RoleID {
+			// Access tokens carry the role
+			revokeAccess = services.RevokeRoleChange
+		}
+		changes = append(changes, fmt.Sprintf("role_id %s -> %d", formatRoleID(targetUser.RoleID), *req.RoleID))
 		targetUser.RoleID = req.RoleID
 	}
 	if req.IsActive != nil {
+		if targetUser.IsActive && !*req.IsActive {
+			revokeSessions = services.RevokeDeactivated
+		}
+		changes = append(changes, fmt.Sprintf("is_active %t -> %t", targetUser.IsActive, *req.IsActive))
 		targetUser.IsActive = *req.IsActive
 	}
+	if req.Password != nil {
+		if len(*req.Password) < 8 {
+			h.audit(c, user, "update_user", "users", services.AuditError, fmt.Sprintf("update user %d: password", targetUser.ID), "password too short")
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Password must be at least 8 characters",
+			})
+		}
+		hashedPassword, err := utils.HashPassword(*req.Password)
+		if err != nil {
+			h.audit(c, user, "update_user", "users", services.AuditError, fmt.Sprintf("update user %d: password", targetUser.ID), err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to hash password",
+			})
+		}
+		changes = append(changes, "password")
+		targetUser.PasswordHash = hashedPassword
+		revokeSessions = services.RevokePasswordChange
+	}
+	if req.LegalHold != nil {
+		changes = append(changes, fmt.Sprintf("legal_hold %t -> %t", targetUser.LegalHold, *req.LegalHold))
+		targetUser.LegalHold = *req.LegalHold
+	}
+	detail := fmt.Sprintf("update user %d: %s", targetUser.ID, strings.Join(changes, ", "))
 
 	if err := database.DB.Save(&targetUser).Error; err != nil {
+		h.audit(c, user, "update_user", "users", services.AuditError, detail, err.Error())
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update user",
 		})
 	}
 
+	// Existing tokens must not outlive the change
+	var revokeErr error
+	switch {
+	case revokeSessions != "":
+		revokeErr = services.RevokeUserSessions(targetUser.ID, revokeSessions)
+	case revokeAccess != "":
+		revokeErr = services.RevokeUserTokens(targetUser.ID, revokeAccess)
+	}
+	if revokeErr != nil {
+		h.audit(c, user, "update_user", "users", services.AuditError, detail, "user updated, revoking tokens failed: "+revokeErr.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "User updated, but revoking their tokens failed",
+		})
+	}
+
 	database.DB.Preload("Role").First(&targetUser, targetUser.ID)
+	h.audit(c, user, "update_user", "users", services.AuditSuccess, detail, "")
 
 	return c.JSON(fiber.Map{
 		"user": targetUser,
@@ -239,6 +326,7 @@ func (h *AdminHandler) DeleteUser(c *fiber.Ctx) error {
 
 	// Only admin can delete users
 	if !middleware.CanDeleteUsers(user) {
+		h.audit(c, user, "delete_user", "users", services.AuditDenied, "delete user "+c.Params("id"), "insufficient permissions")
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"error": "Insufficient permissions to delete users",
 		})
@@ -265,17 +353,343 @@ func (h *AdminHandler) DeleteUser(c *fiber.Ctx) error {
 		})
 	}
 
+	detail := fmt.Sprintf("delete user %d %s", targetUser.ID, targetUser.Email)
+	if targetUser.LegalHold {
+		h.audit(c, user, "delete_user", "users", services.AuditDenied, detail, "user is under legal hold")
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "User is under legal hold; lift the hold before deleting",
+		})
+	}
 	if err := database.DB.Delete(&targetUser).Error; err != nil {
+		h.audit(c, user, "delete_user", "users", services.AuditError, detail, err.Error())
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to delete user",
 		})
 	}
+	h.audit(c, user, "delete_user", "users", services.AuditSuccess, detail, "")
 
 	return c.JSON(fiber.Map{
 		"message": "User deleted successfully",
 	})
 }
 
+// GetUserSessions lists the active sessions of a user (admin only)
+func (h *AdminHandler) GetUserSessions(c *fiber.Ctx) error {
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var targetUser models.User
+	if err := database.DB.First(&targetUser, userID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	sessions, err := services.GetSessions(targetUser.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve sessions",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"sessions": sessions,
+	})
+}
+
+// TerminateUserSession ends a session of a user (admin only)
+func (h *AdminHandler) TerminateUserSession(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	sessionID := c.Params("sessionId")
+	detail := fmt.Sprintf("terminate session %s of user %d", sessionID, userID)
+	if err := services.TerminateSession(uint(userID), sessionID, services.RevokeTerminated); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+		h.audit(c, user, "terminate_session", "sessions", services.AuditError, detail, err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to terminate session",
+		})
+	}
+	h.audit(c, user, "terminate_session", "sessions", services.AuditSuccess, detail, "")
+
+	return c.JSON(fiber.Map{
+		"message": "Session terminated",
+	})
+}
+
+// TerminateUserSessions ends every session of a user (admin only)
+func (h *AdminHandler) TerminateUserSessions(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var targetUser models.User
+	if err := database.DB.First(&targetUser, userID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	detail := fmt.Sprintf("terminate all sessions of user %d %s", targetUser.ID, targetUser.Email)
+	if err := services.RevokeUserSessions(targetUser.ID, services.RevokeTerminated); err != nil {
+		h.audit(c, user, "terminate_sessions", "sessions", services.AuditError, detail, err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to terminate sessions",
+		})
+	}
+	h.audit(c, user, "terminate_sessions", "sessions", services.AuditSuccess, detail, "")
+
+	return c.JSON(fiber.Map{
+		"message": "All sessions terminated",
+	})
+}
+
+// ResetUserMFA turns MFA off for a user who lost their authenticator
+// (admin only)
+func (h *AdminHandler) ResetUserMFA(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var targetUser models.User
+	if err := database.DB.First(&targetUser, userID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	detail := fmt.Sprintf("reset MFA of user %d %s", targetUser.ID, targetUser.Email)
+	if err := services.ResetMFA(targetUser.ID); err != nil {
+		h.audit(c, user, "reset_mfa", "users", services.AuditError, detail, err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reset MFA",
+		})
+	}
+	h.audit(c, user, "reset_mfa", "users", services.AuditSuccess, detail, "")
+
+	return c.JSON(fiber.Map{
+		"message": "MFA reset",
+	})
+}
+
+// GetRoles lists the roles (admin only)
+func (h *AdminHandler) GetRoles(c *fiber.Ctx) error {
+	var roles []models.Role
+	if err := database.DB.Order("id").Find(&roles).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve roles",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"roles": roles,
+	})
+}
+
+// UpdateRoleRequest represents a request to update a role
+type UpdateRoleRequest struct {
+	// MFARequired makes the users of the role pass MFA at login
+	MFARequired *bool `json:"mfa_required,omitempty"`
+}
+
+// UpdateRole updates a role (admin only). Requiring MFA logs out the users
+// of the role without it, to enrol at their next login.
+func (h *AdminHandler) UpdateRole(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	roleID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid role ID",
+		})
+	}
+
+	var req UpdateRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	var role models.Role
+	if err := database.DB.First(&role, roleID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Role not found",
+		})
+	}
+
+	if req.MFARequired != nil {
+		detail := fmt.Sprintf("update role %d %s: mfa_required %t -> %t", role.ID, role.Name, role.MFARequired, *req.MFARequired)
+		if err := services.SetRoleMFARequired(&role, *req.MFARequired); err != nil {
+			h.audit(c, user, "update_role", "roles", services.AuditError, detail, err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update role",
+			})
+		}
+		h.audit(c, user, "update_role", "roles", services.AuditSuccess, detail, "")
+	}
+
+	return c.JSON(fiber.Map{
+		"role": role,
+	})
+}
+
+// UnlockUser lifts the login lockout of a user's account (admin only)
+func (h *AdminHandler) UnlockUser(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var targetUser models.User
+	if err := database.DB.First(&targetUser, userID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	detail := fmt.Sprintf("unlock user %d %s", targetUser.ID, targetUser.Email)
+	if err := services.UnlockAccount(targetUser.Email); err != nil {
+		h.audit(c, user, "unlock_login", "users", services.AuditError, detail, err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unlock user",
+		})
+	}
+	h.audit(c, user, "unlock_login", "users", services.AuditSuccess, detail, "")
+
+	return c.JSON(fiber.Map{
+		"message": "User unlocked",
+	})
+}
+
+// GetLockouts lists the accounts and IP addresses locked out of login
+// (admin only)
+func (h *AdminHandler) GetLockouts(c *fiber.Ctx) error {
+	lockouts, err := services.GetLockouts()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve lockouts",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"lockouts": lockouts,
+	})
+}
+
+// DeleteLockout lifts a login lockout of an account or IP address
+// (admin only)
+func (h *AdminHandler) DeleteLockout(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	lockoutID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid lockout ID",
+		})
+	}
+
+	lockout, err := services.UnlockThrottle(uint(lockoutID))
+	if err != nil {
+		if errors.Is(err, services.ErrLockoutNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		h.audit(c, user, "unlock_login", "lockouts", services.AuditError, "unlock lockout "+c.Params("id"), err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unlock",
+		})
+	}
+	h.audit(c, user, "unlock_login", "lockouts", services.AuditSuccess, fmt.Sprintf("unlock %s %s", lockout.Scope, lockout.Subject), "")
+
+	return c.JSON(fiber.Map{
+		"message": "Unlocked",
+	})
+}
+
+// audit records a user management action of actor. The detail names the
+// target user and the changes; errorMessage is empty on success.
+func (h *AdminHandler) audit(c *fiber.Ctx, actor *models.User, action, resource, status, detail, errorMessage string) {
+	// Written by the background audit writer after the request buffers are reused
+	ipAddress, userAgent := clientStrings(c)
+	var errPtr *string
+	if errorMessage != "" {
+		errPtr = &errorMessage
+	}
+
+	if err := h.auditService.LogAction(&actor.ID, action, resource, &detail, nil, nil, &ipAddress, &userAgent, status, errPtr, nil); err != nil {
+		fmt.Printf("Warning: Failed to audit %s: %v\n", action, err)
+	}
+}
+
+// formatRoleID formats an optional role ID for audit details
+func formatRoleID(roleID *uint) string {
+	if roleID == nil {
+		return "none"
+	}
+	return strconv.FormatUint(uint64(*roleID), 10)
+}
+
 // GetAuditLogs retrieves audit logs (manager and admin only)
 func (h *AdminHandler) GetAuditLogs(c *fiber.Ctx) error {
 	user, ok := c.Locals("user").(*models.User)
@@ -291,39 +705,278 @@ func (h *AdminHandler) GetAuditLogs(c *fiber.Ctx) error {
 			"error": "Insufficient permissions to view audit logs",
 		})
 	}
-	limit, _ := strconv.Atoi(c.Query("limit", "50"))
-	offset, _ := strconv.Atoi(c.Query("offset", "0"))
-	action := c.Query("action")
-	resource := c.Query("resource")
-	status := c.Query("status")
 
+	filter, err := auditLogFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if limit < 1 {
+		limit = 50
+	}
 	if limit > 100 {
 		limit = 100
 	}
+	before, err := strconv.ParseUint(c.Query("before", "0"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid before cursor",
+		})
+	}
+
+	logs, next, err := h.auditService.GetAuditLogs(filter, limit, uint(before))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	response := fiber.Map{
+		"logs":  logs,
+		"limit": limit,
+	}
+	if next > 0 {
+		response["next_cursor"] = next
+	}
+	return c.JSON(response)
+}
+
+// ExportAuditLogs streams the audit entries matching the filters of
+// GetAuditLogs as CSV (manager and admin only). The export is audit logged.
+func (h *AdminHandler) ExportAuditLogs(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	if !middleware.HasPermission(user, "audit_logs", "read") && !middleware.IsAdmin(user) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Insufficient permissions to view audit logs",
+		})
+	}
+
+	filter, err := auditLogFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The request context is recycled once the handler returns
+	userID := user.ID
+	detail := string(c.Request().URI().QueryString())
+	ip, userAgent := clientStrings(c)
+
+	c.Set("Content-Type", "text/csv; charset=utf-8")
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-logs-%s.csv"`, time.Now().UTC().Format("20060102-150405")))
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		start := time.Now()
+		rows, err := h.auditService.ExportAuditLogs(context.Background(), filter, w)
+		if err == nil {
+			err = w.Flush()
+		}
+		elapsed := int(time.Since(start).Milliseconds())
+
+		status := services.AuditSuccess
+		var errMsg *string
+		if err != nil {
+			// Headers are already sent; the client sees a truncated file
+			status = services.AuditError
+			text := err.Error()
+			errMsg = &text
+			fmt.Printf("Warning: Audit log export failed: %v\n", err)
+		}
+		if logErr := h.auditService.LogAction(&userID, "export_audit_logs", "audit_logs", &detail, nil,
+			&rows, &ip, &userAgent, status, errMsg, &elapsed); logErr != nil {
+			fmt.Printf("Warning: Failed to log audit log export: %v\n", logErr)
+		}
+	})
+
+	return nil
+}
+
+// auditLogFilter reads the audit log filters of a request: user_id, action,
+// resource, status, from/to (RFC 3339 or YYYY-MM-DD, a date-only to
+// includes that day), ip (address or CIDR range), q (text in the question
+// or SQL) and min_execution_ms. The values are copied, so the filter can be
+// used by an export streamed after the handler returns.
+func auditLogFilter(c *fiber.Ctx) (*services.AuditLogFilter, error) {
+	filter := &services.AuditLogFilter{
+		Action:    copiedQuery(c, "action"),
+		Resource:  copiedQuery(c, "resource"),
+		Status:    copiedQuery(c, "status"),
+		IPAddress: copiedQuery(c, "ip"),
+		Search:    strings.TrimSpace(copiedQuery(c, "q")),
+	}
+
+	if value := c.Query("user_id"); value != "" {
+		userID, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user_id %q", value)
+		}
+		id := uint(userID)
+		filter.UserID = &id
+	}
 
-	var actionPtr, resourcePtr, statusPtr *string
-	if action != "" {
-		actionPtr = &action
+	if value := c.Query("from"); value != "" {
+		from, _, err := parseAuditTime(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %w", err)
+		}
+		filter.From = &from
+	}
+	if value := c.Query("to"); value != "" {
+		to, dateOnly, err := parseAuditTime(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to: %w", err)
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = &to
 	}
-	if resource != "" {
-		resourcePtr = &resource
+
+	if filter.IPAddress != "" {
+		if err := services.ValidateIPFilter(filter.IPAddress); err != nil {
+			return nil, err
+		}
 	}
-	if status != "" {
-		statusPtr = &status
+
+	if value := c.Query("min_execution_ms"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("invalid min_execution_ms %q", value)
+		}
+		filter.MinExecutionMs = &ms
 	}
 
-	logs, total, err := h.auditService.GetAuditLogs(nil, limit, offset, actionPtr, resourcePtr, statusPtr)
+	return filter, nil
+}
+
+// parseAuditTime parses an RFC 3339 timestamp or a UTC date
+func parseAuditTime(value string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	if t, err = time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("%q is not an RFC 3339 timestamp or YYYY-MM-DD date", value)
+}
+
+// VerifyAuditLogs walks the audit hash chain and reports the first broken
+// link (admin only)
+func (h *AdminHandler) VerifyAuditLogs(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	report, err := h.auditService.VerifyChain()
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
+	status, errorMessage := services.AuditSuccess, ""
+	if !report.Valid {
+		status = services.AuditError
+		errorMessage = fmt.Sprintf("chain broken at entry %d: %s", report.Broken.ID, report.Broken.Reason)
+	}
+	h.audit(c, user, "verify_audit_logs", "audit_logs", status, fmt.Sprintf("checked %d entries, head %d", report.Checked, report.HeadID), errorMessage)
+
 	return c.JSON(fiber.Map{
-		"logs":  logs,
-		"total": total,
-		"limit": limit,
-		"offset": offset,
+		"report": report,
+	})
+}
+
+// StartAuditArchive starts an audit log archival run (admin only)
+func (h *AdminHandler) StartAuditArchive(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	run, err := h.auditService.StartArchive(user.ID)
+	if err != nil {
+		h.audit(c, user, "archive_audit_logs", "audit_logs", services.AuditError, "start archival run", err.Error())
+		switch {
+		case errors.Is(err, services.ErrRetentionDisabled):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrArchiveRunning):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	h.audit(c, user, "archive_audit_logs", "audit_logs", services.AuditSuccess, fmt.Sprintf("start archival run %d, cutoff %s", run.ID, run.Cutoff.Format(time.RFC3339)), "")
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"run": run,
+	})
+}
+
+// GetAuditArchives lists the latest audit log archival runs (admin only)
+func (h *AdminHandler) GetAuditArchives(c *fiber.Ctx) error {
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if limit < 1 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	runs, err := h.auditService.GetArchiveRuns(limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"runs": runs,
+	})
+}
+
+// GetAuditArchive returns an audit log archival run (admin only)
+func (h *AdminHandler) GetAuditArchive(c *fiber.Ctx) error {
+	runID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid run ID",
+		})
+	}
+
+	run, err := h.auditService.GetArchiveRun(uint(runID))
+	if err != nil {
+		if errors.Is(err, services.ErrArchiveRunNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"run": run,
 	})
 }
 
@@ -346,8 +999,11 @@ func (h *AdminHandler) GetMetrics(c *fiber.Ctx) error {
 
 	database.DB.Model(&models.User{}).Count(&totalUsers)
 	database.DB.Model(&models.User{}).Where("is_active = ?", true).Count(&activeUsers)
-	database.DB.Model(&models.Conversation{}).Count(&totalConversations)
-	database.DB.Model(&models.Message{}).Count(&totalMessages)
+	_ = database.WithUser(user.ID, func(tx *gorm.DB) error {
+		tx.Model(&models.Conversation{}).Count(&totalConversations)
+		tx.Model(&models.Message{}).Count(&totalMessages)
+		return nil
+	})
 	database.DB.Model(&models.AuditLog{}).Where("action = ?", "query").Count(&totalQueries)
 
 	return c.JSON(fiber.Map{
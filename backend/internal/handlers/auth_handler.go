@@ -1,7 +1,12 @@
 package handlers
 
 import (
+	"errors"
+	"strconv"
+	"strings"
+
 	"mastercard-backend/internal/services"
+	"mastercard-backend/internal/utils"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -27,6 +32,15 @@ type LoginRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
 type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
@@ -80,25 +94,98 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
-	user, accessToken, refreshToken, err := h.authService.Login(req.Email, req.Password)
+	result, err := h.authService.Login(clientContext(c), req.Email, req.Password)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": err.Error(),
+		return loginError(c, err)
+	}
+
+	// Users who must pass MFA get a challenge instead of tokens
+	if result.MFAToken != "" {
+		return c.JSON(fiber.Map{
+			"message":                 "MFA required",
+			"mfa_required":            true,
+			"mfa_token":               result.MFAToken,
+			"mfa_enrollment_required": result.MFAEnrollment,
 		})
 	}
 
-	return c.JSON(fiber.Map{
+	return c.JSON(loginResponse(result))
+}
+
+// LoginMFA completes a login with the MFA challenge token and a TOTP or
+// recovery code
+func (h *AuthHandler) LoginMFA(c *fiber.Ctx) error {
+	var req MFALoginRequest
+	if err := c.BodyParser(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "MFA token and code are required",
+		})
+	}
+
+	result, err := h.authService.CompleteMFALogin(clientContext(c), req.MFAToken, req.Code)
+	if err != nil {
+		return loginError(c, err)
+	}
+
+	response := loginResponse(result)
+	if result.RecoveryCodes != nil {
+		// Shown once: only their hashes are stored
+		response["recovery_codes"] = result.RecoveryCodes
+	}
+	return c.JSON(response)
+}
+
+// LoginMFAEnroll returns the TOTP secret of a user challenged at login who
+// must enrol before completing it
+func (h *AuthHandler) LoginMFAEnroll(c *fiber.Ctx) error {
+	var req MFALoginRequest
+	if err := c.BodyParser(&req); err != nil || req.MFAToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "MFA token is required",
+		})
+	}
+
+	enrollment, err := h.authService.StartMFALoginEnrollment(clientContext(c), req.MFAToken)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(enrollment)
+}
+
+// loginError responds to a failed login; throttled attempts get 429 with
+// the seconds to wait in Retry-After
+func loginError(c *fiber.Ctx, err error) error {
+	var blocked *services.LoginBlockedError
+	if errors.As(err, &blocked) {
+		seconds := int(blocked.RetryAfter().Seconds())
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error":       err.Error(),
+			"retry_after": seconds,
+		})
+	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// loginResponse is the response of a completed login
+func loginResponse(result *services.LoginResult) fiber.Map {
+	user := result.User
+	return fiber.Map{
 		"message":       "Login successful",
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
+		"access_token":  result.AccessToken,
+		"refresh_token": result.RefreshToken,
 		"user": fiber.Map{
-			"id":        user.ID,
-			"email":     user.Email,
-			"full_name": user.FullName,
-			"role_id":   user.RoleID,
-			"role":      user.Role,
+			"id":          user.ID,
+			"email":       user.Email,
+			"full_name":   user.FullName,
+			"role_id":     user.RoleID,
+			"role":        user.Role,
+			"mfa_enabled": user.MFAEnabled,
 		},
-	})
+	}
 }
 
 // RefreshToken handles token refresh
@@ -110,15 +197,213 @@ func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 		})
 	}
 
-	accessToken, err := h.authService.RefreshToken(req.RefreshToken)
+	accessToken, refreshToken, err := h.authService.RefreshToken(clientContext(c), req.RefreshToken)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
+	// The presented refresh token is now used up
+	return c.JSON(fiber.Map{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+	})
+}
+
+// Logout ends the session of a refresh token
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	var req RefreshTokenRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// The access token of the session is revoked as well when sent
+	var accessClaims *utils.Claims
+	if header := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(header, "Bearer ") {
+		if claims, err := utils.ValidateToken(strings.TrimPrefix(header, "Bearer "), utils.TokenTypeAccess); err == nil {
+			accessClaims = claims
+		}
+	}
+
+	if err := h.authService.Logout(clientContext(c), req.RefreshToken, accessClaims); err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log out",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Logged out",
+	})
+}
+
+// LogoutAll ends every session of the current user
+func (h *AuthHandler) LogoutAll(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	if err := h.authService.LogoutAll(clientContext(c), userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log out",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Logged out of all sessions",
+	})
+}
+
+// EnrollMFA starts the MFA enrolment of the current user
+func (h *AuthHandler) EnrollMFA(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	enrollment, err := h.authService.EnrollMFA(clientContext(c), userID)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(enrollment)
+}
+
+// VerifyMFA enables MFA for the current user with a code of the enrolled
+// secret and returns the recovery codes
+func (h *AuthHandler) VerifyMFA(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+	var req MFACodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code is required",
+		})
+	}
+
+	recoveryCodes, err := h.authService.VerifyMFA(clientContext(c), userID, req.Code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message":        "MFA enabled",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// DisableMFA turns MFA off for the current user
+func (h *AuthHandler) DisableMFA(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+	var req MFACodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code is required",
+		})
+	}
+
+	if err := h.authService.DisableMFA(clientContext(c), userID, req.Code); err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "MFA disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user
+func (h *AuthHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+	var req MFACodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code is required",
+		})
+	}
+
+	recoveryCodes, err := h.authService.RegenerateRecoveryCodes(clientContext(c), userID, req.Code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// mfaError responds with the status of an MFA error; codes refused by the
+// login throttle get 429 as at login
+func mfaError(c *fiber.Ctx, err error) error {
+	var blocked *services.LoginBlockedError
+	if errors.As(err, &blocked) {
+		return loginError(c, err)
+	}
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrInvalidMFAChallenge):
+		status = fiber.StatusUnauthorized
+	case errors.Is(err, services.ErrInvalidMFACode):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, services.ErrMFAAlreadyEnabled),
+		errors.Is(err, services.ErrMFANotEnabled),
+		errors.Is(err, services.ErrMFANotEnrolling):
+		status = fiber.StatusConflict
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// GetSessions lists the active sessions of the current user
+func (h *AuthHandler) GetSessions(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+	claims, _ := c.Locals("claims").(*utils.Claims)
+
+	sessions, err := services.GetSessions(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve sessions",
+		})
+	}
+
+	// Flag the session making the request
+	result := make([]fiber.Map, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, fiber.Map{
+			"id":           session.ID,
+			"device":       session.Device,
+			"ip_address":   session.IPAddress,
+			"user_agent":   session.UserAgent,
+			"created_at":   session.CreatedAt,
+			"last_seen_at": session.LastSeenAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      claims != nil && claims.SessionID == session.ID,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"sessions": result,
+	})
+}
+
+// TerminateSession ends one of the current user's sessions
+func (h *AuthHandler) TerminateSession(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	if err := h.authService.TerminateSession(clientContext(c), userID, c.Params("id")); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to terminate session",
+		})
+	}
+
 	return c.JSON(fiber.Map{
-		"access_token": accessToken,
+		"message": "Session terminated",
 	})
 }
 
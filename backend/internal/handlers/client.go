@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"context"
+
+	"mastercard-backend/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// clientContext returns a background context carrying the client of the
+// request for audit logging. Fiber recycles c and its buffers once the
+// handler returns, so work that outlives the handler must not use
+// c.Context(), and the values are copied.
+func clientContext(c *fiber.Ctx) context.Context {
+	ipAddress, userAgent := clientStrings(c)
+	return services.WithClientInfo(context.Background(), ipAddress, userAgent)
+}
+
+// clientStrings returns copies of the client IP and user agent of a
+// request, safe to keep after the handler returns
+func clientStrings(c *fiber.Ctx) (ipAddress, userAgent string) {
+	return utils.CopyString(c.IP()), utils.CopyString(c.Get(fiber.HeaderUserAgent))
+}
+
+// copiedQuery returns a copy of a query parameter, safe to keep after the
+// handler returns
+func copiedQuery(c *fiber.Ctx, key string) string {
+	return utils.CopyString(c.Query(key))
+}
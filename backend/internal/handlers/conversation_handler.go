@@ -1,24 +1,26 @@
 package handlers
 
 import (
+	"bytes"
+	"fmt"
 	"strconv"
 
-	"mastercard-backend/internal/database"
 	"mastercard-backend/internal/middleware"
 	"mastercard-backend/internal/models"
 	"mastercard-backend/internal/services"
 
 	"github.com/gofiber/fiber/v2"
-	"gorm.io/gorm"
 )
 
 type ConversationHandler struct {
 	conversationService *services.ConversationService
+	reportService       *services.ReportService
 }
 
 func NewConversationHandler() *ConversationHandler {
 	return &ConversationHandler{
 		conversationService: services.NewConversationService(),
+		reportService:       services.NewReportService(),
 	}
 }
 
@@ -83,7 +85,7 @@ func (h *ConversationHandler) GetConversations(c *fiber.Ctx) error {
 
 	if middleware.CanViewAllConversations(user) {
 		// Managers and admins can see all conversations
-		conversations, total, err = h.conversationService.GetAllConversations(limit, offset)
+		conversations, total, err = h.conversationService.GetAllConversations(userID, limit, offset)
 	} else {
 		// Analyzers see only their own conversations
 		conversations, total, err = h.conversationService.GetConversations(userID, limit, offset)
@@ -124,13 +126,8 @@ func (h *ConversationHandler) GetConversation(c *fiber.Ctx) error {
 	// Check if user can view all conversations (manager or admin)
 	if middleware.CanViewAllConversations(user) {
 		// Managers and admins can view any conversation
-		var conversation models.Conversation
-		if err := database.DB.Where("id = ?", conversationID).
-			Preload("Messages", func(db *gorm.DB) *gorm.DB {
-				return db.Order("created_at ASC")
-			}).
-			Preload("User").
-			First(&conversation).Error; err != nil {
+		conversation, err := h.conversationService.GetAnyConversation(uint(conversationID), userID)
+		if err != nil {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "Conversation not found",
 			})
@@ -263,3 +260,53 @@ func (h *ConversationHandler) SearchConversations(c *fiber.Ctx) error {
 	})
 }
 
+
+// GetConversationReport renders a conversation with its messages as a
+// self-contained HTML or PDF report
+// Analyzers can only report on their own conversations, Managers and Admins on all
+func (h *ConversationHandler) GetConversationReport(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+	user, ok := c.Locals("user").(*models.User)
+	if !ok || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	conversationID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid conversation ID",
+		})
+	}
+
+	format := c.Query("format", services.ReportHTML)
+	if format != services.ReportHTML && format != services.ReportPDF {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": services.ErrUnsupportedReportFormat.Error(),
+		})
+	}
+
+	var conversation *models.Conversation
+	if middleware.CanViewAllConversations(user) {
+		conversation, err = h.conversationService.GetAnyConversation(uint(conversationID), userID)
+	} else {
+		conversation, err = h.conversationService.GetConversation(uint(conversationID), userID)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Conversation not found",
+		})
+	}
+
+	var report bytes.Buffer
+	if err := h.reportService.Render(conversation, format, &report); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to render report: " + err.Error(),
+		})
+	}
+
+	c.Set("Content-Type", services.ReportContentType(format))
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, services.ReportFileName(conversation, format)))
+	return c.Send(report.Bytes())
+}
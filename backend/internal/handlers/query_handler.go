@@ -1,24 +1,37 @@
 package handlers
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
 	"mastercard-backend/internal/services"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 )
 
 type QueryHandler struct {
 	queryService *services.QueryService
+	auditService *services.AuditService
 }
 
 func NewQueryHandler(queryService *services.QueryService) *QueryHandler {
 	return &QueryHandler{
 		queryService: queryService,
+		auditService: services.NewAuditService(),
 	}
 }
 
 type QueryRequest struct {
 	Query          string `json:"query" validate:"required"`
 	ConversationID *uint  `json:"conversation_id,omitempty"`
+	// Async queues the query and returns a job instead of waiting for the result
+	Async bool `json:"async,omitempty"`
 }
 
 // ExecuteQuery handles natural language query execution
@@ -38,8 +51,201 @@ func (h *QueryHandler) ExecuteQuery(c *fiber.Ctx) error {
 		})
 	}
 
-	message, err := h.queryService.ExecuteQuery(userID, req.Query, req.ConversationID)
+	if req.Async || c.QueryBool("async") {
+		job, err := h.queryService.SubmitQuery(clientContext(c), userID, req.Query, req.ConversationID)
+		if err != nil {
+			status := fiber.StatusInternalServerError
+			if errors.Is(err, services.ErrJobQueueFull) {
+				status = fiber.StatusServiceUnavailable
+			}
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"job": job,
+		})
+	}
+
+	message, err := h.queryService.ExecuteQuery(clientContext(c), userID, req.Query, req.ConversationID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": message,
+	})
+}
+
+// GetQueryJob returns the status, and once finished the message, of a query job
+func (h *QueryHandler) GetQueryJob(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	job, err := h.queryService.GetJob(userID, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"job": job,
+	})
+}
+
+// CancelQueryJob cancels a queued or running query job
+func (h *QueryHandler) CancelQueryJob(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	job, err := h.queryService.CancelJob(userID, c.Params("id"))
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrJobNotFound):
+			status = fiber.StatusNotFound
+		case errors.Is(err, services.ErrJobFinished):
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"job": job,
+	})
+}
+
+// StreamQuery handles natural language query execution over Server-Sent
+// Events. Pipeline stages are sent as they complete, followed by a "done"
+// event with the saved message or an "error" event.
+func (h *QueryHandler) StreamQuery(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	var req QueryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.Query == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Query is required",
+		})
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	client := clientContext(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// Stop the LLM calls once the client is gone
+		ctx, cancel := context.WithCancel(client)
+		defer cancel()
+
+		send := func(event string, data interface{}) {
+			if ctx.Err() != nil {
+				return
+			}
+			payload, err := json.Marshal(data)
+			if err != nil {
+				payload, _ = json.Marshal(fiber.Map{"error": err.Error()})
+				event = "error"
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+			if err := w.Flush(); err != nil {
+				cancel()
+			}
+		}
+
+		message, err := h.queryService.StreamQuery(ctx, userID, req.Query, req.ConversationID, send)
+		if err != nil {
+			send("error", fiber.Map{"error": err.Error()})
+			return
+		}
+		send("done", fiber.Map{"message": message})
+	})
+
+	return nil
+}
+
+// GetMessageResults returns another page of a previous message's result
+func (h *QueryHandler) GetMessageResults(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	messageID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid page",
+		})
+	}
+
+	result, err := h.queryService.GetResultPage(userID, uint(messageID), page)
+	if err != nil {
+		var rejection *services.SQLValidationError
+		switch {
+		case errors.Is(err, services.ErrMessageNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrNoQueryResult), errors.Is(err, services.ErrUnorderedResult):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.As(err, &rejection):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Query rejected " + rejection.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(result)
+}
+
+// ConfirmMessage runs a query that was held back by the cost guard
+func (h *QueryHandler) ConfirmMessage(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	messageID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	message, err := h.queryService.ConfirmQuery(clientContext(c), userID, uint(messageID))
 	if err != nil {
+		var rejection *services.SQLValidationError
+		switch {
+		case errors.Is(err, services.ErrMessageNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrNotAwaitingConfirmation):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.As(err, &rejection):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Query rejected " + rejection.Error(),
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
@@ -50,3 +256,87 @@ func (h *QueryHandler) ExecuteQuery(c *fiber.Ctx) error {
 	})
 }
 
+// The trailer of an export response tells whether the file is complete
+const (
+	exportStatusTrailer = "X-Export-Status"
+	exportComplete      = "complete"
+	exportFailed        = "failed"
+)
+
+// ExportMessage re-runs the query of a previous message and streams its
+// result as csv, xlsx, parquet or json. Every export is audit logged.
+func (h *QueryHandler) ExportMessage(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	messageID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	// Copied: the stream writer runs after the request buffers are reused
+	format := utils.CopyString(c.Query("format", services.ExportCSV))
+	export, err := h.queryService.PrepareExport(userID, uint(messageID), format)
+	if err != nil {
+		var rejection *services.SQLValidationError
+		switch {
+		case errors.Is(err, services.ErrUnsupportedExportFormat):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrMessageNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.As(err, &rejection):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Query rejected " + rejection.Error(),
+			})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The request context is recycled once the handler returns
+	ip, userAgent := clientStrings(c)
+
+	c.Set("Content-Type", services.ExportContentType(format))
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="message-%d.%s"`, messageID, format))
+	// The 200 is sent before the query runs: whether the file is complete
+	// is reported in a trailer after the last chunk
+	header := &c.Response().Header
+	if err := header.SetTrailer(exportStatusTrailer); err != nil {
+		return err
+	}
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		start := time.Now()
+		rows, err := h.queryService.WriteExport(context.Background(), userID, export, w)
+		if err == nil {
+			err = w.Flush()
+		}
+		elapsed := int(time.Since(start).Milliseconds())
+
+		status := services.AuditSuccess
+		var errMsg *string
+		if err != nil {
+			// Headers are already sent; the client sees a truncated file
+			// and the failure in the trailer
+			status = services.AuditError
+			text := err.Error()
+			errMsg = &text
+			fmt.Printf("Warning: Export of message %d failed: %v\n", messageID, err)
+			header.Set(exportStatusTrailer, exportFailed)
+		} else {
+			header.Set(exportStatusTrailer, exportComplete)
+		}
+		if logErr := h.auditService.LogAction(&userID, "export_"+format, "messages", &export.Message.UserMessage, export.Message.SQLQuery,
+			&rows, &ip, &userAgent, status, errMsg, &elapsed); logErr != nil {
+			fmt.Printf("Warning: Failed to log export: %v\n", logErr)
+		}
+	})
+
+	return nil
+}
@@ -1,10 +1,12 @@
 package middleware
 
 import (
+	"errors"
 	"strings"
 
 	"mastercard-backend/internal/database"
 	"mastercard-backend/internal/models"
+	"mastercard-backend/internal/services"
 	"mastercard-backend/internal/utils"
 
 	"github.com/gofiber/fiber/v2"
@@ -29,12 +31,28 @@ func AuthMiddleware() fiber.Handler {
 		}
 
 		token := parts[1]
-		claims, err := utils.ValidateToken(token)
+		claims, err := utils.ValidateToken(token, utils.TokenTypeAccess)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid or expired token",
 			})
 		}
+		if services.IsTokenRevoked(claims) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Token has been revoked",
+			})
+		}
+		// Ended and idle sessions no longer accept their access tokens
+		if err := services.CheckSession(claims); err != nil {
+			if errors.Is(err, services.ErrSessionExpired) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Session has expired",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check session",
+			})
+		}
 
 		// Get user from database
 		var user models.User
@@ -54,12 +72,9 @@ func AuthMiddleware() fiber.Handler {
 		c.Locals("user", &user)
 		c.Locals("userID", user.ID)
 		c.Locals("roleID", user.RoleID)
+		c.Locals("claims", claims)
 
-		// Set RLS context for database queries
-		if err := database.SetCurrentUserID(user.ID); err != nil {
-			// Log error but don't fail the request
-			_ = err
-		}
+		// RLS context is bound per transaction with database.WithUser
 
 		return c.Next()
 	}
@@ -72,14 +87,14 @@ func OptionalAuthMiddleware() fiber.Handler {
 		if authHeader != "" {
 			parts := strings.Split(authHeader, " ")
 			if len(parts) == 2 && parts[0] == "Bearer" {
-				claims, err := utils.ValidateToken(parts[1])
-				if err == nil {
+				claims, err := utils.ValidateToken(parts[1], utils.TokenTypeAccess)
+				if err == nil && !services.IsTokenRevoked(claims) && services.CheckSession(claims) == nil {
 					var user models.User
 					if err := database.DB.Preload("Role").First(&user, claims.UserID).Error; err == nil && user.IsActive {
 						c.Locals("user", &user)
 						c.Locals("userID", user.ID)
 						c.Locals("roleID", user.RoleID)
-						_ = database.SetCurrentUserID(user.ID)
+						c.Locals("claims", claims)
 					}
 				}
 			}
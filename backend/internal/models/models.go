@@ -15,6 +15,11 @@ type User struct {
 	RoleID       *uint      `gorm:"index" json:"role_id"`
 	Role         *Role      `gorm:"foreignKey:RoleID" json:"role,omitempty"`
 	IsActive     bool       `gorm:"default:true" json:"is_active"`
+	LegalHold    bool       `gorm:"default:false" json:"legal_hold"` // Exempts the user's audit entries from archival
+	MFAEnabled   bool       `gorm:"column:mfa_enabled;default:false" json:"mfa_enabled"`
+	MFASecret    *string    `gorm:"column:mfa_secret;type:text" json:"-"`    // Encrypted TOTP secret, pending until MFAEnabled
+	MFALastStep  int64      `gorm:"column:mfa_last_step;default:0" json:"-"` // Time step of the last accepted code
+	MFAEnabledAt *time.Time `gorm:"column:mfa_enabled_at" json:"mfa_enabled_at,omitempty"`
 	LastLogin    *time.Time `json:"last_login,omitempty"`
 	CreatedAt    time.Time  `json:"created_at"`
 	UpdatedAt    time.Time  `json:"updated_at"`
@@ -25,6 +30,7 @@ type Role struct {
 	ID          uint         `gorm:"primaryKey" json:"id"`
 	Name        string       `gorm:"uniqueIndex;not null" json:"name"`
 	Description string       `json:"description,omitempty"`
+	MFARequired bool         `gorm:"column:mfa_required;default:false" json:"mfa_required"` // Users of the role must use MFA
 	Permissions []Permission `gorm:"many2many:role_permissions;" json:"permissions,omitempty"`
 	CreatedAt   time.Time    `json:"created_at"`
 	UpdatedAt   time.Time    `json:"updated_at"`
@@ -102,6 +108,12 @@ type Message struct {
 	ErrorMessage    *string      `gorm:"type:text" json:"error_message,omitempty"`
 	Analysis        *string      `gorm:"type:text" json:"analysis,omitempty"` // Conversational analysis and insights
 	ExecutionTimeMs *int         `json:"execution_time_ms,omitempty"`
+	RowCount        *int         `json:"row_count,omitempty"`                      // Rows returned in result_data
+	Truncated       bool         `gorm:"default:false" json:"truncated"`           // More rows exist than MAX_RESULT_ROWS
+	TotalEstimate   *int64       `json:"total_estimate,omitempty"`                 // Planner row estimate when truncated
+	PlanSummary     *string      `gorm:"type:jsonb" json:"plan_summary,omitempty"` // EXPLAIN summary checked by the cost guard
+	Attempts        *string      `gorm:"type:jsonb" json:"attempts,omitempty"`     // SQL tried by the self-correction loop
+	ChartSpec       *string      `gorm:"type:jsonb" json:"chart_spec,omitempty"`   // Recommended chart for result_data
 	CreatedAt       time.Time    `gorm:"index" json:"created_at"`
 }
 
@@ -117,10 +129,103 @@ type AuditLog struct {
 	ResultCount     *int      `json:"result_count,omitempty"`
 	IPAddress       *string   `gorm:"type:inet" json:"ip_address,omitempty"`
 	UserAgent       *string   `gorm:"type:text" json:"user_agent,omitempty"`
-	Status          *string   `gorm:"type:varchar(20);index" json:"status,omitempty"` // success, error, denied
+	Status          *string   `gorm:"type:varchar(20);index" json:"status,omitempty"` // success, error, denied, pending
 	ErrorMessage    *string   `gorm:"type:text" json:"error_message,omitempty"`
 	ExecutionTimeMs *int      `json:"execution_time_ms,omitempty"`
 	Timestamp       time.Time `gorm:"index" json:"timestamp"`
+	PrevHash        *string   `gorm:"type:varchar(64)" json:"prev_hash,omitempty"`  // entry_hash of the previous entry
+	EntryHash       *string   `gorm:"type:varchar(64)" json:"entry_hash,omitempty"` // SHA-256 of prev_hash and the content
+}
+
+// RefreshToken is an issued refresh token, identified by its jti claim
+type RefreshToken struct {
+	ID         string     `gorm:"type:uuid;primaryKey" json:"id"`
+	FamilyID   string     `gorm:"type:uuid;not null;index" json:"family_id"` // Tokens rotated from one login
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt     *time.Time `json:"used_at,omitempty"`
+	ReplacedBy *string    `gorm:"type:uuid" json:"replaced_by,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Session is a login, sharing its ID with the refresh token family issued
+// by it
+type Session struct {
+	ID         string     `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Device     string     `gorm:"type:varchar(100);not null" json:"device"` // Browser and OS from the user agent
+	IPAddress  *string    `gorm:"type:inet" json:"ip_address,omitempty"`
+	UserAgent  *string    `gorm:"type:text" json:"user_agent,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `gorm:"not null" json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"not null;index" json:"expires_at"` // Expiry of the latest refresh token
+	EndedAt    *time.Time `json:"ended_at,omitempty"`
+	EndReason  *string    `gorm:"type:varchar(100)" json:"end_reason,omitempty"`
+}
+
+// MFARecoveryCode is a single-use recovery code of a user's MFA
+type MFARecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"type:varchar(64);not null" json:"-"` // SHA-256 of the code
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MFAChallenge is the second step of a login, identified by the jti of the
+// challenge token
+type MFAChallenge struct {
+	ID          string     `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"` // Failed codes
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// LoginThrottle tracks the failed logins of an account or a client IP
+type LoginThrottle struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Scope         string     `gorm:"type:varchar(10);not null;uniqueIndex:idx_login_throttles_scope_subject" json:"scope"`    // account, ip
+	Subject       string     `gorm:"type:varchar(255);not null;uniqueIndex:idx_login_throttles_scope_subject" json:"subject"` // Email or IP address
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time  `gorm:"not null" json:"last_failure_at"`
+	BlockedUntil  *time.Time `gorm:"index" json:"blocked_until,omitempty"` // End of the backoff or lockout
+	LockedAt      *time.Time `json:"locked_at,omitempty"`                  // Start of the lockout
+}
+
+// RevokedToken revokes an access token by its jti or, without jti, every
+// access token of the user issued at or before IssuedBefore
+type RevokedToken struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	JTI          *string    `gorm:"column:jti;type:uuid" json:"jti,omitempty"`
+	UserID       uint       `gorm:"not null" json:"user_id"`
+	IssuedBefore *time.Time `json:"issued_before,omitempty"`
+	Reason       string     `gorm:"type:varchar(100);not null" json:"reason"`
+	ExpiresAt    time.Time  `gorm:"not null;index" json:"expires_at"` // When the covered tokens have expired
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// AuditArchiveRun is a run of the audit log retention job
+type AuditArchiveRun struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Status       string     `gorm:"type:varchar(20);not null" json:"status"`  // running, completed, failed, skipped
+	Trigger      string     `gorm:"type:varchar(20);not null" json:"trigger"` // schedule, manual
+	TriggeredBy  *uint      `json:"triggered_by,omitempty"`
+	Cutoff       time.Time  `gorm:"not null" json:"cutoff"` // Entries older than this are archived
+	FirstID      *uint      `json:"first_id,omitempty"`
+	LastID       *uint      `json:"last_id,omitempty"`
+	PrevHash     *string    `gorm:"type:varchar(64)" json:"prev_hash,omitempty"`
+	LastHash     *string    `gorm:"type:varchar(64)" json:"last_hash,omitempty"`
+	Archived     int        `gorm:"not null;default:0" json:"archived"`
+	Deleted      int        `gorm:"not null;default:0" json:"deleted"`
+	Held         int        `gorm:"not null;default:0" json:"held"`     // Archived but kept under legal hold
+	Released     int        `gorm:"not null;default:0" json:"released"` // Deleted from earlier runs once no longer held
+	Files        *string    `gorm:"type:jsonb" json:"files,omitempty"`
+	ErrorMessage *string    `gorm:"type:text" json:"error_message,omitempty"`
+	StartedAt    time.Time  `gorm:"not null" json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
 }
 
 // TableName overrides
@@ -152,6 +257,34 @@ func (AuditLog) TableName() string {
 	return "audit_logs"
 }
 
+func (AuditArchiveRun) TableName() string {
+	return "audit_archive_runs"
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
+
+func (MFARecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+func (MFAChallenge) TableName() string {
+	return "mfa_challenges"
+}
+
+func (LoginThrottle) TableName() string {
+	return "login_throttles"
+}
+
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
 // BeforeCreate hook for User
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	u.CreatedAt = time.Now()
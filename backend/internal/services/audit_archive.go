@@ -0,0 +1,426 @@
+package services
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Archive run statuses
+const (
+	ArchiveRunning   = "running"
+	ArchiveCompleted = "completed"
+	ArchiveFailed    = "failed"
+	// ArchiveSkipped marks a run started while another instance was archiving
+	ArchiveSkipped = "skipped"
+)
+
+// Archive run triggers
+const (
+	ArchiveTriggerSchedule = "schedule"
+	ArchiveTriggerManual   = "manual"
+)
+
+// auditArchiveLock is the advisory lock key held by the archiving instance
+const auditArchiveLock = 0x61726368
+
+var (
+	// ErrRetentionDisabled is returned when archiving without a retention period
+	ErrRetentionDisabled = errors.New("audit log retention is disabled, set AUDIT_RETENTION_DAYS")
+	// ErrArchiveRunning is returned when a run is already in progress here
+	ErrArchiveRunning = errors.New("an audit archival run is already in progress")
+	// ErrArchiveRunNotFound is returned for unknown archive runs
+	ErrArchiveRunNotFound = errors.New("archive run not found")
+
+	errArchiveLocked = errors.New("another instance is archiving")
+)
+
+// notHeldCondition selects the entries not under legal hold
+const notHeldCondition = "(user_id IS NULL OR user_id NOT IN (SELECT id FROM users WHERE legal_hold))"
+
+// AuditArchiveFile is one compressed JSONL file written by a run
+type AuditArchiveFile struct {
+	Name    string `json:"name"`
+	Month   string `json:"month"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// auditArchiving allows one run at a time per instance
+var auditArchiving struct {
+	mu      sync.Mutex
+	running bool
+}
+
+// StartAuditRetention archives old audit entries every AUDIT_ARCHIVE_INTERVAL
+// when AUDIT_RETENTION_DAYS is set
+func StartAuditRetention() {
+	if config.AppConfig.AuditRetentionDays <= 0 || config.AppConfig.AuditArchiveInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(config.AppConfig.AuditArchiveInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := startArchive(ArchiveTriggerSchedule, nil); err != nil && !errors.Is(err, ErrArchiveRunning) {
+				fmt.Printf("Warning: Failed to start audit archival: %v\n", err)
+			}
+		}
+	}()
+}
+
+// StartArchive starts an archival run in the background and returns it;
+// its progress is read with GetArchiveRun
+func (s *AuditService) StartArchive(userID uint) (*models.AuditArchiveRun, error) {
+	return startArchive(ArchiveTriggerManual, &userID)
+}
+
+func startArchive(trigger string, userID *uint) (*models.AuditArchiveRun, error) {
+	retentionDays := config.AppConfig.AuditRetentionDays
+	if retentionDays <= 0 {
+		return nil, ErrRetentionDisabled
+	}
+
+	auditArchiving.mu.Lock()
+	if auditArchiving.running {
+		auditArchiving.mu.Unlock()
+		return nil, ErrArchiveRunning
+	}
+	auditArchiving.running = true
+	auditArchiving.mu.Unlock()
+
+	now := time.Now()
+	run := &models.AuditArchiveRun{
+		Status:      ArchiveRunning,
+		Trigger:     trigger,
+		TriggeredBy: userID,
+		// Audit timestamps are stored in UTC
+		Cutoff:    now.UTC().AddDate(0, 0, -retentionDays).Truncate(time.Microsecond),
+		StartedAt: now,
+	}
+	if err := database.DB.Create(run).Error; err != nil {
+		auditArchiving.mu.Lock()
+		auditArchiving.running = false
+		auditArchiving.mu.Unlock()
+		return nil, err
+	}
+
+	snapshot := *run
+	go func() {
+		defer func() {
+			auditArchiving.mu.Lock()
+			auditArchiving.running = false
+			auditArchiving.mu.Unlock()
+		}()
+		runArchive(run)
+	}()
+	return &snapshot, nil
+}
+
+// runArchive runs an archival while holding the archive lock and records
+// how it ended
+func runArchive(run *models.AuditArchiveRun) {
+	err := database.DB.Connection(func(conn *gorm.DB) error {
+		var locked bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", auditArchiveLock).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return errArchiveLocked
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", auditArchiveLock)
+
+		// Runs left running hold no lock: their instance stopped mid-run
+		if err := conn.Model(&models.AuditArchiveRun{}).
+			Where("status = ? AND id <> ?", ArchiveRunning, run.ID).
+			Updates(map[string]interface{}{"status": ArchiveFailed, "error_message": "interrupted", "finished_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		return archiveAuditLogs(run)
+	})
+	if err == nil {
+		return
+	}
+
+	finishedAt := time.Now()
+	run.Status = ArchiveFailed
+	if errors.Is(err, errArchiveLocked) {
+		run.Status = ArchiveSkipped
+	} else {
+		fmt.Printf("Warning: Audit archival run %d failed: %v\n", run.ID, err)
+	}
+	message := err.Error()
+	run.ErrorMessage = &message
+	run.FinishedAt = &finishedAt
+	if err := database.DB.Save(run).Error; err != nil {
+		fmt.Printf("Warning: Failed to record audit archival run %d: %v\n", run.ID, err)
+	}
+}
+
+// archiveAuditLogs writes the entries older than the cutoff that follow the
+// previous run to monthly files, then deletes those not under legal hold.
+// Archived ranges are contiguous in id order, so the chain left in the
+// table continues from the last hash of the last run.
+func archiveAuditLogs(run *models.AuditArchiveRun) error {
+	last, err := lastArchiveRun(database.DB)
+	if err != nil {
+		return err
+	}
+	var archivedThrough uint
+	if last != nil {
+		archivedThrough = *last.LastID
+	}
+
+	// The range ends before the first entry inside the retention period.
+	// Entries appended meanwhile are inside it too, so the end is bounded by
+	// the cutoff rather than by the last entry at the time.
+	var boundary *uint
+	if err := database.DB.Model(&models.AuditLog{}).
+		Where("id > ? AND timestamp >= ?", archivedThrough, run.Cutoff).
+		Select("MIN(id)").Scan(&boundary).Error; err != nil {
+		return err
+	}
+	query := database.DB.Model(&models.AuditLog{}).Where("id > ? AND timestamp < ?", archivedThrough, run.Cutoff)
+	if boundary != nil {
+		query = query.Where("id < ?", *boundary)
+	}
+	var rangeEnd *uint
+	if err := query.Select("MAX(id)").Scan(&rangeEnd).Error; err != nil {
+		return err
+	}
+
+	files := newAuditArchiveFiles(config.AppConfig.AuditArchiveDir, run.ID)
+	if rangeEnd != nil {
+		if err := files.write(run, archivedThrough, *rangeEnd); err != nil {
+			files.remove()
+			return err
+		}
+	}
+	archived, err := files.close()
+	if err != nil {
+		files.remove()
+		return err
+	}
+	data, _ := json.Marshal(archived)
+	filesJSON := string(data)
+	run.Files = &filesJSON
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Appends read the chain head under this lock: they must not link to
+		// an entry deleted here before the run that archived it is visible
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLock).Error; err != nil {
+			return err
+		}
+
+		if rangeEnd != nil {
+			result := tx.Where("id > ? AND id <= ?", archivedThrough, *rangeEnd).Where(notHeldCondition).Delete(&models.AuditLog{})
+			if result.Error != nil {
+				return result.Error
+			}
+			run.Deleted = int(result.RowsAffected)
+			run.Held = run.Archived - run.Deleted
+		}
+
+		// Entries archived earlier whose legal hold was lifted
+		if archivedThrough > 0 {
+			result := tx.Where("id <= ?", archivedThrough).Where(notHeldCondition).Delete(&models.AuditLog{})
+			if result.Error != nil {
+				return result.Error
+			}
+			run.Released = int(result.RowsAffected)
+		}
+
+		finishedAt := time.Now()
+		run.Status = ArchiveCompleted
+		run.FinishedAt = &finishedAt
+		return tx.Save(run).Error
+	})
+	if err != nil {
+		files.remove()
+	}
+	return err
+}
+
+// lastArchiveRun returns the completed run that archived the highest ids,
+// nil when nothing was archived yet
+func lastArchiveRun(db *gorm.DB) (*models.AuditArchiveRun, error) {
+	var run models.AuditArchiveRun
+	err := db.Where("status = ? AND last_id IS NOT NULL", ArchiveCompleted).Order("last_id DESC").Take(&run).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// auditArchiveFiles writes the entries of a run to one gzip compressed
+// JSONL file per month
+type auditArchiveFiles struct {
+	dir    string
+	runID  uint
+	months map[string]*auditArchiveFile
+}
+
+type auditArchiveFile struct {
+	AuditArchiveFile
+	path    string
+	file    *os.File
+	gzip    *gzip.Writer
+	hash    hash.Hash
+	written *countingWriter
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newAuditArchiveFiles(dir string, runID uint) *auditArchiveFiles {
+	return &auditArchiveFiles{dir: dir, runID: runID, months: make(map[string]*auditArchiveFile)}
+}
+
+// write archives the entries with ids after..through and records the range
+// on run
+func (a *auditArchiveFiles) write(run *models.AuditArchiveRun, after, through uint) error {
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return err
+	}
+
+	lastID := after
+	for {
+		var batch []models.AuditLog
+		if err := database.DB.Where("id > ? AND id <= ?", lastID, through).Order("id ASC").Limit(auditBatchSize).Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for i := range batch {
+			entry := &batch[i]
+			if run.FirstID == nil {
+				run.FirstID = &entry.ID
+				run.PrevHash = entry.PrevHash
+			}
+			if err := a.append(entry); err != nil {
+				return err
+			}
+			run.LastID = &entry.ID
+			run.LastHash = entry.EntryHash
+			run.Archived++
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+func (a *auditArchiveFiles) append(entry *models.AuditLog) error {
+	month := entry.Timestamp.UTC().Format("2006-01")
+	f, ok := a.months[month]
+	if !ok {
+		name := fmt.Sprintf("audit-logs-%s-run%06d.jsonl.gz", month, a.runID)
+		path := filepath.Join(a.dir, name)
+		file, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		f = &auditArchiveFile{
+			AuditArchiveFile: AuditArchiveFile{Name: name, Month: month},
+			path:             path,
+			file:             file,
+			hash:             sha256.New(),
+		}
+		f.written = &countingWriter{w: io.MultiWriter(file, f.hash)}
+		f.gzip = gzip.NewWriter(f.written)
+		a.months[month] = f
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := f.gzip.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	f.Entries++
+	return nil
+}
+
+// close completes the files and moves them in place
+func (a *auditArchiveFiles) close() ([]AuditArchiveFile, error) {
+	files := []AuditArchiveFile{}
+	for _, f := range a.months {
+		if err := f.gzip.Close(); err != nil {
+			return nil, err
+		}
+		if err := f.file.Sync(); err != nil {
+			return nil, err
+		}
+		if err := f.file.Close(); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(f.path+".tmp", f.path); err != nil {
+			return nil, err
+		}
+		f.Bytes = f.written.n
+		f.SHA256 = hex.EncodeToString(f.hash.Sum(nil))
+		files = append(files, f.AuditArchiveFile)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Month < files[j].Month })
+	return files, nil
+}
+
+// remove deletes the files of a failed run
+func (a *auditArchiveFiles) remove() {
+	for _, f := range a.months {
+		f.file.Close()
+		os.Remove(f.path + ".tmp")
+		os.Remove(f.path)
+	}
+}
+
+// GetArchiveRuns returns the latest archival runs, newest first
+func (s *AuditService) GetArchiveRuns(limit int) ([]models.AuditArchiveRun, error) {
+	var runs []models.AuditArchiveRun
+	if err := database.DB.Order("id DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// GetArchiveRun returns an archival run
+func (s *AuditService) GetArchiveRun(id uint) (*models.AuditArchiveRun, error) {
+	var run models.AuditArchiveRun
+	if err := database.DB.First(&run, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrArchiveRunNotFound
+		}
+		return nil, err
+	}
+	return &run, nil
+}
@@ -0,0 +1,285 @@
+//go:build integration
+
+package services
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const day = 24 * time.Hour
+
+// useEmptyAuditLog starts a test transaction with an empty audit log and no
+// archive runs, archiving to a temporary directory
+func useEmptyAuditLog(t *testing.T) *gorm.DB {
+	t.Helper()
+	tx := useTestTx(t)
+	for _, table := range []string{"audit_logs", "audit_archive_runs"} {
+		if err := tx.Exec("DELETE FROM " + table).Error; err != nil {
+			t.Fatalf("failed to clear %s: %v", table, err)
+		}
+	}
+
+	dir := config.AppConfig.AuditArchiveDir
+	config.AppConfig.AuditArchiveDir = t.TempDir()
+	t.Cleanup(func() { config.AppConfig.AuditArchiveDir = dir })
+	return tx
+}
+
+// appendTestEntry appends an entry of userID written age ago
+func appendTestEntry(t *testing.T, userID *uint, age time.Duration) *models.AuditLog {
+	t.Helper()
+	status := "success"
+	entry := &models.AuditLog{UserID: userID, Action: "query", Status: &status, Timestamp: time.Now().Add(-age)}
+	if err := appendAuditEntry(entry); err != nil {
+		t.Fatalf("failed to append entry: %v", err)
+	}
+	return entry
+}
+
+// archiveOlderThan runs an archival of the entries older than retention
+func archiveOlderThan(t *testing.T, tx *gorm.DB, retention time.Duration) *models.AuditArchiveRun {
+	t.Helper()
+	now := time.Now()
+	run := &models.AuditArchiveRun{
+		Status:    ArchiveRunning,
+		Trigger:   ArchiveTriggerManual,
+		Cutoff:    now.UTC().Add(-retention).Truncate(time.Microsecond),
+		StartedAt: now,
+	}
+	if err := tx.Create(run).Error; err != nil {
+		t.Fatalf("failed to create run: %v", err)
+	}
+	if err := archiveAuditLogs(run); err != nil {
+		t.Fatalf("archival failed: %v", err)
+	}
+	if run.Status != ArchiveCompleted {
+		t.Fatalf("run status = %s, want %s", run.Status, ArchiveCompleted)
+	}
+	return run
+}
+
+// createHeldUser creates a user whose audit entries are under legal hold
+func createHeldUser(t *testing.T, tx *gorm.DB) uint {
+	t.Helper()
+	user := models.User{
+		Email:        fmt.Sprintf("held-%d@example.test", time.Now().UnixNano()),
+		PasswordHash: "x",
+		FullName:     "Held",
+		IsActive:     true,
+		LegalHold:    true,
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user.ID
+}
+
+// verifyValidChain verifies the chain and fails the test when it is broken
+func verifyValidChain(t *testing.T) *AuditChainReport {
+	t.Helper()
+	report, err := NewAuditService().VerifyChain()
+	if err != nil {
+		t.Fatalf("VerifyChain error: %v", err)
+	}
+	if !report.Valid {
+		t.Fatalf("chain is broken: %+v", report.Broken)
+	}
+	return report
+}
+
+// tableIDs returns the ids left in audit_logs
+func tableIDs(t *testing.T, tx *gorm.DB) []uint {
+	t.Helper()
+	var ids []uint
+	if err := tx.Model(&models.AuditLog{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		t.Fatalf("failed to list entries: %v", err)
+	}
+	return ids
+}
+
+func TestArchiveOfTheWholeTableKeepsTheChainVerifiable(t *testing.T) {
+	tx := useEmptyAuditLog(t)
+	var entries []*models.AuditLog
+	for i := 0; i < 3; i++ {
+		entries = append(entries, appendTestEntry(t, nil, 40*day))
+	}
+
+	run := archiveOlderThan(t, tx, 30*day)
+	if run.Archived != 3 || run.Deleted != 3 || run.Held != 0 {
+		t.Fatalf("archived/deleted/held = %d/%d/%d, want 3/3/0", run.Archived, run.Deleted, run.Held)
+	}
+	if *run.FirstID != entries[0].ID || *run.LastID != entries[2].ID || *run.LastHash != *entries[2].EntryHash {
+		t.Fatalf("run range %d..%d does not match the entries", *run.FirstID, *run.LastID)
+	}
+	if ids := tableIDs(t, tx); len(ids) != 0 {
+		t.Fatalf("entries %v left in the table", ids)
+	}
+
+	// The chain head was deleted: the next entry continues from the run
+	next := appendTestEntry(t, nil, 0)
+	if *next.PrevHash != *run.LastHash {
+		t.Errorf("prev_hash = %q, want the last archived hash %q", *next.PrevHash, *run.LastHash)
+	}
+	report := verifyValidChain(t)
+	if report.Archived != 3 || report.Checked != 1 || report.Anchor != *run.LastHash {
+		t.Errorf("report = %+v, want 3 archived, 1 checked, anchored on the run", report)
+	}
+}
+
+func TestArchiveFilesHoldTheArchivedEntries(t *testing.T) {
+	tx := useEmptyAuditLog(t)
+	first := appendTestEntry(t, nil, 40*day)
+	second := appendTestEntry(t, nil, 40*day)
+
+	run := archiveOlderThan(t, tx, 30*day)
+	var files []AuditArchiveFile
+	if err := json.Unmarshal([]byte(*run.Files), &files); err != nil {
+		t.Fatalf("invalid files: %v", err)
+	}
+	if len(files) != 1 || files[0].Entries != 2 {
+		t.Fatalf("files = %+v, want one file of 2 entries", files)
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.AppConfig.AuditArchiveDir, files[0].Name))
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != files[0].SHA256 || int64(len(data)) != files[0].Bytes {
+		t.Errorf("archive size or SHA-256 does not match the run")
+	}
+
+	file, err := os.Open(filepath.Join(config.AppConfig.AuditArchiveDir, files[0].Name))
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("invalid gzip: %v", err)
+	}
+	var archived []models.AuditLog
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var entry models.AuditLog
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid line: %v", err)
+		}
+		archived = append(archived, entry)
+	}
+	if len(archived) != 2 || archived[0].ID != first.ID || archived[1].ID != second.ID {
+		t.Fatalf("archived entries = %+v, want %d and %d", archived, first.ID, second.ID)
+	}
+	// The archived content still hashes to the chain
+	for i := range archived {
+		if hash := auditHash(&archived[i]); hash != *archived[i].EntryHash {
+			t.Errorf("entry %d hashes to %s, stored %s", archived[i].ID, hash, *archived[i].EntryHash)
+		}
+	}
+}
+
+func TestArchiveKeepsEntriesUnderLegalHold(t *testing.T) {
+	tx := useEmptyAuditLog(t)
+	held := createHeldUser(t, tx)
+	heldEntry := appendTestEntry(t, &held, 40*day)
+	appendTestEntry(t, nil, 40*day)
+
+	run := archiveOlderThan(t, tx, 30*day)
+	if run.Archived != 2 || run.Deleted != 1 || run.Held != 1 {
+		t.Fatalf("archived/deleted/held = %d/%d/%d, want 2/1/1", run.Archived, run.Deleted, run.Held)
+	}
+	if ids := tableIDs(t, tx); len(ids) != 1 || ids[0] != heldEntry.ID {
+		t.Fatalf("entries left = %v, want only the held entry %d", ids, heldEntry.ID)
+	}
+
+	// The held entry is older than the archived head and must not become it
+	next := appendTestEntry(t, nil, 0)
+	if *next.PrevHash != *run.LastHash {
+		t.Errorf("prev_hash = %q, want the last archived hash %q", *next.PrevHash, *run.LastHash)
+	}
+	report := verifyValidChain(t)
+	if report.Held != 1 || report.Checked != 1 {
+		t.Errorf("report = %+v, want 1 held and 1 checked", report)
+	}
+}
+
+func TestArchiveReleasesEntriesOnceTheHoldIsLifted(t *testing.T) {
+	tx := useEmptyAuditLog(t)
+	held := createHeldUser(t, tx)
+	appendTestEntry(t, &held, 40*day)
+	appendTestEntry(t, &held, 40*day)
+	archiveOlderThan(t, tx, 30*day)
+	recent := appendTestEntry(t, nil, day)
+
+	if err := tx.Model(&models.User{}).Where("id = ?", held).Update("legal_hold", false).Error; err != nil {
+		t.Fatalf("failed to lift hold: %v", err)
+	}
+	run := archiveOlderThan(t, tx, 30*day)
+	if run.Released != 2 || run.Archived != 0 {
+		t.Fatalf("released/archived = %d/%d, want 2/0", run.Released, run.Archived)
+	}
+	if ids := tableIDs(t, tx); len(ids) != 1 || ids[0] != recent.ID {
+		t.Fatalf("entries left = %v, want only %d", ids, recent.ID)
+	}
+
+	report := verifyValidChain(t)
+	if report.Held != 0 || report.Checked != 1 || report.Archived != 2 {
+		t.Errorf("report = %+v, want 0 held, 1 checked, 2 archived", report)
+	}
+}
+
+func TestArchiveStopsAtTheFirstEntryInsideRetention(t *testing.T) {
+	tx := useEmptyAuditLog(t)
+	first := appendTestEntry(t, nil, 40*day)
+	second := appendTestEntry(t, nil, 40*day)
+	recent := appendTestEntry(t, nil, day)
+	// Older than the cutoff, but after an entry inside the retention period
+	late := appendTestEntry(t, nil, 40*day)
+
+	run := archiveOlderThan(t, tx, 30*day)
+	if run.Archived != 2 || *run.FirstID != first.ID || *run.LastID != second.ID {
+		t.Fatalf("archived %d entries %v..%v, want %d..%d", run.Archived, run.FirstID, run.LastID, first.ID, second.ID)
+	}
+	if ids := tableIDs(t, tx); len(ids) != 2 || ids[0] != recent.ID || ids[1] != late.ID {
+		t.Fatalf("entries left = %v, want %d and %d", ids, recent.ID, late.ID)
+	}
+
+	next := appendTestEntry(t, nil, 0)
+	if *next.PrevHash != *late.EntryHash {
+		t.Errorf("prev_hash = %q, want the hash of the last entry %q", *next.PrevHash, *late.EntryHash)
+	}
+	if report := verifyValidChain(t); report.Checked != 3 {
+		t.Errorf("checked = %d, want 3", report.Checked)
+	}
+}
+
+func TestArchiveWithNothingOldArchivesNothing(t *testing.T) {
+	tx := useEmptyAuditLog(t)
+	entry := appendTestEntry(t, nil, day)
+
+	run := archiveOlderThan(t, tx, 30*day)
+	if run.Archived != 0 || run.LastID != nil {
+		t.Fatalf("archived %d entries through %v, want none", run.Archived, run.LastID)
+	}
+	if ids := tableIDs(t, tx); len(ids) != 1 || ids[0] != entry.ID {
+		t.Fatalf("entries left = %v, want %d", ids, entry.ID)
+	}
+	next := appendTestEntry(t, nil, 0)
+	if *next.PrevHash != *entry.EntryHash {
+		t.Errorf("prev_hash = %q, want %q", *next.PrevHash, *entry.EntryHash)
+	}
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// auditChainLock is the advisory lock key serializing chain appends of all
+// backend instances
+const auditChainLock = 0x61756469
+
+// auditBatchSize is the number of entries read at a time when walking the log
+const auditBatchSize = 1000
+
+// AuditChainReport is the result of walking the audit hash chain
+type AuditChainReport struct {
+	Valid bool `json:"valid"`
+	// Checked counts the chained entries verified
+	Checked int64 `json:"checked"`
+	// Unchained counts entries written before the chain was introduced
+	Unchained int64 `json:"unchained"`
+	// Archived counts entries moved to archive files, Held the archived
+	// entries kept in the table under legal hold
+	Archived int64 `json:"archived"`
+	Held     int64 `json:"held"`
+	// Anchor is the prev_hash of the first chained entry: empty when the
+	// chain starts in the table, the hash of the last archived entry otherwise
+	Anchor string `json:"anchor"`
+	// HeadID and HeadHash identify the last entry. Truncating the end of the
+	// chain is only detectable against a head recorded elsewhere.
+	HeadID     uint             `json:"head_id,omitempty"`
+	HeadHash   string           `json:"head_hash,omitempty"`
+	Broken     *AuditChainBreak `json:"broken,omitempty"`
+	VerifiedAt time.Time        `json:"verified_at"`
+}
+
+// AuditChainBreak is the first entry that does not fit the chain
+type AuditChainBreak struct {
+	ID       uint   `json:"id"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// auditHash returns the chain hash of an entry: SHA-256 over its prev_hash
+// and content. Timestamps count in microseconds, the precision stored.
+func auditHash(entry *models.AuditLog) string {
+	prevHash := ""
+	if entry.PrevHash != nil {
+		prevHash = *entry.PrevHash
+	}
+	content := []interface{}{
+		prevHash,
+		entry.UserID,
+		entry.Action,
+		entry.Resource,
+		entry.QueryText,
+		entry.SQLExecuted,
+		entry.ResultCount,
+		normalizeIP(entry.IPAddress),
+		entry.UserAgent,
+		entry.Status,
+		entry.ErrorMessage,
+		entry.ExecutionTimeMs,
+		entry.Timestamp.UnixMicro(),
+	}
+	data, _ := json.Marshal(content)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeIP returns the canonical form of an IP address, or nil when it
+// is missing or not an address
+func normalizeIP(address *string) *string {
+	if address == nil {
+		return nil
+	}
+	ip := net.ParseIP(*address)
+	if ip == nil {
+		return nil
+	}
+	text := ip.String()
+	return &text
+}
+
+// appendAuditEntry links an entry to the head of the chain and inserts it
+func appendAuditEntry(entry *models.AuditLog) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLock).Error; err != nil {
+			return err
+		}
+
+		prevHash, err := auditChainHead(tx)
+		if err != nil {
+			return fmt.Errorf("failed to read chain head: %w", err)
+		}
+
+		sealAuditEntry(entry, prevHash)
+		return tx.Create(entry).Error
+	})
+}
+
+// sealAuditEntry brings an entry to the form it is stored in and links it
+// to prevHash
+func sealAuditEntry(entry *models.AuditLog, prevHash string) {
+	// Stored as timestamp without time zone, with microsecond precision
+	entry.Timestamp = entry.Timestamp.UTC().Truncate(time.Microsecond)
+	entry.IPAddress = normalizeIP(entry.IPAddress)
+
+	entry.PrevHash = &prevHash
+	hash := auditHash(entry)
+	entry.EntryHash = &hash
+}
+
+// auditChainHead returns the hash the next entry links to: that of the last
+// chained entry after the archived ranges, or the last archived hash when
+// archival removed all of them. Entries kept under legal hold inside an
+// archived range are never the head.
+func auditChainHead(tx *gorm.DB) (string, error) {
+	run, err := lastArchiveRun(tx)
+	if err != nil {
+		return "", err
+	}
+	var archivedThrough uint
+	anchor := ""
+	if run != nil {
+		archivedThrough = *run.LastID
+		anchor = hashValue(run.LastHash)
+	}
+
+	var head models.AuditLog
+	err = tx.Select("entry_hash").Where("entry_hash IS NOT NULL AND id > ?", archivedThrough).Order("id DESC").Take(&head).Error
+	switch {
+	case err == nil:
+		return *head.EntryHash, nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return anchor, nil
+	}
+	return "", err
+}
+
+// VerifyChain walks the audit log in id order, recomputing every hash, and
+// reports the first entry that was edited, removed from or inserted into
+// the middle of the chain. Archived ranges must follow each other, and the
+// table continues from the last hash of the last one; entries kept under
+// legal hold in an archived range are only checked against their own hash.
+func (s *AuditService) VerifyChain() (*AuditChainReport, error) {
+	var runs []models.AuditArchiveRun
+	if err := database.DB.Where("status = ? AND last_id IS NOT NULL", ArchiveCompleted).Order("last_id ASC").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	v := newAuditChainVerifier(runs)
+	if !v.report.Valid {
+		return v.report, nil
+	}
+
+	var lastID uint
+	for {
+		var batch []models.AuditLog
+		if err := database.DB.Where("id > ?", lastID).Order("id ASC").Limit(auditBatchSize).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for i := range batch {
+			lastID = batch[i].ID
+			if !v.check(&batch[i]) {
+				return v.report, nil
+			}
+		}
+	}
+
+	v.report.VerifiedAt = time.Now()
+	return v.report, nil
+}
+
+// auditChainVerifier checks the entries of the table in id order against
+// the chain anchored by the archive runs
+type auditChainVerifier struct {
+	report *AuditChainReport
+	// expected is the prev_hash the next chained entry must have, nil until
+	// the chain starts
+	expected        *string
+	expectedFrom    string
+	archivedThrough uint
+}
+
+// newAuditChainVerifier checks that the completed runs, in last_id order,
+// follow each other and anchors the chain on the last one
+func newAuditChainVerifier(runs []models.AuditArchiveRun) *auditChainVerifier {
+	v := &auditChainVerifier{report: &AuditChainReport{Valid: true}}
+	for i, run := range runs {
+		if i > 0 && hashValue(run.PrevHash) != hashValue(runs[i-1].LastHash) {
+			v.report.fail(*run.FirstID, fmt.Sprintf("archive run %d does not continue archive run %d", run.ID, runs[i-1].ID), hashValue(runs[i-1].LastHash), hashValue(run.PrevHash))
+			return v
+		}
+		v.report.Archived += int64(run.Archived)
+		v.archivedThrough = *run.LastID
+		v.expected = run.LastHash
+		v.expectedFrom = fmt.Sprintf("the last entry of archive run %d", run.ID)
+	}
+	if v.expected != nil {
+		v.report.Anchor = *v.expected
+	}
+	return v
+}
+
+// check verifies the next entry of the table, returning false once the
+// chain is broken
+func (v *auditChainVerifier) check(entry *models.AuditLog) bool {
+	report := v.report
+	if entry.ID <= v.archivedThrough {
+		if entry.EntryHash != nil {
+			if hash := auditHash(entry); hash != *entry.EntryHash {
+				report.fail(entry.ID, "content of an entry under legal hold does not match entry_hash; the entry was modified", hash, *entry.EntryHash)
+				return false
+			}
+		}
+		report.Held++
+		return true
+	}
+
+	if entry.EntryHash == nil {
+		if v.expected == nil {
+			report.Unchained++
+			return true
+		}
+		report.fail(entry.ID, "entry has no hash but follows chained entries", "", "")
+		return false
+	}
+
+	prevHash := hashValue(entry.PrevHash)
+	if v.expected == nil {
+		if report.Checked == 0 {
+			report.Anchor = prevHash
+		}
+	} else if prevHash != *v.expected {
+		report.fail(entry.ID, fmt.Sprintf("prev_hash does not match %s; entries were removed or inserted", v.expectedFrom), *v.expected, prevHash)
+		return false
+	}
+
+	if hash := auditHash(entry); hash != *entry.EntryHash {
+		report.fail(entry.ID, "content does not match entry_hash; the entry was modified", hash, *entry.EntryHash)
+		return false
+	}
+
+	report.Checked++
+	report.HeadID = entry.ID
+	report.HeadHash = *entry.EntryHash
+	v.expected = entry.EntryHash
+	v.expectedFrom = fmt.Sprintf("entry %d", entry.ID)
+	return true
+}
+
+// hashValue returns a stored hash, empty when missing
+func hashValue(hash *string) string {
+	if hash == nil {
+		return ""
+	}
+	return *hash
+}
+
+func (r *AuditChainReport) fail(id uint, reason, expected, actual string) {
+	r.Valid = false
+	r.Broken = &AuditChainBreak{ID: id, Reason: reason, Expected: expected, Actual: actual}
+	r.VerifiedAt = time.Now()
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"mastercard-backend/internal/models"
+)
+
+// chainEntries returns n entries with ids from firstID on, linked from
+// prevHash as appendAuditEntry links them
+func chainEntries(firstID uint, prevHash string, n int) []models.AuditLog {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := make([]models.AuditLog, n)
+	for i := range entries {
+		count := i
+		status := "success"
+		entry := &entries[i]
+		*entry = models.AuditLog{
+			ID:          firstID + uint(i),
+			Action:      "query",
+			ResultCount: &count,
+			Status:      &status,
+			Timestamp:   base.Add(time.Duration(i) * time.Minute),
+		}
+		sealAuditEntry(entry, prevHash)
+		prevHash = *entry.EntryHash
+	}
+	return entries
+}
+
+// unchainedEntries returns n entries written before the chain existed
+func unchainedEntries(firstID uint, n int) []models.AuditLog {
+	entries := make([]models.AuditLog, n)
+	for i := range entries {
+		entries[i] = models.AuditLog{ID: firstID + uint(i), Action: "login", Timestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	}
+	return entries
+}
+
+// archiveRun returns a completed run that archived entries
+func archiveRun(id uint, entries []models.AuditLog) models.AuditArchiveRun {
+	first, last := entries[0], entries[len(entries)-1]
+	return models.AuditArchiveRun{
+		ID:       id,
+		Status:   ArchiveCompleted,
+		FirstID:  &first.ID,
+		LastID:   &last.ID,
+		PrevHash: first.PrevHash,
+		LastHash: last.EntryHash,
+		Archived: len(entries),
+	}
+}
+
+func verifyEntries(runs []models.AuditArchiveRun, entries []models.AuditLog) *AuditChainReport {
+	v := newAuditChainVerifier(runs)
+	if !v.report.Valid {
+		return v.report
+	}
+	for i := range entries {
+		if !v.check(&entries[i]) {
+			break
+		}
+	}
+	return v.report
+}
+
+// join concatenates entry lists
+func join(lists ...[]models.AuditLog) []models.AuditLog {
+	var entries []models.AuditLog
+	for _, list := range lists {
+		entries = append(entries, list...)
+	}
+	return entries
+}
+
+func expectBroken(t *testing.T, report *AuditChainReport, id uint, reason string) {
+	t.Helper()
+	if report.Valid || report.Broken == nil {
+		t.Fatalf("chain is valid, want a break at entry %d", id)
+	}
+	if report.Broken.ID != id || !strings.Contains(report.Broken.Reason, reason) {
+		t.Errorf("broken = %+v, want entry %d: ...%s...", report.Broken, id, reason)
+	}
+}
+
+func expectValid(t *testing.T, report *AuditChainReport) {
+	t.Helper()
+	if !report.Valid {
+		t.Fatalf("chain is broken: %+v", report.Broken)
+	}
+}
+
+func TestAuditHashSurvivesStorage(t *testing.T) {
+	ip := "::ffff:10.0.0.1"
+	agent := "curl/8.0"
+	entry := models.AuditLog{
+		ID:        7,
+		Action:    "login",
+		IPAddress: &ip,
+		UserAgent: &agent,
+		// Nanoseconds and a zone, as time.Now() returns them
+		Timestamp: time.Date(2026, 3, 1, 13, 30, 15, 123456789, time.FixedZone("CET", 3600)),
+	}
+	sealAuditEntry(&entry, "")
+
+	if entry.Timestamp.Location() != time.UTC || entry.Timestamp.Nanosecond() != 123456000 {
+		t.Errorf("sealed timestamp = %v, want UTC with microseconds", entry.Timestamp)
+	}
+	if *entry.IPAddress != "10.0.0.1" {
+		t.Errorf("sealed IP = %s, want the canonical 10.0.0.1", *entry.IPAddress)
+	}
+
+	// Read back from timestamp without time zone and inet columns
+	stored := entry
+	wall := entry.Timestamp
+	stored.Timestamp = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), time.UTC)
+	storedIP := "10.0.0.1"
+	stored.IPAddress = &storedIP
+	if hash := auditHash(&stored); hash != *entry.EntryHash {
+		t.Errorf("stored entry hashes to %s, sealed %s", hash, *entry.EntryHash)
+	}
+
+	// Written to and read from an archive file
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archived models.AuditLog
+	if err := json.Unmarshal(data, &archived); err != nil {
+		t.Fatal(err)
+	}
+	if hash := auditHash(&archived); hash != *entry.EntryHash {
+		t.Errorf("archived entry hashes to %s, sealed %s", hash, *entry.EntryHash)
+	}
+}
+
+func TestAuditHashCoversContentAndLink(t *testing.T) {
+	entry := chainEntries(1, "", 1)[0]
+	hash := *entry.EntryHash
+
+	edited := entry
+	status := "error"
+	edited.Status = &status
+	if auditHash(&edited) == hash {
+		t.Error("editing the status keeps the hash")
+	}
+
+	relinked := entry
+	other := "00"
+	relinked.PrevHash = &other
+	if auditHash(&relinked) == hash {
+		t.Error("changing prev_hash keeps the hash")
+	}
+
+	later := entry
+	later.Timestamp = entry.Timestamp.Add(time.Microsecond)
+	if auditHash(&later) == hash {
+		t.Error("moving the timestamp by a microsecond keeps the hash")
+	}
+}
+
+func TestVerifyChainAcceptsIntactChain(t *testing.T) {
+	entries := chainEntries(1, "", 5)
+	report := verifyEntries(nil, entries)
+	expectValid(t, report)
+	if report.Checked != 5 || report.Anchor != "" || report.HeadID != 5 || report.HeadHash != *entries[4].EntryHash {
+		t.Errorf("report = %+v, want 5 checked up to entry 5", report)
+	}
+}
+
+func TestVerifyChainDetectsEditedEntry(t *testing.T) {
+	entries := chainEntries(1, "", 5)
+	count := 1000
+	entries[2].ResultCount = &count
+
+	expectBroken(t, verifyEntries(nil, entries), 3, "was modified")
+}
+
+func TestVerifyChainDetectsDeletedEntry(t *testing.T) {
+	entries := chainEntries(1, "", 5)
+	entries = append(entries[:2], entries[3:]...)
+
+	expectBroken(t, verifyEntries(nil, entries), 4, "removed or inserted")
+}
+
+func TestVerifyChainDetectsInsertedEntry(t *testing.T) {
+	entries := chainEntries(1, "", 4)
+	forged := chainEntries(10, *entries[1].EntryHash, 1)[0]
+	entries = join(entries[:2], []models.AuditLog{forged}, entries[2:])
+
+	expectBroken(t, verifyEntries(nil, entries), 3, "removed or inserted")
+}
+
+func TestVerifyChainCountsUnchainedPrefix(t *testing.T) {
+	entries := join(unchainedEntries(1, 2), chainEntries(3, "", 3))
+	report := verifyEntries(nil, entries)
+	expectValid(t, report)
+	if report.Unchained != 2 || report.Checked != 3 {
+		t.Errorf("unchained/checked = %d/%d, want 2/3", report.Unchained, report.Checked)
+	}
+}
+
+func TestVerifyChainRejectsUnchainedEntryAfterChain(t *testing.T) {
+	entries := join(chainEntries(1, "", 2), unchainedEntries(3, 1))
+	expectBroken(t, verifyEntries(nil, entries), 3, "no hash")
+}
+
+func TestVerifyChainContinuesAcrossArchiveRuns(t *testing.T) {
+	entries := chainEntries(1, "", 8)
+	runs := []models.AuditArchiveRun{archiveRun(1, entries[:3]), archiveRun(2, entries[3:5])}
+
+	report := verifyEntries(runs, entries[5:])
+	expectValid(t, report)
+	if report.Archived != 5 || report.Checked != 3 || report.Anchor != *entries[4].EntryHash {
+		t.Errorf("report = %+v, want 5 archived, 3 checked, anchored on entry 5", report)
+	}
+}
+
+func TestVerifyChainContinuesFromArchiveAfterTheHeadIsArchived(t *testing.T) {
+	archived := chainEntries(1, "", 3)
+	runs := []models.AuditArchiveRun{archiveRun(1, archived)}
+
+	// The next entry must link to the run, not restart the chain
+	next := chainEntries(4, *archived[2].EntryHash, 1)
+	expectValid(t, verifyEntries(runs, next))
+
+	restarted := chainEntries(4, "", 1)
+	expectBroken(t, verifyEntries(runs, restarted), 4, "archive run 1")
+}
+
+func TestVerifyChainChecksHeldEntriesInArchivedRange(t *testing.T) {
+	entries := chainEntries(1, "", 5)
+	runs := []models.AuditArchiveRun{archiveRun(1, entries[:3])}
+
+	// Entry 2 stayed in the table under legal hold
+	table := join(entries[1:2], entries[3:])
+	report := verifyEntries(runs, table)
+	expectValid(t, report)
+	if report.Held != 1 || report.Checked != 2 {
+		t.Errorf("held/checked = %d/%d, want 1/2", report.Held, report.Checked)
+	}
+
+	// The next entry links to the run, not to the held entry
+	next := chainEntries(4, *entries[1].EntryHash, 1)
+	expectBroken(t, verifyEntries(runs, join(entries[1:2], next)), 4, "archive run 1")
+
+	status := "denied"
+	table[0].Status = &status
+	expectBroken(t, verifyEntries(runs, table), 2, "legal hold")
+}
+
+func TestVerifyChainRejectsGapBetweenArchiveRuns(t *testing.T) {
+	entries := chainEntries(1, "", 6)
+	runs := []models.AuditArchiveRun{archiveRun(1, entries[:2]), archiveRun(2, entries[3:5])}
+
+	expectBroken(t, verifyEntries(runs, entries[5:]), 4, "does not continue archive run 1")
+}
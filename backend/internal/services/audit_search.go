@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// AuditLogFilter selects audit entries; zero fields match everything
+type AuditLogFilter struct {
+	UserID   *uint
+	Action   string
+	Resource string
+	Status   string
+	// From is inclusive, To exclusive
+	From *time.Time
+	To   *time.Time
+	// IPAddress is an address or a CIDR range
+	IPAddress string
+	// Search matches query_text or sql_executed, case-insensitively
+	Search         string
+	MinExecutionMs *int
+}
+
+// ValidateIPFilter checks that an IP filter is an address or a CIDR range
+func ValidateIPFilter(value string) error {
+	if net.ParseIP(value) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid IP address or CIDR range %q", value)
+}
+
+// apply adds the filter conditions to an audit_logs query
+func (f *AuditLogFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.UserID != nil {
+		query = query.Where("user_id = ?", *f.UserID)
+	}
+	if f.Action != "" {
+		query = query.Where("action = ?", f.Action)
+	}
+	if f.Resource != "" {
+		query = query.Where("resource = ?", f.Resource)
+	}
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	// Timestamps are stored in UTC
+	if f.From != nil {
+		query = query.Where("timestamp >= ?", f.From.UTC())
+	}
+	if f.To != nil {
+		query = query.Where("timestamp < ?", f.To.UTC())
+	}
+	if f.IPAddress != "" {
+		query = query.Where("ip_address <<= ?::inet", f.IPAddress)
+	}
+	if f.Search != "" {
+		pattern := "%" + likeEscaper.Replace(f.Search) + "%"
+		query = query.Where("(query_text ILIKE ? OR sql_executed ILIKE ?)", pattern, pattern)
+	}
+	if f.MinExecutionMs != nil {
+		query = query.Where("execution_time_ms >= ?", *f.MinExecutionMs)
+	}
+	return query
+}
+
+// likeEscaper escapes the LIKE wildcards of a search term
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetAuditLogs returns a page of the entries matching filter, newest first.
+// Pages are keyed by id: before is the next cursor of the previous page,
+// 0 for the first one. The next cursor is 0 on the last page.
+func (s *AuditService) GetAuditLogs(filter *AuditLogFilter, limit int, before uint) ([]models.AuditLog, uint, error) {
+	var logs []models.AuditLog
+
+	query := filter.apply(database.DB.Model(&models.AuditLog{}))
+	if before > 0 {
+		query = query.Where("id < ?", before)
+	}
+
+	// One more entry tells whether there is a next page
+	if err := query.Order("id DESC").
+		Limit(limit + 1).
+		Preload("User").
+		Find(&logs).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var next uint
+	if len(logs) > limit {
+		logs = logs[:limit]
+		next = logs[limit-1].ID
+	}
+	return logs, next, nil
+}
+
+// auditCSVHeader is the header line of audit exports
+var auditCSVHeader = []string{
+	"id", "timestamp", "user_id", "user_email", "action", "resource", "status",
+	"ip_address", "user_agent", "query_text", "sql_executed", "result_count",
+	"execution_time_ms", "error_message", "entry_hash",
+}
+
+// ExportAuditLogs writes the entries matching filter to w as CSV, oldest
+// first, reading them in batches so exports of any size stream in bounded
+// memory. It returns the number of entries written.
+func (s *AuditService) ExportAuditLogs(ctx context.Context, filter *AuditLogFilter, w io.Writer) (int, error) {
+	out := csv.NewWriter(w)
+	if err := out.Write(auditCSVHeader); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var lastID uint
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		var batch []models.AuditLog
+		query := filter.apply(database.DB.WithContext(ctx).Model(&models.AuditLog{}))
+		if err := query.Where("id > ?", lastID).
+			Order("id ASC").
+			Limit(auditBatchSize).
+			Preload("User").
+			Find(&batch).Error; err != nil {
+			return count, err
+		}
+
+		for i := range batch {
+			if err := out.Write(auditCSVRecord(&batch[i])); err != nil {
+				return count, err
+			}
+			count++
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			return count, err
+		}
+
+		if len(batch) < auditBatchSize {
+			return count, nil
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+func auditCSVRecord(entry *models.AuditLog) []string {
+	email := ""
+	if entry.User != nil {
+		email = entry.User.Email
+	}
+	return []string{
+		strconv.FormatUint(uint64(entry.ID), 10),
+		entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		csvUint(entry.UserID),
+		csvText(&email),
+		csvText(&entry.Action),
+		csvText(entry.Resource),
+		csvText(entry.Status),
+		csvText(entry.IPAddress),
+		csvText(entry.UserAgent),
+		csvText(entry.QueryText),
+		csvText(entry.SQLExecuted),
+		csvInt(entry.ResultCount),
+		csvInt(entry.ExecutionTimeMs),
+		csvText(entry.ErrorMessage),
+		csvText(entry.EntryHash),
+	}
+}
+
+// csvText formats a text cell. Entries carry user input, so cells a
+// spreadsheet would read as a formula are prefixed with a quote.
+func csvText(value *string) string {
+	if value == nil || *value == "" {
+		return ""
+	}
+	switch (*value)[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + *value
+	}
+	return *value
+}
+
+func csvInt(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
+
+func csvUint(value *uint) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*value), 10)
+}
@@ -1,20 +1,150 @@
 package services
 
 import (
-	"mastercard-backend/internal/database"
+	"context"
+	"errors"
+	"fmt"
+	"mastercard-backend/internal/config"
 	"mastercard-backend/internal/models"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Audit statuses
+const (
+	AuditSuccess = "success"
+	AuditError   = "error"
+	AuditDenied  = "denied"
+	// AuditPending marks a query held back by the cost guard
+	AuditPending = "pending"
+)
+
+var (
+	// ErrAuditLogClosed is returned for entries logged after CloseAuditLog
+	ErrAuditLogClosed = errors.New("audit log is closed")
+	// ErrAuditQueueFull is returned for entries dropped because the queue
+	// stayed full for AUDIT_QUEUE_TIMEOUT
+	ErrAuditQueueFull = errors.New("audit queue is full")
+)
+
 type AuditService struct{}
 
 func NewAuditService() *AuditService {
+	startAuditLog()
 	return &AuditService{}
 }
 
-// LogAction logs an action to the audit log
+// auditWriter appends audit entries to the hash chain on a background
+// goroutine, in the order they were logged, so a slow insert never blocks
+// a request, then forwards them to the configured sinks
+type auditWriter struct {
+	mu      sync.RWMutex
+	closed  bool
+	entries chan models.AuditLog
+	// timeout is how long enqueue waits for room in a full queue
+	timeout    time.Duration
+	dropped    int64
+	done       chan struct{}
+	forwarders []*auditForwarder
+}
+
+var (
+	auditLog     *auditWriter
+	auditLogOnce sync.Once
+)
+
+// startAuditLog starts the shared writer of all AuditService values
+func startAuditLog() {
+	auditLogOnce.Do(func() {
+		auditLog = newAuditWriter(config.AppConfig.AuditQueueSize, config.AppConfig.AuditQueueTimeout, newAuditForwarders(config.AppConfig))
+	})
+}
+
+func newAuditWriter(queueSize int, timeout time.Duration, forwarders []*auditForwarder) *auditWriter {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	w := &auditWriter{
+		entries:    make(chan models.AuditLog, queueSize),
+		timeout:    timeout,
+		done:       make(chan struct{}),
+		forwarders: forwarders,
+	}
+	go w.run()
+	return w
+}
+
+func (w *auditWriter) run() {
+	defer close(w.done)
+	for entry := range w.entries {
+		if err := appendAuditEntry(&entry); err != nil {
+			fmt.Printf("Warning: Failed to write audit log (%s by user %v): %v\n", entry.Action, entry.UserID, err)
+		}
+		// Sinks also get entries the table refused, without id and hashes
+		for _, f := range w.forwarders {
+			f.forward(entry)
+		}
+	}
+}
+
+func (w *auditWriter) enqueue(entry models.AuditLog) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrAuditLogClosed
+	}
+
+	select {
+	case w.entries <- entry:
+		return nil
+	default:
+	}
+
+	// The queue is full: the request waits a bounded time for room, so
+	// entries stay in order and none is left behind on shutdown
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+	select {
+	case w.entries <- entry:
+		return nil
+	case <-timer.C:
+		dropped := atomic.AddInt64(&w.dropped, 1)
+		return fmt.Errorf("%w, dropped %s entry (%d dropped)", ErrAuditQueueFull, entry.Action, dropped)
+	}
+}
+
+// close stops accepting entries and waits until the queued ones are written
+// and forwarded
+func (w *auditWriter) close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.entries)
+	<-w.done
+	for _, f := range w.forwarders {
+		f.close()
+	}
+}
+
+// CloseAuditLog writes the pending audit entries and stops the writer; call
+// it on shutdown once no more requests are served
+func CloseAuditLog() {
+	if auditLog != nil {
+		auditLog.close()
+	}
+}
+
+// LogAction queues an action for the audit log. The entry is timestamped
+// now and inserted in the background; insert failures are only reported
+// as warnings.
 func (s *AuditService) LogAction(userID *uint, action, resource string, queryText, sqlExecuted *string, resultCount *int, ipAddress, userAgent *string, status string, errorMessage *string, executionTimeMs *int) error {
-	auditLog := models.AuditLog{
+	entry := models.AuditLog{
 		UserID:          userID,
 		Action:          action,
 		Resource:        &resource,
@@ -29,46 +159,35 @@ func (s *AuditService) LogAction(userID *uint, action, resource string, queryTex
 		Timestamp:       time.Now(),
 	}
 
-	return database.DB.Create(&auditLog).Error
+	startAuditLog()
+	return auditLog.enqueue(entry)
 }
 
-// GetAuditLogs retrieves audit logs with filtering
-func (s *AuditService) GetAuditLogs(userID *uint, limit, offset int, action, resource, status *string) ([]models.AuditLog, int64, error) {
-	var logs []models.AuditLog
-	var total int64
-
-	query := database.DB.Model(&models.AuditLog{})
+// ClientInfo identifies the client of a request in audit entries
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+}
 
-	if userID != nil {
-		query = query.Where("user_id = ?", *userID)
-	}
+type clientInfoKey struct{}
 
-	if action != nil {
-		query = query.Where("action = ?", *action)
-	}
-
-	if resource != nil {
-		query = query.Where("resource = ?", *resource)
-	}
+// WithClientInfo returns a context carrying the client of a request, for
+// services that audit work done on its behalf
+func WithClientInfo(ctx context.Context, ipAddress, userAgent string) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, ClientInfo{IPAddress: ipAddress, UserAgent: userAgent})
+}
 
-	if status != nil {
-		query = query.Where("status = ?", *status)
+// clientInfo returns the client IP address and user agent of ctx, nil when unknown
+func clientInfo(ctx context.Context) (ipAddress, userAgent *string) {
+	info, ok := ctx.Value(clientInfoKey{}).(ClientInfo)
+	if !ok {
+		return nil, nil
 	}
-
-	// Count total
-	if err := query.Count(&total).Error; err != nil {
-		return nil, 0, err
+	if info.IPAddress != "" {
+		ipAddress = &info.IPAddress
 	}
-
-	// Get logs
-	if err := query.Order("timestamp DESC").
-		Limit(limit).
-		Offset(offset).
-		Preload("User").
-		Find(&logs).Error; err != nil {
-		return nil, 0, err
+	if info.UserAgent != "" {
+		userAgent = &info.UserAgent
 	}
-
-	return logs, total, nil
+	return ipAddress, userAgent
 }
-
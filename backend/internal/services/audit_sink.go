@@ -0,0 +1,402 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/models"
+)
+
+// AuditSink receives a copy of every audit entry, e.g. for a SIEM. The
+// audit_logs table stays the system of record: entries a sink cannot take
+// after its retries are dropped with a warning.
+type AuditSink interface {
+	Name() string
+	Send(entry *models.AuditLog) error
+	Close() error
+}
+
+// auditSinkConfig is how entries are buffered and retried for each sink
+type auditSinkConfig struct {
+	bufferSize int
+	maxRetries int
+	retryDelay time.Duration
+}
+
+// newAuditForwarders creates the sinks enabled in the configuration; sinks
+// that cannot be set up are skipped with a warning
+func newAuditForwarders(cfg *config.Config) []*auditForwarder {
+	var sinks []AuditSink
+	if cfg.AuditSyslogAddress != "" {
+		sink, err := newSyslogSink(cfg.AuditSyslogNetwork, cfg.AuditSyslogAddress, cfg.AppName, cfg.AuditSinkTimeout)
+		if err != nil {
+			fmt.Printf("Warning: Audit syslog sink disabled: %v\n", err)
+		} else {
+			sinks = append(sinks, sink)
+		}
+	}
+	if cfg.AuditFilePath != "" {
+		sink, err := newFileSink(cfg.AuditFilePath)
+		if err != nil {
+			fmt.Printf("Warning: Audit file sink disabled: %v\n", err)
+		} else {
+			sinks = append(sinks, sink)
+		}
+	}
+	if cfg.AuditWebhookURL != "" {
+		sinks = append(sinks, newWebhookSink(cfg.AuditWebhookURL, cfg.AuditWebhookToken, cfg.AuditSinkTimeout))
+	}
+
+	sinkConfig := auditSinkConfig{
+		bufferSize: cfg.AuditSinkBufferSize,
+		maxRetries: cfg.AuditSinkMaxRetries,
+		retryDelay: cfg.AuditSinkRetryDelay,
+	}
+	forwarders := make([]*auditForwarder, len(sinks))
+	for i, sink := range sinks {
+		forwarders[i] = newAuditForwarder(sink, sinkConfig)
+	}
+	return forwarders
+}
+
+// auditForwarder feeds one sink from its own buffer, so a slow or
+// unreachable sink never delays the audit table or the other sinks
+type auditForwarder struct {
+	sink    AuditSink
+	config  auditSinkConfig
+	entries chan models.AuditLog
+	stop    chan struct{}
+	done    chan struct{}
+	dropped int64
+}
+
+func newAuditForwarder(sink AuditSink, cfg auditSinkConfig) *auditForwarder {
+	if cfg.bufferSize < 1 {
+		cfg.bufferSize = 1
+	}
+	f := &auditForwarder{
+		sink:    sink,
+		config:  cfg,
+		entries: make(chan models.AuditLog, cfg.bufferSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+// forward buffers an entry for the sink, dropping it when the buffer is full
+func (f *auditForwarder) forward(entry models.AuditLog) {
+	select {
+	case f.entries <- entry:
+	default:
+		f.dropped++
+		fmt.Printf("Warning: Audit sink %s buffer full, dropped entry %d (%d dropped)\n", f.sink.Name(), entry.ID, f.dropped)
+	}
+}
+
+func (f *auditForwarder) run() {
+	defer close(f.done)
+	for entry := range f.entries {
+		if err := f.send(&entry); err != nil {
+			fmt.Printf("Warning: Audit sink %s dropped entry %d: %v\n", f.sink.Name(), entry.ID, err)
+		}
+	}
+}
+
+// send delivers an entry, retrying with exponential backoff. Once the
+// forwarder is closing, entries get a single attempt.
+func (f *auditForwarder) send(entry *models.AuditLog) error {
+	delay := f.config.retryDelay
+	for attempt := 0; ; attempt++ {
+		err := f.sink.Send(entry)
+		if err == nil || attempt >= f.config.maxRetries {
+			return err
+		}
+
+		select {
+		case <-f.stop:
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// close sends the buffered entries and closes the sink. Called by the
+// audit writer once it has stopped forwarding.
+func (f *auditForwarder) close() {
+	close(f.stop)
+	close(f.entries)
+	<-f.done
+	if err := f.sink.Close(); err != nil {
+		fmt.Printf("Warning: Failed to close audit sink %s: %v\n", f.sink.Name(), err)
+	}
+}
+
+// syslogSink sends entries as RFC 5424 messages, framed by octet counting
+// (RFC 6587) over TCP and one per datagram over UDP
+type syslogSink struct {
+	network  string
+	address  string
+	appName  string
+	hostname string
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Syslog facility of the entries: log audit (13)
+const syslogFacility = 13
+
+func newSyslogSink(network, address, appName string, timeout time.Duration) (*syslogSink, error) {
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("unsupported network %q, use udp or tcp", network)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogSink{
+		network:  network,
+		address:  address,
+		appName:  syslogName(appName, 48),
+		hostname: syslogName(hostname, 255),
+		timeout:  timeout,
+	}, nil
+}
+
+func (s *syslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *syslogSink) Send(entry *models.AuditLog) error {
+	message, err := s.format(entry)
+	if err != nil {
+		return err
+	}
+	if s.network == "tcp" {
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err := s.conn.Write(message); err != nil {
+		// Reconnect on the next attempt
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// format renders an entry as an RFC 5424 message: the action is the
+// MSGID, the main fields are structured data and the message is the entry
+// as JSON
+func (s *syslogSink) format(entry *models.AuditLog) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	status := ""
+	if entry.Status != nil {
+		status = *entry.Status
+	}
+	priority := syslogFacility*8 + syslogSeverity(status)
+
+	var params strings.Builder
+	param := func(name string, value string) {
+		fmt.Fprintf(&params, " %s=\"%s\"", name, syslogEscape(value))
+	}
+	param("id", strconv.FormatUint(uint64(entry.ID), 10))
+	if entry.UserID != nil {
+		param("user", strconv.FormatUint(uint64(*entry.UserID), 10))
+	}
+	if entry.Resource != nil {
+		param("resource", *entry.Resource)
+	}
+	if status != "" {
+		param("status", status)
+	}
+	if entry.IPAddress != nil {
+		param("ip", *entry.IPAddress)
+	}
+	if entry.EntryHash != nil {
+		param("hash", *entry.EntryHash)
+	}
+
+	// 32473 is the example enterprise number of RFC 5612
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s [audit@32473%s] ",
+		priority,
+		entry.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname,
+		s.appName,
+		os.Getpid(),
+		syslogName(entry.Action, 32),
+		params.String(),
+	)
+	return append([]byte(header), data...), nil
+}
+
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// syslogSeverity maps an audit status to a syslog severity
+func syslogSeverity(status string) int {
+	switch status {
+	case AuditError:
+		return 3
+	case AuditDenied:
+		return 4
+	case AuditPending:
+		return 5
+	}
+	return 6
+}
+
+// syslogName makes a header field of printable ASCII without spaces, at
+// most max characters long, "-" when empty
+func syslogName(value string, max int) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(name) > max {
+		name = name[:max]
+	}
+	if name == "" {
+		return "-"
+	}
+	return name
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogEscape escapes a structured data parameter value
+func syslogEscape(value string) string {
+	return syslogParamEscaper.Replace(value)
+}
+
+// fileSink appends entries to a file as JSON lines
+type fileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (s *fileSink) Name() string {
+	return "file"
+}
+
+func (s *fileSink) Send(entry *models.AuditLog) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// webhookSink posts each entry as JSON to an HTTP endpoint
+type webhookSink struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+func newWebhookSink(url, token string, timeout time.Duration) *webhookSink {
+	return &webhookSink{
+		url:        url,
+		token:      token,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *webhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *webhookSink) Send(entry *models.AuditLog) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
@@ -1,20 +1,32 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"mastercard-backend/internal/database"
 	"mastercard-backend/internal/models"
 	"mastercard-backend/internal/utils"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-type AuthService struct{}
+var (
+	errInvalidCredentials = errors.New("invalid email or password")
+	errInactiveAccount    = errors.New("user account is inactive")
+)
+
+type AuthService struct {
+	audit *AuditService
+}
 
 func NewAuthService() *AuthService {
-	return &AuthService{}
+	return &AuthService{
+		audit: NewAuditService(),
+	}
 }
 
 // Register creates a new user account
@@ -52,65 +64,144 @@ func (s *AuthService) Register(email, password, fullName string, roleID *uint) (
 	return &user, nil
 }
 
-// Login authenticates a user and returns tokens
-func (s *AuthService) Login(email, password string) (*models.User, string, string, error) {
+// LoginResult is the outcome of a login: the tokens of a new session or,
+// when the user must pass MFA, the token of a challenge to complete with
+// CompleteMFALogin
+type LoginResult struct {
+	User         *models.User
+	AccessToken  string
+	RefreshToken string
+	MFAToken     string
+	// MFAEnrollment is set with MFAToken when the role of the user requires
+	// MFA and the user has not enrolled yet
+	MFAEnrollment bool
+	// RecoveryCodes are the codes of an MFA enrolment completed at login
+	RecoveryCodes []string
+}
+
+// Login authenticates a user and returns tokens, or an MFA challenge. Every
+// attempt is audit logged with the client of ctx. Failed attempts block the
+// account and the client IP for a growing backoff, then lock them out.
+func (s *AuthService) Login(ctx context.Context, email, password string) (*LoginResult, error) {
+	var user *models.User
+	var result *LoginResult
+	var locked []*models.LoginThrottle
+	ipAddress, _ := clientInfo(ctx)
+	// Checked first, recorded after: the password is not checked under a lock
+	err := checkLoginThrottle(database.DB, email, ipAddress)
+	var blocked *LoginBlockedError
+	if err == nil {
+		user, result, err = s.login(ctx, email, password)
+		var recordErr error
+		switch {
+		case errors.Is(err, errInvalidCredentials):
+			locked, recordErr = recordLoginFailure(email, ipAddress)
+		case err == nil && result.MFAToken == "":
+			recordErr = forgetAccountFailures(database.DB, email)
+		}
+		if recordErr != nil {
+			fmt.Printf("Warning: Failed to record login attempt: %v\n", recordErr)
+		}
+	} else if !errors.As(err, &blocked) {
+		fmt.Printf("Warning: Failed to check login throttle: %v\n", err)
+		err = errors.New("database error")
+	}
+
+	var userID *uint
+	status := AuditSuccess
+	var detail, errorMessage *string
+	if user != nil {
+		userID = &user.ID
+	}
+	if err != nil {
+		status = AuditError
+		if errors.Is(err, errInvalidCredentials) || errors.Is(err, errInactiveAccount) || blocked != nil {
+			status = AuditDenied
+		}
+		errorMessage = stringPtr(fmt.Sprintf("%v (email: %s)", err, email))
+	} else if result.MFAToken != "" {
+		detail = stringPtr("password verified, MFA challenge issued")
+	}
+	ipAddress, userAgent := clientInfo(ctx)
+	if logErr := s.audit.LogAction(userID, "login", "auth", detail, nil, nil, ipAddress, userAgent, status, errorMessage, nil); logErr != nil {
+		fmt.Printf("Warning: Failed to audit login: %v\n", logErr)
+	}
+	s.auditLockouts(ctx, userID, locked)
+
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// login checks the credentials and starts a session for the client of ctx,
+// or challenges users who must pass MFA; the user is returned with the error
+// when the account is known
+func (s *AuthService) login(ctx context.Context, email, password string) (*models.User, *LoginResult, error) {
 	var user models.User
 	if err := database.DB.Preload("Role").Where("email = ?", email).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, "", "", errors.New("invalid email or password")
+			return nil, nil, errInvalidCredentials
 		}
-		return nil, "", "", errors.New("database error")
+		return nil, nil, errors.New("database error")
 	}
 
 	if !user.IsActive {
-		return nil, "", "", errors.New("user account is inactive")
+		return &user, nil, errInactiveAccount
 	}
 
 	// Check password
 	if !utils.CheckPasswordHash(password, user.PasswordHash) {
-		return nil, "", "", errors.New("invalid email or password")
+		return &user, nil, errInvalidCredentials
 	}
 
-	// Generate tokens
-	accessToken, err := utils.GenerateAccessToken(user.ID, user.Email, user.RoleID)
-	if err != nil {
-		return nil, "", "", errors.New("failed to generate access token")
+	if mfaRequired(&user) {
+		mfaToken, err := issueMFAChallenge(user.ID)
+		if err != nil {
+			return &user, nil, errors.New("failed to generate MFA challenge")
+		}
+		return &user, &LoginResult{User: &user, MFAToken: mfaToken, MFAEnrollment: !user.MFAEnabled}, nil
 	}
 
-	refreshToken, err := utils.GenerateRefreshToken(user.ID)
+	accessToken, refreshToken, err := startSession(ctx, &user)
 	if err != nil {
-		return nil, "", "", errors.New("failed to generate refresh token")
+		return &user, nil, err
 	}
-
-	// Update last login
-	now := time.Now()
-	user.LastLogin = &now
-	database.DB.Save(&user)
-
-	return &user, accessToken, refreshToken, nil
+	return &user, &LoginResult{User: &user, AccessToken: accessToken, RefreshToken: refreshToken}, nil
 }
 
-// RefreshToken generates new access token from refresh token
-func (s *AuthService) RefreshToken(refreshToken string) (string, error) {
-	claims, err := utils.ValidateToken(refreshToken)
+// startSession completes a login: it starts a session for the client of ctx
+// and issues its access and refresh tokens
+func startSession(ctx context.Context, user *models.User) (string, string, error) {
+	// Each login starts a session, identified by its refresh token family
+	sessionID := uuid.NewString()
+	var refreshToken string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		expiresAt := refreshTokenExpiry()
+		if err := createSession(ctx, tx, sessionID, user.ID, expiresAt); err != nil {
+			return err
+		}
+		var err error
+		_, refreshToken, err = issueRefreshToken(tx, user.ID, sessionID, expiresAt)
+		return err
+	})
 	if err != nil {
-		return "", errors.New("invalid refresh token")
+		return "", "", errors.New("failed to generate refresh token")
 	}
+	// Expired tokens and sessions are only kept until the next login
+	expired := time.Now().UTC()
+	database.DB.Where("user_id = ? AND expires_at < ?", user.ID, expired).Delete(&models.RefreshToken{})
+	database.DB.Where("user_id = ? AND expires_at < ?", user.ID, expired).Delete(&models.Session{})
 
-	var user models.User
-	if err := database.DB.Preload("Role").First(&user, claims.UserID).Error; err != nil {
-		return "", errors.New("user not found")
-	}
-
-	if !user.IsActive {
-		return "", errors.New("user account is inactive")
-	}
-
-	accessToken, err := utils.GenerateAccessToken(user.ID, user.Email, user.RoleID)
+	accessToken, err := utils.GenerateAccessToken(user.ID, user.Email, user.RoleID, sessionID)
 	if err != nil {
-		return "", errors.New("failed to generate access token")
+		return "", "", errors.New("failed to generate access token")
 	}
 
-	return accessToken, nil
-}
+	// Update last login
+	now := time.Now()
+	user.LastLogin = &now
+	database.DB.Model(user).Update("last_login", now)
 
+	return accessToken, refreshToken, nil
+}
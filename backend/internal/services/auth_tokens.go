@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+	"mastercard-backend/internal/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token is presented
+	// after it was rotated; the whole token family is revoked
+	ErrRefreshTokenReused = errors.New("refresh token was already used, the session has been revoked")
+)
+
+// refreshTokenExpiry is the expiry of a refresh token issued now, in UTC
+// like every timestamp of refresh_tokens and sessions (stored without time
+// zone)
+func refreshTokenExpiry() time.Time {
+	// The exp claim has second precision
+	return time.Now().UTC().Add(config.AppConfig.JWTRefreshTokenExpiry).Truncate(time.Second)
+}
+
+// issueRefreshToken stores and signs a new refresh token of a family
+func issueRefreshToken(tx *gorm.DB, userID uint, familyID string, expiresAt time.Time) (*models.RefreshToken, string, error) {
+	token := &models.RefreshToken{
+		ID:        uuid.NewString(),
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: expiresAt.UTC(),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	signed, err := utils.GenerateRefreshToken(userID, token.ID, familyID, token.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := tx.Create(token).Error; err != nil {
+		return nil, "", err
+	}
+	return token, signed, nil
+}
+
+// revokeFamily revokes the tokens of a family that are still valid and
+// ends its session
+func revokeFamily(tx *gorm.DB, familyID, reason string) error {
+	if err := tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now().UTC()).Error; err != nil {
+		return err
+	}
+	return endSession(tx, familyID, reason)
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token of the same family. Each refresh token is accepted once:
+// presenting it again means it leaked, so its family is revoked. The
+// session of the family must still be active.
+func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (string, string, error) {
+	claims, err := utils.ValidateToken(refreshToken, utils.TokenTypeRefresh)
+	if err != nil {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	var accessToken, nextRefreshToken, reusedFamily, expiredFamily string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var stored models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", claims.ID, claims.UserID).
+			Take(&stored).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+		if stored.RevokedAt != nil {
+			return ErrInvalidRefreshToken
+		}
+		if stored.UsedAt != nil {
+			// Committed before ErrRefreshTokenReused is returned
+			reusedFamily = stored.FamilyID
+			return revokeFamily(tx, stored.FamilyID, RevokeTokenReuse)
+		}
+
+		var user models.User
+		if err := tx.First(&user, stored.UserID).Error; err != nil {
+			return errors.New("user not found")
+		}
+		if !user.IsActive {
+			return errInactiveAccount
+		}
+
+		expiresAt := refreshTokenExpiry()
+		if err := touchSession(tx, stored.FamilyID, user.ID, expiresAt); err != nil {
+			if !errors.Is(err, ErrSessionExpired) {
+				return err
+			}
+			// Idle or ended: the family is revoked instead of rotated,
+			// committed before ErrSessionExpired is returned
+			expiredFamily = stored.FamilyID
+			return revokeFamily(tx, stored.FamilyID, RevokeIdle)
+		}
+
+		next, signed, err := issueRefreshToken(tx, user.ID, stored.FamilyID, expiresAt)
+		if err != nil {
+			return errors.New("failed to generate refresh token")
+		}
+		if err := tx.Model(&stored).Updates(map[string]interface{}{
+			"used_at":     time.Now().UTC(),
+			"replaced_by": next.ID,
+		}).Error; err != nil {
+			return err
+		}
+
+		accessToken, err = utils.GenerateAccessToken(user.ID, user.Email, user.RoleID, stored.FamilyID)
+		if err != nil {
+			return errors.New("failed to generate access token")
+		}
+		nextRefreshToken = signed
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	if reusedFamily != "" {
+		ipAddress, userAgent := clientInfo(ctx)
+		message := fmt.Sprintf("refresh token %s reused, token family %s revoked", claims.ID, reusedFamily)
+		if logErr := s.audit.LogAction(&claims.UserID, "refresh_token_reuse", "auth", nil, nil, nil, ipAddress, userAgent, AuditDenied, &message, nil); logErr != nil {
+			fmt.Printf("Warning: Failed to audit refresh token reuse: %v\n", logErr)
+		}
+		return "", "", ErrRefreshTokenReused
+	}
+	if expiredFamily != "" {
+		sessionCache.forget(expiredFamily)
+		return "", "", ErrSessionExpired
+	}
+	return accessToken, nextRefreshToken, nil
+}
+
+// Logout revokes the refresh token family of a login, ending that session.
+// The access token of the session, when given, is revoked too.
+func (s *AuthService) Logout(ctx context.Context, refreshToken string, accessClaims *utils.Claims) error {
+	claims, err := utils.ValidateToken(refreshToken, utils.TokenTypeRefresh)
+	if err != nil {
+		return ErrInvalidRefreshToken
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var stored models.RefreshToken
+		if err := tx.Where("id = ? AND user_id = ?", claims.ID, claims.UserID).Take(&stored).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+		return revokeFamily(tx, stored.FamilyID, RevokeLogout)
+	})
+	if err == nil && accessClaims != nil && accessClaims.UserID == claims.UserID {
+		err = RevokeToken(accessClaims, RevokeLogout)
+	}
+
+	if err == nil {
+		sessionCache.forget(claims.FamilyID)
+	}
+
+	s.auditSession(ctx, "logout", claims.UserID, nil, err)
+	return err
+}
+
+// LogoutAll ends all sessions of a user
+func (s *AuthService) LogoutAll(ctx context.Context, userID uint) error {
+	err := RevokeUserSessions(userID, RevokeLogoutAll)
+
+	s.auditSession(ctx, "logout_all", userID, nil, err)
+	return err
+}
+
+// TerminateSession ends one of the user's own sessions
+func (s *AuthService) TerminateSession(ctx context.Context, userID uint, sessionID string) error {
+	err := TerminateSession(userID, sessionID, RevokeTerminated)
+
+	detail := "session " + sessionID
+	s.auditSession(ctx, "terminate_session", userID, &detail, err)
+	return err
+}
+
+// RevokeUserSessions ends every session of a user: all refresh tokens are
+// revoked and the access tokens issued so far are denied
+func RevokeUserSessions(userID uint, reason string) error {
+	now := time.Now().UTC()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Session{}).
+			Where("user_id = ? AND ended_at IS NULL", userID).
+			Updates(map[string]interface{}{
+				"ended_at":   now,
+				"end_reason": reason,
+			}).Error
+	})
+	if err != nil {
+		return err
+	}
+	return RevokeUserTokens(userID, reason)
+}
+
+func (s *AuthService) auditSession(ctx context.Context, action string, userID uint, detail *string, err error) {
+	status := AuditSuccess
+	var errorMessage *string
+	if err != nil {
+		status = AuditError
+		errorMessage = stringPtr(err.Error())
+	}
+	ipAddress, userAgent := clientInfo(ctx)
+	if logErr := s.audit.LogAction(&userID, action, "auth", detail, nil, nil, ipAddress, userAgent, status, errorMessage, nil); logErr != nil {
+		fmt.Printf("Warning: Failed to audit %s: %v\n", action, logErr)
+	}
+}
@@ -0,0 +1,200 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"mastercard-backend/internal/models"
+	"mastercard-backend/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// testSession is a login of a test user
+type testSession struct {
+	user         *models.User
+	accessToken  string
+	refreshToken string
+}
+
+// startTestSession logs a new user in
+func startTestSession(t *testing.T, tx *gorm.DB) *testSession {
+	t.Helper()
+	user := createLoginUser(t, tx)
+	return startUserSession(t, user)
+}
+
+// startUserSession starts another session of user
+func startUserSession(t *testing.T, user *models.User) *testSession {
+	t.Helper()
+	accessToken, refreshToken, err := startSession(context.Background(), user)
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	return &testSession{user: user, accessToken: accessToken, refreshToken: refreshToken}
+}
+
+// tokenClaims validates a token of the given type
+func tokenClaims(t *testing.T, token, tokenType string) *utils.Claims {
+	t.Helper()
+	claims, err := utils.ValidateToken(token, tokenType)
+	if err != nil {
+		t.Fatalf("invalid %s token: %v", tokenType, err)
+	}
+	return claims
+}
+
+// sessionEndReason returns why a session ended, "" while it is active
+func sessionEndReason(t *testing.T, tx *gorm.DB, id string) string {
+	t.Helper()
+	var session models.Session
+	if err := tx.Where("id = ?", id).Take(&session).Error; err != nil {
+		t.Fatalf("session %s not found: %v", id, err)
+	}
+	if session.EndReason == nil {
+		return ""
+	}
+	return *session.EndReason
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	tx := useTestTx(t)
+	session := startTestSession(t, tx)
+	s := NewAuthService()
+
+	accessToken, refreshToken, err := s.RefreshToken(context.Background(), session.refreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken error: %v", err)
+	}
+	if refreshToken == session.refreshToken {
+		t.Fatal("the refresh token was not rotated")
+	}
+	previous := tokenClaims(t, session.refreshToken, utils.TokenTypeRefresh)
+	next := tokenClaims(t, refreshToken, utils.TokenTypeRefresh)
+	if next.FamilyID != previous.FamilyID || next.ID == previous.ID {
+		t.Errorf("rotated token %s of family %s, want a new token of family %s", next.ID, next.FamilyID, previous.FamilyID)
+	}
+	if access := tokenClaims(t, accessToken, utils.TokenTypeAccess); access.SessionID != previous.FamilyID {
+		t.Errorf("access token of session %s, want %s", access.SessionID, previous.FamilyID)
+	}
+
+	var stored models.RefreshToken
+	if err := tx.Where("id = ?", previous.ID).Take(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.UsedAt == nil || stored.ReplacedBy == nil || *stored.ReplacedBy != next.ID {
+		t.Errorf("rotated token = %+v, want used and replaced by %s", stored, next.ID)
+	}
+
+	// The new token rotates in turn
+	if _, _, err := s.RefreshToken(context.Background(), refreshToken); err != nil {
+		t.Errorf("second rotation failed: %v", err)
+	}
+}
+
+func TestReusedRefreshTokenRevokesTheFamily(t *testing.T) {
+	tx := useTestTx(t)
+	session := startTestSession(t, tx)
+	other := startUserSession(t, session.user)
+	s := NewAuthService()
+
+	_, rotated, err := s.RefreshToken(context.Background(), session.refreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken error: %v", err)
+	}
+	loggedActions()
+
+	// Replaying the rotated token: it leaked
+	if _, _, err := s.RefreshToken(context.Background(), session.refreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("replay error = %v, want ErrRefreshTokenReused", err)
+	}
+	if actions := loggedActions(); len(actions) != 1 || actions[0] != "refresh_token_reuse" {
+		t.Errorf("audit logged %v, want refresh_token_reuse", actions)
+	}
+	// The token issued by the rotation is revoked with its family
+	if _, _, err := s.RefreshToken(context.Background(), rotated); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("rotated token error = %v, want ErrInvalidRefreshToken", err)
+	}
+	family := tokenClaims(t, session.refreshToken, utils.TokenTypeRefresh).FamilyID
+	if reason := sessionEndReason(t, tx, family); reason != RevokeTokenReuse {
+		t.Errorf("session end reason = %q, want %q", reason, RevokeTokenReuse)
+	}
+
+	// Other sessions of the user are kept
+	if _, _, err := s.RefreshToken(context.Background(), other.refreshToken); err != nil {
+		t.Errorf("other session refresh failed: %v", err)
+	}
+}
+
+func TestLogoutEndsOneSession(t *testing.T) {
+	tx := useTestTx(t)
+	session := startTestSession(t, tx)
+	other := startUserSession(t, session.user)
+	s := NewAuthService()
+	access := tokenClaims(t, session.accessToken, utils.TokenTypeAccess)
+
+	if err := s.Logout(context.Background(), session.refreshToken, access); err != nil {
+		t.Fatalf("Logout error: %v", err)
+	}
+	if _, _, err := s.RefreshToken(context.Background(), session.refreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refresh after logout error = %v, want ErrInvalidRefreshToken", err)
+	}
+	if !IsTokenRevoked(access) {
+		t.Error("the access token of the session is not revoked")
+	}
+	if reason := sessionEndReason(t, tx, access.SessionID); reason != RevokeLogout {
+		t.Errorf("session end reason = %q, want %q", reason, RevokeLogout)
+	}
+
+	if IsTokenRevoked(tokenClaims(t, other.accessToken, utils.TokenTypeAccess)) {
+		t.Error("the access token of another session is revoked")
+	}
+	if _, _, err := s.RefreshToken(context.Background(), other.refreshToken); err != nil {
+		t.Errorf("other session refresh failed: %v", err)
+	}
+}
+
+func TestLogoutIgnoresAccessTokenOfAnotherUser(t *testing.T) {
+	tx := useTestTx(t)
+	session := startTestSession(t, tx)
+	stranger := startTestSession(t, tx)
+	strangerAccess := tokenClaims(t, stranger.accessToken, utils.TokenTypeAccess)
+
+	if err := NewAuthService().Logout(context.Background(), session.refreshToken, strangerAccess); err != nil {
+		t.Fatalf("Logout error: %v", err)
+	}
+	if IsTokenRevoked(strangerAccess) {
+		t.Error("logout revoked the access token of another user")
+	}
+}
+
+func TestLogoutAllEndsEverySession(t *testing.T) {
+	tx := useTestTx(t)
+	first := startTestSession(t, tx)
+	second := startUserSession(t, first.user)
+	stranger := startTestSession(t, tx)
+	s := NewAuthService()
+
+	if err := s.LogoutAll(context.Background(), first.user.ID); err != nil {
+		t.Fatalf("LogoutAll error: %v", err)
+	}
+	for _, session := range []*testSession{first, second} {
+		access := tokenClaims(t, session.accessToken, utils.TokenTypeAccess)
+		if !IsTokenRevoked(access) {
+			t.Errorf("access token of session %s is not revoked", access.SessionID)
+		}
+		if _, _, err := s.RefreshToken(context.Background(), session.refreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("refresh of session %s error = %v, want ErrInvalidRefreshToken", access.SessionID, err)
+		}
+		if reason := sessionEndReason(t, tx, access.SessionID); reason != RevokeLogoutAll {
+			t.Errorf("session end reason = %q, want %q", reason, RevokeLogoutAll)
+		}
+	}
+
+	if IsTokenRevoked(tokenClaims(t, stranger.accessToken, utils.TokenTypeAccess)) {
+		t.Error("the access token of another user is revoked")
+	}
+}
@@ -23,7 +23,10 @@ func (s *ConversationService) CreateConversation(userID uint, title string) (*mo
 		Title:  &title,
 	}
 
-	if err := database.DB.Create(&conversation).Error; err != nil {
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		return tx.Create(&conversation).Error
+	})
+	if err != nil {
 		return nil, errors.New("failed to create conversation")
 	}
 
@@ -35,40 +38,46 @@ func (s *ConversationService) GetConversations(userID uint, limit, offset int) (
 	var conversations []models.Conversation
 	var total int64
 
-	query := database.DB.Where("user_id = ?", userID)
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		query := tx.Where("user_id = ?", userID)
 
-	// Count total
-	if err := query.Model(&models.Conversation{}).Count(&total).Error; err != nil {
-		return nil, 0, err
-	}
+		// Count total
+		if err := query.Model(&models.Conversation{}).Count(&total).Error; err != nil {
+			return err
+		}
 
-	// Get conversations
-	if err := query.Order("updated_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&conversations).Error; err != nil {
+		// Get conversations
+		return query.Order("updated_at DESC").
+			Limit(limit).
+			Offset(offset).
+			Find(&conversations).Error
+	})
+	if err != nil {
 		return nil, 0, err
 	}
 
 	return conversations, total, nil
 }
 
-// GetAllConversations retrieves all conversations (for managers and admins)
-func (s *ConversationService) GetAllConversations(limit, offset int) ([]models.Conversation, int64, error) {
+// GetAllConversations retrieves all conversations visible to a manager or admin
+func (s *ConversationService) GetAllConversations(userID uint, limit, offset int) ([]models.Conversation, int64, error) {
 	var conversations []models.Conversation
 	var total int64
 
-	// Count total
-	if err := database.DB.Model(&models.Conversation{}).Count(&total).Error; err != nil {
-		return nil, 0, err
-	}
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		// Count total
+		if err := tx.Model(&models.Conversation{}).Count(&total).Error; err != nil {
+			return err
+		}
 
-	// Get all conversations with user info
-	if err := database.DB.Preload("User").
-		Order("updated_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&conversations).Error; err != nil {
+		// Get all conversations with user info
+		return tx.Preload("User").
+			Order("updated_at DESC").
+			Limit(limit).
+			Offset(offset).
+			Find(&conversations).Error
+	})
+	if err != nil {
 		return nil, 0, err
 	}
 
@@ -78,11 +87,14 @@ func (s *ConversationService) GetAllConversations(limit, offset int) ([]models.C
 // GetConversation retrieves a single conversation with messages
 func (s *ConversationService) GetConversation(conversationID, userID uint) (*models.Conversation, error) {
 	var conversation models.Conversation
-	if err := database.DB.Where("id = ? AND user_id = ?", conversationID, userID).
-		Preload("Messages", func(db *gorm.DB) *gorm.DB {
-			return db.Order("created_at ASC")
-		}).
-		First(&conversation).Error; err != nil {
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		return tx.Where("id = ? AND user_id = ?", conversationID, userID).
+			Preload("Messages", func(db *gorm.DB) *gorm.DB {
+				return db.Order("created_at ASC")
+			}).
+			First(&conversation).Error
+	})
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, errors.New("conversation not found")
 		}
@@ -92,21 +104,46 @@ func (s *ConversationService) GetConversation(conversationID, userID uint) (*mod
 	return &conversation, nil
 }
 
-// UpdateConversation updates a conversation (e.g., rename)
-func (s *ConversationService) UpdateConversation(conversationID, userID uint, title string) (*models.Conversation, error) {
+// GetAnyConversation retrieves any conversation visible to a manager or admin,
+// including its owner
+func (s *ConversationService) GetAnyConversation(conversationID, userID uint) (*models.Conversation, error) {
 	var conversation models.Conversation
-	if err := database.DB.Where("id = ? AND user_id = ?", conversationID, userID).
-		First(&conversation).Error; err != nil {
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		return tx.Where("id = ?", conversationID).
+			Preload("Messages", func(db *gorm.DB) *gorm.DB {
+				return db.Order("created_at ASC")
+			}).
+			Preload("User").
+			First(&conversation).Error
+	})
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, errors.New("conversation not found")
 		}
 		return nil, err
 	}
 
-	conversation.Title = &title
-	conversation.UpdatedAt = time.Now()
+	return &conversation, nil
+}
+
+// UpdateConversation updates a conversation (e.g., rename)
+func (s *ConversationService) UpdateConversation(conversationID, userID uint, title string) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", conversationID, userID).
+			First(&conversation).Error; err != nil {
+			return err
+		}
+
+		conversation.Title = &title
+		conversation.UpdatedAt = time.Now()
 
-	if err := database.DB.Save(&conversation).Error; err != nil {
+		return tx.Save(&conversation).Error
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("conversation not found")
+		}
 		return nil, errors.New("failed to update conversation")
 	}
 
@@ -115,14 +152,19 @@ func (s *ConversationService) UpdateConversation(conversationID, userID uint, ti
 
 // DeleteConversation deletes a conversation
 func (s *ConversationService) DeleteConversation(conversationID, userID uint) error {
-	result := database.DB.Where("id = ? AND user_id = ?", conversationID, userID).
-		Delete(&models.Conversation{})
-
-	if result.Error != nil {
+	var rowsAffected int64
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", conversationID, userID).
+			Delete(&models.Conversation{})
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+
+	if err != nil {
 		return errors.New("failed to delete conversation")
 	}
 
-	if result.RowsAffected == 0 {
+	if rowsAffected == 0 {
 		return errors.New("conversation not found")
 	}
 
@@ -131,23 +173,30 @@ func (s *ConversationService) DeleteConversation(conversationID, userID uint) er
 
 // CreateBranch creates a new conversation branch from a message
 func (s *ConversationService) CreateBranch(parentConversationID, branchPointMessageID, userID uint, title string) (*models.Conversation, error) {
-	// Verify parent conversation belongs to user
-	var parent models.Conversation
-	if err := database.DB.Where("id = ? AND user_id = ?", parentConversationID, userID).
-		First(&parent).Error; err != nil {
-		return nil, errors.New("parent conversation not found")
-	}
+	var branch models.Conversation
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		// Verify parent conversation belongs to user
+		var parent models.Conversation
+		if err := tx.Where("id = ? AND user_id = ?", parentConversationID, userID).
+			First(&parent).Error; err != nil {
+			return errors.New("parent conversation not found")
+		}
 
-	// Create new branch
-	branch := models.Conversation{
-		UserID:             userID,
-		Title:              &title,
-		ParentBranchID:     &parentConversationID,
-		BranchPointMessageID: &branchPointMessageID,
-	}
+		// Create new branch
+		branch = models.Conversation{
+			UserID:               userID,
+			Title:                &title,
+			ParentBranchID:       &parentConversationID,
+			BranchPointMessageID: &branchPointMessageID,
+		}
 
-	if err := database.DB.Create(&branch).Error; err != nil {
-		return nil, errors.New("failed to create branch")
+		if err := tx.Create(&branch).Error; err != nil {
+			return errors.New("failed to create branch")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &branch, nil
@@ -158,22 +207,24 @@ func (s *ConversationService) SearchConversations(userID uint, keyword string, l
 	var conversations []models.Conversation
 	var total int64
 
-	query := database.DB.Where("user_id = ?", userID).
-		Where("to_tsvector('english', COALESCE(title, '')) @@ plainto_tsquery('english', ?)", keyword)
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		query := tx.Where("user_id = ?", userID).
+			Where("to_tsvector('english', COALESCE(title, '')) @@ plainto_tsquery('english', ?)", keyword)
 
-	// Count total
-	if err := query.Model(&models.Conversation{}).Count(&total).Error; err != nil {
-		return nil, 0, err
-	}
+		// Count total
+		if err := query.Model(&models.Conversation{}).Count(&total).Error; err != nil {
+			return err
+		}
 
-	// Get conversations
-	if err := query.Order("updated_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&conversations).Error; err != nil {
+		// Get conversations
+		return query.Order("updated_at DESC").
+			Limit(limit).
+			Offset(offset).
+			Find(&conversations).Error
+	})
+	if err != nil {
 		return nil, 0, err
 	}
 
 	return conversations, total, nil
 }
-
@@ -0,0 +1,79 @@
+//go:build integration
+
+package services
+
+import (
+	"sync"
+	"testing"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// These tests run against the migrated database of the DB_* settings:
+//
+//	go test -tags integration ./internal/services/
+//
+// Each test runs inside a transaction that is rolled back when it ends, so
+// the tests may clear tables and nothing they write is kept. Audit entries
+// logged by the services are queued, not written, and read back with
+// loggedActions.
+
+var (
+	connectOnce sync.Once
+	connectErr  error
+	// testRootDB is the connection pool; database.DB points at the
+	// transaction of the running test
+	testRootDB *gorm.DB
+)
+
+// useTestTx points database.DB at a transaction that is rolled back when
+// the test ends and returns it
+func useTestTx(t *testing.T) *gorm.DB {
+	t.Helper()
+	connectOnce.Do(func() {
+		if connectErr = config.Load(); connectErr != nil {
+			return
+		}
+		if connectErr = database.Connect(); connectErr != nil {
+			return
+		}
+		testRootDB = database.DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+		// Without a writer: its own transactions would race the test's
+		auditLogOnce.Do(func() {
+			auditLog = &auditWriter{entries: make(chan models.AuditLog, 1000), done: make(chan struct{})}
+		})
+	})
+	if connectErr != nil {
+		t.Fatalf("failed to connect: %v", connectErr)
+	}
+
+	tx := testRootDB.Begin()
+	if tx.Error != nil {
+		t.Fatalf("failed to begin transaction: %v", tx.Error)
+	}
+	database.DB = tx
+	loggedActions()
+	t.Cleanup(func() {
+		tx.Rollback()
+		database.DB = testRootDB
+	})
+	return tx
+}
+
+// loggedActions returns the actions audit logged since the last call
+func loggedActions() []string {
+	var actions []string
+	for {
+		select {
+		case entry := <-auditLog.entries:
+			actions = append(actions, entry.Action)
+		default:
+			return actions
+		}
+	}
+}
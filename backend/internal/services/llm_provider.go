@@ -0,0 +1,38 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"mastercard-backend/pkg/fakellm"
+	"mastercard-backend/pkg/gemini"
+	"mastercard-backend/pkg/llm"
+	"mastercard-backend/pkg/ollama"
+	"mastercard-backend/pkg/openai"
+)
+
+// NewLLMProvider creates the language model provider with the given name
+func NewLLMProvider(name string) (llm.Provider, error) {
+	var (
+		provider llm.Provider
+		err      error
+	)
+
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "gemini":
+		provider, err = gemini.NewClient()
+	case "openai":
+		provider, err = openai.NewClient()
+	case "ollama":
+		provider, err = ollama.NewClient()
+	case "fake":
+		provider, err = fakellm.NewClient()
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
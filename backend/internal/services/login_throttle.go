@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Login throttle scopes
+const (
+	throttleAccount = "account"
+	throttleIP      = "ip"
+)
+
+// ErrLockoutNotFound is returned when unlocking a subject that is not locked
+var ErrLockoutNotFound = errors.New("lockout not found")
+
+// LoginBlockedError is returned for logins attempted during the backoff
+// after a failure or during a lockout
+type LoginBlockedError struct {
+	Until  time.Time
+	Locked bool
+}
+
+func (e *LoginBlockedError) Error() string {
+	if e.Locked {
+		return "too many failed login attempts, login is temporarily locked"
+	}
+	return "too many failed login attempts, try again later"
+}
+
+// RetryAfter is how long the client has to wait, at least a second
+func (e *LoginBlockedError) RetryAfter() time.Duration {
+	wait := time.Until(e.Until).Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// loginSubjects returns the throttle scopes and subjects of a login
+// attempt: the email as typed, lowercased, and the client IP when known.
+// Failures lock them in this order.
+func loginSubjects(email string, ipAddress *string) [][2]string {
+	subjects := [][2]string{{throttleAccount, strings.ToLower(strings.TrimSpace(email))}}
+	if ipAddress != nil {
+		subjects = append(subjects, [2]string{throttleIP, *ipAddress})
+	}
+	return subjects
+}
+
+// checkLoginThrottle refuses a login attempt with a *LoginBlockedError while
+// the account or the IP is blocked. Nothing is locked, so the password is
+// never checked under a row lock: parallel attempts may all pass the check
+// before their failures are recorded, letting a client try as many
+// passwords as it sends at once before the backoff applies.
+func checkLoginThrottle(db *gorm.DB, email string, ipAddress *string) error {
+	now := time.Now().UTC()
+	var blocked *LoginBlockedError
+	for _, subject := range loginSubjects(email, ipAddress) {
+		var throttles []models.LoginThrottle
+		if err := db.Where("scope = ? AND subject = ? AND blocked_until > ?", subject[0], subject[1], now).
+			Limit(1).Find(&throttles).Error; err != nil {
+			return err
+		}
+		if len(throttles) == 0 {
+			continue
+		}
+		throttle := throttles[0]
+		if blocked == nil {
+			blocked = &LoginBlockedError{}
+		}
+		if throttle.BlockedUntil.After(blocked.Until) {
+			blocked.Until = *throttle.BlockedUntil
+		}
+		if throttle.LockedAt != nil {
+			blocked.Locked = true
+		}
+	}
+	if blocked != nil {
+		return blocked
+	}
+	return nil
+}
+
+// loginBackoff is how long a subject is blocked after its nth failure:
+// LOGIN_BACKOFF_BASE doubled with each failure, at most the lockout
+func loginBackoff(failures int) time.Duration {
+	lockout := config.AppConfig.LoginLockoutDuration
+	backoff := config.AppConfig.LoginBackoffBase
+	for i := 1; i < failures && backoff < lockout; i++ {
+		backoff *= 2
+	}
+	if backoff > lockout {
+		backoff = lockout
+	}
+	return backoff
+}
+
+// recordLoginFailure counts a failed attempt against the account and the
+// IP, returning the throttles it locked out. Only failures are stored: the
+// rows of subjects whose failures are forgotten are pruned on the way.
+func recordLoginFailure(email string, ipAddress *string) ([]*models.LoginThrottle, error) {
+	var locked []*models.LoginThrottle
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		forgotten := now.Add(-config.AppConfig.LoginLockoutDuration)
+		if err := tx.Where("blocked_until < ? AND last_failure_at < ?", now, forgotten).
+			Delete(&models.LoginThrottle{}).Error; err != nil {
+			return err
+		}
+
+		maxAttempts := map[string]int{
+			throttleAccount: config.AppConfig.LoginMaxAttempts,
+			throttleIP:      config.AppConfig.LoginIPMaxAttempts,
+		}
+		// The rows stay locked only while their count is incremented
+		for _, subject := range loginSubjects(email, ipAddress) {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.LoginThrottle{
+				Scope:         subject[0],
+				Subject:       subject[1],
+				LastFailureAt: now,
+			}).Error; err != nil {
+				return err
+			}
+			var throttle models.LoginThrottle
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("scope = ? AND subject = ?", subject[0], subject[1]).
+				Take(&throttle).Error; err != nil {
+				return err
+			}
+
+			// Failures older than a lockout are forgotten
+			if throttle.LastFailureAt.Before(forgotten) {
+				throttle.Failures = 0
+			}
+			throttle.Failures++
+			throttle.LastFailureAt = now
+			throttle.LockedAt = nil
+			blockedUntil := now.Add(loginBackoff(throttle.Failures))
+			if limit := maxAttempts[throttle.Scope]; limit > 0 && throttle.Failures >= limit {
+				blockedUntil = now.Add(config.AppConfig.LoginLockoutDuration)
+				throttle.LockedAt = &now
+				locked = append(locked, &throttle)
+			}
+			throttle.BlockedUntil = &blockedUntil
+
+			if err := tx.Save(&throttle).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return locked, nil
+}
+
+// forgetAccountFailures forgets the failures of an account, once a login
+// completed or when an admin unlocks it. Those of the IP are kept: one
+// valid account must not reset the attempts left for guessing others.
+func forgetAccountFailures(db *gorm.DB, email string) error {
+	return db.Where("scope = ? AND subject = ?", throttleAccount, strings.ToLower(strings.TrimSpace(email))).
+		Delete(&models.LoginThrottle{}).Error
+}
+
+// auditLockouts audit logs each subject locked by a failed login as
+// login_lockout
+func (s *AuthService) auditLockouts(ctx context.Context, userID *uint, locked []*models.LoginThrottle) {
+	ipAddress, userAgent := clientInfo(ctx)
+	for _, throttle := range locked {
+		var lockedUser *uint
+		if throttle.Scope == throttleAccount {
+			lockedUser = userID
+		}
+		detail := fmt.Sprintf("%s %s locked until %s after %d failed logins",
+			throttle.Scope, throttle.Subject, throttle.BlockedUntil.Format(time.RFC3339), throttle.Failures)
+		if err := s.audit.LogAction(lockedUser, "login_lockout", "auth", &detail, nil, nil, ipAddress, userAgent, AuditDenied, nil, nil); err != nil {
+			fmt.Printf("Warning: Failed to audit login lockout: %v\n", err)
+		}
+	}
+}
+
+// GetLockouts lists the accounts and IPs locked out at the moment
+func GetLockouts() ([]models.LoginThrottle, error) {
+	var throttles []models.LoginThrottle
+	err := database.DB.Where("locked_at IS NOT NULL AND blocked_until > ?", time.Now().UTC()).
+		Order("locked_at DESC").
+		Find(&throttles).Error
+	return throttles, err
+}
+
+// UnlockThrottle lifts the lockout of an account or IP and forgets its
+// failures
+func UnlockThrottle(id uint) (*models.LoginThrottle, error) {
+	var throttle models.LoginThrottle
+	if err := database.DB.First(&throttle, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrLockoutNotFound
+		}
+		return nil, err
+	}
+	if err := database.DB.Delete(&throttle).Error; err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// UnlockAccount lifts the lockout of a user's account and forgets its
+// failures
+func UnlockAccount(email string) error {
+	return forgetAccountFailures(database.DB, email)
+}
@@ -0,0 +1,231 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const testPassword = "correct horse battery staple"
+
+// useLoginThrottle locks an account after accountMax failures and an IP
+// after ipMax (0 disables), without backoff between attempts
+func useLoginThrottle(t *testing.T, accountMax, ipMax int) {
+	t.Helper()
+	saved := *config.AppConfig
+	config.AppConfig.LoginMaxAttempts = accountMax
+	config.AppConfig.LoginIPMaxAttempts = ipMax
+	config.AppConfig.LoginBackoffBase = 0
+	config.AppConfig.LoginLockoutDuration = 15 * time.Minute
+	t.Cleanup(func() { *config.AppConfig = saved })
+}
+
+// createLoginUser creates an active user with testPassword
+func createLoginUser(t *testing.T, tx *gorm.DB) *models.User {
+	t.Helper()
+	// The lowest cost keeps the tests fast
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{
+		Email:        fmt.Sprintf("login-%d@example.test", time.Now().UnixNano()),
+		PasswordHash: string(hash),
+		FullName:     "Login",
+		IsActive:     true,
+	}
+	if err := tx.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+// fromIP returns a context of a client at ipAddress
+func fromIP(ipAddress string) context.Context {
+	return WithClientInfo(context.Background(), ipAddress, "test")
+}
+
+// findThrottle returns the throttle of a subject, nil when none is stored
+func findThrottle(t *testing.T, tx *gorm.DB, scope, subject string) *models.LoginThrottle {
+	t.Helper()
+	var throttles []models.LoginThrottle
+	if err := tx.Where("scope = ? AND subject = ?", scope, subject).Find(&throttles).Error; err != nil {
+		t.Fatalf("failed to read throttle: %v", err)
+	}
+	if len(throttles) == 0 {
+		return nil
+	}
+	return &throttles[0]
+}
+
+func expectLocked(t *testing.T, err error) {
+	t.Helper()
+	var blocked *LoginBlockedError
+	if !errors.As(err, &blocked) || !blocked.Locked {
+		t.Fatalf("error = %v, want a lockout", err)
+	}
+}
+
+func TestLoginLocksTheAccountAtTheThreshold(t *testing.T) {
+	tx := useTestTx(t)
+	useLoginThrottle(t, 3, 0)
+	user := createLoginUser(t, tx)
+	s := NewAuthService()
+	ctx := fromIP("192.0.2.10")
+
+	for i := 1; i <= 3; i++ {
+		if _, err := s.Login(ctx, user.Email, "wrong"); !errors.Is(err, errInvalidCredentials) {
+			t.Fatalf("attempt %d error = %v, want invalid credentials", i, err)
+		}
+		throttle := findThrottle(t, tx, throttleAccount, user.Email)
+		if throttle == nil || throttle.Failures != i {
+			t.Fatalf("after attempt %d: throttle %+v, want %d failures", i, throttle, i)
+		}
+		if locked := throttle.LockedAt != nil; locked != (i == 3) {
+			t.Fatalf("after attempt %d: locked = %v", i, locked)
+		}
+	}
+	if !strings.Contains(strings.Join(loggedActions(), ","), "login_lockout") {
+		t.Error("the lockout was not audit logged")
+	}
+
+	// The right password is refused before it is checked
+	_, err := s.Login(ctx, user.Email, testPassword)
+	expectLocked(t, err)
+	// Also from another IP: the account is locked
+	_, err = s.Login(fromIP("192.0.2.20"), strings.ToUpper(user.Email), testPassword)
+	expectLocked(t, err)
+	if throttle := findThrottle(t, tx, throttleAccount, user.Email); throttle.Failures != 3 {
+		t.Errorf("refused attempts counted: %d failures, want 3", throttle.Failures)
+	}
+}
+
+func TestLoginLocksTheIPAcrossAccounts(t *testing.T) {
+	tx := useTestTx(t)
+	useLoginThrottle(t, 0, 3)
+	user := createLoginUser(t, tx)
+	s := NewAuthService()
+	ctx := fromIP("192.0.2.30")
+
+	// Guessing a different account each time
+	for i := 1; i <= 3; i++ {
+		if _, err := s.Login(ctx, fmt.Sprintf("guess-%d@example.test", i), "wrong"); !errors.Is(err, errInvalidCredentials) {
+			t.Fatalf("attempt %d error = %v, want invalid credentials", i, err)
+		}
+	}
+	if throttle := findThrottle(t, tx, throttleIP, "192.0.2.30"); throttle == nil || throttle.Failures != 3 || throttle.LockedAt == nil {
+		t.Fatalf("IP throttle = %+v, want 3 failures and locked", throttle)
+	}
+
+	_, err := s.Login(ctx, user.Email, testPassword)
+	expectLocked(t, err)
+	if _, err := s.Login(fromIP("192.0.2.31"), user.Email, testPassword); err != nil {
+		t.Errorf("login from another IP failed: %v", err)
+	}
+}
+
+func TestLoginBackoffBlocksTheNextAttempt(t *testing.T) {
+	tx := useTestTx(t)
+	useLoginThrottle(t, 5, 0)
+	config.AppConfig.LoginBackoffBase = time.Minute
+	user := createLoginUser(t, tx)
+	s := NewAuthService()
+
+	if _, err := s.Login(context.Background(), user.Email, "wrong"); !errors.Is(err, errInvalidCredentials) {
+		t.Fatalf("error = %v, want invalid credentials", err)
+	}
+	_, err := s.Login(context.Background(), user.Email, testPassword)
+	var blocked *LoginBlockedError
+	if !errors.As(err, &blocked) || blocked.Locked {
+		t.Fatalf("error = %v, want a backoff", err)
+	}
+	if wait := blocked.RetryAfter(); wait < 59*time.Second || wait > time.Minute {
+		t.Errorf("retry after %v, want a minute", wait)
+	}
+}
+
+func TestLoginForgetsFailuresAfterTheLockoutDuration(t *testing.T) {
+	tx := useTestTx(t)
+	useLoginThrottle(t, 3, 0)
+	user := createLoginUser(t, tx)
+	s := NewAuthService()
+
+	for i := 0; i < 2; i++ {
+		s.Login(context.Background(), user.Email, "wrong")
+	}
+	// Both failures are older than the lockout duration
+	old := time.Now().UTC().Add(-time.Hour)
+	if err := tx.Model(&models.LoginThrottle{}).Where("scope = ? AND subject = ?", throttleAccount, user.Email).
+		Updates(map[string]interface{}{"last_failure_at": old, "blocked_until": old}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	s.Login(context.Background(), user.Email, "wrong")
+	throttle := findThrottle(t, tx, throttleAccount, user.Email)
+	if throttle == nil || throttle.Failures != 1 || throttle.LockedAt != nil {
+		t.Fatalf("throttle = %+v, want the count to restart at 1", throttle)
+	}
+}
+
+func TestLoginStoresOnlyFailures(t *testing.T) {
+	tx := useTestTx(t)
+	useLoginThrottle(t, 3, 10)
+	user := createLoginUser(t, tx)
+	s := NewAuthService()
+	ctx := fromIP("192.0.2.40")
+
+	if _, err := s.Login(ctx, user.Email, testPassword); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if findThrottle(t, tx, throttleAccount, user.Email) != nil || findThrottle(t, tx, throttleIP, "192.0.2.40") != nil {
+		t.Fatal("a successful login stored a throttle")
+	}
+
+	s.Login(ctx, user.Email, "wrong")
+	if _, err := s.Login(ctx, user.Email, testPassword); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	// The account is cleared, the IP keeps its failure
+	if throttle := findThrottle(t, tx, throttleAccount, user.Email); throttle != nil {
+		t.Errorf("account throttle %+v kept after a successful login", throttle)
+	}
+	if throttle := findThrottle(t, tx, throttleIP, "192.0.2.40"); throttle == nil || throttle.Failures != 1 {
+		t.Errorf("IP throttle = %+v, want 1 failure", throttle)
+	}
+}
+
+func TestLoginFailurePrunesForgottenThrottles(t *testing.T) {
+	tx := useTestTx(t)
+	useLoginThrottle(t, 3, 0)
+	user := createLoginUser(t, tx)
+	old := time.Now().UTC().Add(-time.Hour)
+	stale := models.LoginThrottle{Scope: throttleAccount, Subject: "stale@example.test", Failures: 2, LastFailureAt: old, BlockedUntil: &old}
+	// Locked, and the lockout not over yet
+	recent := time.Now().UTC().Add(-time.Minute)
+	until := recent.Add(config.AppConfig.LoginLockoutDuration)
+	locked := models.LoginThrottle{Scope: throttleAccount, Subject: "locked@example.test", Failures: 3, LastFailureAt: recent, BlockedUntil: &until, LockedAt: &recent}
+	for _, throttle := range []*models.LoginThrottle{&stale, &locked} {
+		if err := tx.Create(throttle).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	NewAuthService().Login(context.Background(), user.Email, "wrong")
+	if findThrottle(t, tx, throttleAccount, "stale@example.test") != nil {
+		t.Error("a forgotten throttle was kept")
+	}
+	if findThrottle(t, tx, throttleAccount, "locked@example.test") == nil {
+		t.Error("an active lockout was pruned")
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"mastercard-backend/internal/config"
+)
+
+func TestLoginSubjects(t *testing.T) {
+	ip := "192.0.2.10"
+	tests := []struct {
+		name      string
+		email     string
+		ipAddress *string
+		want      [][2]string
+	}{
+		{"account and IP", "ann@example.com", &ip, [][2]string{{throttleAccount, "ann@example.com"}, {throttleIP, ip}}},
+		{"email as typed", "  Ann@Example.COM ", &ip, [][2]string{{throttleAccount, "ann@example.com"}, {throttleIP, ip}}},
+		{"unknown IP", "ann@example.com", nil, [][2]string{{throttleAccount, "ann@example.com"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loginSubjects(tt.email, tt.ipAddress); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginBackoff(t *testing.T) {
+	saved := config.AppConfig
+	config.AppConfig = &config.Config{LoginBackoffBase: time.Second, LoginLockoutDuration: 15 * time.Second}
+	t.Cleanup(func() { config.AppConfig = saved })
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 15 * time.Second, 15 * time.Second}
+	for i, backoff := range want {
+		if got := loginBackoff(i + 1); got != backoff {
+			t.Errorf("failure %d: backoff %v, want %v", i+1, got, backoff)
+		}
+	}
+}
+
+func TestLoginBlockedErrorRetryAfter(t *testing.T) {
+	tests := []struct {
+		until time.Duration
+		want  time.Duration
+	}{
+		{90 * time.Second, 90 * time.Second},
+		{1400 * time.Millisecond, time.Second},
+		{100 * time.Millisecond, time.Second},
+		{-time.Minute, time.Second},
+	}
+	for _, tt := range tests {
+		err := &LoginBlockedError{Until: time.Now().Add(tt.until)}
+		if got := err.RetryAfter(); got != tt.want {
+			t.Errorf("blocked for %v: retry after %v, want %v", tt.until, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+	"mastercard-backend/internal/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidMFAChallenge is returned for unknown, expired, completed or
+	// exhausted login challenges
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA challenge")
+	// ErrInvalidMFACode is returned when a TOTP or recovery code does not match
+	ErrInvalidMFACode = errors.New("invalid MFA code")
+	// ErrMFAAlreadyEnabled is returned when enrolling a user who has MFA
+	ErrMFAAlreadyEnabled = errors.New("MFA is already enabled")
+	// ErrMFANotEnabled is returned for actions that need MFA enabled
+	ErrMFANotEnabled = errors.New("MFA is not enabled")
+	// ErrMFANotEnrolling is returned when verifying without an enrolment
+	ErrMFANotEnrolling = errors.New("no MFA enrolment in progress")
+)
+
+// recoveryCodeCount is the number of recovery codes issued at once
+const recoveryCodeCount = 10
+
+// MFAEnrollment is the secret of an MFA enrolment, to be added to an
+// authenticator app directly or by scanning ProvisioningURI as a QR code
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// mfaRequired reports whether a user must pass MFA to log in
+func mfaRequired(user *models.User) bool {
+	return user.MFAEnabled || (user.Role != nil && user.Role.MFARequired)
+}
+
+// issueMFAChallenge stores a login challenge and signs its token
+func issueMFAChallenge(userID uint) (string, error) {
+	now := time.Now().UTC()
+	challenge := &models.MFAChallenge{
+		ID:     uuid.NewString(),
+		UserID: userID,
+		// The exp claim has second precision
+		ExpiresAt: now.Add(config.AppConfig.MFAChallengeExpiry).Truncate(time.Second),
+		CreatedAt: now,
+	}
+	if err := database.DB.Create(challenge).Error; err != nil {
+		return "", err
+	}
+	// Expired challenges are only kept until the next one
+	database.DB.Where("user_id = ? AND expires_at < ?", userID, now).Delete(&models.MFAChallenge{})
+
+	return utils.GenerateMFAToken(userID, challenge.ID, challenge.ExpiresAt)
+}
+
+// openMFAChallenge locks the challenge of a token if it still accepts codes
+func openMFAChallenge(tx *gorm.DB, mfaToken string) (*models.MFAChallenge, error) {
+	claims, err := utils.ValidateToken(mfaToken, utils.TokenTypeMFA)
+	if err != nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	var challenge models.MFAChallenge
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND user_id = ? AND completed_at IS NULL AND expires_at > ? AND attempts < ?",
+			claims.ID, claims.UserID, time.Now().UTC(), config.AppConfig.MFAMaxAttempts).
+		Take(&challenge).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidMFAChallenge
+		}
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// newMFASecret generates a TOTP secret and stores it encrypted as the
+// user's pending enrolment
+func newMFASecret(tx *gorm.DB, user *models.User) (*MFAEnrollment, error) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := utils.EncryptSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"mfa_secret":    encrypted,
+		"mfa_last_step": 0,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	issuer := config.AppConfig.MFAIssuer
+	if issuer == "" {
+		issuer = config.AppConfig.AppName
+	}
+	return &MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(secret, issuer, user.Email),
+	}, nil
+}
+
+// checkTOTP checks a TOTP code against the user's secret and records its
+// time step, so it is not accepted again
+func checkTOTP(tx *gorm.DB, user *models.User, code string) (bool, error) {
+	if user.MFASecret == nil {
+		return false, nil
+	}
+	secret, err := utils.DecryptSecret(*user.MFASecret)
+	if err != nil {
+		return false, fmt.Errorf("failed to decrypt MFA secret: %w", err)
+	}
+	step, ok := utils.ValidateTOTP(secret, code, time.Now(), user.MFALastStep)
+	if !ok {
+		return false, nil
+	}
+	user.MFALastStep = step
+	return true, tx.Model(user).Update("mfa_last_step", step).Error
+}
+
+// checkMFACode checks a TOTP code or, failing that, uses up a recovery code
+// of a user with MFA enabled
+func checkMFACode(tx *gorm.DB, user *models.User, code string) (bool, error) {
+	if ok, err := checkTOTP(tx, user, code); ok || err != nil {
+		return ok, err
+	}
+
+	result := tx.Model(&models.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(code)).
+		Update("used_at", time.Now().UTC())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// checkThrottledCode checks a code of a logged in user with check, refused
+// while the login throttle blocks their account or the client IP. Wrong
+// codes are counted as failed logins by finishThrottledCode, so codes are
+// no easier to guess here than at login.
+func checkThrottledCode(tx *gorm.DB, user *models.User, ipAddress *string, code string,
+	check func(*gorm.DB, *models.User, string) (bool, error)) error {
+	if err := checkLoginThrottle(tx, user.Email, ipAddress); err != nil {
+		return err
+	}
+	ok, err := check(tx, user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// newRecoveryCodes replaces the recovery codes of a user
+func newRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.MFARecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		rows = append(rows, models.MFARecoveryCode{
+			UserID:    userID,
+			CodeHash:  hashRecoveryCode(code),
+			CreatedAt: time.Now().UTC(),
+		})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns a random code of 50 bits such as
+// "k3m9q-x7p2a"
+func generateRecoveryCode() (string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = alphabet[b&31]
+	}
+	return string(buf[:5]) + "-" + string(buf[5:]), nil
+}
+
+// hashRecoveryCode hashes a recovery code as typed, ignoring case, spaces
+// and dashes
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// enableMFA turns MFA on for a user whose pending secret was verified and
+// issues the recovery codes
+func enableMFA(tx *gorm.DB, user *models.User) ([]string, error) {
+	now := time.Now().UTC()
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"mfa_enabled":    true,
+		"mfa_enabled_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	user.MFAEnabled = true
+	user.MFAEnabledAt = &now
+	return newRecoveryCodes(tx, user.ID)
+}
+
+// CompleteMFALogin completes a login challenged for MFA with a TOTP or
+// recovery code. A user enrolling at login (their role requires MFA)
+// verifies the secret of StartMFALoginEnrollment and gets recovery codes.
+// Failed codes count against the challenge; every attempt is audit logged.
+func (s *AuthService) CompleteMFALogin(ctx context.Context, mfaToken, code string) (*LoginResult, error) {
+	var user models.User
+	var recoveryCodes []string
+	var invalidCode bool
+	var locked []*models.LoginThrottle
+	ipAddress, _ := clientInfo(ctx)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		challenge, err := openMFAChallenge(tx, mfaToken)
+		if err != nil {
+			return err
+		}
+		if err := tx.Preload("Role").First(&user, challenge.UserID).Error; err != nil {
+			return errors.New("user not found")
+		}
+		if !user.IsActive {
+			return errInactiveAccount
+		}
+		// Codes count as login attempts: challenges issued before a lockout
+		// are refused too
+		if err := checkLoginThrottle(tx, user.Email, ipAddress); err != nil {
+			var blocked *LoginBlockedError
+			if !errors.As(err, &blocked) {
+				return errors.New("database error")
+			}
+			return err
+		}
+
+		var ok bool
+		if user.MFAEnabled {
+			ok, err = checkMFACode(tx, &user, code)
+		} else if mfaRequired(&user) {
+			// Enrolling: only the pending secret is accepted
+			ok, err = checkTOTP(tx, &user, code)
+			if ok && err == nil {
+				recoveryCodes, err = enableMFA(tx, &user)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// Committed before ErrInvalidMFACode is returned
+			invalidCode = true
+			return tx.Model(challenge).Update("attempts", gorm.Expr("attempts + 1")).Error
+		}
+		if err := forgetAccountFailures(tx, user.Email); err != nil {
+			return err
+		}
+		return tx.Model(challenge).Update("completed_at", time.Now().UTC()).Error
+	})
+	if err == nil && invalidCode {
+		err = ErrInvalidMFACode
+		var recordErr error
+		if locked, recordErr = recordLoginFailure(user.Email, ipAddress); recordErr != nil {
+			fmt.Printf("Warning: Failed to record login attempt: %v\n", recordErr)
+		}
+	}
+
+	var result *LoginResult
+	if err == nil {
+		result = &LoginResult{User: &user, RecoveryCodes: recoveryCodes}
+		result.AccessToken, result.RefreshToken, err = startSession(ctx, &user)
+	}
+
+	var userID *uint
+	if user.ID != 0 {
+		userID = &user.ID
+	}
+	detail := "second factor"
+	if recoveryCodes != nil {
+		detail = "second factor, MFA enrolled"
+	}
+	s.auditMFA(ctx, "login_mfa", userID, detail, err)
+	if errors.Is(err, ErrInvalidMFACode) {
+		s.auditLockouts(ctx, userID, locked)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// StartMFALoginEnrollment starts the MFA enrolment of a user challenged at
+// login because their role requires MFA
+func (s *AuthService) StartMFALoginEnrollment(ctx context.Context, mfaToken string) (*MFAEnrollment, error) {
+	var user models.User
+	var enrollment *MFAEnrollment
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		challenge, err := openMFAChallenge(tx, mfaToken)
+		if err != nil {
+			return err
+		}
+		if err := tx.Preload("Role").First(&user, challenge.UserID).Error; err != nil {
+			return errors.New("user not found")
+		}
+		if user.MFAEnabled {
+			return ErrMFAAlreadyEnabled
+		}
+		if !mfaRequired(&user) {
+			return ErrInvalidMFAChallenge
+		}
+		enrollment, err = newMFASecret(tx, &user)
+		return err
+	})
+
+	var userID *uint
+	if user.ID != 0 {
+		userID = &user.ID
+	}
+	s.auditMFA(ctx, "mfa_enroll", userID, "enrolment at login", err)
+	return enrollment, err
+}
+
+// EnrollMFA starts the MFA enrolment of a logged in user. The secret stays
+// pending until VerifyMFA; enrolling again replaces it.
+func (s *AuthService) EnrollMFA(ctx context.Context, userID uint) (*MFAEnrollment, error) {
+	var enrollment *MFAEnrollment
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return errors.New("user not found")
+		}
+		if user.MFAEnabled {
+			return ErrMFAAlreadyEnabled
+		}
+		var err error
+		enrollment, err = newMFASecret(tx, &user)
+		return err
+	})
+
+	s.auditMFA(ctx, "mfa_enroll", &userID, "", err)
+	return enrollment, err
+}
+
+// VerifyMFA enables MFA once a code of the pending secret is verified and
+// returns the recovery codes, shown to the user once
+func (s *AuthService) VerifyMFA(ctx context.Context, userID uint, code string) ([]string, error) {
+	var recoveryCodes []string
+	var user models.User
+	ipAddress, _ := clientInfo(ctx)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return errors.New("user not found")
+		}
+		if user.MFAEnabled {
+			return ErrMFAAlreadyEnabled
+		}
+		if user.MFASecret == nil {
+			return ErrMFANotEnrolling
+		}
+		err := checkThrottledCode(tx, &user, ipAddress, code, checkTOTP)
+		if err != nil {
+			return err
+		}
+		recoveryCodes, err = enableMFA(tx, &user)
+		return err
+	})
+
+	err = s.finishThrottledCode(ctx, "mfa_enable", userID, user.Email, err)
+	return recoveryCodes, err
+}
+
+// DisableMFA turns MFA off after checking a TOTP or recovery code. Users
+// of a role requiring MFA enrol again at their next login.
+func (s *AuthService) DisableMFA(ctx context.Context, userID uint, code string) error {
+	var user models.User
+	ipAddress, _ := clientInfo(ctx)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return errors.New("user not found")
+		}
+		if !user.MFAEnabled {
+			return ErrMFANotEnabled
+		}
+		if err := checkThrottledCode(tx, &user, ipAddress, code, checkMFACode); err != nil {
+			return err
+		}
+		return resetMFA(tx, userID)
+	})
+
+	return s.finishThrottledCode(ctx, "mfa_disable", userID, user.Email, err)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user after
+// checking a TOTP code
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error) {
+	var recoveryCodes []string
+	var user models.User
+	ipAddress, _ := clientInfo(ctx)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return errors.New("user not found")
+		}
+		if !user.MFAEnabled {
+			return ErrMFANotEnabled
+		}
+		err := checkThrottledCode(tx, &user, ipAddress, code, checkTOTP)
+		if err != nil {
+			return err
+		}
+		recoveryCodes, err = newRecoveryCodes(tx, userID)
+		return err
+	})
+
+	err = s.finishThrottledCode(ctx, "mfa_recovery_codes", userID, user.Email, err)
+	return recoveryCodes, err
+}
+
+// finishThrottledCode audit logs an action confirmed by a throttled code.
+// A wrong code is counted as a failed login of the account and the client
+// IP, and the lockouts it causes are audit logged.
+func (s *AuthService) finishThrottledCode(ctx context.Context, action string, userID uint, email string, err error) error {
+	s.auditMFA(ctx, action, &userID, "", err)
+	if !errors.Is(err, ErrInvalidMFACode) {
+		return err
+	}
+	ipAddress, _ := clientInfo(ctx)
+	locked, recordErr := recordLoginFailure(email, ipAddress)
+	if recordErr != nil {
+		fmt.Printf("Warning: Failed to record login attempt: %v\n", recordErr)
+	}
+	s.auditLockouts(ctx, &userID, locked)
+	return err
+}
+
+// resetMFA removes the MFA secret and recovery codes of a user
+func resetMFA(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"mfa_enabled":    false,
+		"mfa_secret":     nil,
+		"mfa_last_step":  0,
+		"mfa_enabled_at": nil,
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error
+}
+
+// ResetMFA turns MFA off for a user who lost their authenticator and
+// recovery codes
+func ResetMFA(userID uint) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		return resetMFA(tx, userID)
+	})
+}
+
+// SetRoleMFARequired requires MFA, or stops requiring it, for the users of
+// a role. Users of the role without MFA are logged out, to enrol at their
+// next login.
+func SetRoleMFARequired(role *models.Role, required bool) error {
+	if err := database.DB.Model(role).Update("mfa_required", required).Error; err != nil {
+		return err
+	}
+	if !required {
+		return nil
+	}
+
+	var userIDs []uint
+	if err := database.DB.Model(&models.User{}).
+		Where("role_id = ? AND mfa_enabled = ?", role.ID, false).
+		Pluck("id", &userIDs).Error; err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if err := RevokeUserSessions(userID, RevokeMFARequired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AuthService) auditMFA(ctx context.Context, action string, userID *uint, detail string, err error) {
+	status := AuditSuccess
+	var errorMessage *string
+	if err != nil {
+		status = AuditError
+		var blocked *LoginBlockedError
+		if errors.Is(err, ErrInvalidMFACode) || errors.Is(err, ErrInvalidMFAChallenge) || errors.Is(err, errInactiveAccount) || errors.As(err, &blocked) {
+			status = AuditDenied
+		}
+		errorMessage = stringPtr(err.Error())
+	}
+	var detailPtr *string
+	if detail != "" {
+		detailPtr = &detail
+	}
+	ipAddress, userAgent := clientInfo(ctx)
+	if logErr := s.audit.LogAction(userID, action, "auth", detailPtr, nil, nil, ipAddress, userAgent, status, errorMessage, nil); logErr != nil {
+		fmt.Printf("Warning: Failed to audit %s: %v\n", action, logErr)
+	}
+}
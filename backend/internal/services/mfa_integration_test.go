@@ -0,0 +1,203 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"mastercard-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// mfaUser is a user with MFA enabled
+type mfaUser struct {
+	models.User
+	secret        string
+	recoveryCodes []string
+}
+
+// createMFAUser creates a user and enables MFA for them
+func createMFAUser(t *testing.T, tx *gorm.DB) *mfaUser {
+	t.Helper()
+	user := &mfaUser{User: models.User{
+		Email:        fmt.Sprintf("mfa-%d@example.test", time.Now().UnixNano()),
+		PasswordHash: "x",
+		FullName:     "MFA",
+		IsActive:     true,
+	}}
+	if err := tx.Create(&user.User).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	enrollment, err := newMFASecret(tx, &user.User)
+	if err != nil {
+		t.Fatalf("failed to enrol: %v", err)
+	}
+	user.secret = enrollment.Secret
+	if user.recoveryCodes, err = enableMFA(tx, &user.User); err != nil {
+		t.Fatalf("failed to enable MFA: %v", err)
+	}
+	return user
+}
+
+// currentTOTP returns the TOTP code of secret now
+func currentTOTP(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("invalid secret: %v", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:])&0x7fffffff)%1000000)
+}
+
+// A code of 7 digits is neither a TOTP nor a recovery code
+const wrongMFACode = "0000000"
+
+func TestRecoveryCodeIsAcceptedOnce(t *testing.T) {
+	tx := useTestTx(t)
+	user := createMFAUser(t, tx)
+	if len(user.recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(user.recoveryCodes), recoveryCodeCount)
+	}
+
+	// As typed: upper case, without the dash
+	typed := strings.ToUpper(strings.ReplaceAll(user.recoveryCodes[0], "-", " "))
+	for i, want := range []bool{true, false} {
+		ok, err := checkMFACode(tx, &user.User, typed)
+		if err != nil {
+			t.Fatalf("checkMFACode error: %v", err)
+		}
+		if ok != want {
+			t.Errorf("use %d accepted = %v, want %v", i+1, ok, want)
+		}
+	}
+}
+
+func TestTOTPCodeIsAcceptedOnce(t *testing.T) {
+	tx := useTestTx(t)
+	user := createMFAUser(t, tx)
+	code := currentTOTP(t, user.secret)
+
+	for i, want := range []bool{true, false} {
+		ok, err := checkTOTP(tx, &user.User, code)
+		if err != nil {
+			t.Fatalf("checkTOTP error: %v", err)
+		}
+		if ok != want {
+			t.Errorf("use %d accepted = %v, want %v", i+1, ok, want)
+		}
+	}
+}
+
+func TestRegeneratedRecoveryCodesReplaceTheOldOnes(t *testing.T) {
+	tx := useTestTx(t)
+	user := createMFAUser(t, tx)
+
+	codes, err := NewAuthService().RegenerateRecoveryCodes(context.Background(), user.ID, currentTOTP(t, user.secret))
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes error: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	if ok, _ := checkMFACode(tx, &user.User, user.recoveryCodes[0]); ok {
+		t.Error("an old recovery code is still accepted")
+	}
+	if ok, _ := checkMFACode(tx, &user.User, codes[0]); !ok {
+		t.Error("a new recovery code is refused")
+	}
+}
+
+func TestWrongMFACodesLockTheAccount(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(s *AuthService, ctx context.Context, userID uint, code string) error
+	}{
+		{"disable", func(s *AuthService, ctx context.Context, userID uint, code string) error {
+			return s.DisableMFA(ctx, userID, code)
+		}},
+		{"recovery codes", func(s *AuthService, ctx context.Context, userID uint, code string) error {
+			_, err := s.RegenerateRecoveryCodes(ctx, userID, code)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := useTestTx(t)
+			useLoginThrottle(t, 3, 0)
+			user := createMFAUser(t, tx)
+			s := NewAuthService()
+			ctx := WithClientInfo(context.Background(), "192.0.2.10", "test")
+
+			for i := 0; i < 3; i++ {
+				if err := tt.call(s, ctx, user.ID, wrongMFACode); !errors.Is(err, ErrInvalidMFACode) {
+					t.Fatalf("attempt %d error = %v, want ErrInvalidMFACode", i+1, err)
+				}
+			}
+			// Even the right code is refused once locked
+			err := tt.call(s, ctx, user.ID, currentTOTP(t, user.secret))
+			var blocked *LoginBlockedError
+			if !errors.As(err, &blocked) || !blocked.Locked {
+				t.Fatalf("error = %v, want a lockout", err)
+			}
+
+			var throttle models.LoginThrottle
+			if err := tx.Where("scope = ? AND subject = ?", throttleAccount, user.Email).Take(&throttle).Error; err != nil {
+				t.Fatalf("no throttle for the account: %v", err)
+			}
+			if throttle.Failures != 3 || throttle.LockedAt == nil {
+				t.Errorf("failures = %d, locked = %v, want 3 and locked", throttle.Failures, throttle.LockedAt != nil)
+			}
+			if !strings.Contains(strings.Join(loggedActions(), ","), "login_lockout") {
+				t.Error("the lockout was not audit logged")
+			}
+		})
+	}
+}
+
+func TestWrongCodeOfPendingEnrolmentCountsAsFailedLogin(t *testing.T) {
+	tx := useTestTx(t)
+	useLoginThrottle(t, 3, 0)
+	user := models.User{Email: fmt.Sprintf("enrol-%d@example.test", time.Now().UnixNano()), PasswordHash: "x", FullName: "Enrol", IsActive: true}
+	if err := tx.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	enrollment, err := newMFASecret(tx, &user)
+	if err != nil {
+		t.Fatalf("failed to enrol: %v", err)
+	}
+	s := NewAuthService()
+
+	if _, err := s.VerifyMFA(context.Background(), user.ID, wrongMFACode); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("error = %v, want ErrInvalidMFACode", err)
+	}
+	var throttle models.LoginThrottle
+	if err := tx.Where("scope = ? AND subject = ?", throttleAccount, user.Email).Take(&throttle).Error; err != nil || throttle.Failures != 1 {
+		t.Fatalf("failures = %d (%v), want 1", throttle.Failures, err)
+	}
+
+	codes, err := s.VerifyMFA(context.Background(), user.ID, currentTOTP(t, enrollment.Secret))
+	if err != nil || len(codes) != recoveryCodeCount {
+		t.Fatalf("VerifyMFA = %d codes, %v; want MFA enabled", len(codes), err)
+	}
+	// A session does not prove the password: the failure is kept
+	if err := tx.Where("scope = ? AND subject = ?", throttleAccount, user.Email).Take(&throttle).Error; err != nil || throttle.Failures != 1 {
+		t.Errorf("failures = %d (%v) after success, want 1", throttle.Failures, err)
+	}
+}
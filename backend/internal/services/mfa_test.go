@@ -0,0 +1,38 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateRecoveryCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != 11 || code[5] != '-' {
+			t.Fatalf("code %q is not of the form xxxxx-xxxxx", code)
+		}
+		if strings.Trim(strings.Replace(code, "-", "", 1), "abcdefghijklmnopqrstuvwxyz234567") != "" {
+			t.Fatalf("code %q has characters outside the alphabet", code)
+		}
+		if seen[code] {
+			t.Fatalf("code %q generated twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCodeIgnoresHowItIsTyped(t *testing.T) {
+	hash := hashRecoveryCode("k3m9q-x7p2a")
+	for _, typed := range []string{"k3m9qx7p2a", "K3M9Q-X7P2A", " k3m9q x7p2a ", "k3m9q - x7p2a"} {
+		if got := hashRecoveryCode(typed); got != hash {
+			t.Errorf("%q hashes differently from k3m9q-x7p2a", typed)
+		}
+	}
+	if hashRecoveryCode("k3m9q-x7p2b") == hash {
+		t.Error("different codes hash the same")
+	}
+}
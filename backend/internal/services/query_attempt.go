@@ -0,0 +1,47 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// QueryAttempt is one SQL query tried for a message by the self-correction loop
+type QueryAttempt struct {
+	Attempt int    `json:"attempt"`
+	SQL     string `json:"sql"`
+	// Stage is where the attempt failed: validate, plan or execute
+	Stage string `json:"stage,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// correctableError reports whether err is a PostgreSQL error the LLM can fix
+// by rewriting the query: syntax and undefined objects (class 42), data
+// exceptions such as invalid casts (class 22) and cardinality violations
+// (class 21). Permission, timeout, connection and resource errors are not
+// retried.
+func correctableError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || len(pgErr.Code) < 2 || pgErr.Code == "42501" {
+		return false
+	}
+	switch pgErr.Code[:2] {
+	case "21", "22", "42":
+		return true
+	}
+	return false
+}
+
+// encodeAttempts returns the JSON attempt list stored on a message
+func encodeAttempts(attempts []QueryAttempt) *string {
+	if len(attempts) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(attempts)
+	if err != nil {
+		return nil
+	}
+	text := string(data)
+	return &text
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"mastercard-backend/internal/models"
+)
+
+// Audit actions of the query pipeline; GetMetrics counts "query" entries
+const (
+	AuditActionQuery        = "query"
+	AuditActionConfirmQuery = "confirm_query"
+)
+
+// auditQuery records a run of the query pipeline: the question, the SQL
+// of the last attempt, the row count, the outcome and the time taken
+func (s *QueryService) auditQuery(ctx context.Context, action string, userID uint, question string, message *models.Message, err error, startTime time.Time) {
+	executionTime := int(time.Since(startTime).Milliseconds())
+	ipAddress, userAgent := clientInfo(ctx)
+
+	status := AuditSuccess
+	var sqlQuery, errorMessage *string
+	var rowCount *int
+	if err != nil {
+		status = AuditError
+		errorMessage = stringPtr(err.Error())
+	}
+	if message != nil {
+		sqlQuery = message.SQLQuery
+		rowCount = message.RowCount
+		status, errorMessage = queryAuditStatus(message)
+	}
+
+	if err := s.audit.LogAction(&userID, action, "queries", &question, sqlQuery, rowCount, ipAddress, userAgent, status, errorMessage, &executionTime); err != nil {
+		fmt.Printf("Warning: Failed to audit query: %v\n", err)
+	}
+}
+
+// queryAuditStatus maps the outcome stored on a message to an audit status
+func queryAuditStatus(message *models.Message) (string, *string) {
+	format := ""
+	if message.ResultFormat != nil {
+		format = *message.ResultFormat
+	}
+
+	switch format {
+	case "error":
+		if message.ErrorMessage != nil && strings.HasPrefix(*message.ErrorMessage, "Query rejected") {
+			return AuditDenied, message.ErrorMessage
+		}
+		return AuditError, message.ErrorMessage
+	case ResultFormatConfirmationRequired:
+		return AuditPending, message.ErrorMessage
+	}
+	return AuditSuccess, nil
+}
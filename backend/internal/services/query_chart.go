@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"mastercard-backend/internal/config"
+)
+
+// Chart marks
+const (
+	ChartLine    = "line"
+	ChartBar     = "bar"
+	ChartPie     = "pie"
+	ChartScatter = "scatter"
+)
+
+// Encoding types, as in Vega-Lite
+const (
+	FieldTemporal     = "temporal"
+	FieldQuantitative = "quantitative"
+	FieldNominal      = "nominal"
+)
+
+// Recommendation bounds
+const (
+	chartMaxPieSlices = 6
+	chartMaxSeries    = 10
+)
+
+// ChartSpec is a Vega-Lite style chart recommendation for a result. Several
+// measures are drawn as one series each by folding them into key/value.
+type ChartSpec struct {
+	Mark      string        `json:"mark"`
+	Transform []ChartFold   `json:"transform,omitempty"`
+	Encoding  ChartEncoding `json:"encoding"`
+	// Reason says why the chart was picked
+	Reason string `json:"reason,omitempty"`
+}
+
+// ChartFold turns the Fold columns into rows of As[0] (column name) and As[1] (value)
+type ChartFold struct {
+	Fold []string `json:"fold"`
+	As   []string `json:"as"`
+}
+
+// ChartEncoding binds result columns to the visual channels of a chart
+type ChartEncoding struct {
+	X     *ChartChannel `json:"x,omitempty"`
+	Y     *ChartChannel `json:"y,omitempty"`
+	Color *ChartChannel `json:"color,omitempty"`
+	Theta *ChartChannel `json:"theta,omitempty"`
+}
+
+// ChartChannel is the column shown on a channel
+type ChartChannel struct {
+	Field string `json:"field"`
+	Type  string `json:"type"`
+}
+
+// recommendChart picks a chart for a result from its column kinds, or
+// returns nil when the result is not worth charting:
+//   - a time column and measures: line, one series per measure or per category
+//   - a category and one measure: pie for a few non-negative slices, bar otherwise
+//   - two categories and one measure: bar with the second category as series
+//   - a category and several measures: grouped bar
+//   - two measures only: scatter
+func recommendChart(set *ResultSet) *ChartSpec {
+	if set == nil || len(set.Rows) < 2 {
+		return nil
+	}
+
+	var temporals, categories, measures []string
+	for _, column := range set.Columns {
+		switch column.Kind {
+		case KindDate, KindTimestamp:
+			temporals = append(temporals, column.Name)
+		case KindInteger, KindDecimal, KindFloat:
+			measures = append(measures, column.Name)
+		case KindText, KindBoolean:
+			categories = append(categories, column.Name)
+		}
+	}
+	if len(measures) == 0 {
+		return nil
+	}
+
+	switch {
+	case len(temporals) >= 1:
+		spec := &ChartSpec{
+			Mark:     ChartLine,
+			Encoding: ChartEncoding{X: &ChartChannel{Field: temporals[0], Type: FieldTemporal}},
+			Reason:   "time column with numeric measures",
+		}
+		if len(measures) == 1 && len(categories) >= 1 && distinctValues(set, categories[0]) <= chartMaxSeries {
+			spec.Encoding.Y = &ChartChannel{Field: measures[0], Type: FieldQuantitative}
+			spec.Encoding.Color = &ChartChannel{Field: categories[0], Type: FieldNominal}
+			spec.Reason = "time column with one series per category"
+			return spec
+		}
+		foldMeasures(spec, measures)
+		return spec
+
+	case len(categories) == 1 && len(measures) == 1:
+		if len(set.Rows) <= chartMaxPieSlices && nonNegative(set, measures[0]) {
+			return &ChartSpec{
+				Mark: ChartPie,
+				Encoding: ChartEncoding{
+					Theta: &ChartChannel{Field: measures[0], Type: FieldQuantitative},
+					Color: &ChartChannel{Field: categories[0], Type: FieldNominal},
+				},
+				Reason: "few categories sharing one measure",
+			}
+		}
+		return &ChartSpec{
+			Mark: ChartBar,
+			Encoding: ChartEncoding{
+				X: &ChartChannel{Field: categories[0], Type: FieldNominal},
+				Y: &ChartChannel{Field: measures[0], Type: FieldQuantitative},
+			},
+			Reason: "category with one measure",
+		}
+
+	case len(categories) >= 2 && len(measures) == 1 && distinctValues(set, categories[1]) <= chartMaxSeries:
+		return &ChartSpec{
+			Mark: ChartBar,
+			Encoding: ChartEncoding{
+				X:     &ChartChannel{Field: categories[0], Type: FieldNominal},
+				Y:     &ChartChannel{Field: measures[0], Type: FieldQuantitative},
+				Color: &ChartChannel{Field: categories[1], Type: FieldNominal},
+			},
+			Reason: "two categories with one measure",
+		}
+
+	case len(categories) >= 1:
+		spec := &ChartSpec{
+			Mark:     ChartBar,
+			Encoding: ChartEncoding{X: &ChartChannel{Field: categories[0], Type: FieldNominal}},
+			Reason:   "category with several measures",
+		}
+		foldMeasures(spec, measures)
+		return spec
+
+	case len(measures) == 2:
+		return &ChartSpec{
+			Mark: ChartScatter,
+			Encoding: ChartEncoding{
+				X: &ChartChannel{Field: measures[0], Type: FieldQuantitative},
+				Y: &ChartChannel{Field: measures[1], Type: FieldQuantitative},
+			},
+			Reason: "two numeric measures",
+		}
+	}
+
+	return nil
+}
+
+// chartFor recommends a chart for a result. With QUERY_CHART_LLM on, the
+// LLM may refine the recommendation; a refinement that does not fit the
+// result is dropped in favour of the recommendation.
+func (s *QueryService) chartFor(ctx context.Context, userQuery, sqlQuery string, set *ResultSet) *ChartSpec {
+	spec := recommendChart(set)
+	if spec == nil || !config.AppConfig.QueryChartLLM {
+		return spec
+	}
+
+	columns, err := json.Marshal(set.Columns)
+	if err != nil {
+		return spec
+	}
+	proposed, err := json.Marshal(spec)
+	if err != nil {
+		return spec
+	}
+
+	refinedText, err := s.provider.RefineChart(ctx, userQuery, sqlQuery, string(columns), string(proposed))
+	if err != nil {
+		fmt.Printf("Warning: Failed to refine chart: %v\n", err)
+		return spec
+	}
+	var refined ChartSpec
+	if err := json.Unmarshal([]byte(refinedText), &refined); err != nil {
+		fmt.Printf("Warning: Invalid refined chart: %v\n", err)
+		return spec
+	}
+	if err := validateChart(&refined, set); err != nil {
+		fmt.Printf("Warning: Invalid refined chart: %v\n", err)
+		return spec
+	}
+
+	return &refined
+}
+
+// foldMeasures puts one measure on y, or several as key/value series
+func foldMeasures(spec *ChartSpec, measures []string) {
+	if len(measures) == 1 {
+		spec.Encoding.Y = &ChartChannel{Field: measures[0], Type: FieldQuantitative}
+		return
+	}
+	spec.Transform = []ChartFold{{Fold: measures, As: []string{"measure", "value"}}}
+	spec.Encoding.Y = &ChartChannel{Field: "value", Type: FieldQuantitative}
+	spec.Encoding.Color = &ChartChannel{Field: "measure", Type: FieldNominal}
+}
+
+// validateChart checks that a chart spec, e.g. one refined by the LLM, uses
+// a known mark and only fields of the result
+func validateChart(spec *ChartSpec, set *ResultSet) error {
+	switch spec.Mark {
+	case ChartLine, ChartBar, ChartPie, ChartScatter:
+	default:
+		return fmt.Errorf("unknown chart mark %q", spec.Mark)
+	}
+
+	kinds := make(map[string]string, len(set.Columns))
+	for _, column := range set.Columns {
+		kinds[column.Name] = column.Kind
+	}
+	for _, fold := range spec.Transform {
+		if len(fold.As) != 2 {
+			return fmt.Errorf("fold needs two output fields")
+		}
+		for _, field := range fold.Fold {
+			switch kinds[field] {
+			case KindInteger, KindDecimal, KindFloat:
+			default:
+				return fmt.Errorf("fold field %q is not a numeric column", field)
+			}
+		}
+		kinds[fold.As[0]] = KindText
+		kinds[fold.As[1]] = KindDecimal
+	}
+
+	channels := []*ChartChannel{spec.Encoding.X, spec.Encoding.Y, spec.Encoding.Color, spec.Encoding.Theta}
+	used := 0
+	for _, channel := range channels {
+		if channel == nil {
+			continue
+		}
+		if _, ok := kinds[channel.Field]; !ok {
+			return fmt.Errorf("unknown chart field %q", channel.Field)
+		}
+		switch channel.Type {
+		case FieldTemporal, FieldQuantitative, FieldNominal:
+		default:
+			return fmt.Errorf("unknown encoding type %q", channel.Type)
+		}
+		used++
+	}
+	if used < 2 {
+		return fmt.Errorf("chart needs at least two encoded fields")
+	}
+
+	return nil
+}
+
+// encodeChart returns the JSON chart spec stored on a message
+func encodeChart(spec *ChartSpec) *string {
+	if spec == nil {
+		return nil
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil
+	}
+	text := string(data)
+	return &text
+}
+
+// columnIndex returns the position of a column in the result, or -1
+func columnIndex(set *ResultSet, name string) int {
+	for i, column := range set.Columns {
+		if column.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// distinctValues counts the distinct values of a column
+func distinctValues(set *ResultSet, name string) int {
+	i := columnIndex(set, name)
+	if i < 0 {
+		return 0
+	}
+	seen := make(map[string]bool)
+	for _, row := range set.Rows {
+		seen[fmt.Sprint(row[i])] = true
+	}
+	return len(seen)
+}
+
+// nonNegative reports whether a numeric column has no negative values
+func nonNegative(set *ResultSet, name string) bool {
+	i := columnIndex(set, name)
+	if i < 0 {
+		return false
+	}
+	for _, row := range set.Rows {
+		switch v := row[i].(type) {
+		case int64:
+			if v < 0 {
+				return false
+			}
+		case float64:
+			if v < 0 {
+				return false
+			}
+		case string:
+			// Decimals are exact strings
+			if f, err := strconv.ParseFloat(v, 64); err != nil || f < 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
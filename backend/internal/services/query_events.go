@@ -0,0 +1,33 @@
+package services
+
+import "encoding/json"
+
+// Progress events of StreamQuery, in pipeline order
+const (
+	EventSQLGenerated  = "sql_generated"
+	EventValidated     = "validated"
+	EventPlanned       = "planned"
+	EventAttemptFailed = "attempt_failed"
+	EventRows          = "rows"
+	EventChart         = "chart"
+	EventAnalysis      = "analysis"
+)
+
+// QueryEventFunc receives the progress events of StreamQuery. data is
+// marshalled to JSON by the caller.
+type QueryEventFunc func(event string, data interface{})
+
+// send reports an event when a receiver is set
+func (f QueryEventFunc) send(event string, data interface{}) {
+	if f != nil {
+		f(event, data)
+	}
+}
+
+// rawJSON embeds stored JSON text in an event as is
+func rawJSON(text *string) json.RawMessage {
+	if text == nil || *text == "" {
+		return nil
+	}
+	return json.RawMessage(*text)
+}
@@ -0,0 +1,446 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+	"mastercard-backend/pkg/parquet"
+	"mastercard-backend/pkg/pgsql"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// Export formats
+const (
+	ExportCSV     = "csv"
+	ExportXLSX    = "xlsx"
+	ExportParquet = "parquet"
+	ExportJSON    = "json"
+)
+
+// ErrUnsupportedExportFormat is returned for unknown export formats
+var ErrUnsupportedExportFormat = errors.New("unsupported export format, use csv, xlsx, parquet or json")
+
+// ExportContentType returns the MIME type of an export format
+func ExportContentType(format string) string {
+	switch format {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case ExportParquet:
+		return "application/vnd.apache.parquet"
+	}
+	return "application/json"
+}
+
+// ResultExport is the query of a message prepared for export
+type ResultExport struct {
+	Message models.Message
+	Format  string
+	stmt    *pgsql.Statement
+}
+
+// PrepareExport loads a message visible to the user whose query ran and
+// validates its SQL against the user's current allowlist, before anything is
+// streamed. SQL held back or rejected by the cost guard is not exported.
+func (s *QueryService) PrepareExport(userID, messageID uint, format string) (*ResultExport, error) {
+	switch format {
+	case ExportCSV, ExportXLSX, ExportParquet, ExportJSON:
+	default:
+		return nil, ErrUnsupportedExportFormat
+	}
+
+	var message models.Message
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		return tx.First(&message, messageID).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	stmt, err := s.executedStatement(userID, &message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResultExport{Message: message, Format: format, stmt: stmt}, nil
+}
+
+// WriteExport re-runs the query of a prepared export on the restricted pool
+// and streams up to ExportMaxRows rows to w. It returns the number of rows
+// written.
+func (s *QueryService) WriteExport(ctx context.Context, userID uint, export *ResultExport, w io.Writer) (int, error) {
+	timeout := time.Duration(config.AppConfig.QueryTimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	tx, err := beginQueryTx(ctx, userID, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	limited := fmt.Sprintf("SELECT * FROM (\n%s\n) AS q LIMIT %d", export.stmt.Text, config.AppConfig.ExportMaxRows)
+	rows, err := tx.QueryContext(ctx, limited)
+	if err != nil {
+		return 0, fmt.Errorf("query execution error: %w", err)
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get columns: %w", err)
+	}
+	columns := newResultColumns(columnTypes)
+
+	out, err := newResultWriter(export.Format, columns, w)
+	if err != nil {
+		return 0, err
+	}
+
+	rowCount := 0
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return rowCount, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if err := out.WriteRow(values); err != nil {
+			return rowCount, fmt.Errorf("failed to write row: %w", err)
+		}
+		rowCount++
+	}
+	if err := rows.Err(); err != nil {
+		return rowCount, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	if err := out.Close(); err != nil {
+		return rowCount, fmt.Errorf("failed to finish export: %w", err)
+	}
+	return rowCount, nil
+}
+
+// resultWriter encodes scanned rows in an export format
+type resultWriter interface {
+	WriteRow(values []interface{}) error
+	// Close writes any buffered data and the format's trailer
+	Close() error
+}
+
+func newResultWriter(format string, columns []ResultColumn, w io.Writer) (resultWriter, error) {
+	switch format {
+	case ExportCSV:
+		return newCSVResultWriter(columns, w)
+	case ExportXLSX:
+		return newXLSXResultWriter(columns, w)
+	case ExportParquet:
+		return newParquetResultWriter(columns, w)
+	case ExportJSON:
+		return newJSONResultWriter(columns, w)
+	}
+	return nil, ErrUnsupportedExportFormat
+}
+
+// exportText formats a value as text, as in result_data. NULL is reported
+// with ok false.
+func exportText(column *ResultColumn, value interface{}) (text string, ok bool) {
+	switch v := resultValue(column, value).(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.RawMessage:
+		return string(v), true
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// csvResultWriter writes a header line and one line per row; NULL is empty
+type csvResultWriter struct {
+	columns []ResultColumn
+	w       *csv.Writer
+}
+
+func newCSVResultWriter(columns []ResultColumn, w io.Writer) (*csvResultWriter, error) {
+	out := &csvResultWriter{columns: columns, w: csv.NewWriter(w)}
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	return out, out.w.Write(header)
+}
+
+func (out *csvResultWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i], _ = exportText(&out.columns[i], value)
+	}
+	return out.w.Write(record)
+}
+
+func (out *csvResultWriter) Close() error {
+	out.w.Flush()
+	return out.w.Error()
+}
+
+// jsonResultWriter streams the ResultSet envelope of result_data
+type jsonResultWriter struct {
+	columns []ResultColumn
+	w       io.Writer
+	rows    int
+}
+
+func newJSONResultWriter(columns []ResultColumn, w io.Writer) (*jsonResultWriter, error) {
+	header, err := json.Marshal(columns)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(w, `{"columns":%s,"rows":[`, header); err != nil {
+		return nil, err
+	}
+	return &jsonResultWriter{columns: columns, w: w}, nil
+}
+
+func (out *jsonResultWriter) WriteRow(values []interface{}) error {
+	row := make([]interface{}, len(values))
+	for i, value := range values {
+		row[i] = resultValue(&out.columns[i], value)
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if out.rows > 0 {
+		if _, err := io.WriteString(out.w, ","); err != nil {
+			return err
+		}
+	}
+	out.rows++
+	_, err = out.w.Write(data)
+	return err
+}
+
+func (out *jsonResultWriter) Close() error {
+	_, err := io.WriteString(out.w, "]}")
+	return err
+}
+
+// xlsxResultWriter writes one sheet with a header row. Numbers, decimals and
+// dates become native cells; the workbook is written to w on Close.
+type xlsxResultWriter struct {
+	columns []ResultColumn
+	file    *excelize.File
+	sheet   *excelize.StreamWriter
+	w       io.Writer
+	row     int
+}
+
+func newXLSXResultWriter(columns []ResultColumn, w io.Writer) (*xlsxResultWriter, error) {
+	file := excelize.NewFile()
+	sheet, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	if err := sheet.SetRow("A1", header); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &xlsxResultWriter{columns: columns, file: file, sheet: sheet, w: w, row: 1}, nil
+}
+
+func (out *xlsxResultWriter) WriteRow(values []interface{}) error {
+	cells := make([]interface{}, len(values))
+	for i, value := range values {
+		column := &out.columns[i]
+		switch v := value.(type) {
+		case nil:
+			cells[i] = nil
+		case time.Time, int64, bool:
+			cells[i] = v
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				cells[i], _ = exportText(column, v)
+			} else {
+				cells[i] = v
+			}
+		default:
+			text, _ := exportText(column, v)
+			if column.Kind == KindDecimal {
+				// Spreadsheets hold numbers as doubles
+				if f, err := strconv.ParseFloat(text, 64); err == nil {
+					cells[i] = f
+					continue
+				}
+			}
+			cells[i] = text
+		}
+	}
+
+	out.row++
+	cell, err := excelize.CoordinatesToCellName(1, out.row)
+	if err != nil {
+		return err
+	}
+	return out.sheet.SetRow(cell, cells)
+}
+
+func (out *xlsxResultWriter) Close() error {
+	defer out.file.Close()
+	if err := out.sheet.Flush(); err != nil {
+		return err
+	}
+	return out.file.Write(out.w)
+}
+
+// parquetResultWriter writes a Parquet file with one OPTIONAL column per
+// result column. Decimals with a declared precision become DECIMAL, dates
+// DATE and timestamps TIMESTAMP_MILLIS; other values are stored as text.
+type parquetResultWriter struct {
+	columns []ResultColumn
+	kinds   []parquet.Kind
+	w       *parquet.Writer
+}
+
+var parquetNameInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func newParquetResultWriter(columns []ResultColumn, w io.Writer) (*parquetResultWriter, error) {
+	schema := make([]parquet.Column, len(columns))
+	kinds := make([]parquet.Kind, len(columns))
+	seen := make(map[string]bool)
+	for i, column := range columns {
+		name := parquetNameInvalid.ReplaceAllString(column.Name, "_")
+		if name == "" || seen[strings.ToLower(name)] {
+			name = fmt.Sprintf("%s_%d", name, i+1)
+		}
+		seen[strings.ToLower(name)] = true
+
+		schema[i] = parquet.Column{Name: name, Kind: parquetKind(&column)}
+		if schema[i].Kind == parquet.Decimal {
+			schema[i].Precision = int(*column.Precision)
+			schema[i].Scale = int(*column.Scale)
+		}
+		kinds[i] = schema[i].Kind
+	}
+
+	pw, err := parquet.NewWriter(w, schema)
+	if err != nil {
+		return nil, err
+	}
+	return &parquetResultWriter{columns: columns, kinds: kinds, w: pw}, nil
+}
+
+// parquetKind returns the Parquet type of a column
+func parquetKind(column *ResultColumn) parquet.Kind {
+	switch column.Kind {
+	case KindInteger:
+		return parquet.Int64
+	case KindFloat:
+		return parquet.Double
+	case KindBoolean:
+		return parquet.Boolean
+	case KindDate:
+		return parquet.Date
+	case KindTimestamp:
+		return parquet.TimestampMillis
+	case KindBinary:
+		return parquet.Bytes
+	case KindDecimal:
+		if column.Precision != nil && column.Scale != nil && *column.Precision > 0 {
+			return parquet.Decimal
+		}
+	}
+	return parquet.String
+}
+
+func (out *parquetResultWriter) WriteRow(values []interface{}) error {
+	row := make([]interface{}, len(values))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		column := &out.columns[i]
+		switch out.kinds[i] {
+		case parquet.Int64:
+			switch v := value.(type) {
+			case int64:
+				row[i] = v
+			case int32:
+				row[i] = int64(v)
+			case int16:
+				row[i] = int64(v)
+			}
+		case parquet.Double:
+			if v, ok := value.(float64); ok {
+				row[i] = v
+			} else if v, ok := value.(float32); ok {
+				row[i] = float64(v)
+			}
+		case parquet.Boolean:
+			if v, ok := value.(bool); ok {
+				row[i] = v
+			}
+		case parquet.Date:
+			if v, ok := value.(time.Time); ok {
+				days := v.Unix() / 86400
+				if v.Unix() < 0 && v.Unix()%86400 != 0 {
+					days--
+				}
+				row[i] = int32(days)
+			}
+		case parquet.TimestampMillis:
+			if v, ok := value.(time.Time); ok {
+				row[i] = v.UnixMilli()
+			}
+		case parquet.Bytes:
+			if v, ok := value.([]byte); ok {
+				row[i] = v
+			}
+		case parquet.Decimal:
+			// NaN has no DECIMAL representation and is written as NULL
+			if text, ok := exportText(column, value); ok && text != "NaN" {
+				row[i] = text
+			}
+		default:
+			if text, ok := exportText(column, value); ok {
+				row[i] = text
+			}
+		}
+	}
+	return out.w.Write(row)
+}
+
+func (out *parquetResultWriter) Close() error {
+	return out.w.Close()
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"mastercard-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Query job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+var (
+	// ErrJobNotFound is returned for unknown or expired jobs and for jobs of other users
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobQueueFull is returned when the job queue has no room left
+	ErrJobQueueFull = errors.New("too many queued queries, try again later")
+	// ErrJobFinished is returned when cancelling a job that already finished
+	ErrJobFinished = errors.New("job already finished")
+)
+
+// QueryJob is a natural language query run in the background by the
+// worker pool of QueryService
+type QueryJob struct {
+	ID             string          `json:"id"`
+	UserID         uint            `json:"user_id"`
+	Query          string          `json:"query"`
+	ConversationID *uint           `json:"conversation_id,omitempty"`
+	Status         string          `json:"status"`
+	Message        *models.Message `json:"message,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	StartedAt      *time.Time      `json:"started_at,omitempty"`
+	FinishedAt     *time.Time      `json:"finished_at,omitempty"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// cancelledAt is when the job was cancelled while it ran
+	cancelledAt *time.Time
+}
+
+// finished reports whether the job reached a final status
+func (j *QueryJob) finished() bool {
+	return j.FinishedAt != nil
+}
+
+// jobPool is the in-memory job table and the bounded queue feeding the workers
+type jobPool struct {
+	mu     sync.Mutex
+	jobs   map[string]*QueryJob
+	queue  chan *QueryJob
+	ttl    time.Duration
+	closed bool
+	wg     sync.WaitGroup
+	// done stops the pruner
+	done chan struct{}
+}
+
+// maxPruneInterval bounds how long an expired job outlives its TTL
+const maxPruneInterval = time.Minute
+
+// startJobs starts the worker pool of the service
+func (s *QueryService) startJobs(workers, queueSize int, ttl time.Duration) {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	s.jobs = &jobPool{
+		jobs:  make(map[string]*QueryJob),
+		queue: make(chan *QueryJob, queueSize),
+		ttl:   ttl,
+		done:  make(chan struct{}),
+	}
+	if ttl > 0 {
+		go s.jobs.pruneEvery(min(ttl, maxPruneInterval))
+	}
+
+	for i := 0; i < workers; i++ {
+		s.jobs.wg.Add(1)
+		go func() {
+			defer s.jobs.wg.Done()
+			for job := range s.jobs.queue {
+				s.runJob(job)
+			}
+		}()
+	}
+}
+
+// SubmitQuery queues a query for the worker pool and returns the job
+// immediately. The result is read with GetJob. The job keeps the values of
+// ctx, such as the client info, but not its cancellation.
+func (s *QueryService) SubmitQuery(ctx context.Context, userID uint, query string, conversationID *uint) (*QueryJob, error) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	job := &QueryJob{
+		ID:             uuid.NewString(),
+		UserID:         userID,
+		Query:          query,
+		ConversationID: conversationID,
+		Status:         JobQueued,
+		CreatedAt:      time.Now(),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+
+	p := s.jobs
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		cancel()
+		return nil, errors.New("query service is shutting down")
+	}
+
+	select {
+	case p.queue <- job:
+	default:
+		cancel()
+		return nil, ErrJobQueueFull
+	}
+	p.jobs[job.ID] = job
+
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// GetJob returns the current state of a job of the user
+func (s *QueryService) GetJob(userID uint, jobID string) (*QueryJob, error) {
+	p := s.jobs
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job, ok := p.jobs[jobID]
+	if !ok || job.UserID != userID {
+		return nil, ErrJobNotFound
+	}
+
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// CancelJob cancels a job of the user. A queued job is cancelled at once; a
+// running job has its context cancelled, which stops the LLM call or the
+// running statement, and turns cancelled when its worker returns.
+func (s *QueryService) CancelJob(userID uint, jobID string) (*QueryJob, error) {
+	p := s.jobs
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job, ok := p.jobs[jobID]
+	if !ok || job.UserID != userID {
+		return nil, ErrJobNotFound
+	}
+	if job.finished() {
+		return nil, ErrJobFinished
+	}
+
+	now := time.Now()
+	job.cancel()
+	job.cancelledAt = &now
+	if job.Status == JobQueued {
+		job.Status = JobCancelled
+		job.FinishedAt = &now
+	}
+
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// runJob runs one job on a worker
+func (s *QueryService) runJob(job *QueryJob) {
+	p := s.jobs
+
+	p.mu.Lock()
+	if job.finished() {
+		// Cancelled while queued
+		p.mu.Unlock()
+		return
+	}
+	if job.ctx.Err() != nil {
+		// Drained from the queue during shutdown
+		now := time.Now()
+		job.Status = JobCancelled
+		job.FinishedAt = &now
+		p.mu.Unlock()
+		return
+	}
+	startedAt := time.Now()
+	job.Status = JobRunning
+	job.StartedAt = &startedAt
+	p.mu.Unlock()
+
+	message, err := s.StreamQuery(job.ctx, job.UserID, job.Query, job.ConversationID, nil)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job.finish(message, err)
+	job.cancel()
+}
+
+// finish records the outcome of the job's query. Called with mu held.
+func (j *QueryJob) finish(message *models.Message, err error) {
+	finishedAt := time.Now()
+	j.FinishedAt = &finishedAt
+	j.Message = message
+	// A complete result stands whenever the cancel came, and so does an
+	// error saved before it
+	failed := message != nil && message.ResultFormat != nil && *message.ResultFormat == "error"
+	cancelled := j.cancelledAt != nil && (message == nil || j.cancelledAt.Before(message.CreatedAt))
+	switch {
+	case (err != nil || failed) && cancelled:
+		j.Status = JobCancelled
+	case err != nil:
+		j.Status = JobFailed
+		j.Error = err.Error()
+	case failed:
+		j.Status = JobFailed
+		if message.ErrorMessage != nil {
+			j.Error = *message.ErrorMessage
+		}
+	default:
+		j.Status = JobSucceeded
+	}
+}
+
+// pruneEvery prunes the jobs at every interval until stopJobs
+func (p *jobPool) pruneEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			p.prune()
+			p.mu.Unlock()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// prune drops finished jobs older than the TTL. Called with mu held.
+func (p *jobPool) prune() {
+	cutoff := time.Now().Add(-p.ttl)
+	for id, job := range p.jobs {
+		if job.finished() && job.FinishedAt.Before(cutoff) {
+			delete(p.jobs, id)
+		}
+	}
+}
+
+// stopJobs cancels all jobs and waits for the workers to return
+func (s *QueryService) stopJobs() {
+	p := s.jobs
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	now := time.Now()
+	for _, job := range p.jobs {
+		if !job.finished() {
+			job.cancel()
+			job.cancelledAt = &now
+		}
+	}
+	close(p.queue)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"mastercard-backend/internal/models"
+)
+
+func TestJobFinishStatus(t *testing.T) {
+	saved := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	before, after := saved.Add(-time.Second), saved.Add(time.Second)
+	result := &models.Message{ResultFormat: stringPtr("table"), CreatedAt: saved}
+	failure := &models.Message{ResultFormat: stringPtr("error"), ErrorMessage: stringPtr("Query execution failed"), CreatedAt: saved}
+
+	tests := []struct {
+		name        string
+		message     *models.Message
+		err         error
+		cancelledAt *time.Time
+		want        string
+	}{
+		{"result", result, nil, nil, JobSucceeded},
+		{"error message", failure, nil, nil, JobFailed},
+		{"error", nil, errors.New("failed to load user"), nil, JobFailed},
+		{"cancelled run", nil, errors.New("context canceled"), &before, JobCancelled},
+		{"error saved after cancel", failure, nil, &before, JobCancelled},
+		{"result saved after cancel", result, nil, &before, JobSucceeded},
+		{"cancel after result saved", result, nil, &after, JobSucceeded},
+		{"cancel after error saved", failure, nil, &after, JobFailed},
+	}
+	for _, tt := range tests {
+		job := &QueryJob{Status: JobRunning, cancelledAt: tt.cancelledAt}
+		job.finish(tt.message, tt.err)
+		if job.Status != tt.want || !job.finished() || job.Message != tt.message {
+			t.Errorf("%s: status = %s, want %s", tt.name, job.Status, tt.want)
+		}
+	}
+}
+
+func TestJobsArePrunedWithoutSubmissions(t *testing.T) {
+	s := &QueryService{}
+	s.startJobs(1, 1, 20*time.Millisecond)
+	defer s.stopJobs()
+
+	finishedAt := time.Now()
+	s.jobs.mu.Lock()
+	s.jobs.jobs["done"] = &QueryJob{ID: "done", UserID: 1, Status: JobSucceeded, FinishedAt: &finishedAt}
+	s.jobs.jobs["running"] = &QueryJob{ID: "running", UserID: 1, Status: JobRunning, cancel: func() {}}
+	s.jobs.mu.Unlock()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := s.GetJob(1, "done"); errors.Is(err, ErrJobNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the finished job was not pruned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := s.GetJob(1, "running"); err != nil {
+		t.Errorf("the running job was pruned: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mastercard-backend/internal/config"
+)
+
+// QueryPlan is the summary of the top node of an EXPLAIN plan
+type QueryPlan struct {
+	NodeType    string  `json:"Node Type"`
+	StartupCost float64 `json:"Startup Cost"`
+	TotalCost   float64 `json:"Total Cost"`
+	PlanRows    int64   `json:"Plan Rows"`
+	PlanWidth   int     `json:"Plan Width"`
+	// Plans are the input nodes
+	Plans []QueryPlan `json:"Plans"`
+}
+
+// underLimit summarizes the plan of a statement wrapped in a LIMIT: the
+// cost of the Limit node, which stops the statement early, with the node
+// type and row estimate of the statement itself
+func (p *QueryPlan) underLimit() *QueryPlan {
+	if p.NodeType != "Limit" || len(p.Plans) != 1 {
+		return p
+	}
+	inner := p.Plans[0]
+	// A subquery Postgres could not pull up is scanned as a whole
+	if inner.NodeType == "Subquery Scan" && len(inner.Plans) == 1 {
+		inner = inner.Plans[0]
+	}
+	return &QueryPlan{
+		NodeType:    inner.NodeType,
+		StartupCost: p.StartupCost,
+		TotalCost:   p.TotalCost,
+		PlanRows:    inner.PlanRows,
+		PlanWidth:   inner.PlanWidth,
+	}
+}
+
+// explainQuery runs EXPLAIN (FORMAT JSON) for sqlText on tx without executing it
+func explainQuery(ctx context.Context, tx *sql.Tx, sqlText string) (*QueryPlan, error) {
+	var raw []byte
+	if err := tx.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+sqlText).Scan(&raw); err != nil {
+		return nil, fmt.Errorf("explain failed: %w", err)
+	}
+
+	var plans []struct {
+		Plan QueryPlan `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	if len(plans) == 0 {
+		return nil, errors.New("explain returned no plan")
+	}
+
+	return &plans[0].Plan, nil
+}
+
+// QueryLimits are the EXPLAIN thresholds of a role. Zero disables a limit.
+type QueryLimits struct {
+	MaxCost float64
+	MaxRows int64
+}
+
+// LoadQueryLimits returns the thresholds for a role from QUERY_MAX_COST and
+// QUERY_MAX_ROWS. A "*" entry applies to roles without their own entry.
+func LoadQueryLimits(roleName string) QueryLimits {
+	return QueryLimits{
+		MaxCost: roleLimit(config.AppConfig.QueryMaxCost, roleName),
+		MaxRows: int64(roleLimit(config.AppConfig.QueryMaxRows, roleName)),
+	}
+}
+
+// Exceeded returns why the plan is over the limits, or "" when it is not
+func (l QueryLimits) Exceeded(plan *QueryPlan) string {
+	if l.MaxCost > 0 && plan.TotalCost > l.MaxCost {
+		return fmt.Sprintf("estimated cost %.0f exceeds the limit of %.0f", plan.TotalCost, l.MaxCost)
+	}
+	if l.MaxRows > 0 && plan.PlanRows > l.MaxRows {
+		return fmt.Sprintf("estimated %d rows exceed the limit of %d", plan.PlanRows, l.MaxRows)
+	}
+	return ""
+}
+
+// PlanSummary is the plan information stored on a message
+type PlanSummary struct {
+	NodeType  string  `json:"node_type"`
+	TotalCost float64 `json:"total_cost"`
+	PlanRows  int64   `json:"plan_rows"`
+	MaxCost   float64 `json:"max_cost,omitempty"`
+	MaxRows   int64   `json:"max_rows,omitempty"`
+	Exceeded  string  `json:"exceeded,omitempty"`
+}
+
+// summarizePlan returns the JSON plan summary for a message
+func summarizePlan(plan *QueryPlan, limits QueryLimits) *string {
+	summary, err := json.Marshal(PlanSummary{
+		NodeType:  plan.NodeType,
+		TotalCost: plan.TotalCost,
+		PlanRows:  plan.PlanRows,
+		MaxCost:   limits.MaxCost,
+		MaxRows:   limits.MaxRows,
+		Exceeded:  limits.Exceeded(plan),
+	})
+	if err != nil {
+		return nil
+	}
+	text := string(summary)
+	return &text
+}
+
+// roleLimit parses a "role=limit,..." list
+func roleLimit(list, roleName string) float64 {
+	var fallback float64
+	for _, entry := range strings.Split(list, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		limit, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case roleName:
+			return limit
+		case "*":
+			fallback = limit
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/pkg/pgsql"
+)
+
+// explainJSON is EXPLAIN (FORMAT JSON) output of a statement wrapped by
+// pagedSQL, trimmed to the fields QueryPlan reads
+const explainJSON = `[{"Plan": {
+	"Node Type": "Limit", "Startup Cost": 0.00, "Total Cost": 412.51, "Plan Rows": 10001, "Plan Width": 64,
+	"Plans": [{"Node Type": "Seq Scan", "Parent Relationship": "Outer", "Startup Cost": 0.00,
+		"Total Cost": 98231.00, "Plan Rows": 2381500, "Plan Width": 64}]
+}}]`
+
+func parsePlan(t *testing.T, raw string) *QueryPlan {
+	t.Helper()
+	var plans []struct {
+		Plan QueryPlan `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(raw), &plans); err != nil {
+		t.Fatal(err)
+	}
+	return &plans[0].Plan
+}
+
+func TestPlanUnderLimit(t *testing.T) {
+	plan := parsePlan(t, explainJSON).underLimit()
+	if plan.NodeType != "Seq Scan" || plan.TotalCost != 412.51 || plan.PlanRows != 2381500 {
+		t.Errorf("plan = %+v, want the Limit cost with the rows of the Seq Scan", plan)
+	}
+}
+
+func TestPlanUnderLimitSkipsSubqueryScan(t *testing.T) {
+	plan := (&QueryPlan{NodeType: "Limit", TotalCost: 10, PlanRows: 11, Plans: []QueryPlan{
+		{NodeType: "Subquery Scan", TotalCost: 900, PlanRows: 5000, Plans: []QueryPlan{
+			{NodeType: "HashAggregate", TotalCost: 850, PlanRows: 5000},
+		}},
+	}}).underLimit()
+	if plan.NodeType != "HashAggregate" || plan.TotalCost != 10 || plan.PlanRows != 5000 {
+		t.Errorf("plan = %+v, want the Limit cost with the rows of the HashAggregate", plan)
+	}
+}
+
+func TestPlanUnderLimitKeepsOtherPlans(t *testing.T) {
+	plan := &QueryPlan{NodeType: "Aggregate", TotalCost: 10, PlanRows: 1}
+	if got := plan.underLimit(); got != plan {
+		t.Errorf("got %+v, want the plan unchanged", got)
+	}
+}
+
+func TestQueryLimitsExceeded(t *testing.T) {
+	plan := &QueryPlan{TotalCost: 1500, PlanRows: 20000}
+	tests := []struct {
+		limits QueryLimits
+		want   string
+	}{
+		{QueryLimits{}, ""},
+		{QueryLimits{MaxCost: 2000, MaxRows: 50000}, ""},
+		{QueryLimits{MaxCost: 1000}, "estimated cost 1500 exceeds the limit of 1000"},
+		{QueryLimits{MaxRows: 10000}, "estimated 20000 rows exceed the limit of 10000"},
+	}
+	for _, tt := range tests {
+		if got := tt.limits.Exceeded(plan); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.limits, got, tt.want)
+		}
+	}
+}
+
+func TestLoadQueryLimits(t *testing.T) {
+	saved := config.AppConfig
+	config.AppConfig = &config.Config{QueryMaxCost: "analyzer=500, admin=0, *=100", QueryMaxRows: "analyzer=1000,bad,manager=x"}
+	t.Cleanup(func() { config.AppConfig = saved })
+
+	tests := []struct {
+		role string
+		want QueryLimits
+	}{
+		{"analyzer", QueryLimits{MaxCost: 500, MaxRows: 1000}},
+		{"admin", QueryLimits{MaxCost: 0}},
+		{"manager", QueryLimits{MaxCost: 100}},
+		{"", QueryLimits{MaxCost: 100}},
+	}
+	for _, tt := range tests {
+		if got := LoadQueryLimits(tt.role); got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestPagedSQL(t *testing.T) {
+	stmt, err := NewSQLValidator().Validate("SELECT id FROM transactions ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT * FROM (\nSELECT id FROM transactions ORDER BY id\n) AS q LIMIT 101 OFFSET 200"
+	if got := pagedSQL(stmt, 101, 200); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestIsOrdered(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"SELECT id FROM transactions ORDER BY id", true},
+		{"SELECT id FROM transactions UNION SELECT id FROM transactions ORDER BY 1", true},
+		{"SELECT id FROM transactions", false},
+		{"SELECT id FROM (SELECT id FROM transactions ORDER BY id) AS t", false},
+		{"WITH t AS (SELECT id FROM transactions ORDER BY id) SELECT id FROM t", false},
+	}
+	for _, tt := range tests {
+		stmt, err := NewSQLValidator().Validate(tt.sql)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.sql, err)
+		}
+		if got := isOrdered(stmt); got != tt.want {
+			t.Errorf("%s: ordered = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+	if isOrdered(&pgsql.Statement{Keyword: "select"}) {
+		t.Error("a statement without a query is ordered")
+	}
+}
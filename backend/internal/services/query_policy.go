@@ -0,0 +1,450 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+	"mastercard-backend/pkg/pgsql"
+)
+
+// TablePolicy lists the columns of a table that generated SQL may read.
+// A nil Columns map means every column is readable.
+type TablePolicy struct {
+	Columns map[string]bool
+}
+
+// allows reports whether the column may be read
+func (t *TablePolicy) allows(column string) bool {
+	return t.Columns == nil || t.Columns[column]
+}
+
+// QueryPolicy is the table and column allowlist applied to generated SQL
+type QueryPolicy struct {
+	Tables map[string]*TablePolicy
+}
+
+// LoadQueryPolicy builds the allowlist for a role from its "<table>.read"
+// (all columns) and "<table>.read_limited" (conditions.columns) permissions,
+// capped by QUERY_ALLOWED_TABLES
+func LoadQueryPolicy(roleID *uint) (*QueryPolicy, error) {
+	policy := &QueryPolicy{Tables: make(map[string]*TablePolicy)}
+	if roleID == nil {
+		return policy, nil
+	}
+
+	allowedTables := make(map[string]bool)
+	for _, table := range strings.Split(config.AppConfig.QueryAllowedTables, ",") {
+		if table = strings.ToLower(strings.TrimSpace(table)); table != "" {
+			allowedTables[table] = true
+		}
+	}
+
+	var permissions []models.Permission
+	if err := database.DB.
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Where("role_permissions.role_id = ? AND permissions.action IN ?", *roleID, []string{"read", "read_limited"}).
+		Find(&permissions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load query permissions: %w", err)
+	}
+
+	for _, permission := range permissions {
+		table := strings.ToLower(permission.Resource)
+		if !allowedTables[table] {
+			continue
+		}
+
+		existing := policy.Tables[table]
+		if permission.Action == "read" {
+			policy.Tables[table] = &TablePolicy{}
+			continue
+		}
+		if existing != nil && existing.Columns == nil {
+			continue
+		}
+
+		var conditions struct {
+			Columns []string `json:"columns"`
+		}
+		if permission.Conditions != "" {
+			if err := json.Unmarshal([]byte(permission.Conditions), &conditions); err != nil {
+				return nil, fmt.Errorf("invalid conditions on permission %s.%s: %w", permission.Resource, permission.Action, err)
+			}
+		}
+
+		if existing == nil {
+			existing = &TablePolicy{Columns: make(map[string]bool)}
+			policy.Tables[table] = existing
+		}
+		for _, column := range conditions.Columns {
+			existing.Columns[strings.ToLower(column)] = true
+		}
+	}
+
+	return policy, nil
+}
+
+// policyScope holds the FROM items visible to the expressions of one SELECT
+type policyScope struct {
+	parent *policyScope
+	// tables maps an alias (or table name) to the allowlist of the base table
+	tables map[string]*TablePolicy
+	// derived holds aliases of subqueries, CTEs and table functions
+	derived map[string]bool
+	// aliases holds the output names of the select list, which only ORDER BY
+	// may refer to
+	aliases map[string]bool
+}
+
+func newPolicyScope(parent *policyScope) *policyScope {
+	return &policyScope{
+		parent:  parent,
+		tables:  make(map[string]*TablePolicy),
+		derived: make(map[string]bool),
+		aliases: make(map[string]bool),
+	}
+}
+
+// Check verifies that every relation and column referenced by the query is
+// allowed, returning a *SQLValidationError otherwise
+func (p *QueryPolicy) Check(query *pgsql.Query) error {
+	if err := p.checkQuery(query, nil, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *QueryPolicy) checkQuery(query *pgsql.Query, scope *policyScope, ctes map[string]bool) *SQLValidationError {
+	visible := make(map[string]bool, len(ctes)+len(query.With))
+	for name := range ctes {
+		visible[name] = true
+	}
+
+	for _, cte := range query.With {
+		if query.Recursive {
+			visible[cte.Name] = true
+		}
+		if cte.Query != nil {
+			if err := p.checkQuery(cte.Query, scope, visible); err != nil {
+				return err
+			}
+		}
+		visible[cte.Name] = true
+	}
+
+	var first *policyScope
+	for _, sel := range query.Selects {
+		selScope, err := p.checkSelect(sel, scope, visible)
+		if err != nil {
+			return err
+		}
+		if first == nil {
+			first = selScope
+		}
+	}
+
+	// ORDER BY may use output names of the first branch
+	orderScope := first
+	if orderScope == nil {
+		orderScope = newPolicyScope(scope)
+	}
+	for _, e := range query.OrderBy {
+		if isOutputName(e, orderScope) {
+			continue
+		}
+		if err := p.checkExpr(e, orderScope, visible); err != nil {
+			return err
+		}
+	}
+	for _, e := range []*pgsql.Expr{query.Limit, query.Offset} {
+		if err := p.checkExpr(e, newPolicyScope(scope), visible); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *QueryPolicy) checkSelect(sel *pgsql.Select, parent *policyScope, ctes map[string]bool) (*policyScope, *SQLValidationError) {
+	scope := newPolicyScope(parent)
+
+	if sel.Nested != nil {
+		return scope, p.checkQuery(sel.Nested, parent, ctes)
+	}
+
+	if sel.Table != nil {
+		table, err := p.table(sel.Table, ctes)
+		if err != nil {
+			return nil, err
+		}
+		if table != nil && table.Columns != nil {
+			return nil, &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("TABLE %s would read restricted columns; select the allowed columns explicitly", sel.Table.Name)}
+		}
+		return scope, nil
+	}
+
+	for _, item := range sel.From {
+		if err := p.addFromItem(item, scope, parent, ctes); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, target := range sel.Targets {
+		if target.Alias != "" {
+			scope.aliases[target.Alias] = true
+		}
+	}
+
+	for _, target := range sel.Targets {
+		if target.Star {
+			if err := p.checkStar(target.StarQualifier, scope); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := p.checkExpr(target.Expr, scope, ctes); err != nil {
+			return nil, err
+		}
+	}
+
+	// ON conditions are checked once the whole FROM list is in scope
+	for _, item := range sel.From {
+		if strings.HasPrefix(item.JoinType, "natural") {
+			for name, table := range scope.tables {
+				if table.Columns != nil {
+					return nil, &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("NATURAL JOIN with restricted table %s is not allowed; join ON the allowed columns", name)}
+				}
+			}
+		}
+		if err := p.checkExpr(item.On, scope, ctes); err != nil {
+			return nil, err
+		}
+		for _, column := range item.Using {
+			if err := p.checkColumn(&pgsql.ColumnRef{Name: column}, scope); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	exprs := []*pgsql.Expr{sel.Where, sel.Having}
+	exprs = append(exprs, sel.DistinctOn...)
+	exprs = append(exprs, sel.GroupBy...)
+	exprs = append(exprs, sel.Windows...)
+	for _, row := range sel.Values {
+		exprs = append(exprs, row...)
+	}
+	for _, e := range exprs {
+		if err := p.checkExpr(e, scope, ctes); err != nil {
+			return nil, err
+		}
+	}
+
+	return scope, nil
+}
+
+func (p *QueryPolicy) addFromItem(item *pgsql.FromItem, scope, parent *policyScope, ctes map[string]bool) *SQLValidationError {
+	for _, e := range item.Sample {
+		if err := p.checkExpr(e, scope, ctes); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case item.Relation != nil:
+		table, err := p.table(item.Relation, ctes)
+		if err != nil {
+			return err
+		}
+		alias := item.Alias
+		if alias == "" {
+			alias = item.Relation.Name
+		}
+		if table == nil || len(item.ColumnAliases) > 0 {
+			// CTE reference, or renamed columns that can no longer be traced
+			if table != nil && table.Columns != nil {
+				return &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("column aliases on restricted table %s are not allowed", item.Relation.Name)}
+			}
+			scope.derived[alias] = true
+			return nil
+		}
+		scope.tables[alias] = table
+
+	case item.Subquery != nil:
+		outer := parent
+		if item.Lateral {
+			outer = scope
+		}
+		if err := p.checkQuery(item.Subquery, outer, ctes); err != nil {
+			return err
+		}
+		scope.derived[item.Alias] = true
+
+	case len(item.Funcs) > 0:
+		for _, fn := range item.Funcs {
+			for _, arg := range fn.Args {
+				if err := p.checkExpr(arg, scope, ctes); err != nil {
+					return err
+				}
+			}
+		}
+		alias := item.Alias
+		if alias == "" {
+			alias = item.Funcs[0].Name
+		}
+		scope.derived[alias] = true
+	}
+
+	return nil
+}
+
+// table returns the allowlist entry for a relation, nil for a CTE reference
+func (p *QueryPolicy) table(rel *pgsql.RelationRef, ctes map[string]bool) (*TablePolicy, *SQLValidationError) {
+	if rel.Schema == "" && ctes[rel.Name] {
+		return nil, nil
+	}
+	if rel.Schema != "" && rel.Schema != "public" {
+		return nil, &SQLValidationError{Code: "table_not_allowed", Reason: fmt.Sprintf("table %s.%s is not allowed", rel.Schema, rel.Name)}
+	}
+
+	table, ok := p.Tables[rel.Name]
+	if !ok {
+		return nil, &SQLValidationError{Code: "table_not_allowed", Reason: fmt.Sprintf("table %s is not allowed", rel.Name)}
+	}
+	return table, nil
+}
+
+func (p *QueryPolicy) checkStar(qualifier string, scope *policyScope) *SQLValidationError {
+	if qualifier != "" {
+		table, derived := scope.resolve(lastPart(qualifier))
+		if table == nil && !derived {
+			return &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("unknown table reference %s", qualifier)}
+		}
+		if table != nil && table.Columns != nil {
+			return &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("%s.* would read restricted columns; select the allowed columns explicitly", qualifier)}
+		}
+		return nil
+	}
+
+	for name, table := range scope.tables {
+		if table.Columns != nil {
+			return &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("* would read restricted columns of %s; select the allowed columns explicitly", name)}
+		}
+	}
+	return nil
+}
+
+func (p *QueryPolicy) checkExpr(e *pgsql.Expr, scope *policyScope, ctes map[string]bool) *SQLValidationError {
+	if e == nil {
+		return nil
+	}
+
+	for _, column := range e.Columns {
+		if err := p.checkColumn(column, scope); err != nil {
+			return err
+		}
+	}
+	for _, fn := range e.Funcs {
+		for _, arg := range fn.Args {
+			if err := p.checkExpr(arg, scope, ctes); err != nil {
+				return err
+			}
+		}
+	}
+	for _, sub := range e.Subqueries {
+		if err := p.checkQuery(sub, scope, ctes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *QueryPolicy) checkColumn(column *pgsql.ColumnRef, scope *policyScope) *SQLValidationError {
+	if column.Qualifier != "" {
+		table, derived := scope.resolve(lastPart(column.Qualifier))
+		if derived {
+			return nil
+		}
+		if table == nil {
+			return &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("unknown table reference %s", column.Qualifier)}
+		}
+		if column.Name == "*" && table.Columns != nil {
+			return &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("%s.* would read restricted columns", column.Qualifier)}
+		}
+		if column.Name != "*" && !table.allows(column.Name) {
+			return &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("column %s.%s is not allowed", column.Qualifier, column.Name)}
+		}
+		return nil
+	}
+
+	// An unqualified column must be readable in every restricted table in
+	// scope, since it cannot be attributed to a specific one. Output names
+	// of the select list do not count: Postgres resolves the name to the
+	// table column everywhere but in a bare ORDER BY item.
+	found := false
+	for s := scope; s != nil; s = s.parent {
+		for name, table := range s.tables {
+			if table.Columns != nil && !table.Columns[column.Name] {
+				return &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("column %s is not allowed on %s; qualify it with its table name if it belongs to another table", column.Name, name)}
+			}
+			found = true
+		}
+		if len(s.derived) > 0 {
+			found = true
+		}
+	}
+
+	if !found {
+		return &SQLValidationError{Code: "column_not_allowed", Reason: fmt.Sprintf("column %s does not belong to an allowed table", column.Name)}
+	}
+	return nil
+}
+
+// isOutputName reports whether an ORDER BY item is just an output name of
+// the select list, optionally followed by ASC/DESC and NULLS FIRST/LAST.
+// Anything else is an expression over the FROM columns.
+func isOutputName(e *pgsql.Expr, scope *policyScope) bool {
+	if len(e.Columns) != 1 || len(e.Funcs) > 0 || len(e.Subqueries) > 0 {
+		return false
+	}
+	column := e.Columns[0]
+	if column.Qualifier != "" || !scope.aliases[column.Name] {
+		return false
+	}
+
+	fields := strings.Fields(e.Text)
+	if len(fields) == 0 || !strings.EqualFold(strings.Trim(fields[0], `"`), column.Name) {
+		return false
+	}
+	for _, field := range fields[1:] {
+		switch strings.ToLower(field) {
+		case "asc", "desc", "nulls", "first", "last":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// resolve finds the FROM item with the given alias in the scope chain
+func (s *policyScope) resolve(alias string) (*TablePolicy, bool) {
+	for ; s != nil; s = s.parent {
+		if table, ok := s.tables[alias]; ok {
+			return table, false
+		}
+		if s.derived[alias] {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+func lastPart(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
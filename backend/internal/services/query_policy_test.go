@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+// limitedPolicy is the allowlist of a read_limited role: four columns of
+// transactions, and all of a second table
+func limitedPolicy() *QueryPolicy {
+	return &QueryPolicy{Tables: map[string]*TablePolicy{
+		"transactions": {Columns: map[string]bool{
+			"date":           true,
+			"merch_name":     true,
+			"trx_amount_usd": true,
+			"location_city":  true,
+		}},
+		"merchants": {},
+	}}
+}
+
+func checkPolicy(t *testing.T, policy *QueryPolicy, sql string) error {
+	t.Helper()
+	stmt, err := NewSQLValidator().Validate(sql)
+	if err != nil {
+		t.Fatalf("Validate(%q) error: %v", sql, err)
+	}
+	return policy.Check(stmt.Query)
+}
+
+func TestQueryPolicyRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		code string
+	}{
+		{"unknown table", "SELECT * FROM users", "table_not_allowed"},
+		{"other schema", "SELECT * FROM audit.transactions", "table_not_allowed"},
+		{"table in subquery", "SELECT merch_name FROM transactions WHERE EXISTS (SELECT 1 FROM users)", "table_not_allowed"},
+		{"table in cte", "WITH u AS (SELECT * FROM users) SELECT * FROM u", "table_not_allowed"},
+		{"table in union", "SELECT merch_name FROM transactions UNION SELECT email FROM users", "table_not_allowed"},
+
+		{"restricted column", "SELECT card_number FROM transactions", "column_not_allowed"},
+		{"restricted qualified column", "SELECT t.card_number FROM transactions t", "column_not_allowed"},
+		{"star", "SELECT * FROM transactions", "column_not_allowed"},
+		{"qualified star", "SELECT t.* FROM transactions t", "column_not_allowed"},
+		{"table shorthand", "TABLE transactions", "column_not_allowed"},
+		{"restricted column in where", "SELECT merch_name FROM transactions WHERE card_number LIKE '4%'", "column_not_allowed"},
+		{"restricted column in function", "SELECT count(DISTINCT card_number) FROM transactions", "column_not_allowed"},
+		{"restricted column in group by", "SELECT count(*) FROM transactions GROUP BY card_number", "column_not_allowed"},
+		{"restricted column in order by", "SELECT merch_name FROM transactions ORDER BY card_number", "column_not_allowed"},
+		{"restricted column in subquery", "SELECT merch_name FROM transactions t WHERE EXISTS (SELECT 1 FROM transactions u WHERE u.card_number = '1')", "column_not_allowed"},
+		{"restricted column through outer reference", "SELECT (SELECT t.card_number) FROM transactions t", "column_not_allowed"},
+		{"restricted column in cte", "WITH c AS (SELECT card_number FROM transactions) SELECT * FROM c", "column_not_allowed"},
+		{"column aliases on restricted table", "SELECT a FROM transactions AS t(a)", "column_not_allowed"},
+		{"unqualified column with merchants joined", "SELECT card_number FROM transactions, merchants", "column_not_allowed"},
+
+		// Output names only stand for the select list in a bare ORDER BY item;
+		// everywhere else Postgres resolves the name to the table column
+		{"alias shadowing in select list", "SELECT 1 AS card_number, card_number FROM transactions", "column_not_allowed"},
+		{"alias shadowing in where", "SELECT 1 AS card_number FROM transactions WHERE card_number LIKE '4%'", "column_not_allowed"},
+		{"alias shadowing in group by", "SELECT 1 AS card_number, count(*) FROM transactions GROUP BY card_number", "column_not_allowed"},
+		{"alias shadowing in having", "SELECT 1 AS card_number FROM transactions GROUP BY 1 HAVING max(card_number) > '4'", "column_not_allowed"},
+		{"alias shadowing in order by expression", "SELECT 1 AS card_number FROM transactions ORDER BY card_number || ''", "column_not_allowed"},
+		{"alias shadowing in subquery", "SELECT 1 AS card_number FROM transactions WHERE EXISTS (SELECT 1 WHERE card_number LIKE '4%')", "column_not_allowed"},
+
+		{"natural join", "SELECT merch_name FROM transactions NATURAL JOIN merchants", "column_not_allowed"},
+		{"natural left join", "SELECT merch_name FROM merchants NATURAL LEFT JOIN transactions", "column_not_allowed"},
+		{"using restricted column", "SELECT t.merch_name FROM transactions t JOIN merchants m USING (card_number)", "column_not_allowed"},
+
+		{"table in tablesample", "SELECT merch_name FROM transactions TABLESAMPLE SYSTEM ((SELECT count(*) FROM users))", "table_not_allowed"},
+		{"table in repeatable", "SELECT merch_name FROM transactions t TABLESAMPLE SYSTEM (10) REPEATABLE ((SELECT length(password_hash) FROM users LIMIT 1))", "table_not_allowed"},
+		{"restricted column in tablesample", "SELECT merch_name FROM transactions TABLESAMPLE BERNOULLI ((SELECT max(length(card_number)) FROM transactions))", "column_not_allowed"},
+		{"restricted column in repeatable", "SELECT m.name FROM merchants m TABLESAMPLE SYSTEM (10) REPEATABLE ((SELECT length(card_number) FROM transactions LIMIT 1))", "column_not_allowed"},
+		{"table in rows from", "SELECT * FROM ROWS FROM (generate_series(1, (SELECT count(*) FROM users)::int))", "table_not_allowed"},
+	}
+
+	policy := limitedPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPolicy(t, policy, tt.sql)
+			var rejection *SQLValidationError
+			if !errors.As(err, &rejection) {
+				t.Fatalf("Check(%q) error = %v, want [%s]", tt.sql, err, tt.code)
+			}
+			if rejection.Code != tt.code {
+				t.Errorf("Check(%q) = %v, want [%s]", tt.sql, rejection, tt.code)
+			}
+		})
+	}
+}
+
+func TestQueryPolicyAccepts(t *testing.T) {
+	policy := limitedPolicy()
+	for _, sql := range []string{
+		"SELECT merch_name, trx_amount_usd FROM transactions",
+		"SELECT t.merch_name FROM transactions t WHERE t.location_city = 'Paris'",
+		"SELECT merch_name, sum(trx_amount_usd) AS total FROM transactions GROUP BY merch_name ORDER BY total DESC LIMIT 5",
+		"SELECT trx_amount_usd AS card_number FROM transactions ORDER BY card_number",
+		"SELECT trx_amount_usd AS amount FROM transactions ORDER BY amount DESC NULLS LAST",
+		"SELECT date_trunc('month', date) AS month, count(*) FROM transactions GROUP BY 1 ORDER BY month",
+		"WITH c AS (SELECT merch_name FROM transactions) SELECT * FROM c",
+		"SELECT s.merch_name FROM (SELECT merch_name FROM transactions) s",
+		"SELECT * FROM merchants",
+		"SELECT m.* FROM merchants m JOIN transactions t ON t.merch_name = m.name",
+		"SELECT t.merch_name FROM transactions t JOIN transactions u USING (merch_name)",
+		"SELECT merch_name FROM transactions WHERE trx_amount_usd > (SELECT avg(trx_amount_usd) FROM transactions)",
+		"SELECT merch_name FROM transactions t TABLESAMPLE SYSTEM (10) REPEATABLE (42)",
+	} {
+		if err := checkPolicy(t, policy, sql); err != nil {
+			t.Errorf("Check(%q) error: %v", sql, err)
+		}
+	}
+}
+
+func TestQueryPolicyFullAccess(t *testing.T) {
+	policy := &QueryPolicy{Tables: map[string]*TablePolicy{"transactions": {}}}
+	for _, sql := range []string{
+		"SELECT * FROM transactions",
+		"TABLE transactions",
+		"SELECT card_number FROM transactions NATURAL JOIN transactions",
+	} {
+		if err := checkPolicy(t, policy, sql); err != nil {
+			t.Errorf("Check(%q) error: %v", sql, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Value kinds of result columns, telling clients how to format the values
+const (
+	KindInteger   = "integer"   // JSON number
+	KindDecimal   = "decimal"   // exact decimal as a JSON string, e.g. "1234.50"
+	KindFloat     = "float"     // JSON number, or "NaN"/"Infinity"/"-Infinity" strings
+	KindBoolean   = "boolean"   // JSON boolean
+	KindDate      = "date"      // "2006-01-02"
+	KindTimestamp = "timestamp" // RFC 3339; without offset for timestamp without time zone
+	KindJSON      = "json"      // embedded JSON value
+	KindBinary    = "binary"    // base64 string
+	KindText      = "text"      // JSON string
+)
+
+// ResultColumn describes one column of a query result
+type ResultColumn struct {
+	Name string `json:"name"`
+	// Type is the PostgreSQL type name, e.g. numeric, int8, timestamptz
+	Type      string `json:"type"`
+	Kind      string `json:"kind"`
+	Precision *int64 `json:"precision,omitempty"`
+	Scale     *int64 `json:"scale,omitempty"`
+	// Nullable reports whether the column holds NULLs in this result
+	Nullable bool `json:"nullable"`
+}
+
+// ResultSet is the typed result envelope stored in Message.ResultData. Rows
+// hold the values in column order.
+type ResultSet struct {
+	Columns []ResultColumn  `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// newResultColumns describes the columns of rows
+func newResultColumns(columnTypes []*sql.ColumnType) []ResultColumn {
+	columns := make([]ResultColumn, len(columnTypes))
+	for i, ct := range columnTypes {
+		typeName := strings.ToLower(ct.DatabaseTypeName())
+		columns[i] = ResultColumn{
+			Name: ct.Name(),
+			Type: typeName,
+			Kind: columnKind(typeName),
+		}
+		if precision, scale, ok := ct.DecimalSize(); ok && precision > 0 {
+			columns[i].Precision = &precision
+			columns[i].Scale = &scale
+		}
+	}
+	return columns
+}
+
+// columnKind maps a PostgreSQL type name to the kind of its values
+func columnKind(typeName string) string {
+	switch typeName {
+	case "int2", "int4", "int8", "oid":
+		return KindInteger
+	case "numeric":
+		return KindDecimal
+	case "float4", "float8":
+		return KindFloat
+	case "bool":
+		return KindBoolean
+	case "date":
+		return KindDate
+	case "timestamp", "timestamptz":
+		return KindTimestamp
+	case "json", "jsonb":
+		return KindJSON
+	case "bytea":
+		return KindBinary
+	}
+	return KindText
+}
+
+// resultValue converts a scanned value to its JSON form for the column
+func resultValue(column *ResultColumn, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		column.Nullable = true
+		return nil
+	case time.Time:
+		switch column.Type {
+		case "date":
+			return v.Format("2006-01-02")
+		case "timestamp":
+			return v.Format("2006-01-02T15:04:05.999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return v
+	case []byte:
+		if column.Kind == KindJSON {
+			return json.RawMessage(v)
+		}
+		if column.Kind == KindBinary {
+			return v
+		}
+		return string(v)
+	case string:
+		if column.Kind == KindJSON {
+			return json.RawMessage(v)
+		}
+		return v
+	case int64, int32, int16, bool:
+		return v
+	}
+	return fmt.Sprint(value)
+}
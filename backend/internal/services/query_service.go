@@ -2,158 +2,564 @@ package services
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 
-	// "errors"
 	"fmt"
-	"strings"
+	"strconv"
 	"time"
 
 	"mastercard-backend/internal/config"
 	"mastercard-backend/internal/database"
 	"mastercard-backend/internal/models"
-	"mastercard-backend/pkg/gemini"
+	"mastercard-backend/pkg/llm"
+	"mastercard-backend/pkg/pgsql"
+
+	"gorm.io/gorm"
 )
 
 type QueryService struct {
-	geminiClient *gemini.Client
+	provider  llm.Provider
+	validator *SQLValidator
+	jobs      *jobPool
+	audit     *AuditService
 }
 
 func NewQueryService() (*QueryService, error) {
-	client, err := gemini.NewClient()
+	provider, err := NewLLMProvider(config.AppConfig.LLMProvider)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Gemini client: %w", err)
+		return nil, fmt.Errorf("failed to initialize LLM provider %q: %w", config.AppConfig.LLMProvider, err)
 	}
 
-	return &QueryService{
-		geminiClient: client,
-	}, nil
+	service := &QueryService{
+		provider:  provider,
+		validator: NewSQLValidator(),
+		audit:     NewAuditService(),
+	}
+	service.startJobs(config.AppConfig.QueryWorkers, config.AppConfig.QueryQueueSize, config.AppConfig.QueryJobTTL)
+
+	return service, nil
+}
+
+// ProviderName returns the name of the configured LLM provider
+func (s *QueryService) ProviderName() string {
+	return s.provider.Name()
+}
+
+// Health checks that the configured LLM provider is reachable
+func (s *QueryService) Health(ctx context.Context) error {
+	return s.provider.Health(ctx)
+}
+
+// ExecuteQuery processes a natural language query and returns results.
+// The run is audit logged with the client of ctx (see WithClientInfo).
+func (s *QueryService) ExecuteQuery(ctx context.Context, userID uint, query string, conversationID *uint) (*models.Message, error) {
+	return s.StreamQuery(ctx, userID, query, conversationID, nil)
+}
+
+// StreamQuery runs the ExecuteQuery pipeline and reports its progress to
+// emit as it goes. The returned message is the one ExecuteQuery would
+// return; cancelling ctx stops the LLM calls.
+func (s *QueryService) StreamQuery(ctx context.Context, userID uint, query string, conversationID *uint, emit QueryEventFunc) (*models.Message, error) {
+	startTime := time.Now()
+	message, err := s.streamQuery(ctx, userID, query, conversationID, emit)
+	s.auditQuery(ctx, AuditActionQuery, userID, query, message, err, startTime)
+	return message, err
 }
 
-// ExecuteQuery processes a natural language query and returns results
-func (s *QueryService) ExecuteQuery(userID uint, query string, conversationID *uint) (*models.Message, error) {
+func (s *QueryService) streamQuery(ctx context.Context, userID uint, query string, conversationID *uint, emit QueryEventFunc) (*models.Message, error) {
 	startTime := time.Now()
 
 	// Get conversation history if conversationID is provided
-	var history []string
-	if conversationID != nil && *conversationID > 0 {
-		var messages []models.Message
-		database.DB.Where("conversation_id = ?", *conversationID).
-			Order("created_at DESC").
-			Limit(10).
-			Find(&messages)
+	history := s.loadHistory(userID, conversationID, 10)
+
+	// Generate SQL using the configured LLM provider
+	schemaContext := llm.GetSchemaContext()
+	sqlQuery, err := s.provider.GenerateSQL(ctx, query, schemaContext, history)
+	if err != nil {
+		return s.createErrorMessage(userID, conversationID, query, fmt.Sprintf("Failed to generate SQL: %v", err), startTime)
+	}
+	emit.send(EventSQLGenerated, map[string]interface{}{"attempt": 1, "sql": sqlQuery})
+
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	roleName := ""
+	if user.Role != nil {
+		roleName = user.Role.Name
+	}
+	limits := LoadQueryLimits(roleName)
+
+	maxAttempts := config.AppConfig.QueryMaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	// Run the generated SQL. When PostgreSQL rejects it with an error the
+	// model can fix, the failed SQL and the error are sent back for a
+	// corrected query, up to maxAttempts queries in total.
+	message := models.Message{UserMessage: query}
+	var attempts []QueryAttempt
+	for attempt := 1; ; attempt++ {
+		message.PlanSummary = nil
+		message.ErrorMessage = nil
+
+		// Validate SQL and enforce the table and column allowlist of the user's role
+		stmt, err := s.authorizeSQL(user, sqlQuery)
+		if err != nil {
+			var rejection *SQLValidationError
+			if !errors.As(err, &rejection) {
+				return nil, err
+			}
+			if attempt == 1 {
+				return s.createErrorMessage(userID, conversationID, query, fmt.Sprintf("Query rejected %v", rejection), startTime)
+			}
+			// A correction is held to the same rules and is not corrected again
+			attempts = append(attempts, QueryAttempt{Attempt: attempt, SQL: sqlQuery, Stage: "validate", Error: rejection.Error()})
+			message.ResultFormat = stringPtr("error")
+			message.ErrorMessage = stringPtr(fmt.Sprintf("Query rejected %v", rejection))
+			break
+		}
+		message.SQLQuery = stringPtr(sqlQuery)
+		emit.send(EventValidated, map[string]interface{}{"attempt": attempt})
+
+		// Cost guard: ask the planner before running the query
+		stage := "plan"
+		plan, err := s.explainSQL(ctx, userID, stmt)
+		if err == nil {
+			message.PlanSummary = summarizePlan(plan, limits)
+			emit.send(EventPlanned, map[string]interface{}{"attempt": attempt, "plan_summary": rawJSON(message.PlanSummary)})
+			if reason := limits.Exceeded(plan); reason != "" {
+				attempts = append(attempts, QueryAttempt{Attempt: attempt, SQL: sqlQuery})
+				if config.AppConfig.QueryCostAction == "reject" {
+					message.ResultFormat = stringPtr("error")
+					message.ErrorMessage = stringPtr(fmt.Sprintf("Query rejected [cost_limit] %s", reason))
+				} else {
+					message.ResultFormat = stringPtr(ResultFormatConfirmationRequired)
+					message.ErrorMessage = stringPtr(fmt.Sprintf("Confirmation required: %s", reason))
+				}
+				break
+			}
+
+			stage = "execute"
+			err = s.runQuery(ctx, userID, conversationID, stmt, &message, emit)
+		}
+		if err == nil {
+			attempts = append(attempts, QueryAttempt{Attempt: attempt, SQL: sqlQuery})
+			break
+		}
 
-		// Reverse to get chronological order
-		for i := len(messages) - 1; i >= 0; i-- {
-			history = append(history, messages[i].UserMessage)
+		attempts = append(attempts, QueryAttempt{Attempt: attempt, SQL: sqlQuery, Stage: stage, Error: err.Error()})
+		emit.send(EventAttemptFailed, attempts[len(attempts)-1])
+		message.ResultFormat = stringPtr("error")
+		if stage == "plan" {
+			message.ErrorMessage = stringPtr(fmt.Sprintf("Query planning failed: %v", err))
+		} else {
+			message.ErrorMessage = stringPtr(fmt.Sprintf("Query execution failed: %v", err))
 		}
+		if attempt >= maxAttempts || !correctableError(err) {
+			break
+		}
+
+		corrected, correctErr := s.provider.CorrectSQL(ctx, query, schemaContext, sqlQuery, err.Error(), history)
+		if correctErr != nil {
+			fmt.Printf("Warning: Failed to correct SQL: %v\n", correctErr)
+			break
+		}
+		if corrected == "" || corrected == sqlQuery {
+			// The model has nothing new to try
+			break
+		}
+		sqlQuery = corrected
+		emit.send(EventSQLGenerated, map[string]interface{}{"attempt": attempt + 1, "sql": sqlQuery})
 	}
+	message.Attempts = encodeAttempts(attempts)
+
+	return s.finishMessage(userID, conversationID, &message, startTime)
+}
+
+// ResultFormatConfirmationRequired marks a message whose query exceeded the
+// cost thresholds and waits for ConfirmQuery
+const ResultFormatConfirmationRequired = "confirmation_required"
+
+// ConfirmQuery runs the query of a message that was held back by the cost
+// guard and stores its result on the same message
+func (s *QueryService) ConfirmQuery(ctx context.Context, userID, messageID uint) (*models.Message, error) {
+	startTime := time.Now()
 
-	// Generate SQL using Gemini
-	schemaContext := gemini.GetSchemaContext()
-	sqlQuery, err := s.geminiClient.GenerateSQL(query, schemaContext, history)
+	var message models.Message
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		return tx.Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+			Where("messages.id = ? AND conversations.user_id = ?", messageID, userID).
+			First(&message).Error
+	})
 	if err != nil {
-		return s.createErrorMessage(userID, conversationID, query, fmt.Sprintf("Failed to generate SQL: %v", err), startTime)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	if message.ResultFormat == nil || *message.ResultFormat != ResultFormatConfirmationRequired || message.SQLQuery == nil {
+		return nil, ErrNotAwaitingConfirmation
 	}
 
-	// Validate SQL (basic check - no DROP, DELETE, UPDATE, INSERT, TRUNCATE)
-	if !s.isValidReadOnlyQuery(sqlQuery) {
-		return s.createErrorMessage(userID, conversationID, query, "Only SELECT queries are allowed", startTime)
+	// The allowlist may have changed since the query was generated
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := s.authorizeSQL(user, *message.SQLQuery)
+	if err != nil {
+		return nil, err
 	}
 
-	// Execute SQL query
-	result, resultFormat, err := s.executeSQL(sqlQuery)
+	message.ErrorMessage = nil
+	runErr := s.runQuery(ctx, userID, &message.ConversationID, stmt, &message, nil)
+
 	executionTime := int(time.Since(startTime).Milliseconds())
+	message.ExecutionTimeMs = &executionTime
 
+	// A failed run is saved too, so the message no longer awaits confirmation
+	err = database.WithUser(userID, func(tx *gorm.DB) error {
+		return tx.Save(&message).Error
+	})
 	if err != nil {
-		return s.createErrorMessage(userID, conversationID, query, fmt.Sprintf("Query execution failed: %v", err), startTime)
+		err = fmt.Errorf("failed to save message: %w", err)
+		s.auditQuery(ctx, AuditActionConfirmQuery, userID, message.UserMessage, nil, err, startTime)
+		return nil, err
+	}
+	s.auditQuery(ctx, AuditActionConfirmQuery, userID, message.UserMessage, &message, nil, startTime)
+	if runErr != nil {
+		return nil, fmt.Errorf("query execution failed: %w", runErr)
+	}
+
+	return &message, nil
+}
+
+// runQuery executes a validated statement and fills the result, the
+// conversational analysis or the execution error into message. The
+// execution error is also returned. With emit set, the rows and the
+// analysis tokens are reported as they become available.
+func (s *QueryService) runQuery(ctx context.Context, userID uint, conversationID *uint, stmt *pgsql.Statement, message *models.Message, emit QueryEventFunc) error {
+	result, err := s.executeSQL(ctx, userID, stmt, 0)
+	if err != nil {
+		message.ResultFormat = stringPtr("error")
+		message.ErrorMessage = stringPtr(fmt.Sprintf("Query execution failed: %v", err))
+		return err
+	}
+
+	// Recommend a chart for tabular results
+	var chart *ChartSpec
+	if result.Format == "table" {
+		chart = s.chartFor(ctx, message.UserMessage, stmt.Text, result.Set)
+		if chart != nil {
+			result.Format = "chart"
+			message.ChartSpec = encodeChart(chart)
+		}
+	}
+
+	emit.send(EventRows, map[string]interface{}{
+		"row_count":      result.RowCount,
+		"truncated":      result.Truncated,
+		"total_estimate": result.TotalEstimate,
+		"result_format":  result.Format,
+		"result_data":    rawJSON(&result.Data),
+	})
+	if chart != nil {
+		emit.send(EventChart, map[string]interface{}{"chart_spec": chart})
 	}
 
 	// Generate conversational analysis
-	var analysis *string
-	if result != "" && resultFormat != "error" {
+	if result.Data != "" && result.Format != "error" {
 		// Get conversation history for analysis context
-		var analysisHistory []string
-		if conversationID != nil && *conversationID > 0 {
-			var messages []models.Message
-			database.DB.Where("conversation_id = ?", *conversationID).
-				Order("created_at DESC").
-				Limit(5).
-				Find(&messages)
-
-			// Reverse to get chronological order
-			for i := len(messages) - 1; i >= 0; i-- {
-				analysisHistory = append(analysisHistory, messages[i].UserMessage)
-			}
+		analysisHistory := s.loadHistory(userID, conversationID, 5)
+
+		// Generate analysis using the configured LLM provider
+		var analysisText string
+		var err error
+		if emit != nil {
+			analysisText, err = s.provider.StreamAnalysis(ctx, message.UserMessage, stmt.Text, result.Data, result.Format, analysisHistory, func(token string) error {
+				emit.send(EventAnalysis, map[string]interface{}{"text": token})
+				return ctx.Err()
+			})
+		} else {
+			analysisText, err = s.provider.GenerateAnalysis(ctx, message.UserMessage, stmt.Text, result.Data, result.Format, analysisHistory)
 		}
-
-		// Generate analysis using Gemini
-		analysisText, err := s.geminiClient.GenerateAnalysis(query, sqlQuery, result, resultFormat, analysisHistory)
 		if err != nil {
 			// Log error but don't fail the query - analysis is optional
 			fmt.Printf("Warning: Failed to generate analysis: %v\n", err)
 		} else if analysisText != "" {
-			analysis = &analysisText
+			message.Analysis = &analysisText
 		}
 	}
 
-	// Create message record
-	message := models.Message{
-		UserMessage:     query,
-		SQLQuery:        &sqlQuery,
-		ResultFormat:    &resultFormat,
-		Analysis:        analysis,
-		ExecutionTimeMs: &executionTime,
-	}
+	message.ResultFormat = &result.Format
+	message.RowCount = &result.RowCount
+	message.Truncated = result.Truncated
+	message.TotalEstimate = result.TotalEstimate
 
 	// Set result_data only if not empty (JSONB requires valid JSON or NULL)
-	if result != "" {
-		message.ResultData = &result
+	if result.Data != "" {
+		message.ResultData = &result.Data
 	}
 
+	return nil
+}
+
+// finishMessage sets the execution time and conversation of a message and saves it
+func (s *QueryService) finishMessage(userID uint, conversationID *uint, message *models.Message, startTime time.Time) (*models.Message, error) {
+	executionTime := int(time.Since(startTime).Milliseconds())
+	message.ExecutionTimeMs = &executionTime
+
 	// Set conversation ID if provided
 	if conversationID != nil && *conversationID > 0 {
 		message.ConversationID = *conversationID
 	}
 
-	if err := database.DB.Create(&message).Error; err != nil {
+	if err := s.saveMessage(userID, message); err != nil {
 		return nil, fmt.Errorf("failed to save message: %w", err)
 	}
 
-	return &message, nil
+	return message, nil
+}
+
+var (
+	// ErrMessageNotFound is returned when a message does not exist or is not
+	// visible to the user
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrNoQueryResult is returned for messages whose query did not run: it
+	// failed, was rejected or still awaits confirmation
+	ErrNoQueryResult = errors.New("message has no query result")
+	// ErrNotAwaitingConfirmation is returned when confirming a message that
+	// was not held back by the cost guard
+	ErrNotAwaitingConfirmation = errors.New("message is not awaiting confirmation")
+	// ErrUnorderedResult is returned for pages past the first of a query
+	// without ORDER BY: each page runs the query again, and without an order
+	// Postgres may return its rows differently each time
+	ErrUnorderedResult = errors.New("query has no ORDER BY, only its first page can be shown")
+)
+
+// ResultPage is one page of a previous message's result
+type ResultPage struct {
+	MessageID     uint            `json:"message_id"`
+	Page          int             `json:"page"`
+	PageSize      int             `json:"page_size"`
+	RowCount      int             `json:"row_count"`
+	Truncated     bool            `json:"truncated"`
+	TotalEstimate *int64          `json:"total_estimate,omitempty"`
+	ResultFormat  string          `json:"result_format"`
+	ResultData    json.RawMessage `json:"result_data"`
 }
 
-// executeSQL executes a SQL query and returns results
-func (s *QueryService) executeSQL(sqlQuery string) (string, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.AppConfig.QueryTimeoutSeconds)*time.Second)
+// GetResultPage re-runs the SQL of a previous message and returns the given
+// 1-based page of MaxResultRows rows. Only queries that ran (directly or once
+// confirmed) are re-run, and the SQL is validated again against the user's
+// current allowlist. Pages past the first need a top-level ORDER BY; one
+// that identifies rows keeps the pages from overlapping.
+func (s *QueryService) GetResultPage(userID, messageID uint, page int) (*ResultPage, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	var message models.Message
+	err := database.WithUser(userID, func(tx *gorm.DB) error {
+		return tx.First(&message, messageID).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	stmt, err := s.executedStatement(userID, &message)
+	if err != nil {
+		return nil, err
+	}
+	if page > 1 && !isOrdered(stmt) {
+		return nil, ErrUnorderedResult
+	}
+
+	pageSize := config.AppConfig.MaxResultRows
+	result, err := s.executeSQL(context.Background(), userID, stmt, (page-1)*pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResultPage{
+		MessageID:     message.ID,
+		Page:          page,
+		PageSize:      pageSize,
+		RowCount:      result.RowCount,
+		Truncated:     result.Truncated,
+		TotalEstimate: result.TotalEstimate,
+		ResultFormat:  result.Format,
+		ResultData:    json.RawMessage(result.Data),
+	}, nil
+}
+
+// isOrdered reports whether a statement orders its rows
+func isOrdered(stmt *pgsql.Statement) bool {
+	return stmt.Query != nil && len(stmt.Query.OrderBy) > 0
+}
+
+// pagedSQL wraps a statement to return at most limit rows from offset on
+func pagedSQL(stmt *pgsql.Statement, limit, offset int) string {
+	return fmt.Sprintf("SELECT * FROM (\n%s\n) AS q LIMIT %d OFFSET %d", stmt.Text, limit, offset)
+}
+
+// executedStatement validates the SQL of a message for running it again.
+// A message holds SQL that did not run when it failed, was rejected by the
+// cost guard or awaits confirmation; that SQL is never run from here.
+func (s *QueryService) executedStatement(userID uint, message *models.Message) (*pgsql.Statement, error) {
+	if message.SQLQuery == nil || *message.SQLQuery == "" || message.ResultFormat == nil {
+		return nil, ErrNoQueryResult
+	}
+	switch *message.ResultFormat {
+	case "error", ResultFormatConfirmationRequired:
+		return nil, ErrNoQueryResult
+	}
+
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.authorizeSQL(user, *message.SQLQuery)
+}
+
+// loadUser loads a user with their role
+func (s *QueryService) loadUser(userID uint) (*models.User, error) {
+	var user models.User
+	if err := database.DB.Preload("Role").First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	return &user, nil
+}
+
+// authorizeSQL validates generated SQL and checks it against the allowlist of
+// the user's role. Rejections are returned as *SQLValidationError.
+func (s *QueryService) authorizeSQL(user *models.User, sqlQuery string) (*pgsql.Statement, error) {
+	stmt, err := s.validator.Validate(sqlQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := LoadQueryPolicy(user.RoleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.Check(stmt.Query); err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// sqlResult is one page of the result of a generated query
+type sqlResult struct {
+	// Data is the JSON ResultSet
+	Data      string
+	Set       *ResultSet
+	Format    string
+	RowCount  int
+	Truncated bool
+	// TotalEstimate is the planner's row estimate, set when truncated
+	TotalEstimate *int64
+}
+
+// beginQueryTx starts a read-only transaction on the restricted pool with a
+// transaction-local statement timeout and RLS user
+func beginQueryTx(ctx context.Context, userID uint, timeout time.Duration) (*sql.Tx, error) {
+	// Get raw connection pool of the least-privilege role
+	sqlDB, err := database.QueryDB.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database connection: %w", err)
+	}
+
+	// BEGIN READ ONLY
+	tx, err := sqlDB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	// Transaction-scoped settings: statement timeout and RLS user
+	if _, err := tx.ExecContext(ctx, "SELECT set_config('statement_timeout', $1, true)", strconv.FormatInt(timeout.Milliseconds(), 10)); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to set statement timeout: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT set_config('app.current_user_id', $1, true)", strconv.FormatUint(uint64(userID), 10)); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to set current user: %w", err)
+	}
+
+	return tx, nil
+}
+
+// explainSQL returns the planner's estimate for a validated statement
+// without executing it. The statement is explained as executeSQL runs it,
+// wrapped in its LIMIT: the cost is that of the rows fetched, while the
+// rows are those of the whole result.
+func (s *QueryService) explainSQL(ctx context.Context, userID uint, stmt *pgsql.Statement) (*QueryPlan, error) {
+	timeout := time.Duration(config.AppConfig.QueryTimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	tx, err := beginQueryTx(ctx, userID, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	plan, err := explainQuery(ctx, tx, pagedSQL(stmt, config.AppConfig.MaxResultRows+1, 0))
+	if err != nil {
+		return nil, err
+	}
+	return plan.underLimit(), nil
+}
+
+// executeSQL executes a validated statement and returns at most MaxResultRows
+// rows starting at offset. The query runs on the restricted pool inside a
+// read-only transaction with a statement timeout, wrapped in a LIMIT of
+// MaxResultRows+1 so Postgres stops early and truncation can be detected.
+// Cancelling ctx cancels the running statement.
+func (s *QueryService) executeSQL(ctx context.Context, userID uint, stmt *pgsql.Statement, offset int) (*sqlResult, error) {
+	timeout := time.Duration(config.AppConfig.QueryTimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	// Get raw database connection
-	sqlDB, err := database.DB.DB()
+	tx, err := beginQueryTx(ctx, userID, timeout)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get database connection: %w", err)
+		return nil, err
 	}
+	defer tx.Rollback()
 
 	// Execute query with timeout
-	rows, err := sqlDB.QueryContext(ctx, sqlQuery)
+	maxRows := config.AppConfig.MaxResultRows
+	rows, err := tx.QueryContext(ctx, pagedSQL(stmt, maxRows+1, offset))
 	if err != nil {
-		return "", "", fmt.Errorf("query execution error: %w", err)
+		return nil, fmt.Errorf("query execution error: %w", err)
 	}
 	defer rows.Close()
 
-	// Get column names
-	columns, err := rows.Columns()
+	// Describe the columns: names in order, PostgreSQL types and value kinds
+	columnTypes, err := rows.ColumnTypes()
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get columns: %w", err)
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	resultSet := ResultSet{
+		Columns: newResultColumns(columnTypes),
+		Rows:    [][]interface{}{},
 	}
+	columns := resultSet.Columns
 
 	// Scan results
-	var results []map[string]interface{}
 	rowCount := 0
-	maxRows := config.AppConfig.MaxResultRows
+	truncated := false
 
 	for rows.Next() {
 		if rowCount >= maxRows {
+			truncated = true
 			break
 		}
 
@@ -164,63 +570,51 @@ func (s *QueryService) executeSQL(sqlQuery string) (string, string, error) {
 		}
 
 		if err := rows.Scan(valuePtrs...); err != nil {
-			return "", "", fmt.Errorf("failed to scan row: %w", err)
+			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 
-		row := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			if val != nil {
-				// Handle different types
-				switch v := val.(type) {
-				case []byte:
-					row[col] = string(v)
-				case time.Time:
-					row[col] = v.Format(time.RFC3339)
-				default:
-					row[col] = v
-				}
-			} else {
-				row[col] = nil
-			}
+		row := make([]interface{}, len(columns))
+		for i := range columns {
+			row[i] = resultValue(&columns[i], values[i])
 		}
-		results = append(results, row)
+		resultSet.Rows = append(resultSet.Rows, row)
 		rowCount++
 	}
 
 	if err := rows.Err(); err != nil {
-		return "", "", fmt.Errorf("row iteration error: %w", err)
+		return nil, fmt.Errorf("row iteration error: %w", err)
 	}
+	rows.Close()
 
-	// Determine result format
-	resultFormat := "table"
-	if len(results) == 0 {
-		resultFormat = "text"
-	} else if len(results) == 1 && len(columns) == 1 {
-		resultFormat = "text"
+	result := &sqlResult{
+		RowCount:  rowCount,
+		Truncated: truncated,
 	}
 
-	// Convert to JSON
-	resultJSON, err := json.Marshal(results)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to marshal results: %w", err)
+	// Ask the planner how many rows the full result would have
+	if truncated {
+		if plan, err := explainQuery(ctx, tx, stmt.Text); err == nil {
+			result.TotalEstimate = &plan.PlanRows
+		}
 	}
 
-	return string(resultJSON), resultFormat, nil
-}
-
-// isValidReadOnlyQuery checks if the query is a safe read-only query
-func (s *QueryService) isValidReadOnlyQuery(sql string) bool {
-	upperSQL := strings.ToUpper(" " + sql + " ")
-	dangerousKeywords := []string{" DROP ", " DELETE ", " UPDATE ", " INSERT ", " TRUNCATE ", " ALTER ", " CREATE ", " GRANT ", " REVOKE "}
+	// Determine result format
+	result.Format = "table"
+	if rowCount == 0 {
+		result.Format = "text"
+	} else if rowCount == 1 && len(columns) == 1 {
+		result.Format = "text"
+	}
 
-	for _, keyword := range dangerousKeywords {
-		if strings.Contains(upperSQL, keyword) {
-			return false
-		}
+	// Convert to JSON
+	resultJSON, err := json.Marshal(resultSet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal results: %w", err)
 	}
+	result.Data = string(resultJSON)
+	result.Set = &resultSet
 
-	return strings.Contains(upperSQL, " SELECT ")
+	return result, nil
 }
 
 // createErrorMessage creates an error message record
@@ -240,17 +634,53 @@ func (s *QueryService) createErrorMessage(userID uint, conversationID *uint, que
 		message.ConversationID = *conversationID
 	}
 
-	if err := database.DB.Create(&message).Error; err != nil {
+	if err := s.saveMessage(userID, &message); err != nil {
 		return nil, fmt.Errorf("failed to save error message: %w", err)
 	}
 
 	return &message, nil
 }
 
-// Close closes the Gemini client
+// loadHistory returns the last user messages of a conversation in
+// chronological order, as visible to userID under RLS
+func (s *QueryService) loadHistory(userID uint, conversationID *uint, limit int) []string {
+	if conversationID == nil || *conversationID == 0 {
+		return nil
+	}
+
+	var messages []models.Message
+	_ = database.WithUser(userID, func(tx *gorm.DB) error {
+		return tx.Where("conversation_id = ?", *conversationID).
+			Order("created_at DESC").
+			Limit(limit).
+			Find(&messages).Error
+	})
+
+	// Reverse to get chronological order
+	var history []string
+	for i := len(messages) - 1; i >= 0; i-- {
+		history = append(history, messages[i].UserMessage)
+	}
+	return history
+}
+
+// saveMessage inserts a message in a transaction bound to userID, so the
+// messages insert policy checks the conversation belongs to the user
+func (s *QueryService) saveMessage(userID uint, message *models.Message) error {
+	return database.WithUser(userID, func(tx *gorm.DB) error {
+		return tx.Create(message).Error
+	})
+}
+
+func stringPtr(value string) *string {
+	return &value
+}
+
+// Close cancels the running query jobs and closes the LLM provider
 func (s *QueryService) Close() error {
-	if s.geminiClient != nil {
-		return s.geminiClient.Close()
+	s.stopJobs()
+	if s.provider != nil {
+		return s.provider.Close()
 	}
 	return nil
 }
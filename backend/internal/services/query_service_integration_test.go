@@ -0,0 +1,291 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/models"
+	"mastercard-backend/pkg/fakellm"
+
+	"gorm.io/gorm"
+)
+
+// The question every fake rule below answers
+const testQuestion = "how many transactions are there"
+
+// Generated SQL: valid, failing in the planner and outside the allowlist
+const (
+	countSQL      = "SELECT COUNT(*) AS transaction_count FROM transactions"
+	badColumnSQL  = "SELECT COUNT(no_such_column) FROM transactions"
+	badColumn2SQL = "SELECT SUM(no_such_amount) FROM transactions"
+	disallowedSQL = "SELECT email FROM users"
+)
+
+// newFakeQueryService returns a query service whose LLM answers
+// testQuestion with sql, and corrects it to correctedSQL
+func newFakeQueryService(t *testing.T, sql, correctedSQL string) *QueryService {
+	t.Helper()
+	fixtures, err := json.Marshal(fakellm.Fixtures{Rules: []fakellm.Rule{{
+		Name:         "count",
+		Pattern:      ".",
+		SQL:          sql,
+		CorrectedSQL: correctedSQL,
+		Analysis:     "There are few transactions in total.",
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := fakellm.NewClientFromJSON(fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &QueryService{provider: provider, validator: NewSQLValidator(), audit: NewAuditService()}
+}
+
+// useQueryConfig lifts the cost guard and the chart refinement, and allows
+// maxAttempts queries per question, until the test ends
+func useQueryConfig(t *testing.T, maxAttempts int) {
+	t.Helper()
+	saved := *config.AppConfig
+	config.AppConfig.QueryMaxCost = ""
+	config.AppConfig.QueryMaxRows = ""
+	config.AppConfig.QueryChartLLM = false
+	config.AppConfig.QueryMaxAttempts = maxAttempts
+	t.Cleanup(func() { *config.AppConfig = saved })
+}
+
+// createQueryUser creates an analyzer with a conversation
+func createQueryUser(t *testing.T, tx *gorm.DB) (*models.User, uint) {
+	t.Helper()
+	var role models.Role
+	if err := tx.Where("name = ?", "analyzer").Take(&role).Error; err != nil {
+		t.Fatalf("no analyzer role: %v", err)
+	}
+	user := &models.User{
+		Email:        fmt.Sprintf("query-%d@example.test", time.Now().UnixNano()),
+		PasswordHash: "x",
+		FullName:     "Query",
+		RoleID:       &role.ID,
+		IsActive:     true,
+	}
+	if err := tx.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	conversation, err := NewConversationService().CreateConversation(user.ID, "Test")
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	return user, conversation.ID
+}
+
+// messageAttempts decodes the attempts stored on a message
+func messageAttempts(t *testing.T, message *models.Message) []QueryAttempt {
+	t.Helper()
+	if message.Attempts == nil {
+		return nil
+	}
+	var attempts []QueryAttempt
+	if err := json.Unmarshal([]byte(*message.Attempts), &attempts); err != nil {
+		t.Fatalf("invalid attempts: %v", err)
+	}
+	return attempts
+}
+
+func deref(text *string) string {
+	if text == nil {
+		return ""
+	}
+	return *text
+}
+
+func TestExecuteQueryAnswersTheQuestion(t *testing.T) {
+	tx := useTestTx(t)
+	useQueryConfig(t, 3)
+	user, conversationID := createQueryUser(t, tx)
+	s := newFakeQueryService(t, countSQL, "")
+
+	message, err := s.ExecuteQuery(context.Background(), user.ID, testQuestion, &conversationID)
+	if err != nil {
+		t.Fatalf("ExecuteQuery error: %v", err)
+	}
+	if deref(message.ResultFormat) != "text" || deref(message.SQLQuery) != countSQL || message.ErrorMessage != nil {
+		t.Errorf("message = %s %q (%s), want a text result of %q", deref(message.ResultFormat), deref(message.SQLQuery), deref(message.ErrorMessage), countSQL)
+	}
+	if message.RowCount == nil || *message.RowCount != 1 || message.ResultData == nil || message.PlanSummary == nil {
+		t.Errorf("message has no result or plan: %+v", message)
+	}
+	if deref(message.Analysis) != "There are few transactions in total." {
+		t.Errorf("analysis = %q", deref(message.Analysis))
+	}
+	if attempts := messageAttempts(t, message); len(attempts) != 1 || attempts[0].Error != "" {
+		t.Errorf("attempts = %+v, want one successful attempt", attempts)
+	}
+
+	var stored models.Message
+	if err := tx.Where("id = ? AND conversation_id = ?", message.ID, conversationID).Take(&stored).Error; err != nil {
+		t.Errorf("message not saved: %v", err)
+	}
+	if actions := loggedActions(); !reflect.DeepEqual(actions, []string{AuditActionQuery}) {
+		t.Errorf("audit logged %v, want query", actions)
+	}
+}
+
+func TestExecuteQueryRejectsSQLOutsideTheAllowlist(t *testing.T) {
+	tx := useTestTx(t)
+	useQueryConfig(t, 3)
+	user, conversationID := createQueryUser(t, tx)
+	// A correction would pass: rejections of generated SQL are final
+	s := newFakeQueryService(t, disallowedSQL, countSQL)
+
+	message, err := s.ExecuteQuery(context.Background(), user.ID, testQuestion, &conversationID)
+	if err != nil {
+		t.Fatalf("ExecuteQuery error: %v", err)
+	}
+	if deref(message.ResultFormat) != "error" || !strings.HasPrefix(deref(message.ErrorMessage), "Query rejected") {
+		t.Errorf("message = %s: %s, want a rejection", deref(message.ResultFormat), deref(message.ErrorMessage))
+	}
+	if message.SQLQuery != nil || message.ResultData != nil {
+		t.Errorf("rejected SQL was kept or run: %+v", message)
+	}
+	if message.ID == 0 {
+		t.Error("the rejection was not saved")
+	}
+}
+
+func TestExecuteQueryRetryLoop(t *testing.T) {
+	tests := []struct {
+		name         string
+		sql          string
+		correctedSQL string
+		maxAttempts  int
+		// wantStages are the failed stages of the attempts, "" for success
+		wantStages []string
+		wantFormat string
+		wantError  string
+	}{
+		{"corrected", badColumnSQL, countSQL, 3, []string{"plan", ""}, "text", ""},
+		{"nothing new to try", badColumnSQL, "", 3, []string{"plan"}, "error", "Query planning failed"},
+		{"no attempts left", badColumnSQL, countSQL, 1, []string{"plan"}, "error", "Query planning failed"},
+		{"correction fails too", badColumnSQL, badColumn2SQL, 2, []string{"plan", "plan"}, "error", "Query planning failed"},
+		{"correction rejected", badColumnSQL, disallowedSQL, 3, []string{"plan", "validate"}, "error", "Query rejected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := useTestTx(t)
+			useQueryConfig(t, tt.maxAttempts)
+			user, conversationID := createQueryUser(t, tx)
+			s := newFakeQueryService(t, tt.sql, tt.correctedSQL)
+
+			message, err := s.ExecuteQuery(context.Background(), user.ID, testQuestion, &conversationID)
+			if err != nil {
+				t.Fatalf("ExecuteQuery error: %v", err)
+			}
+			if deref(message.ResultFormat) != tt.wantFormat || !strings.HasPrefix(deref(message.ErrorMessage), tt.wantError) {
+				t.Errorf("message = %s: %s, want %s: %s...", deref(message.ResultFormat), deref(message.ErrorMessage), tt.wantFormat, tt.wantError)
+			}
+
+			attempts := messageAttempts(t, message)
+			var stages []string
+			for i, attempt := range attempts {
+				if attempt.Attempt != i+1 {
+					t.Errorf("attempt %d is numbered %d", i+1, attempt.Attempt)
+				}
+				if (attempt.Stage == "") != (attempt.Error == "") {
+					t.Errorf("attempt %d failed in %q with %q", i+1, attempt.Stage, attempt.Error)
+				}
+				stages = append(stages, attempt.Stage)
+			}
+			if !reflect.DeepEqual(stages, tt.wantStages) {
+				t.Errorf("attempt stages = %q, want %q", stages, tt.wantStages)
+			}
+			if attempts[0].SQL != tt.sql || !strings.Contains(attempts[0].Error, "no_such_column") {
+				t.Errorf("first attempt = %+v, want %q failing on no_such_column", attempts[0], tt.sql)
+			}
+		})
+	}
+}
+
+// queryEvent is an event reported by StreamQuery
+type queryEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+func TestStreamQueryEventOrder(t *testing.T) {
+	tx := useTestTx(t)
+	useQueryConfig(t, 3)
+	user, conversationID := createQueryUser(t, tx)
+	s := newFakeQueryService(t, badColumnSQL, countSQL)
+
+	var events []queryEvent
+	var analysis strings.Builder
+	message, err := s.StreamQuery(context.Background(), user.ID, testQuestion, &conversationID, func(event string, data interface{}) {
+		// Events are sent as JSON: compare what the client receives
+		payload, err := json.Marshal(data)
+		if err != nil {
+			t.Fatalf("event %s is not JSON: %v", event, err)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(payload, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if event == EventAnalysis {
+			analysis.WriteString(decoded["text"].(string))
+			if len(events) > 0 && events[len(events)-1].name == EventAnalysis {
+				return
+			}
+		}
+		events = append(events, queryEvent{event, decoded})
+	})
+	if err != nil {
+		t.Fatalf("StreamQuery error: %v", err)
+	}
+
+	var names []string
+	for _, event := range events {
+		names = append(names, event.name)
+	}
+	want := []string{
+		EventSQLGenerated, EventValidated, EventAttemptFailed,
+		EventSQLGenerated, EventValidated, EventPlanned, EventRows, EventAnalysis,
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("events = %q, want %q", names, want)
+	}
+
+	// Each attempt's events carry its number and SQL
+	for i, wantSQL := range map[int]string{0: badColumnSQL, 3: countSQL} {
+		if sql := events[i].data["sql"]; sql != wantSQL {
+			t.Errorf("event %d sql = %v, want %q", i, sql, wantSQL)
+		}
+	}
+	for i, attempt := range []float64{1, 1, 1, 2, 2, 2} {
+		if got := events[i].data["attempt"]; got != attempt {
+			t.Errorf("event %d (%s) attempt = %v, want %v", i, events[i].name, got, attempt)
+		}
+	}
+	if stage := events[2].data["stage"]; stage != "plan" {
+		t.Errorf("attempt_failed stage = %v, want plan", stage)
+	}
+
+	rows := events[6].data
+	if rows["row_count"] != float64(1) || rows["result_format"] != "text" || rows["result_data"] == nil {
+		t.Errorf("rows event = %v, want one text row", rows)
+	}
+	// The streamed tokens add up to the saved analysis
+	if analysis.String() != deref(message.Analysis) || message.Analysis == nil {
+		t.Errorf("streamed %q, saved %q", analysis.String(), deref(message.Analysis))
+	}
+	if deref(message.SQLQuery) != countSQL {
+		t.Errorf("saved SQL = %q, want the correction", deref(message.SQLQuery))
+	}
+}
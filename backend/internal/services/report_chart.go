@@ -0,0 +1,479 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Report chart bounds
+const (
+	reportChartMaxCategories = 40
+	reportChartMaxLabels     = 10
+)
+
+// reportPalette colors the series of report charts
+var reportPalette = []string{
+	"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f",
+	"#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac",
+}
+
+// reportChart is a chart spec resolved against a result: the data to plot,
+// independent of whether it is drawn as SVG or into a PDF
+type reportChart struct {
+	Mark   string
+	XLabel string
+	YLabel string
+	// Categories are the x values of line and bar charts and the slices of pie charts
+	Categories []string
+	// Series hold one value per category; NaN where a category has no value
+	Series []reportSeries
+	// Points are the (x, y) values of scatter charts
+	Points [][2]float64
+}
+
+type reportSeries struct {
+	Name   string
+	Values []float64
+}
+
+// chartCanvas is a drawing surface in chart units, origin top left
+type chartCanvas interface {
+	Rect(x, y, w, h float64, color string)
+	Line(x1, y1, x2, y2 float64, color string)
+	Polyline(points [][2]float64, color string)
+	Polygon(points [][2]float64, color string)
+	Circle(x, y, r float64, color string)
+	// Text draws a label with its baseline at y, anchored "start", "middle" or "end" at x
+	Text(x, y float64, text string, size float64, anchor string)
+}
+
+// resolveChart plots the result of a message with its chart spec, or
+// returns nil when the spec does not fit the data
+func resolveChart(spec *ChartSpec, set *ResultSet) *reportChart {
+	if spec == nil || set == nil || validateChart(spec, set) != nil {
+		return nil
+	}
+
+	records := chartRecords(spec, set)
+	enc := spec.Encoding
+	chart := &reportChart{Mark: spec.Mark}
+
+	switch spec.Mark {
+	case ChartScatter:
+		chart.XLabel, chart.YLabel = enc.X.Field, enc.Y.Field
+		for _, record := range records {
+			x, okX := chartNumber(record[enc.X.Field])
+			y, okY := chartNumber(record[enc.Y.Field])
+			if okX && okY {
+				chart.Points = append(chart.Points, [2]float64{x, y})
+			}
+		}
+		if len(chart.Points) == 0 {
+			return nil
+		}
+		return chart
+
+	case ChartPie:
+		if enc.Theta == nil || enc.Color == nil {
+			return nil
+		}
+		series := reportSeries{Name: enc.Theta.Field}
+		for _, record := range records {
+			value, ok := chartNumber(record[enc.Theta.Field])
+			if !ok || value <= 0 {
+				continue
+			}
+			chart.Categories = append(chart.Categories, chartLabel(record[enc.Color.Field]))
+			series.Values = append(series.Values, value)
+		}
+		if len(series.Values) == 0 {
+			return nil
+		}
+		chart.Series = []reportSeries{series}
+		return chart
+	}
+
+	// Line and bar: categories on x, one series per color value
+	if enc.X == nil || enc.Y == nil {
+		return nil
+	}
+	chart.XLabel, chart.YLabel = enc.X.Field, enc.Y.Field
+
+	categoryIndex := make(map[string]int)
+	seriesIndex := make(map[string]int)
+	for _, record := range records {
+		category := chartLabel(record[enc.X.Field])
+		if _, ok := categoryIndex[category]; !ok {
+			categoryIndex[category] = len(chart.Categories)
+			chart.Categories = append(chart.Categories, category)
+		}
+		name := enc.Y.Field
+		if enc.Color != nil {
+			name = chartLabel(record[enc.Color.Field])
+		}
+		if _, ok := seriesIndex[name]; !ok {
+			seriesIndex[name] = len(chart.Series)
+			chart.Series = append(chart.Series, reportSeries{Name: name})
+		}
+	}
+	if len(chart.Categories) == 0 || len(chart.Series) > chartMaxSeries {
+		return nil
+	}
+	if enc.X.Type == FieldTemporal {
+		// Dates and RFC 3339 timestamps sort as text
+		sort.Strings(chart.Categories)
+		for i, category := range chart.Categories {
+			categoryIndex[category] = i
+		}
+	}
+	if len(chart.Categories) > reportChartMaxCategories {
+		chart.Categories = chart.Categories[:reportChartMaxCategories]
+	}
+
+	for i := range chart.Series {
+		chart.Series[i].Values = make([]float64, len(chart.Categories))
+		for j := range chart.Series[i].Values {
+			chart.Series[i].Values[j] = math.NaN()
+		}
+	}
+	for _, record := range records {
+		value, ok := chartNumber(record[enc.Y.Field])
+		if !ok {
+			continue
+		}
+		j := categoryIndex[chartLabel(record[enc.X.Field])]
+		if j >= len(chart.Categories) {
+			continue
+		}
+		name := enc.Y.Field
+		if enc.Color != nil {
+			name = chartLabel(record[enc.Color.Field])
+		}
+		values := chart.Series[seriesIndex[name]].Values
+		// Several rows of one category and series are summed
+		if math.IsNaN(values[j]) {
+			values[j] = value
+		} else {
+			values[j] += value
+		}
+	}
+
+	return chart
+}
+
+// chartRecords returns the result rows keyed by field, with the spec's fold
+// transforms applied
+func chartRecords(spec *ChartSpec, set *ResultSet) []map[string]interface{} {
+	records := make([]map[string]interface{}, 0, len(set.Rows))
+	for _, row := range set.Rows {
+		record := make(map[string]interface{}, len(set.Columns))
+		for i, column := range set.Columns {
+			if i < len(row) {
+				record[column.Name] = row[i]
+			}
+		}
+		records = append(records, record)
+	}
+
+	for _, fold := range spec.Transform {
+		folded := make([]map[string]interface{}, 0, len(records)*len(fold.Fold))
+		for _, record := range records {
+			for _, field := range fold.Fold {
+				next := make(map[string]interface{}, len(record)+2)
+				for k, v := range record {
+					next[k] = v
+				}
+				next[fold.As[0]] = field
+				next[fold.As[1]] = record[field]
+				folded = append(folded, next)
+			}
+		}
+		records = folded
+	}
+
+	return records
+}
+
+// chartNumber reads a numeric result value; decimals are strings
+func chartNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+	}
+	return 0, false
+}
+
+func chartLabel(value interface{}) string {
+	if value == nil {
+		return "(null)"
+	}
+	return fmt.Sprint(value)
+}
+
+// formatChartNumber formats an axis or table number compactly
+func formatChartNumber(v float64) string {
+	abs := math.Abs(v)
+	switch {
+	case abs >= 1e9:
+		return strconv.FormatFloat(math.Round(v/1e7)/1e2, 'f', -1, 64) + "B"
+	case abs >= 1e6:
+		return strconv.FormatFloat(math.Round(v/1e4)/1e2, 'f', -1, 64) + "M"
+	case abs >= 1e4:
+		return strconv.FormatFloat(math.Round(v/10)/1e2, 'f', -1, 64) + "k"
+	}
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// shortLabel truncates an axis label
+func shortLabel(label string, max int) string {
+	runes := []rune(label)
+	if len(runes) <= max {
+		return label
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// niceTicks returns about n round tick values covering [min, max]
+func niceTicks(min, max float64, n int) []float64 {
+	if min == max {
+		if min == 0 {
+			max = 1
+		} else {
+			min, max = math.Min(0, min), math.Max(0, max)
+		}
+	}
+	raw := (max - min) / float64(n)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := magnitude
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		if m*magnitude >= raw {
+			step = m * magnitude
+			break
+		}
+	}
+
+	start := math.Floor(min/step) * step
+	ticks := []float64{start}
+	for i := 1; ticks[len(ticks)-1] < max-step*1e-9; i++ {
+		ticks = append(ticks, start+float64(i)*step)
+	}
+	return ticks
+}
+
+// drawChart draws a chart into a width × height area of canvas
+func drawChart(canvas chartCanvas, chart *reportChart, width, height float64) {
+	const (
+		fontSize = 10
+		top      = 16
+		bottom   = 36
+		left     = 56
+	)
+	right := 16.0
+	legend := len(chart.Series) > 1 || chart.Mark == ChartPie
+	if legend {
+		right = 130
+	}
+
+	if chart.Mark == ChartPie {
+		drawPie(canvas, chart, width-right, height, fontSize)
+		drawLegend(canvas, chart.Categories, width-right+12, top, fontSize)
+		return
+	}
+
+	plotW, plotH := width-left-right, height-top-bottom
+	if plotW <= 0 || plotH <= 0 {
+		return
+	}
+
+	// Value range of the y axis, and of the x axis for scatter
+	yMin, yMax := math.Inf(1), math.Inf(-1)
+	xMin, xMax := math.Inf(1), math.Inf(-1)
+	for _, series := range chart.Series {
+		for _, v := range series.Values {
+			if !math.IsNaN(v) {
+				yMin, yMax = math.Min(yMin, v), math.Max(yMax, v)
+			}
+		}
+	}
+	for _, p := range chart.Points {
+		xMin, xMax = math.Min(xMin, p[0]), math.Max(xMax, p[0])
+		yMin, yMax = math.Min(yMin, p[1]), math.Max(yMax, p[1])
+	}
+	if math.IsInf(yMin, 0) {
+		return
+	}
+	if chart.Mark == ChartBar {
+		// Bars start at zero
+		yMin, yMax = math.Min(0, yMin), math.Max(0, yMax)
+	}
+	yTicks := niceTicks(yMin, yMax, 5)
+	yLow, yHigh := yTicks[0], yTicks[len(yTicks)-1]
+	yPos := func(v float64) float64 {
+		return top + plotH - (v-yLow)/(yHigh-yLow)*plotH
+	}
+
+	// Grid and y axis labels
+	for _, tick := range yTicks {
+		y := yPos(tick)
+		canvas.Line(left, y, left+plotW, y, "#e0e0e0")
+		canvas.Text(left-6, y+fontSize/3, formatChartNumber(tick), fontSize, "end")
+	}
+	canvas.Line(left, top+plotH, left+plotW, top+plotH, "#555555")
+	canvas.Line(left, top, left, top+plotH, "#555555")
+
+	switch chart.Mark {
+	case ChartScatter:
+		xTicks := niceTicks(xMin, xMax, 5)
+		xLow, xHigh := xTicks[0], xTicks[len(xTicks)-1]
+		xPos := func(v float64) float64 {
+			return left + (v-xLow)/(xHigh-xLow)*plotW
+		}
+		for _, tick := range xTicks {
+			canvas.Text(xPos(tick), top+plotH+fontSize+4, formatChartNumber(tick), fontSize, "middle")
+		}
+		for _, p := range chart.Points {
+			canvas.Circle(xPos(p[0]), yPos(p[1]), 2.5, reportPalette[0])
+		}
+
+	default:
+		group := plotW / float64(len(chart.Categories))
+		every := (len(chart.Categories) + reportChartMaxLabels - 1) / reportChartMaxLabels
+		for j, category := range chart.Categories {
+			if j%every == 0 {
+				canvas.Text(left+group*(float64(j)+0.5), top+plotH+fontSize+4, shortLabel(category, 12), fontSize, "middle")
+			}
+		}
+
+		if chart.Mark == ChartBar {
+			barW := group * 0.8 / float64(len(chart.Series))
+			zero := yPos(0)
+			for i, series := range chart.Series {
+				color := reportPalette[i%len(reportPalette)]
+				for j, v := range series.Values {
+					if math.IsNaN(v) {
+						continue
+					}
+					x := left + group*float64(j) + group*0.1 + barW*float64(i)
+					y := yPos(v)
+					canvas.Rect(x, math.Min(y, zero), barW, math.Abs(zero-y), color)
+				}
+			}
+		} else {
+			for i, series := range chart.Series {
+				color := reportPalette[i%len(reportPalette)]
+				var points [][2]float64
+				for j, v := range series.Values {
+					if math.IsNaN(v) {
+						continue
+					}
+					points = append(points, [2]float64{left + group*(float64(j)+0.5), yPos(v)})
+				}
+				canvas.Polyline(points, color)
+				if len(points) == 1 {
+					canvas.Circle(points[0][0], points[0][1], 2.5, color)
+				}
+			}
+		}
+	}
+
+	canvas.Text(left+plotW/2, height-4, shortLabel(chart.XLabel, 40), fontSize, "middle")
+	if legend {
+		names := make([]string, len(chart.Series))
+		for i, series := range chart.Series {
+			names[i] = series.Name
+		}
+		drawLegend(canvas, names, width-right+12, top, fontSize)
+	} else {
+		canvas.Text(left, top-6, shortLabel(chart.YLabel, 40), fontSize, "start")
+	}
+}
+
+// drawPie draws the slices of the first series
+func drawPie(canvas chartCanvas, chart *reportChart, width, height, fontSize float64) {
+	values := chart.Series[0].Values
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+
+	cx, cy := width/2, height/2
+	r := math.Min(width, height)/2 - fontSize
+	angle := -math.Pi / 2
+	for i, v := range values {
+		sweep := v / total * 2 * math.Pi
+		points := [][2]float64{{cx, cy}}
+		steps := int(math.Ceil(sweep/0.05)) + 1
+		for k := 0; k <= steps; k++ {
+			a := angle + sweep*float64(k)/float64(steps)
+			points = append(points, [2]float64{cx + r*math.Cos(a), cy + r*math.Sin(a)})
+		}
+		canvas.Polygon(points, reportPalette[i%len(reportPalette)])
+		angle += sweep
+	}
+}
+
+// drawLegend lists the series names with their colors
+func drawLegend(canvas chartCanvas, names []string, x, y, fontSize float64) {
+	for i, name := range names {
+		rowY := y + float64(i)*(fontSize+6)
+		canvas.Rect(x, rowY, fontSize, fontSize, reportPalette[i%len(reportPalette)])
+		canvas.Text(x+fontSize+4, rowY+fontSize-1, shortLabel(name, 18), fontSize, "start")
+	}
+}
+
+// svgCanvas draws a chart as inline SVG
+type svgCanvas struct {
+	b strings.Builder
+}
+
+func newSVGCanvas(width, height float64) *svgCanvas {
+	c := &svgCanvas{}
+	fmt.Fprintf(&c.b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %g %g" width="100%%" font-family="Helvetica, Arial, sans-serif">`, width, height)
+	return c
+}
+
+func (c *svgCanvas) Rect(x, y, w, h float64, color string) {
+	fmt.Fprintf(&c.b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`, x, y, w, h, color)
+}
+
+func (c *svgCanvas) Line(x1, y1, x2, y2 float64, color string) {
+	fmt.Fprintf(&c.b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`, x1, y1, x2, y2, color)
+}
+
+func (c *svgCanvas) Polyline(points [][2]float64, color string) {
+	fmt.Fprintf(&c.b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, svgPoints(points), color)
+}
+
+func (c *svgCanvas) Polygon(points [][2]float64, color string) {
+	fmt.Fprintf(&c.b, `<polygon points="%s" fill="%s" stroke="#ffffff"/>`, svgPoints(points), color)
+}
+
+func (c *svgCanvas) Circle(x, y, r float64, color string) {
+	fmt.Fprintf(&c.b, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s"/>`, x, y, r, color)
+}
+
+func (c *svgCanvas) Text(x, y float64, text string, size float64, anchor string) {
+	fmt.Fprintf(&c.b, `<text x="%.1f" y="%.1f" font-size="%g" text-anchor="%s" fill="#333333">%s</text>`, x, y, size, anchor, html.EscapeString(text))
+}
+
+// String closes the SVG element and returns the markup
+func (c *svgCanvas) String() string {
+	return c.b.String() + "</svg>"
+}
+
+func svgPoints(points [][2]float64) string {
+	parts := make([]string, len(points))
+	for i, p := range points {
+		parts[i] = fmt.Sprintf("%.1f,%.1f", p[0], p[1])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// PDF layout, in millimetres on A4 portrait
+const (
+	pdfMargin     = 15
+	pdfBodyWidth  = 210 - 2*pdfMargin
+	pdfChartScale = float64(pdfBodyWidth) / reportChartWidth
+	pdfMaxColumns = 8
+)
+
+// renderPDFReport lays out a report on A4 pages with the core fonts
+func renderPDFReport(r *report, w io.Writer) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin+5)
+	pdf.SetTitle(r.Title, true)
+	pdf.SetCreator("mastercard-backend", true)
+	pdf.SetCreationDate(r.GeneratedAt)
+	pdf.AliasNbPages("")
+
+	// Core fonts are cp1252
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFooterFunc(func() {
+		if pdf.PageNo() == 1 {
+			return
+		}
+		pdf.SetY(-pdfMargin)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(pdfBodyWidth/2, 5, tr(r.Title), "", 0, "L", false, 0, "")
+		pdf.CellFormat(pdfBodyWidth/2, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+
+	// Title page
+	pdf.AddPage()
+	pdf.SetY(80)
+	pdf.SetFont("Helvetica", "B", 26)
+	pdf.SetTextColor(34, 34, 34)
+	pdf.MultiCell(pdfBodyWidth, 11, tr(r.Title), "", "L", false)
+	pdf.SetFont("Helvetica", "", 14)
+	pdf.SetTextColor(102, 102, 102)
+	pdf.CellFormat(pdfBodyWidth, 10, "Conversation report", "", 1, "L", false, 0, "")
+	pdf.Ln(10)
+
+	details := [][2]string{}
+	if r.Owner != "" {
+		details = append(details, [2]string{"Owner", r.Owner})
+	}
+	details = append(details,
+		[2]string{"Started", reportTime(r.CreatedAt)},
+		[2]string{"Last updated", reportTime(r.UpdatedAt)},
+		[2]string{"Questions", strconv.Itoa(len(r.Entries))},
+		[2]string{"Generated", reportTime(r.GeneratedAt)},
+	)
+	pdf.SetFontSize(11)
+	for _, detail := range details {
+		pdf.SetTextColor(102, 102, 102)
+		pdf.CellFormat(35, 7, detail[0], "", 0, "L", false, 0, "")
+		pdf.SetTextColor(34, 34, 34)
+		pdf.CellFormat(pdfBodyWidth-35, 7, tr(detail[1]), "", 1, "L", false, 0, "")
+	}
+
+	// One section per message
+	pdf.AddPage()
+	for i, entry := range r.Entries {
+		if i > 0 {
+			pdf.Ln(8)
+			pdfEnsureSpace(pdf, 50)
+		}
+		pdfEntry(pdf, tr, &entry)
+	}
+
+	return pdf.Output(w)
+}
+
+// pdfEntry writes the section of one message
+func pdfEntry(pdf *gofpdf.Fpdf, tr func(string) string, entry *reportEntry) {
+	pdf.SetFont("Helvetica", "B", 15)
+	pdf.SetTextColor(34, 34, 34)
+	pdf.CellFormat(pdfBodyWidth, 8, fmt.Sprintf("Question %d", entry.Number), "B", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(102, 102, 102)
+	pdf.CellFormat(pdfBodyWidth, 6, "Asked "+reportTime(entry.AskedAt), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.SetTextColor(34, 34, 34)
+	pdf.MultiCell(pdfBodyWidth, 6, tr(entry.Question), "", "L", false)
+
+	if entry.SQL != "" {
+		pdfHeading(pdf, "SQL")
+		pdf.SetFont("Courier", "", 8)
+		pdf.SetFillColor(245, 245, 245)
+		pdf.MultiCell(pdfBodyWidth, 4, tr(entry.SQL), "", "L", true)
+	}
+	if entry.Error != "" {
+		pdf.Ln(2)
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.SetTextColor(176, 0, 32)
+		pdf.MultiCell(pdfBodyWidth, 5, tr(entry.Error), "", "L", false)
+		pdf.SetTextColor(34, 34, 34)
+	}
+	if entry.Notice != "" {
+		pdf.Ln(2)
+		pdf.SetFont("Helvetica", "I", 9)
+		pdf.MultiCell(pdfBodyWidth, 5, tr(entry.Notice), "", "L", false)
+	}
+
+	if entry.Chart != nil {
+		height := reportChartHeight * pdfChartScale
+		pdfEnsureSpace(pdf, height+12)
+		pdfHeading(pdf, "Chart")
+		canvas := &pdfCanvas{pdf: pdf, tr: tr, x: pdfMargin, y: pdf.GetY(), scale: pdfChartScale}
+		drawChart(canvas, entry.Chart, reportChartWidth, reportChartHeight)
+		pdf.SetY(canvas.y + height)
+		pdf.SetLineWidth(0.2)
+	}
+
+	if len(entry.Columns) > 0 {
+		pdfEnsureSpace(pdf, 25)
+		pdfHeading(pdf, "Result")
+		pdfTable(pdf, tr, entry)
+	}
+
+	if entry.Analysis != "" {
+		pdfEnsureSpace(pdf, 20)
+		pdfHeading(pdf, "Analysis")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.SetTextColor(34, 34, 34)
+		pdf.MultiCell(pdfBodyWidth, 5, tr(entry.Analysis), "", "L", false)
+	}
+}
+
+func pdfHeading(pdf *gofpdf.Fpdf, text string) {
+	pdf.Ln(3)
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.SetTextColor(78, 121, 167)
+	pdf.CellFormat(pdfBodyWidth, 7, text, "", 1, "L", false, 0, "")
+	pdf.SetTextColor(34, 34, 34)
+}
+
+// pdfTable writes the result rows, with up to pdfMaxColumns columns
+func pdfTable(pdf *gofpdf.Fpdf, tr func(string) string, entry *reportEntry) {
+	columns := len(entry.Columns)
+	if columns > pdfMaxColumns {
+		columns = pdfMaxColumns
+	}
+	width := float64(pdfBodyWidth) / float64(columns)
+
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 8)
+		pdf.SetFillColor(240, 243, 247)
+		pdf.SetDrawColor(221, 221, 221)
+		for _, name := range entry.Columns[:columns] {
+			pdf.CellFormat(width, 6, pdfFit(pdf, tr(name), width-2), "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 8)
+	}
+
+	header()
+	_, pageHeight := pdf.GetPageSize()
+	for _, row := range entry.Rows {
+		if pdf.GetY()+5 > pageHeight-pdfMargin-5 {
+			pdf.AddPage()
+			header()
+		}
+		for j := 0; j < columns; j++ {
+			cell := ""
+			if j < len(row) {
+				cell = row[j]
+			}
+			pdf.CellFormat(width, 5, pdfFit(pdf, tr(cell), width-2), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	note := entry.RowNote
+	if len(entry.Columns) > columns {
+		if note != "" {
+			note += " "
+		}
+		note += fmt.Sprintf("%d more columns are in the HTML report.", len(entry.Columns)-columns)
+	}
+	if note != "" {
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.SetTextColor(102, 102, 102)
+		pdf.MultiCell(pdfBodyWidth, 5, tr(note), "", "L", false)
+		pdf.SetTextColor(34, 34, 34)
+	}
+}
+
+// pdfFit shortens text to fit width with the current font
+func pdfFit(pdf *gofpdf.Fpdf, text string, width float64) string {
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	// Translated text is single-byte cp1252
+	for len(text) > 0 && pdf.GetStringWidth(text+"...") > width {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
+
+// pdfEnsureSpace starts a new page when less than height is left on this one
+func pdfEnsureSpace(pdf *gofpdf.Fpdf, height float64) {
+	_, pageHeight := pdf.GetPageSize()
+	if pdf.GetY()+height > pageHeight-pdfMargin-5 {
+		pdf.AddPage()
+	}
+}
+
+// pdfCanvas draws a chart at (x, y) on the current page, scaled from chart
+// units to millimetres
+type pdfCanvas struct {
+	pdf   *gofpdf.Fpdf
+	tr    func(string) string
+	x, y  float64
+	scale float64
+}
+
+func (c *pdfCanvas) point(x, y float64) (float64, float64) {
+	return c.x + x*c.scale, c.y + y*c.scale
+}
+
+func (c *pdfCanvas) fill(color string) {
+	r, g, b := hexColor(color)
+	c.pdf.SetFillColor(r, g, b)
+}
+
+func (c *pdfCanvas) stroke(color string, width float64) {
+	r, g, b := hexColor(color)
+	c.pdf.SetDrawColor(r, g, b)
+	c.pdf.SetLineWidth(width)
+}
+
+func (c *pdfCanvas) Rect(x, y, w, h float64, color string) {
+	c.fill(color)
+	px, py := c.point(x, y)
+	c.pdf.Rect(px, py, w*c.scale, h*c.scale, "F")
+}
+
+func (c *pdfCanvas) Line(x1, y1, x2, y2 float64, color string) {
+	c.stroke(color, 0.2)
+	px1, py1 := c.point(x1, y1)
+	px2, py2 := c.point(x2, y2)
+	c.pdf.Line(px1, py1, px2, py2)
+}
+
+func (c *pdfCanvas) Polyline(points [][2]float64, color string) {
+	c.stroke(color, 0.6)
+	for i := 1; i < len(points); i++ {
+		px1, py1 := c.point(points[i-1][0], points[i-1][1])
+		px2, py2 := c.point(points[i][0], points[i][1])
+		c.pdf.Line(px1, py1, px2, py2)
+	}
+}
+
+func (c *pdfCanvas) Polygon(points [][2]float64, color string) {
+	c.fill(color)
+	c.stroke("#ffffff", 0.3)
+	pts := make([]gofpdf.PointType, len(points))
+	for i, p := range points {
+		pts[i].X, pts[i].Y = c.point(p[0], p[1])
+	}
+	c.pdf.Polygon(pts, "FD")
+}
+
+func (c *pdfCanvas) Circle(x, y, r float64, color string) {
+	c.fill(color)
+	px, py := c.point(x, y)
+	c.pdf.Circle(px, py, r*c.scale, "F")
+}
+
+func (c *pdfCanvas) Text(x, y float64, text string, size float64, anchor string) {
+	// Font sizes are in chart units too
+	c.pdf.SetFont("Helvetica", "", size*c.scale*72/25.4)
+	c.pdf.SetTextColor(51, 51, 51)
+	text = c.tr(text)
+	px, py := c.point(x, y)
+	switch anchor {
+	case "middle":
+		px -= c.pdf.GetStringWidth(text) / 2
+	case "end":
+		px -= c.pdf.GetStringWidth(text)
+	}
+	c.pdf.Text(px, py, text)
+}
+
+// hexColor parses a #rrggbb color
+func hexColor(color string) (int, int, int) {
+	v, err := strconv.ParseUint(color[1:], 16, 32)
+	if err != nil {
+		return 0, 0, 0
+	}
+	return int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff)
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mastercard-backend/internal/models"
+)
+
+// Report formats
+const (
+	ReportHTML = "html"
+	ReportPDF  = "pdf"
+)
+
+// Report bounds
+const (
+	reportMaxRows = 50
+	// reportChartWidth and reportChartHeight are the chart size in chart units
+	reportChartWidth  = 640
+	reportChartHeight = 300
+)
+
+// ErrUnsupportedReportFormat is returned for unknown report formats
+var ErrUnsupportedReportFormat = errors.New("unsupported report format, use html or pdf")
+
+// ReportContentType returns the MIME type of a report format
+func ReportContentType(format string) string {
+	if format == ReportPDF {
+		return "application/pdf"
+	}
+	return "text/html; charset=utf-8"
+}
+
+// ReportService renders conversations as self-contained reports for
+// stakeholders without access to the application
+type ReportService struct{}
+
+func NewReportService() *ReportService {
+	return &ReportService{}
+}
+
+// report is a conversation prepared for rendering
+type report struct {
+	Title       string
+	Owner       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	GeneratedAt time.Time
+	Entries     []reportEntry
+}
+
+// reportEntry is one message of the conversation
+type reportEntry struct {
+	Number   int
+	Question string
+	AskedAt  time.Time
+	SQL      string
+	// Notice explains a message without result, e.g. one held by the cost guard
+	Notice   string
+	Error    string
+	Columns  []string
+	Rows     [][]string
+	RowNote  string
+	Chart    *reportChart
+	Analysis string
+}
+
+// Render writes the report of a conversation, loaded with its messages, to w
+func (s *ReportService) Render(conversation *models.Conversation, format string, w io.Writer) error {
+	r := newReport(conversation)
+	switch format {
+	case ReportHTML:
+		return renderHTMLReport(r, w)
+	case ReportPDF:
+		return renderPDFReport(r, w)
+	}
+	return ErrUnsupportedReportFormat
+}
+
+func newReport(conversation *models.Conversation) *report {
+	r := &report{
+		Title:       fmt.Sprintf("Conversation %d", conversation.ID),
+		CreatedAt:   conversation.CreatedAt,
+		UpdatedAt:   conversation.UpdatedAt,
+		GeneratedAt: time.Now(),
+	}
+	if conversation.Title != nil && *conversation.Title != "" {
+		r.Title = *conversation.Title
+	}
+	if conversation.User.ID != 0 {
+		r.Owner = conversation.User.Email
+		if conversation.User.FullName != "" {
+			r.Owner = fmt.Sprintf("%s <%s>", conversation.User.FullName, conversation.User.Email)
+		}
+	}
+
+	for i, message := range conversation.Messages {
+		r.Entries = append(r.Entries, newReportEntry(i+1, &message))
+	}
+	return r
+}
+
+func newReportEntry(number int, message *models.Message) reportEntry {
+	entry := reportEntry{
+		Number:   number,
+		Question: message.UserMessage,
+		AskedAt:  message.CreatedAt,
+	}
+	if message.SQLQuery != nil {
+		entry.SQL = *message.SQLQuery
+	}
+	if message.Analysis != nil {
+		entry.Analysis = *message.Analysis
+	}
+
+	format := ""
+	if message.ResultFormat != nil {
+		format = *message.ResultFormat
+	}
+	switch format {
+	case "error":
+		entry.Error = "The query failed."
+		if message.ErrorMessage != nil {
+			entry.Error = *message.ErrorMessage
+		}
+		return entry
+	case ResultFormatConfirmationRequired:
+		entry.Notice = "Held back by the cost guard and not run."
+		return entry
+	}
+
+	if message.ResultData == nil {
+		return entry
+	}
+	var set ResultSet
+	if err := json.Unmarshal([]byte(*message.ResultData), &set); err != nil || len(set.Columns) == 0 {
+		return entry
+	}
+
+	for _, column := range set.Columns {
+		entry.Columns = append(entry.Columns, column.Name)
+	}
+	for i, row := range set.Rows {
+		if i == reportMaxRows {
+			break
+		}
+		cells := make([]string, len(row))
+		for j, value := range row {
+			cells[j] = reportCell(value)
+		}
+		entry.Rows = append(entry.Rows, cells)
+	}
+
+	total := len(set.Rows)
+	if message.RowCount != nil {
+		total = *message.RowCount
+	}
+	switch {
+	case message.Truncated && message.TotalEstimate != nil:
+		entry.RowNote = fmt.Sprintf("Showing %d of %d rows returned; the full result has about %d rows.", len(entry.Rows), total, *message.TotalEstimate)
+	case message.Truncated:
+		entry.RowNote = fmt.Sprintf("Showing %d of %d rows returned; the full result has more rows.", len(entry.Rows), total)
+	case total > len(entry.Rows):
+		entry.RowNote = fmt.Sprintf("Showing %d of %d rows.", len(entry.Rows), total)
+	}
+
+	if message.ChartSpec != nil {
+		var spec ChartSpec
+		if err := json.Unmarshal([]byte(*message.ChartSpec), &spec); err == nil {
+			entry.Chart = resolveChart(&spec, &set)
+		}
+	}
+
+	return entry
+}
+
+// reportCell formats a result_data value for a report table
+func reportCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	// Embedded JSON values
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// reportTime formats report timestamps in UTC
+func reportTime(t time.Time) string {
+	return t.UTC().Format("2 Jan 2006 15:04 UTC")
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time": reportTime,
+	"chart": func(chart *reportChart) template.HTML {
+		canvas := newSVGCanvas(reportChartWidth, reportChartHeight)
+		drawChart(canvas, chart, reportChartWidth, reportChartHeight)
+		// The canvas escapes all text it draws
+		return template.HTML(canvas.String())
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #222; max-width: 960px; margin: 0 auto; padding: 24px; }
+.title-page { min-height: 90vh; display: flex; flex-direction: column; justify-content: center; page-break-after: always; }
+.title-page h1 { font-size: 36px; margin-bottom: 8px; }
+.title-page .subtitle { color: #666; font-size: 18px; margin-bottom: 32px; }
+.title-page dl { display: grid; grid-template-columns: max-content 1fr; gap: 6px 16px; }
+.title-page dt { color: #666; }
+.entry { margin-bottom: 48px; page-break-inside: avoid; }
+.entry h2 { font-size: 20px; border-bottom: 2px solid #4e79a7; padding-bottom: 4px; }
+.meta { color: #666; font-size: 13px; }
+.question { font-size: 17px; font-weight: bold; }
+pre { background: #f5f5f5; padding: 12px; border-radius: 4px; white-space: pre-wrap; font-size: 13px; }
+table { border-collapse: collapse; width: 100%; font-size: 13px; margin: 12px 0; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; }
+th { background: #f0f3f7; }
+.note { color: #666; font-size: 12px; font-style: italic; }
+.error { color: #b00020; }
+.analysis { white-space: pre-wrap; line-height: 1.5; }
+</style>
+</head>
+<body>
+<section class="title-page">
+<h1>{{.Title}}</h1>
+<div class="subtitle">Conversation report</div>
+<dl>
+{{if .Owner}}<dt>Owner</dt><dd>{{.Owner}}</dd>{{end}}
+<dt>Started</dt><dd>{{time .CreatedAt}}</dd>
+<dt>Last updated</dt><dd>{{time .UpdatedAt}}</dd>
+<dt>Questions</dt><dd>{{len .Entries}}</dd>
+<dt>Generated</dt><dd>{{time .GeneratedAt}}</dd>
+</dl>
+</section>
+{{range .Entries}}
+<section class="entry">
+<h2>Question {{.Number}}</h2>
+<div class="meta">Asked {{time .AskedAt}}</div>
+<p class="question">{{.Question}}</p>
+{{if .SQL}}<h3>SQL</h3>
+<pre>{{.SQL}}</pre>{{end}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Notice}}<p class="note">{{.Notice}}</p>{{end}}
+{{if .Chart}}<h3>Chart</h3>
+{{chart .Chart}}{{end}}
+{{if .Columns}}<h3>Result</h3>
+<table>
+<thead><tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>{{end}}</tbody>
+</table>
+{{if .RowNote}}<p class="note">{{.RowNote}}</p>{{end}}{{end}}
+{{if .Analysis}}<h3>Analysis</h3>
+<div class="analysis">{{.Analysis}}</div>{{end}}
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+func renderHTMLReport(r *report, w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+var reportSlugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ReportFileName returns the download name of a conversation report
+func ReportFileName(conversation *models.Conversation, format string) string {
+	name := "conversation-" + strconv.FormatUint(uint64(conversation.ID), 10)
+	if conversation.Title != nil {
+		slug := strings.Trim(reportSlugInvalid.ReplaceAllString(strings.ToLower(*conversation.Title), "-"), "-")
+		if len(slug) > 40 {
+			slug = strings.TrimRight(slug[:40], "-")
+		}
+		if slug != "" {
+			name += "-" + slug
+		}
+	}
+	return name + "." + format
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+	"mastercard-backend/internal/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrSessionNotFound is returned for unknown sessions and sessions that
+	// already ended
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionExpired is returned for tokens of a session that ended or
+	// was idle for longer than SESSION_TIMEOUT_MINUTES
+	ErrSessionExpired = errors.New("session has expired")
+)
+
+// sessionChecks remembers when sessions were last found active, so the
+// table is read (and last_seen_at written) at most once per
+// SESSION_TOUCH_INTERVAL per session
+type sessionChecks struct {
+	mu      sync.Mutex
+	checked map[string]time.Time
+	pruned  time.Time
+}
+
+var sessionCache = &sessionChecks{
+	checked: make(map[string]time.Time),
+}
+
+// recent reports whether a session was found active within interval
+func (s *sessionChecks) recent(id string, now time.Time, interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkedAt, ok := s.checked[id]
+	return ok && now.Sub(checkedAt) < interval
+}
+
+// confirm records that a session was found active, dropping stale entries
+func (s *sessionChecks) confirm(id string, now time.Time, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked[id] = now
+	if now.Sub(s.pruned) < interval {
+		return
+	}
+	for id, checkedAt := range s.checked {
+		if now.Sub(checkedAt) >= interval {
+			delete(s.checked, id)
+		}
+	}
+	s.pruned = now
+}
+
+func (s *sessionChecks) forget(id string) {
+	s.mu.Lock()
+	delete(s.checked, id)
+	s.mu.Unlock()
+}
+
+// idleTimeout is SESSION_TIMEOUT_MINUTES, 0 when sessions never go idle
+func idleTimeout() time.Duration {
+	return time.Duration(config.AppConfig.SessionTimeoutMinutes) * time.Minute
+}
+
+// activeSessions restricts a query to the sessions that have not ended,
+// expired or gone idle at now
+func activeSessions(db *gorm.DB, now time.Time) *gorm.DB {
+	db = db.Where("ended_at IS NULL AND expires_at > ?", now)
+	if timeout := idleTimeout(); timeout > 0 {
+		db = db.Where("last_seen_at > ?", now.Add(-timeout))
+	}
+	return db
+}
+
+// createSession records the session of a login with the client of ctx
+func createSession(ctx context.Context, tx *gorm.DB, id string, userID uint, expiresAt time.Time) error {
+	ipAddress, userAgent := clientInfo(ctx)
+	device := "Unknown device"
+	if userAgent != nil {
+		device = deviceName(*userAgent)
+	}
+
+	// Stored without time zone, in UTC
+	now := time.Now().UTC()
+	return tx.Create(&models.Session{
+		ID:         id,
+		UserID:     userID,
+		Device:     device,
+		IPAddress:  ipAddress,
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  expiresAt.UTC(),
+	}).Error
+}
+
+// touchSession marks a session as seen in a refresh that issued a refresh
+// token expiring at expiresAt. It fails with ErrSessionExpired when the
+// session is no longer active.
+func touchSession(tx *gorm.DB, id string, userID uint, expiresAt time.Time) error {
+	now := time.Now().UTC()
+	result := activeSessions(tx.Model(&models.Session{}), now).
+		Where("id = ? AND user_id = ?", id, userID).
+		Updates(map[string]interface{}{
+			"last_seen_at": now,
+			"expires_at":   expiresAt.UTC(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// endSession marks a session as ended; its refresh tokens are revoked by
+// the caller
+func endSession(tx *gorm.DB, id, reason string) error {
+	return tx.Model(&models.Session{}).
+		Where("id = ? AND ended_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"ended_at":   time.Now().UTC(),
+			"end_reason": reason,
+		}).Error
+}
+
+// CheckSession verifies that the session of an access token is still
+// active, recording the request as activity. A session idle for longer than
+// SESSION_TIMEOUT_MINUTES is ended. Tokens issued before sessions were
+// recorded carry no session and pass.
+func CheckSession(claims *utils.Claims) error {
+	if claims.SessionID == "" {
+		return nil
+	}
+	now := time.Now().UTC()
+	interval := config.AppConfig.SessionTouchInterval
+	if sessionCache.recent(claims.SessionID, now, interval) {
+		return nil
+	}
+
+	result := activeSessions(database.DB.Model(&models.Session{}), now).
+		Where("id = ? AND user_id = ?", claims.SessionID, claims.UserID).
+		Update("last_seen_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		sessionCache.forget(claims.SessionID)
+		if err := database.DB.Transaction(func(tx *gorm.DB) error {
+			return revokeFamily(tx, claims.SessionID, RevokeIdle)
+		}); err != nil {
+			return err
+		}
+		return ErrSessionExpired
+	}
+
+	sessionCache.confirm(claims.SessionID, now, interval)
+	return nil
+}
+
+// GetSessions lists the active sessions of a user, most recently seen first
+func GetSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := activeSessions(database.DB, time.Now().UTC()).
+		Where("user_id = ?", userID).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// TerminateSession ends an active session of a user: its refresh tokens
+// are revoked and its access tokens refused
+func TerminateSession(userID uint, sessionID, reason string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return ErrSessionNotFound
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var session models.Session
+		if err := activeSessions(tx, time.Now().UTC()).
+			Where("id = ? AND user_id = ?", sessionID, userID).
+			Take(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrSessionNotFound
+			}
+			return err
+		}
+		return revokeFamily(tx, session.ID, reason)
+	})
+	if err != nil {
+		return err
+	}
+	sessionCache.forget(sessionID)
+	return nil
+}
+
+// deviceName describes the device of a user agent as "<browser> on <OS>"
+func deviceName(userAgent string) string {
+	var os string
+	switch {
+	case strings.Contains(userAgent, "iPhone"):
+		os = "iOS"
+	case strings.Contains(userAgent, "iPad"):
+		os = "iPadOS"
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Macintosh"), strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "CrOS"):
+		os = "ChromeOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	// Checked in order: most browsers also name the ones they derive from
+	var browser string
+	switch {
+	case strings.Contains(userAgent, "Edg/"), strings.Contains(userAgent, "EdgA/"), strings.Contains(userAgent, "EdgiOS/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"), strings.Contains(userAgent, "FxiOS/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case os == "":
+		// API clients such as curl/8.4.0 name themselves first
+		if name, _, ok := strings.Cut(userAgent, "/"); ok && name != "" && !strings.Contains(name, " ") {
+			browser = name
+		}
+	}
+
+	name := "Unknown device"
+	switch {
+	case browser != "" && os != "":
+		name = browser + " on " + os
+	case browser != "":
+		name = browser
+	case os != "":
+		name = os
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"mastercard-backend/pkg/pgsql"
+)
+
+// SQLValidationError describes why generated SQL was rejected
+type SQLValidationError struct {
+	Code   string
+	Reason string
+}
+
+func (e *SQLValidationError) Error() string {
+	return fmt.Sprintf("[%s] %s", e.Code, e.Reason)
+}
+
+// blockedFunctions are functions that can sleep, touch the filesystem,
+// change settings or reach other servers
+var blockedFunctions = map[string]bool{
+	"set_config":                    true,
+	"current_setting":               true,
+	"nextval":                       true,
+	"setval":                        true,
+	"currval":                       true,
+	"lastval":                       true,
+	"query_to_xml":                  true,
+	"query_to_xml_and_xmlschema":    true,
+	"query_to_xmlschema":            true,
+	"table_to_xml":                  true,
+	"table_to_xml_and_xmlschema":    true,
+	"table_to_xmlschema":            true,
+	"cursor_to_xml":                 true,
+	"cursor_to_xmlschema":           true,
+	"schema_to_xml":                 true,
+	"schema_to_xml_and_xmlschema":   true,
+	"schema_to_xmlschema":           true,
+	"database_to_xml":               true,
+	"database_to_xml_and_xmlschema": true,
+	"database_to_xmlschema":         true,
+	"txid_current":                  true,
+}
+
+// blockedFunctionPrefixes cover families such as pg_sleep, pg_read_file,
+// pg_terminate_backend, lo_import and dblink_exec
+var blockedFunctionPrefixes = []string{"pg_", "lo_", "dblink"}
+
+// systemSchemas may not be queried by generated SQL
+var systemSchemas = map[string]bool{
+	"pg_catalog":         true,
+	"information_schema": true,
+	"pg_toast":           true,
+}
+
+// SQLValidator checks that generated SQL is a single read-only query
+type SQLValidator struct{}
+
+func NewSQLValidator() *SQLValidator {
+	return &SQLValidator{}
+}
+
+// Validate parses sql and returns the statement if it is safe to execute,
+// otherwise a *SQLValidationError
+func (v *SQLValidator) Validate(sql string) (*pgsql.Statement, error) {
+	statements, err := pgsql.Parse(sql)
+	if err != nil {
+		var parseErr *pgsql.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &SQLValidationError{Code: "syntax_error", Reason: parseErr.Error()}
+		}
+		return nil, &SQLValidationError{Code: "syntax_error", Reason: err.Error()}
+	}
+
+	if len(statements) == 0 {
+		return nil, &SQLValidationError{Code: "empty", Reason: "no SQL statement was generated"}
+	}
+	if len(statements) > 1 {
+		return nil, &SQLValidationError{Code: "multiple_statements", Reason: fmt.Sprintf("expected a single statement, got %d", len(statements))}
+	}
+
+	stmt := statements[0]
+	if stmt.Query == nil {
+		return nil, &SQLValidationError{Code: "not_select", Reason: fmt.Sprintf("only SELECT queries are allowed, got %s", strings.ToUpper(stmt.Keyword))}
+	}
+
+	var violation *SQLValidationError
+	pgsql.Inspect(stmt.Query, func(node pgsql.Node) bool {
+		if violation != nil {
+			return false
+		}
+		violation = v.check(node)
+		return violation == nil
+	})
+	if violation != nil {
+		return nil, violation
+	}
+
+	return stmt, nil
+}
+
+// check validates a single node of the syntax tree
+func (v *SQLValidator) check(node pgsql.Node) *SQLValidationError {
+	switch n := node.(type) {
+	case *pgsql.Query:
+		if n.Into != nil {
+			return &SQLValidationError{Code: "select_into", Reason: "SELECT ... INTO is not allowed"}
+		}
+		if len(n.Locking) > 0 {
+			return &SQLValidationError{Code: "locking_clause", Reason: fmt.Sprintf("row locking (%s) is not allowed", strings.ToUpper(n.Locking[0]))}
+		}
+
+	case *pgsql.Select:
+		if n.Into != nil {
+			return &SQLValidationError{Code: "select_into", Reason: "SELECT ... INTO is not allowed"}
+		}
+
+	case *pgsql.CTE:
+		if n.Query == nil {
+			return &SQLValidationError{Code: "data_modifying_cte", Reason: fmt.Sprintf("WITH %s contains a %s statement", n.Name, strings.ToUpper(n.Kind))}
+		}
+
+	case *pgsql.FuncCall:
+		if isBlockedFunction(n.Name) {
+			return &SQLValidationError{Code: "blocked_function", Reason: fmt.Sprintf("function %s() is not allowed", n.Name)}
+		}
+
+	case *pgsql.RelationRef:
+		if systemSchemas[n.Schema] || strings.HasPrefix(n.Name, "pg_") || n.Name == "information_schema" {
+			name := n.Name
+			if n.Schema != "" {
+				name = n.Schema + "." + n.Name
+			}
+			return &SQLValidationError{Code: "system_catalog", Reason: fmt.Sprintf("access to system catalog %s is not allowed", name)}
+		}
+	}
+
+	return nil
+}
+
+func isBlockedFunction(name string) bool {
+	name = strings.ToLower(name)
+	if blockedFunctions[name] {
+		return true
+	}
+	for _, prefix := range blockedFunctionPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
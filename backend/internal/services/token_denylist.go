@@ -0,0 +1,177 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/database"
+	"mastercard-backend/internal/models"
+	"mastercard-backend/internal/utils"
+)
+
+// Token and session revocation reasons
+const (
+	RevokeLogout         = "logout"
+	RevokeLogoutAll      = "logout_all"
+	RevokeRoleChange     = "role_change"
+	RevokeDeactivated    = "deactivated"
+	RevokePasswordChange = "password_change"
+	RevokeTerminated     = "terminated"
+	RevokeIdle           = "idle"
+	RevokeTokenReuse     = "token_reuse"
+	RevokeMFARequired    = "mfa_required"
+)
+
+// tokenDenylist caches the rows of revoked_tokens. Revocations made here
+// apply at once; those of other instances once the next sync reads them.
+type tokenDenylist struct {
+	mu sync.RWMutex
+	// tokens maps revoked jtis to the expiry of the token
+	tokens map[string]time.Time
+	// users maps user IDs to the latest user-wide revocation
+	users map[uint]userRevocation
+}
+
+type userRevocation struct {
+	issuedBefore time.Time
+	expiresAt    time.Time
+}
+
+var denylist = &tokenDenylist{
+	tokens: make(map[string]time.Time),
+	users:  make(map[uint]userRevocation),
+}
+
+// StartTokenDenylist loads the revoked tokens and keeps the cache in sync
+// with the other instances every TOKEN_DENYLIST_SYNC_INTERVAL
+func StartTokenDenylist() {
+	if err := denylist.sync(); err != nil {
+		fmt.Printf("Warning: Failed to load revoked tokens: %v\n", err)
+	}
+
+	interval := config.AppConfig.TokenDenylistSyncInterval
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := denylist.sync(); err != nil {
+				fmt.Printf("Warning: Failed to sync revoked tokens: %v\n", err)
+			}
+		}
+	}()
+}
+
+// sync reads the revocations in effect and drops the expired ones, from
+// the cache and from the table. Rows only live as long as access tokens, so
+// the whole table is read: ids of concurrent inserts may commit out of order.
+func (d *tokenDenylist) sync() error {
+	// Stored without time zone, in UTC
+	now := time.Now().UTC()
+	var rows []models.RevokedToken
+	if err := database.DB.Where("expires_at > ?", now).Find(&rows).Error; err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	for i := range rows {
+		d.add(&rows[i])
+	}
+	d.prune(now)
+	d.mu.Unlock()
+
+	return database.DB.Where("expires_at <= ?", now).Delete(&models.RevokedToken{}).Error
+}
+
+// add caches a revocation; the caller holds the lock
+func (d *tokenDenylist) add(row *models.RevokedToken) {
+	if row.JTI != nil {
+		d.tokens[*row.JTI] = row.ExpiresAt
+		return
+	}
+	if row.IssuedBefore == nil {
+		return
+	}
+	current, ok := d.users[row.UserID]
+	if !ok || row.IssuedBefore.After(current.issuedBefore) {
+		d.users[row.UserID] = userRevocation{issuedBefore: *row.IssuedBefore, expiresAt: row.ExpiresAt}
+	}
+}
+
+// prune drops the revocations of expired tokens; the caller holds the lock
+func (d *tokenDenylist) prune(now time.Time) {
+	for jti, expiresAt := range d.tokens {
+		if !expiresAt.After(now) {
+			delete(d.tokens, jti)
+		}
+	}
+	for userID, revocation := range d.users {
+		if !revocation.expiresAt.After(now) {
+			delete(d.users, userID)
+		}
+	}
+}
+
+// revoke stores a revocation and caches it
+func (d *tokenDenylist) revoke(row *models.RevokedToken) error {
+	if err := database.DB.Create(row).Error; err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.add(row)
+	d.mu.Unlock()
+	return nil
+}
+
+// IsTokenRevoked reports whether an access token was revoked
+func IsTokenRevoked(claims *utils.Claims) bool {
+	denylist.mu.RLock()
+	defer denylist.mu.RUnlock()
+
+	if claims.ID != "" {
+		if _, ok := denylist.tokens[claims.ID]; ok {
+			return true
+		}
+	}
+	if revocation, ok := denylist.users[claims.UserID]; ok {
+		// Both have second precision: a token issued in the second of the
+		// revocation is revoked too
+		if claims.IssuedAt == nil || !claims.IssuedAt.Time.After(revocation.issuedBefore) {
+			return true
+		}
+	}
+	return false
+}
+
+// RevokeToken revokes a single access token until it expires
+func RevokeToken(claims *utils.Claims, reason string) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	jti := claims.ID
+	return denylist.revoke(&models.RevokedToken{
+		JTI:       &jti,
+		UserID:    claims.UserID,
+		Reason:    reason,
+		ExpiresAt: claims.ExpiresAt.Time.UTC(),
+	})
+}
+
+// RevokeUserTokens revokes every access token issued to a user so far. The
+// iat claim has second precision, so the cutoff is the current second: a
+// token issued later within that second, such as by a login right after a
+// password change, is revoked too and its user logs in again.
+func RevokeUserTokens(userID uint, reason string) error {
+	issuedBefore := time.Now().UTC().Truncate(time.Second)
+	return denylist.revoke(&models.RevokedToken{
+		UserID:       userID,
+		IssuedBefore: &issuedBefore,
+		Reason:       reason,
+		// Tokens issued up to the end of that second expire by then
+		ExpiresAt: issuedBefore.Add(time.Second + config.AppConfig.JWTAccessTokenExpiry),
+	})
+}
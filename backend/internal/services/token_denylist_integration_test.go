@@ -0,0 +1,71 @@
+//go:build integration
+
+package services
+
+import (
+	"testing"
+	"time"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/internal/models"
+)
+
+func TestDenylistSyncReadsRevocationsOfOtherInstances(t *testing.T) {
+	tx := useTestTx(t)
+	d := useEmptyDenylist(t)
+	user := createLoginUser(t, tx)
+	now := time.Now().UTC()
+
+	// Stored by another instance
+	revoked := models.RevokedToken{JTI: stringPtr("7d0c7b3e-4f7a-4b8e-9d55-0d6f4c2b9a11"), UserID: user.ID, Reason: RevokeLogout, ExpiresAt: now.Add(time.Minute)}
+	expired := models.RevokedToken{JTI: stringPtr("0b7c1f0e-2d1c-4a8e-8f1e-6a4a3b2c1d00"), UserID: user.ID, Reason: RevokeLogout, ExpiresAt: now.Add(-time.Minute)}
+	for _, row := range []*models.RevokedToken{&revoked, &expired} {
+		if err := tx.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if IsTokenRevoked(accessClaims(user.ID, *revoked.JTI, now)) {
+		t.Fatal("revoked before the sync")
+	}
+
+	if err := d.sync(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	if !IsTokenRevoked(accessClaims(user.ID, *revoked.JTI, now)) {
+		t.Error("the synced revocation does not apply")
+	}
+	if _, ok := d.tokens[*expired.JTI]; ok {
+		t.Error("the expired revocation is cached")
+	}
+	var count int64
+	tx.Model(&models.RevokedToken{}).Where("id = ?", expired.ID).Count(&count)
+	if count != 0 {
+		t.Error("the expired revocation is kept in the table")
+	}
+}
+
+func TestRevokeUserTokensCutsOffAtTheSecond(t *testing.T) {
+	tx := useTestTx(t)
+	useEmptyDenylist(t)
+	user := createLoginUser(t, tx)
+
+	before := time.Now().UTC()
+	if err := RevokeUserTokens(user.ID, RevokeLogoutAll); err != nil {
+		t.Fatalf("RevokeUserTokens error: %v", err)
+	}
+	var row models.RevokedToken
+	if err := tx.Where("user_id = ? AND jti IS NULL", user.ID).Take(&row).Error; err != nil {
+		t.Fatalf("no revocation stored: %v", err)
+	}
+	cutoff := *row.IssuedBefore
+	if cutoff.Nanosecond() != 0 || cutoff.After(before) || before.Sub(cutoff) >= time.Second {
+		t.Errorf("cutoff %v is not the second of %v", cutoff, before)
+	}
+	if want := cutoff.Add(time.Second + config.AppConfig.JWTAccessTokenExpiry); !row.ExpiresAt.Equal(want) {
+		t.Errorf("expires at %v, want %v", row.ExpiresAt, want)
+	}
+
+	if !IsTokenRevoked(accessClaims(user.ID, "a", cutoff)) || IsTokenRevoked(accessClaims(user.ID, "b", cutoff.Add(time.Second))) {
+		t.Error("tokens are not revoked up to the end of the cutoff second")
+	}
+}
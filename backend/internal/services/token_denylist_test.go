@@ -0,0 +1,110 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"mastercard-backend/internal/models"
+	"mastercard-backend/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useEmptyDenylist replaces the denylist cache for a test
+func useEmptyDenylist(t *testing.T) *tokenDenylist {
+	t.Helper()
+	saved := denylist
+	denylist = &tokenDenylist{
+		tokens: make(map[string]time.Time),
+		users:  make(map[uint]userRevocation),
+	}
+	t.Cleanup(func() { denylist = saved })
+	return denylist
+}
+
+// accessClaims returns the claims of an access token issued at iat
+func accessClaims(userID uint, jti string, iat time.Time) *utils.Claims {
+	return &utils.Claims{
+		UserID:    userID,
+		TokenType: utils.TokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       jti,
+			IssuedAt: jwt.NewNumericDate(iat),
+		},
+	}
+}
+
+func TestIsTokenRevokedByJTI(t *testing.T) {
+	d := useEmptyDenylist(t)
+	now := time.Now()
+	d.add(&models.RevokedToken{JTI: stringPtr("revoked"), UserID: 1, ExpiresAt: now.Add(time.Minute)})
+
+	if !IsTokenRevoked(accessClaims(1, "revoked", now)) {
+		t.Error("the revoked token is accepted")
+	}
+	if IsTokenRevoked(accessClaims(1, "other", now)) {
+		t.Error("another token of the user is revoked")
+	}
+}
+
+func TestIsTokenRevokedByUserCutoff(t *testing.T) {
+	d := useEmptyDenylist(t)
+	cutoff := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	d.add(&models.RevokedToken{UserID: 1, IssuedBefore: &cutoff, ExpiresAt: cutoff.Add(time.Hour)})
+
+	tests := []struct {
+		name    string
+		claims  *utils.Claims
+		revoked bool
+	}{
+		{"issued before", accessClaims(1, "a", cutoff.Add(-time.Minute)), true},
+		// iat is truncated to the second when the token is signed
+		{"issued in the same second", accessClaims(1, "b", cutoff), true},
+		{"issued the next second", accessClaims(1, "c", cutoff.Add(time.Second)), false},
+		{"without iat", &utils.Claims{UserID: 1}, true},
+		{"another user", accessClaims(2, "d", cutoff.Add(-time.Minute)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTokenRevoked(tt.claims); got != tt.revoked {
+				t.Errorf("revoked = %v, want %v", got, tt.revoked)
+			}
+		})
+	}
+}
+
+func TestDenylistKeepsTheLatestUserCutoff(t *testing.T) {
+	d := useEmptyDenylist(t)
+	early := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	late := early.Add(time.Minute)
+	d.add(&models.RevokedToken{UserID: 1, IssuedBefore: &late, ExpiresAt: late.Add(time.Hour)})
+	// Synced after the later one
+	d.add(&models.RevokedToken{UserID: 1, IssuedBefore: &early, ExpiresAt: early.Add(time.Hour)})
+
+	if !d.users[1].issuedBefore.Equal(late) {
+		t.Errorf("cutoff = %v, want %v", d.users[1].issuedBefore, late)
+	}
+}
+
+func TestDenylistPrunesExpiredRevocations(t *testing.T) {
+	d := useEmptyDenylist(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	d.add(&models.RevokedToken{JTI: stringPtr("expired"), UserID: 1, ExpiresAt: now})
+	d.add(&models.RevokedToken{JTI: stringPtr("valid"), UserID: 1, ExpiresAt: now.Add(time.Second)})
+	d.add(&models.RevokedToken{UserID: 2, IssuedBefore: &now, ExpiresAt: now.Add(-time.Second)})
+	d.add(&models.RevokedToken{UserID: 3, IssuedBefore: &now, ExpiresAt: now.Add(time.Minute)})
+
+	d.prune(now)
+	if _, ok := d.tokens["expired"]; ok {
+		t.Error("the expired token revocation is kept")
+	}
+	if _, ok := d.tokens["valid"]; !ok {
+		t.Error("the token revocation still in effect is pruned")
+	}
+	if _, ok := d.users[2]; ok {
+		t.Error("the expired user revocation is kept")
+	}
+	if _, ok := d.users[3]; !ok {
+		t.Error("the user revocation still in effect is pruned")
+	}
+}
@@ -7,22 +7,42 @@ import (
 	"mastercard-backend/internal/config"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Token types, carried in the typ claim so a token is only accepted where
+// it was issued for
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+	// TokenTypeMFA is the challenge of a login waiting for its second factor
+	TokenTypeMFA = "mfa"
 )
 
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Email  string `json:"email"`
-	RoleID *uint  `json:"role_id,omitempty"`
+	UserID    uint   `json:"user_id"`
+	Email     string `json:"email"`
+	RoleID    *uint  `json:"role_id,omitempty"`
+	TokenType string `json:"typ"`
+	// FamilyID groups the refresh tokens rotated from one login
+	FamilyID string `json:"fam,omitempty"`
+	// SessionID names the session of an access token, the family of its
+	// refresh tokens
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateAccessToken generates a JWT access token
-func GenerateAccessToken(userID uint, email string, roleID *uint) (string, error) {
+// GenerateAccessToken generates a JWT access token of a session
+func GenerateAccessToken(userID uint, email string, roleID *uint, sessionID string) (string, error) {
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
-		RoleID: roleID,
+		UserID:    userID,
+		Email:     email,
+		RoleID:    roleID,
+		TokenType: TokenTypeAccess,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			// jti, to revoke the token before it expires
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.AppConfig.JWTAccessTokenExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    config.AppConfig.AppName,
@@ -33,12 +53,33 @@ func GenerateAccessToken(userID uint, email string, roleID *uint) (string, error
 	return token.SignedString([]byte(config.AppConfig.JWTSecret))
 }
 
-// GenerateRefreshToken generates a JWT refresh token
-func GenerateRefreshToken(userID uint) (string, error) {
+// GenerateRefreshToken generates a JWT refresh token with the given ID in
+// a token family, expiring at expiresAt
+func GenerateRefreshToken(userID uint, tokenID, familyID string, expiresAt time.Time) (string, error) {
+	claims := &Claims{
+		UserID:    userID,
+		TokenType: TokenTypeRefresh,
+		FamilyID:  familyID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    config.AppConfig.AppName,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(config.AppConfig.JWTSecret))
+}
+
+// GenerateMFAToken generates the token of an MFA login challenge
+func GenerateMFAToken(userID uint, challengeID string, expiresAt time.Time) (string, error) {
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		TokenType: TokenTypeMFA,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.AppConfig.JWTRefreshTokenExpiry)),
+			ID:        challengeID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    config.AppConfig.AppName,
 		},
@@ -48,8 +89,8 @@ func GenerateRefreshToken(userID uint) (string, error) {
 	return token.SignedString([]byte(config.AppConfig.JWTSecret))
 }
 
-// ValidateToken validates and parses a JWT token
-func ValidateToken(tokenString string) (*Claims, error) {
+// ValidateToken validates and parses a JWT token of the given type
+func ValidateToken(tokenString, tokenType string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("invalid signing method")
@@ -62,6 +103,9 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		if claims.TokenType != tokenType {
+			return nil, errors.New("wrong token type")
+		}
 		return claims, nil
 	}
 
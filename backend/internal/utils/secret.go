@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+
+	"mastercard-backend/internal/config"
+)
+
+// secretKey is the AES-256 key of stored secrets: MFA_ENCRYPTION_KEY, or
+// JWT_SECRET when unset, hashed to 32 bytes
+func secretKey() []byte {
+	key := config.AppConfig.MFAEncryptionKey
+	if key == "" {
+		key = config.AppConfig.JWTSecret
+	}
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+func secretCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secretKey())
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret encrypts a secret for storage with AES-GCM
+func EncryptSecret(plaintext string) (string, error) {
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret decrypts a secret encrypted by EncryptSecret
+func DecryptSecret(ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted secret")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
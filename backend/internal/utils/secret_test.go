@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"testing"
+
+	"mastercard-backend/internal/config"
+)
+
+func useSecretKeys(t *testing.T, mfaKey, jwtSecret string) {
+	t.Helper()
+	saved := config.AppConfig
+	config.AppConfig = &config.Config{MFAEncryptionKey: mfaKey, JWTSecret: jwtSecret}
+	t.Cleanup(func() { config.AppConfig = saved })
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	useSecretKeys(t, "mfa-key", "jwt-secret")
+	first, err := EncryptSecret("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := EncryptSecret("JBSWY3DPEHPK3PXP")
+	if first == second {
+		t.Error("encrypting twice gives the same ciphertext")
+	}
+
+	for _, ciphertext := range []string{first, second} {
+		plaintext, err := DecryptSecret(ciphertext)
+		if err != nil || plaintext != "JBSWY3DPEHPK3PXP" {
+			t.Errorf("DecryptSecret = %q, %v", plaintext, err)
+		}
+	}
+}
+
+func TestDecryptSecretNeedsTheSameKey(t *testing.T) {
+	useSecretKeys(t, "mfa-key", "jwt-secret")
+	ciphertext, err := EncryptSecret("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.AppConfig.MFAEncryptionKey = "other-key"
+	if _, err := DecryptSecret(ciphertext); err == nil {
+		t.Error("decrypted with another key")
+	}
+	// JWT_SECRET is only the fallback
+	config.AppConfig.MFAEncryptionKey = ""
+	if _, err := DecryptSecret(ciphertext); err == nil {
+		t.Error("decrypted with JWT_SECRET")
+	}
+}
+
+func TestDecryptSecretRejectsInvalidInput(t *testing.T) {
+	useSecretKeys(t, "mfa-key", "")
+	for _, ciphertext := range []string{"", "not base64!", "c2hvcnQ="} {
+		if _, err := DecryptSecret(ciphertext); err == nil {
+			t.Errorf("DecryptSecret(%q) succeeded", ciphertext)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// The secret of the RFC 4226 and RFC 6238 (SHA-1) test vectors
+var rfcKey = []byte("12345678901234567890")
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := totpCode(rfcKey, int64(counter)); got != code {
+			t.Errorf("counter %d: got %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, the last 6 of the 8 digits
+	secret := totpEncoding.EncodeToString(rfcKey)
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(secret, tt.code, time.Unix(tt.unix, 0), 0)
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("at %d: %s = step %d, %v; want step %d", tt.unix, tt.code, step, ok, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfcKey)
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	code := totpCode(rfcKey, step)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		at       time.Time
+		lastStep int64
+		want     bool
+	}{
+		{"current step", secret, code, now, 0, true},
+		{"previous step", secret, code, now.Add(totpPeriod * time.Second), 0, true},
+		{"next step", secret, code, now.Add(-totpPeriod * time.Second), 0, true},
+		{"two steps later", secret, code, now.Add(2 * totpPeriod * time.Second), 0, false},
+		{"spaces", secret, " " + code[:3] + " " + code[3:] + " ", now, 0, true},
+		{"lower case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code, now, 0, true},
+		{"replayed", secret, code, now, step, false},
+		{"after a later step", secret, code, now, step + 1, false},
+		{"wrong code", secret, "000000", now, 0, false},
+		{"too short", secret, code[:5], now, 0, false},
+		{"too long", secret, code + "0", now, 0, false},
+		{"invalid secret", "not base32!", code, now, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(tt.secret, tt.code, tt.at, tt.lastStep)
+			if ok != tt.want {
+				t.Fatalf("ok = %v, want %v", ok, tt.want)
+			}
+			if ok && got != step {
+				t.Errorf("step = %d, want %d", got, step)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes (%v), want 20", secret, len(key), err)
+	}
+	other, _ := GenerateTOTPSecret()
+	if other == secret {
+		t.Error("two secrets are equal")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	got := TOTPProvisioningURI("JBSWY3DPEHPK3PXP", "Data Platform", "ann@example.com")
+	want := "otpauth://totp/Data%20Platform:ann@example.com?algorithm=SHA1&digits=6&issuer=Data%20Platform&period=30&secret=JBSWY3DPEHPK3PXP"
+	if got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+-- Least-privilege role used by the backend to execute LLM-generated SQL
+-- The password must match QUERY_DB_PASSWORD; change both outside development
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'mastercard_query') THEN
+        CREATE ROLE mastercard_query LOGIN PASSWORD 'mastercard_query_pass'
+            NOSUPERUSER NOCREATEDB NOCREATEROLE NOINHERIT NOREPLICATION;
+    END IF;
+END
+$$;
+
+DO $$
+BEGIN
+    EXECUTE format('GRANT CONNECT ON DATABASE %I TO mastercard_query', current_database());
+END
+$$;
+
+-- Start from nothing, then grant only what generated queries need
+REVOKE ALL ON ALL TABLES IN SCHEMA public FROM mastercard_query;
+REVOKE ALL ON ALL SEQUENCES IN SCHEMA public FROM mastercard_query;
+GRANT USAGE ON SCHEMA public TO mastercard_query;
+
+GRANT SELECT ON transactions TO mastercard_query;
+
+-- The RLS policy on transactions looks up the caller's role, which runs with
+-- the querying role's privileges: expose only the columns it needs
+GRANT SELECT (id, role_id) ON users TO mastercard_query;
+GRANT SELECT (id, name) ON roles TO mastercard_query;
+
+-- Defence in depth in case a session forgets BEGIN READ ONLY
+ALTER ROLE mastercard_query SET default_transaction_read_only = on;
+ALTER ROLE mastercard_query SET statement_timeout = '30s';
+
+-- Recreate the transactions policy: migration 009 renamed analyst to analyzer,
+-- and an unset app.current_user_id must deny instead of raising an error
+DROP POLICY IF EXISTS transactions_read_policy ON transactions;
+
+CREATE POLICY transactions_read_policy ON transactions
+    FOR SELECT
+    USING (
+        EXISTS (
+            SELECT 1 FROM users u
+            JOIN roles r ON u.role_id = r.id
+            WHERE u.id = NULLIF(current_setting('app.current_user_id', true), '')::INTEGER
+            AND r.name IN ('admin', 'manager', 'analyzer')
+        )
+    );
+
+COMMENT ON POLICY transactions_read_policy ON transactions IS 'RLS policy controlling read access to transactions based on user role';
+COMMENT ON ROLE mastercard_query IS 'Read-only role for executing generated SQL (SELECT on transactions only)';
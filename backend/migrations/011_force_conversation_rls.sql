@@ -0,0 +1,100 @@
+-- Bind RLS to the transaction-local app.current_user_id set by database.WithUser
+-- and enforce the policies for the table owner the backend connects as
+
+-- Current user of the transaction, NULL (deny) when unset
+CREATE OR REPLACE FUNCTION app_current_user_id() RETURNS INTEGER
+    LANGUAGE sql STABLE
+AS $$
+    SELECT NULLIF(current_setting('app.current_user_id', true), '')::INTEGER
+$$;
+
+-- Whether the current user's role grants resource.action
+CREATE OR REPLACE FUNCTION app_current_user_can(p_resource TEXT, p_action TEXT) RETURNS BOOLEAN
+    LANGUAGE sql STABLE
+AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM users u
+        JOIN role_permissions rp ON rp.role_id = u.role_id
+        JOIN permissions p ON p.id = rp.permission_id
+        WHERE u.id = app_current_user_id()
+        AND p.resource = p_resource
+        AND p.action = p_action
+    )
+$$;
+
+ALTER TABLE conversations FORCE ROW LEVEL SECURITY;
+ALTER TABLE messages FORCE ROW LEVEL SECURITY;
+
+-- Conversations
+DROP POLICY IF EXISTS conversations_read_policy ON conversations;
+DROP POLICY IF EXISTS conversations_insert_policy ON conversations;
+DROP POLICY IF EXISTS conversations_update_policy ON conversations;
+DROP POLICY IF EXISTS conversations_delete_policy ON conversations;
+
+-- Users see their own conversations; managers and admins (conversations.read_all) see all
+CREATE POLICY conversations_read_policy ON conversations
+    FOR SELECT
+    USING (
+        user_id = app_current_user_id()
+        OR app_current_user_can('conversations', 'read_all')
+    );
+
+CREATE POLICY conversations_insert_policy ON conversations
+    FOR INSERT
+    WITH CHECK (
+        user_id = app_current_user_id()
+    );
+
+CREATE POLICY conversations_update_policy ON conversations
+    FOR UPDATE
+    USING (
+        user_id = app_current_user_id()
+    );
+
+CREATE POLICY conversations_delete_policy ON conversations
+    FOR DELETE
+    USING (
+        user_id = app_current_user_id()
+        OR app_current_user_can('conversations', 'delete_all')
+    );
+
+-- Messages follow the visibility of their conversation
+DROP POLICY IF EXISTS messages_read_policy ON messages;
+DROP POLICY IF EXISTS messages_insert_policy ON messages;
+DROP POLICY IF EXISTS messages_update_policy ON messages;
+
+CREATE POLICY messages_read_policy ON messages
+    FOR SELECT
+    USING (
+        EXISTS (
+            SELECT 1 FROM conversations c
+            WHERE c.id = messages.conversation_id
+            AND (
+                c.user_id = app_current_user_id()
+                OR app_current_user_can('conversations', 'read_all')
+            )
+        )
+    );
+
+CREATE POLICY messages_insert_policy ON messages
+    FOR INSERT
+    WITH CHECK (
+        EXISTS (
+            SELECT 1 FROM conversations c
+            WHERE c.id = messages.conversation_id
+            AND c.user_id = app_current_user_id()
+        )
+    );
+
+CREATE POLICY messages_update_policy ON messages
+    FOR UPDATE
+    USING (
+        EXISTS (
+            SELECT 1 FROM conversations c
+            WHERE c.id = messages.conversation_id
+            AND c.user_id = app_current_user_id()
+        )
+    );
+
+COMMENT ON POLICY conversations_read_policy ON conversations IS 'RLS policy allowing users to read their own conversations, and all with conversations.read_all';
+COMMENT ON POLICY messages_read_policy ON messages IS 'RLS policy allowing users to read messages from conversations they can see';
@@ -0,0 +1,8 @@
+-- Add result truncation metadata to messages
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS row_count INTEGER;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS truncated BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS total_estimate BIGINT;
+
+COMMENT ON COLUMN messages.row_count IS 'Number of rows stored in result_data';
+COMMENT ON COLUMN messages.truncated IS 'True when the query returned more rows than MAX_RESULT_ROWS';
+COMMENT ON COLUMN messages.total_estimate IS 'Planner estimate of the total row count, set when truncated';
@@ -0,0 +1,4 @@
+-- Add EXPLAIN plan summary used by the query cost guard
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS plan_summary JSONB;
+
+COMMENT ON COLUMN messages.plan_summary IS 'EXPLAIN summary (node type, cost, rows, thresholds) of the generated SQL';
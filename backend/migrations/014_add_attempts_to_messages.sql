@@ -0,0 +1,4 @@
+-- Add the SQL attempts of the self-correction loop to messages
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS attempts JSONB;
+
+COMMENT ON COLUMN messages.attempts IS 'Generated SQL tried for the message in order, with the PostgreSQL error of each failed attempt';
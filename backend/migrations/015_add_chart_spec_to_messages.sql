@@ -0,0 +1,4 @@
+-- Add the recommended chart of a query result to messages
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS chart_spec JSONB;
+
+COMMENT ON COLUMN messages.chart_spec IS 'Vega-Lite style chart recommendation (mark and x/y/color/theta bindings) for result_data';
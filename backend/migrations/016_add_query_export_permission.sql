@@ -0,0 +1,12 @@
+-- Allow exporting message results (GET /messages/:id/export)
+INSERT INTO permissions (resource, action, conditions) VALUES
+    ('queries', 'export', '{}')
+ON CONFLICT (resource, action) DO NOTHING;
+
+-- Every role that can run queries can export their results
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name IN ('analyzer', 'manager', 'admin')
+  AND p.resource = 'queries' AND p.action = 'export'
+ON CONFLICT DO NOTHING;
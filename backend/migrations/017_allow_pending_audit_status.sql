@@ -0,0 +1,6 @@
+-- Allow the pending status of queries held back by the cost guard
+ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS audit_logs_status_check;
+ALTER TABLE audit_logs ADD CONSTRAINT audit_logs_status_check
+    CHECK (status IN ('success', 'error', 'denied', 'pending'));
+
+COMMENT ON COLUMN audit_logs.status IS 'Status of the action: success, error, denied, or pending (query awaiting cost confirmation)';
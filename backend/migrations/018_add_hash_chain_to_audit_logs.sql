@@ -0,0 +1,23 @@
+-- Tamper-evident audit log: every entry stores the hash of the previous
+-- entry and the SHA-256 hash of its own content including that link
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64);
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS entry_hash VARCHAR(64);
+
+COMMENT ON COLUMN audit_logs.prev_hash IS 'entry_hash of the previous entry in id order, empty for the first entry of the chain';
+COMMENT ON COLUMN audit_logs.entry_hash IS 'SHA-256 of prev_hash and the entry content, checked by GET /admin/audit-logs/verify';
+
+-- Entries keep the id of deleted users: ON DELETE SET NULL would rewrite
+-- hashed rows and break the chain
+ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS audit_logs_user_id_fkey;
+
+-- Audit entries are never updated
+CREATE OR REPLACE FUNCTION audit_logs_reject_update() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_logs entries cannot be modified';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_logs_no_update ON audit_logs;
+CREATE TRIGGER audit_logs_no_update
+    BEFORE UPDATE ON audit_logs
+    FOR EACH ROW EXECUTE FUNCTION audit_logs_reject_update();
@@ -0,0 +1,12 @@
+-- Indexes for the audit log search filters
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Free-text search: ILIKE '%term%' over the question and the SQL
+CREATE INDEX IF NOT EXISTS idx_audit_logs_query_text_trgm ON audit_logs USING gin (query_text gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_sql_executed_trgm ON audit_logs USING gin (sql_executed gin_trgm_ops);
+
+-- IP filters by address or CIDR range (<<=)
+CREATE INDEX IF NOT EXISTS idx_audit_logs_ip_address_range ON audit_logs USING gist (ip_address inet_ops);
+
+-- Slow query filter
+CREATE INDEX IF NOT EXISTS idx_audit_logs_execution_time ON audit_logs(execution_time_ms) WHERE execution_time_ms IS NOT NULL;
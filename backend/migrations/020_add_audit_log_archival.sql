@@ -0,0 +1,35 @@
+-- Legal hold: audit entries of held users are never removed from audit_logs
+ALTER TABLE users ADD COLUMN IF NOT EXISTS legal_hold BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN users.legal_hold IS 'Exempts the audit entries of the user from archival';
+
+-- Audit log archival runs. Each completed run archived the entries with ids
+-- first_id..last_id, continuing the range of the previous one, into
+-- compressed monthly JSONL files
+CREATE TABLE IF NOT EXISTS audit_archive_runs (
+    id SERIAL PRIMARY KEY,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('running', 'completed', 'failed', 'skipped')),
+    trigger VARCHAR(20) NOT NULL CHECK (trigger IN ('schedule', 'manual')),
+    triggered_by INTEGER,
+    cutoff TIMESTAMP NOT NULL,
+    first_id INTEGER,
+    last_id INTEGER,
+    prev_hash VARCHAR(64),
+    last_hash VARCHAR(64),
+    archived INTEGER NOT NULL DEFAULT 0,
+    deleted INTEGER NOT NULL DEFAULT 0,
+    held INTEGER NOT NULL DEFAULT 0,
+    released INTEGER NOT NULL DEFAULT 0,
+    files JSONB,
+    error_message TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_archive_runs_started_at ON audit_archive_runs(started_at DESC);
+
+COMMENT ON TABLE audit_archive_runs IS 'Audit log retention runs, see GET /admin/audit-logs/archives';
+COMMENT ON COLUMN audit_archive_runs.prev_hash IS 'prev_hash of the first archived entry';
+COMMENT ON COLUMN audit_archive_runs.last_hash IS 'entry_hash of the last archived entry, the anchor of the chain left in audit_logs';
+COMMENT ON COLUMN audit_archive_runs.held IS 'Archived entries kept in audit_logs under legal hold';
+COMMENT ON COLUMN audit_archive_runs.released IS 'Entries of earlier runs removed after their legal hold was lifted';
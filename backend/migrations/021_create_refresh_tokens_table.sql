@@ -0,0 +1,21 @@
+-- Refresh tokens, rotated on every use. All tokens rotated from one login
+-- share a family; presenting a token that was already rotated revokes the
+-- whole family.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id UUID PRIMARY KEY,
+    family_id UUID NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    replaced_by UUID,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+
+COMMENT ON TABLE refresh_tokens IS 'Issued refresh tokens, identified by their jti claim';
+COMMENT ON COLUMN refresh_tokens.used_at IS 'When the token was exchanged; a second use is treated as theft';
+COMMENT ON COLUMN refresh_tokens.replaced_by IS 'Token issued in exchange for this one';
@@ -0,0 +1,19 @@
+-- Access token denylist, cached in memory by every backend instance. A row
+-- revokes a single token by its jti, or when jti is NULL every token of the
+-- user issued at or before issued_before. Rows are removed once the tokens
+-- they cover have expired.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id SERIAL PRIMARY KEY,
+    jti UUID,
+    user_id INTEGER NOT NULL,
+    issued_before TIMESTAMP,
+    reason VARCHAR(100) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (jti IS NOT NULL OR issued_before IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
+
+COMMENT ON TABLE revoked_tokens IS 'Revoked access tokens, checked by the auth middleware';
+COMMENT ON COLUMN revoked_tokens.reason IS 'Why the tokens were revoked: logout, logout_all, role_change, deactivated, password_change';
@@ -0,0 +1,29 @@
+-- Login sessions. A session is the refresh token family of a login and
+-- shares its id; access tokens name it in their sid claim.
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device VARCHAR(100) NOT NULL,
+    ip_address INET,
+    user_agent TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP,
+    end_reason VARCHAR(100)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+
+COMMENT ON TABLE sessions IS 'Login sessions, one per refresh token family';
+COMMENT ON COLUMN sessions.last_seen_at IS 'Last request of the session; sessions idle for SESSION_TIMEOUT_MINUTES end';
+COMMENT ON COLUMN sessions.expires_at IS 'Expiry of the latest refresh token of the session';
+
+-- Sessions of the logins made before this migration
+INSERT INTO sessions (id, user_id, device, created_at, last_seen_at, expires_at)
+SELECT family_id, user_id, 'Unknown device', MIN(created_at), MAX(created_at), MAX(expires_at)
+FROM refresh_tokens
+WHERE revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+GROUP BY family_id, user_id
+ON CONFLICT (id) DO NOTHING;
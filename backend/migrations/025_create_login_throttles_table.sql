@@ -0,0 +1,21 @@
+-- Failed login tracking, per account (the email as typed, lowercased, known
+-- or not) and per client IP. Every failure blocks the subject for an
+-- exponentially growing backoff; LOGIN_MAX_ATTEMPTS (LOGIN_IP_MAX_ATTEMPTS)
+-- failures lock it for LOGIN_LOCKOUT_DURATION. Failures older than the
+-- lockout duration are forgotten.
+CREATE TABLE IF NOT EXISTS login_throttles (
+    id SERIAL PRIMARY KEY,
+    scope VARCHAR(10) NOT NULL CHECK (scope IN ('account', 'ip')),
+    subject VARCHAR(255) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL,
+    blocked_until TIMESTAMP,
+    locked_at TIMESTAMP,
+    UNIQUE (scope, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_throttles_blocked_until ON login_throttles(blocked_until);
+
+COMMENT ON TABLE login_throttles IS 'Failed login attempts per account and per IP address';
+COMMENT ON COLUMN login_throttles.blocked_until IS 'End of the backoff or lockout; attempts before it are refused';
+COMMENT ON COLUMN login_throttles.locked_at IS 'Start of the lockout, NULL during a backoff';
@@ -0,0 +1,191 @@
+package fakellm
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"mastercard-backend/internal/config"
+)
+
+//go:embed fixtures.json
+var defaultFixtures []byte
+
+// Rule maps a question pattern to a canned SQL query and analysis
+type Rule struct {
+	Name     string `json:"name"`
+	Pattern  string `json:"pattern"`
+	SQL      string `json:"sql"`
+	Analysis string `json:"analysis"`
+	// CorrectedSQL is returned when the SQL of the rule fails and a
+	// correction is requested, so fixtures can script self-correction
+	CorrectedSQL string `json:"corrected_sql,omitempty"`
+	// Chart, when set, is returned as the refined chart spec
+	Chart json.RawMessage `json:"chart,omitempty"`
+	// Error, when set, makes the matching call fail with this message
+	Error string `json:"error,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Fixtures is the on-disk format of a fake provider script
+type Fixtures struct {
+	Rules   []Rule `json:"rules"`
+	Default *Rule  `json:"default,omitempty"`
+}
+
+// Client is a deterministic, offline LLM provider driven by a fixtures file.
+// Rules are evaluated in order and the first pattern matching the question wins.
+type Client struct {
+	rules    []Rule
+	fallback *Rule
+}
+
+// NewClient creates a fake client from LLM_FAKE_FIXTURES, or from the
+// embedded default fixtures when no file is configured
+func NewClient() (*Client, error) {
+	data := defaultFixtures
+	if path := config.AppConfig.LLMFakeFixtures; path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fake LLM fixtures: %w", err)
+		}
+		data = fileData
+	}
+
+	return NewClientFromJSON(data)
+}
+
+// NewClientFromJSON creates a fake client from raw fixtures JSON
+func NewClientFromJSON(data []byte) (*Client, error) {
+	var fixtures Fixtures
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("invalid fake LLM fixtures: %w", err)
+	}
+
+	for i := range fixtures.Rules {
+		re, err := regexp.Compile(fixtures.Rules[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern in rule %q: %w", fixtures.Rules[i].Name, err)
+		}
+		fixtures.Rules[i].re = re
+	}
+
+	return &Client{
+		rules:    fixtures.Rules,
+		fallback: fixtures.Default,
+	}, nil
+}
+
+// Name returns the provider identifier
+func (c *Client) Name() string {
+	return "fake"
+}
+
+// Close is a no-op for the fake provider
+func (c *Client) Close() error {
+	return nil
+}
+
+// Health always succeeds for the fake provider
+func (c *Client) Health(ctx context.Context) error {
+	return nil
+}
+
+// GenerateSQL returns the SQL of the first rule matching the question
+func (c *Client) GenerateSQL(ctx context.Context, naturalLanguageQuery string, schemaContext string, conversationHistory []string) (string, error) {
+	rule, err := c.match(naturalLanguageQuery)
+	if err != nil {
+		return "", err
+	}
+	if rule.SQL == "" {
+		return "", fmt.Errorf("fake rule %q has no SQL", rule.Name)
+	}
+	return strings.TrimSpace(rule.SQL), nil
+}
+
+// CorrectSQL returns the corrected SQL of the first rule matching the
+// question, or the failed SQL unchanged when the rule has none
+func (c *Client) CorrectSQL(ctx context.Context, naturalLanguageQuery string, schemaContext string, failedSQL string, dbError string, conversationHistory []string) (string, error) {
+	rule, err := c.match(naturalLanguageQuery)
+	if err != nil {
+		return "", err
+	}
+	if rule.CorrectedSQL == "" {
+		return strings.TrimSpace(failedSQL), nil
+	}
+	return strings.TrimSpace(rule.CorrectedSQL), nil
+}
+
+// GenerateAnalysis returns the analysis text of the first rule matching the question
+func (c *Client) GenerateAnalysis(ctx context.Context, userQuery string, sqlQuery string, queryResults string, resultFormat string, conversationHistory []string) (string, error) {
+	rule, err := c.match(userQuery)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(rule.Analysis), nil
+}
+
+// StreamAnalysis streams the analysis text of the first rule matching the
+// question word by word
+func (c *Client) StreamAnalysis(ctx context.Context, userQuery string, sqlQuery string, queryResults string, resultFormat string, conversationHistory []string, onToken func(string) error) (string, error) {
+	analysis, err := c.GenerateAnalysis(ctx, userQuery, sqlQuery, queryResults, resultFormat, conversationHistory)
+	if err != nil {
+		return "", err
+	}
+
+	for _, token := range strings.SplitAfter(analysis, " ") {
+		if token == "" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if err := onToken(token); err != nil {
+			return "", err
+		}
+	}
+
+	return analysis, nil
+}
+
+// RefineChart returns the chart of the first rule matching the question,
+// or the proposed spec unchanged when the rule has none
+func (c *Client) RefineChart(ctx context.Context, userQuery string, sqlQuery string, resultColumns string, proposedSpec string) (string, error) {
+	rule, err := c.match(userQuery)
+	if err != nil {
+		return "", err
+	}
+	if len(rule.Chart) == 0 {
+		return proposedSpec, nil
+	}
+	return string(rule.Chart), nil
+}
+
+// match finds the rule for a question, falling back to the default rule
+func (c *Client) match(question string) (*Rule, error) {
+	var rule *Rule
+	for i := range c.rules {
+		if c.rules[i].re.MatchString(question) {
+			rule = &c.rules[i]
+			break
+		}
+	}
+
+	if rule == nil {
+		rule = c.fallback
+	}
+	if rule == nil {
+		return nil, errors.New("no fake LLM rule matches the question")
+	}
+	if rule.Error != "" {
+		return nil, errors.New(rule.Error)
+	}
+
+	return rule, nil
+}
@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"context"
+)
+
+// Provider is a language model backend capable of turning natural language
+// into SQL and of explaining query results
+type Provider interface {
+	// Name returns the provider identifier used in configuration (e.g. "gemini")
+	Name() string
+
+	// GenerateSQL generates a PostgreSQL query for a natural language question
+	GenerateSQL(ctx context.Context, naturalLanguageQuery string, schemaContext string, conversationHistory []string) (string, error)
+
+	// CorrectSQL generates a replacement for a query that PostgreSQL rejected,
+	// given the failed SQL and the database error
+	CorrectSQL(ctx context.Context, naturalLanguageQuery string, schemaContext string, failedSQL string, dbError string, conversationHistory []string) (string, error)
+
+	// GenerateAnalysis generates conversational analysis about query results
+	GenerateAnalysis(ctx context.Context, userQuery string, sqlQuery string, queryResults string, resultFormat string, conversationHistory []string) (string, error)
+
+	// StreamAnalysis generates the same analysis as GenerateAnalysis, calling
+	// onToken with each piece of text as it arrives, and returns the full text.
+	// An error from onToken stops the stream and is returned.
+	StreamAnalysis(ctx context.Context, userQuery string, sqlQuery string, queryResults string, resultFormat string, conversationHistory []string, onToken func(string) error) (string, error)
+
+	// RefineChart reviews a proposed Vega-Lite style chart spec for a query
+	// result, given the result columns as JSON, and returns the spec to use as JSON
+	RefineChart(ctx context.Context, userQuery string, sqlQuery string, resultColumns string, proposedSpec string) (string, error)
+
+	// Health checks that the provider is reachable and the model is available
+	Health(ctx context.Context) error
+
+	// Close releases any resources held by the provider
+	Close() error
+}
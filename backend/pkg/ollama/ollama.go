@@ -0,0 +1,273 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/pkg/llm"
+)
+
+// Client talks to a local Ollama server (or any server exposing the Ollama API)
+type Client struct {
+	httpClient  *http.Client
+	baseURL     string
+	model       string
+	temperature float64
+	maxTokens   int
+}
+
+type generateRequest struct {
+	Model   string          `json:"model"`
+	Prompt  string          `json:"prompt"`
+	Stream  bool            `json:"stream"`
+	Options generateOptions `json:"options"`
+}
+
+type generateOptions struct {
+	Temperature float64 `json:"temperature"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+}
+
+type generateResponse struct {
+	Response string `json:"response"`
+	Done     bool   `json:"done"`
+	Error    string `json:"error,omitempty"`
+}
+
+type tagsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+// NewClient creates a new Ollama client
+func NewClient() (*Client, error) {
+	if config.AppConfig.OllamaBaseURL == "" {
+		return nil, fmt.Errorf("OLLAMA_BASE_URL is not set")
+	}
+	if config.AppConfig.OllamaModel == "" {
+		return nil, fmt.Errorf("OLLAMA_MODEL is not set")
+	}
+
+	return &Client{
+		httpClient:  &http.Client{Timeout: config.AppConfig.LLMRequestTimeout},
+		baseURL:     strings.TrimRight(config.AppConfig.OllamaBaseURL, "/"),
+		model:       config.AppConfig.OllamaModel,
+		temperature: config.AppConfig.LLMTemperature,
+		maxTokens:   config.AppConfig.LLMMaxTokens,
+	}, nil
+}
+
+// Name returns the provider identifier
+func (c *Client) Name() string {
+	return "ollama"
+}
+
+// Close releases idle HTTP connections
+func (c *Client) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// Health checks that the server is reachable and the model has been pulled
+func (c *Client) Health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ollama server unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama server returned status %d", resp.StatusCode)
+	}
+
+	var tags tagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return fmt.Errorf("invalid response from ollama: %w", err)
+	}
+
+	for _, m := range tags.Models {
+		if m.Name == c.model || strings.TrimSuffix(m.Name, ":latest") == c.model {
+			return nil
+		}
+	}
+	return fmt.Errorf("model %q is not available on the ollama server", c.model)
+}
+
+// GenerateSQL generates SQL query from natural language
+func (c *Client) GenerateSQL(ctx context.Context, naturalLanguageQuery string, schemaContext string, conversationHistory []string) (string, error) {
+	prompt := llm.BuildSQLPrompt(naturalLanguageQuery, schemaContext, conversationHistory)
+
+	content, err := c.generate(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate SQL: %w", err)
+	}
+
+	return llm.ExtractSQL(content), nil
+}
+
+// CorrectSQL generates a corrected query for SQL that failed in PostgreSQL
+func (c *Client) CorrectSQL(ctx context.Context, naturalLanguageQuery string, schemaContext string, failedSQL string, dbError string, conversationHistory []string) (string, error) {
+	prompt := llm.BuildSQLCorrectionPrompt(naturalLanguageQuery, schemaContext, failedSQL, dbError, conversationHistory)
+
+	content, err := c.generate(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to correct SQL: %w", err)
+	}
+
+	return llm.ExtractSQL(content), nil
+}
+
+// RefineChart reviews a proposed chart spec for a query result
+func (c *Client) RefineChart(ctx context.Context, userQuery string, sqlQuery string, resultColumns string, proposedSpec string) (string, error) {
+	prompt := llm.BuildChartPrompt(userQuery, sqlQuery, resultColumns, proposedSpec)
+
+	content, err := c.generate(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to refine chart: %w", err)
+	}
+
+	return llm.ExtractJSON(content), nil
+}
+
+// GenerateAnalysis generates conversational analysis and insights about query results
+func (c *Client) GenerateAnalysis(ctx context.Context, userQuery string, sqlQuery string, queryResults string, resultFormat string, conversationHistory []string) (string, error) {
+	prompt := llm.BuildAnalysisPrompt(userQuery, sqlQuery, queryResults, resultFormat, conversationHistory)
+
+	content, err := c.generate(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate analysis: %w", err)
+	}
+
+	return strings.TrimSpace(content), nil
+}
+
+// StreamAnalysis generates analysis like GenerateAnalysis, streaming the response
+func (c *Client) StreamAnalysis(ctx context.Context, userQuery string, sqlQuery string, queryResults string, resultFormat string, conversationHistory []string, onToken func(string) error) (string, error) {
+	prompt := llm.BuildAnalysisPrompt(userQuery, sqlQuery, queryResults, resultFormat, conversationHistory)
+
+	content, err := c.stream(ctx, prompt, onToken)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate analysis: %w", err)
+	}
+
+	return strings.TrimSpace(content), nil
+}
+
+// generate sends a non-streaming generate request and returns the response text
+func (c *Client) generate(ctx context.Context, prompt string) (string, error) {
+	body, err := json.Marshal(generateRequest{
+		Model:  c.model,
+		Prompt: prompt,
+		Stream: false,
+		Options: generateOptions{
+			Temperature: c.temperature,
+			NumPredict:  c.maxTokens,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var parsed generateResponse
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return "", fmt.Errorf("invalid response (status %d): %w", resp.StatusCode, err)
+	}
+
+	if parsed.Error != "" {
+		return "", fmt.Errorf("ollama error: %s", parsed.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ollama returned status %d", resp.StatusCode)
+	}
+
+	return parsed.Response, nil
+}
+
+// stream sends a streaming generate request and passes each response chunk
+// of the newline-delimited JSON reply to onToken
+func (c *Client) stream(ctx context.Context, prompt string, onToken func(string) error) (string, error) {
+	body, err := json.Marshal(generateRequest{
+		Model:  c.model,
+		Prompt: prompt,
+		Stream: true,
+		Options: generateOptions{
+			Temperature: c.temperature,
+			NumPredict:  c.maxTokens,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk generateResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", fmt.Errorf("invalid response (status %d): %w", resp.StatusCode, err)
+		}
+
+		if chunk.Error != "" {
+			return "", fmt.Errorf("ollama error: %s", chunk.Error)
+		}
+		if chunk.Response != "" {
+			content.WriteString(chunk.Response)
+			if err := onToken(chunk.Response); err != nil {
+				return "", err
+			}
+		}
+		if chunk.Done {
+			break
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ollama returned status %d", resp.StatusCode)
+	}
+
+	return content.String(), nil
+}
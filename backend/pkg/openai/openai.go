@@ -0,0 +1,295 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"mastercard-backend/internal/config"
+	"mastercard-backend/pkg/llm"
+)
+
+// Client talks to any OpenAI-compatible chat completions API
+// (OpenAI, Azure OpenAI proxies, vLLM, LM Studio, LocalAI, ...)
+type Client struct {
+	httpClient  *http.Client
+	baseURL     string
+	apiKey      string
+	model       string
+	temperature float64
+	maxTokens   int
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Stream      bool          `json:"stream,omitempty"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// chatStreamChunk is one server-sent event of a streamed chat completion
+type chatStreamChunk struct {
+	Choices []struct {
+		Delta chatMessage `json:"delta"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// NewClient creates a new OpenAI-compatible client
+func NewClient() (*Client, error) {
+	if config.AppConfig.OpenAIBaseURL == "" {
+		return nil, fmt.Errorf("OPENAI_BASE_URL is not set")
+	}
+	if config.AppConfig.OpenAIModel == "" {
+		return nil, fmt.Errorf("OPENAI_MODEL is not set")
+	}
+
+	return &Client{
+		httpClient:  &http.Client{Timeout: config.AppConfig.LLMRequestTimeout},
+		baseURL:     strings.TrimRight(config.AppConfig.OpenAIBaseURL, "/"),
+		apiKey:      config.AppConfig.OpenAIAPIKey,
+		model:       config.AppConfig.OpenAIModel,
+		temperature: config.AppConfig.LLMTemperature,
+		maxTokens:   config.AppConfig.LLMMaxTokens,
+	}, nil
+}
+
+// Name returns the provider identifier
+func (c *Client) Name() string {
+	return "openai"
+}
+
+// Close releases idle HTTP connections
+func (c *Client) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// Health checks that the API is reachable
+func (c *Client) Health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/models", nil)
+	if err != nil {
+		return err
+	}
+	c.setHeaders(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("openai endpoint unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("openai endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// GenerateSQL generates SQL query from natural language
+func (c *Client) GenerateSQL(ctx context.Context, naturalLanguageQuery string, schemaContext string, conversationHistory []string) (string, error) {
+	prompt := llm.BuildSQLPrompt(naturalLanguageQuery, schemaContext, conversationHistory)
+
+	content, err := c.complete(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate SQL: %w", err)
+	}
+
+	return llm.ExtractSQL(content), nil
+}
+
+// CorrectSQL generates a corrected query for SQL that failed in PostgreSQL
+func (c *Client) CorrectSQL(ctx context.Context, naturalLanguageQuery string, schemaContext string, failedSQL string, dbError string, conversationHistory []string) (string, error) {
+	prompt := llm.BuildSQLCorrectionPrompt(naturalLanguageQuery, schemaContext, failedSQL, dbError, conversationHistory)
+
+	content, err := c.complete(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to correct SQL: %w", err)
+	}
+
+	return llm.ExtractSQL(content), nil
+}
+
+// RefineChart reviews a proposed chart spec for a query result
+func (c *Client) RefineChart(ctx context.Context, userQuery string, sqlQuery string, resultColumns string, proposedSpec string) (string, error) {
+	prompt := llm.BuildChartPrompt(userQuery, sqlQuery, resultColumns, proposedSpec)
+
+	content, err := c.complete(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to refine chart: %w", err)
+	}
+
+	return llm.ExtractJSON(content), nil
+}
+
+// GenerateAnalysis generates conversational analysis and insights about query results
+func (c *Client) GenerateAnalysis(ctx context.Context, userQuery string, sqlQuery string, queryResults string, resultFormat string, conversationHistory []string) (string, error) {
+	prompt := llm.BuildAnalysisPrompt(userQuery, sqlQuery, queryResults, resultFormat, conversationHistory)
+
+	content, err := c.complete(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate analysis: %w", err)
+	}
+
+	return strings.TrimSpace(content), nil
+}
+
+// StreamAnalysis generates analysis like GenerateAnalysis, streaming the reply
+func (c *Client) StreamAnalysis(ctx context.Context, userQuery string, sqlQuery string, queryResults string, resultFormat string, conversationHistory []string, onToken func(string) error) (string, error) {
+	prompt := llm.BuildAnalysisPrompt(userQuery, sqlQuery, queryResults, resultFormat, conversationHistory)
+
+	content, err := c.stream(ctx, prompt, onToken)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate analysis: %w", err)
+	}
+
+	return strings.TrimSpace(content), nil
+}
+
+// complete sends a single-turn chat completion request and returns the reply text
+func (c *Client) complete(ctx context.Context, prompt string) (string, error) {
+	body, err := json.Marshal(chatRequest{
+		Model:       c.model,
+		Messages:    []chatMessage{{Role: "user", Content: prompt}},
+		Temperature: c.temperature,
+		MaxTokens:   c.maxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	c.setHeaders(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var parsed chatResponse
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return "", fmt.Errorf("invalid response (status %d): %w", resp.StatusCode, err)
+	}
+
+	if parsed.Error != nil {
+		return "", fmt.Errorf("api error: %s", parsed.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("api returned status %d", resp.StatusCode)
+	}
+	if len(parsed.Choices) == 0 {
+		return "", fmt.Errorf("no response from model")
+	}
+
+	return parsed.Choices[0].Message.Content, nil
+}
+
+// stream sends a single-turn chat completion request with stream enabled and
+// passes each content delta of the server-sent events to onToken
+func (c *Client) stream(ctx context.Context, prompt string, onToken func(string) error) (string, error) {
+	body, err := json.Marshal(chatRequest{
+		Model:       c.model,
+		Messages:    []chatMessage{{Role: "user", Content: prompt}},
+		Temperature: c.temperature,
+		MaxTokens:   c.maxTokens,
+		Stream:      true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	c.setHeaders(req)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(resp.Body)
+		var parsed chatResponse
+		if json.Unmarshal(raw, &parsed) == nil && parsed.Error != nil {
+			return "", fmt.Errorf("api error: %s", parsed.Error.Message)
+		}
+		return "", fmt.Errorf("api returned status %d", resp.StatusCode)
+	}
+
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk chatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", fmt.Errorf("invalid stream event: %w", err)
+		}
+		if chunk.Error != nil {
+			return "", fmt.Errorf("api error: %s", chunk.Error.Message)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		token := chunk.Choices[0].Delta.Content
+		content.WriteString(token)
+		if err := onToken(token); err != nil {
+			return "", err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return content.String(), nil
+}
+
+func (c *Client) setHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+}
@@ -0,0 +1,409 @@
+// Package parquet writes flat Apache Parquet files: a single row group of
+// OPTIONAL columns, each in one uncompressed, PLAIN-encoded data page. It
+// covers the column types of query result exports and buffers the rows in
+// memory until Close.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Kind is the logical type of a column
+type Kind int
+
+const (
+	Boolean Kind = iota
+	Int32
+	Int64
+	Double
+	String
+	Bytes
+	// Date values are int32 days since the Unix epoch
+	Date
+	// TimestampMillis values are int64 milliseconds since the Unix epoch, UTC
+	TimestampMillis
+	// Decimal values are decimal strings such as "-12.50", stored as
+	// unscaled two's complement integers with the column's Scale
+	Decimal
+)
+
+// Column describes one column of a file
+type Column struct {
+	Name      string
+	Kind      Kind
+	Precision int
+	Scale     int
+}
+
+// Parquet physical types, converted types and enums used by the writer
+const (
+	typeBoolean   = 0
+	typeInt32     = 1
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimestampMillis = 9
+
+	repetitionOptional = 1
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecUncompressed  = 0
+	pageTypeData       = 0
+)
+
+var magic = []byte("PAR1")
+
+// Writer buffers rows and writes them as a Parquet file on Close
+type Writer struct {
+	w       io.Writer
+	columns []Column
+	// defs holds the definition level (1 = value, 0 = NULL) of every row
+	defs [][]byte
+	// values holds the PLAIN-encoded non-NULL values of each column
+	values []bytes.Buffer
+	// bools holds the values of boolean columns, bit-packed on Close
+	bools [][]bool
+	rows  int
+}
+
+// NewWriter returns a writer of a file with the given columns to w
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	seen := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if column.Name == "" || seen[column.Name] {
+			return nil, fmt.Errorf("invalid or duplicate column name %q", column.Name)
+		}
+		if column.Kind == Decimal && (column.Precision <= 0 || column.Scale < 0 || column.Scale > column.Precision) {
+			return nil, fmt.Errorf("column %q: invalid decimal precision %d, scale %d", column.Name, column.Precision, column.Scale)
+		}
+		seen[column.Name] = true
+	}
+
+	return &Writer{
+		w:       w,
+		columns: columns,
+		defs:    make([][]byte, len(columns)),
+		values:  make([]bytes.Buffer, len(columns)),
+		bools:   make([][]bool, len(columns)),
+	}, nil
+}
+
+// Write appends a row. Values are nil for NULL, or bool, int32, int64,
+// float64, string or []byte according to the column kind.
+func (pw *Writer) Write(row []interface{}) error {
+	if len(row) != len(pw.columns) {
+		return fmt.Errorf("row has %d values, want %d", len(row), len(pw.columns))
+	}
+
+	for i, value := range row {
+		if value == nil {
+			pw.defs[i] = append(pw.defs[i], 0)
+			continue
+		}
+		if err := pw.writeValue(i, value); err != nil {
+			return fmt.Errorf("column %q: %w", pw.columns[i].Name, err)
+		}
+		pw.defs[i] = append(pw.defs[i], 1)
+	}
+
+	pw.rows++
+	return nil
+}
+
+func (pw *Writer) writeValue(i int, value interface{}) error {
+	column := pw.columns[i]
+	buf := &pw.values[i]
+
+	switch column.Kind {
+	case Boolean:
+		if v, ok := value.(bool); ok {
+			pw.bools[i] = append(pw.bools[i], v)
+			return nil
+		}
+	case Int32, Date:
+		if v, ok := value.(int32); ok {
+			return binary.Write(buf, binary.LittleEndian, v)
+		}
+	case Int64, TimestampMillis:
+		if v, ok := value.(int64); ok {
+			return binary.Write(buf, binary.LittleEndian, v)
+		}
+	case Double:
+		if v, ok := value.(float64); ok {
+			return binary.Write(buf, binary.LittleEndian, math.Float64bits(v))
+		}
+	case String:
+		if v, ok := value.(string); ok {
+			writeByteArray(buf, []byte(v))
+			return nil
+		}
+	case Bytes:
+		if v, ok := value.([]byte); ok {
+			writeByteArray(buf, v)
+			return nil
+		}
+	case Decimal:
+		if v, ok := value.(string); ok {
+			unscaled, err := decimalBytes(v, column.Scale)
+			if err != nil {
+				return err
+			}
+			writeByteArray(buf, unscaled)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unexpected value of type %T", value)
+}
+
+func writeByteArray(buf *bytes.Buffer, v []byte) {
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(v)))
+	buf.Write(size[:])
+	buf.Write(v)
+}
+
+// decimalBytes converts decimal text to the big-endian two's complement
+// bytes of its unscaled value
+func decimalBytes(text string, scale int) ([]byte, error) {
+	digits := strings.TrimLeft(text, "+-")
+	intPart, fracPart, _ := strings.Cut(digits, ".")
+	if intPart == "" && fracPart == "" {
+		return nil, fmt.Errorf("invalid decimal %q", text)
+	}
+	if len(fracPart) > scale {
+		return nil, fmt.Errorf("decimal %q has more than %d fractional digits", text, scale)
+	}
+	fracPart += strings.Repeat("0", scale-len(fracPart))
+
+	unscaled, ok := new(big.Int).SetString(intPart+fracPart, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal %q", text)
+	}
+	if strings.HasPrefix(text, "-") {
+		unscaled.Neg(unscaled)
+	}
+
+	if unscaled.Sign() >= 0 {
+		b := unscaled.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b, nil
+	}
+
+	// Negative: 2^(8n) + v in the smallest n bytes that hold v, the
+	// bytes of -v-1 with room for the sign bit
+	n := (new(big.Int).Not(unscaled).BitLen() + 8) / 8
+	complement := new(big.Int).Lsh(big.NewInt(1), uint(8*n))
+	complement.Add(complement, unscaled)
+	return complement.Bytes(), nil
+}
+
+// chunkInfo is the location of a written column chunk
+type chunkInfo struct {
+	offset int64
+	size   int64
+}
+
+// Close writes the file: the magic number, one data page per column and
+// the footer. It does not close the underlying writer.
+func (pw *Writer) Close() error {
+	out := &countingWriter{w: pw.w}
+	if _, err := out.Write(magic); err != nil {
+		return err
+	}
+
+	chunks := make([]chunkInfo, len(pw.columns))
+	for i := range pw.columns {
+		offset := out.n
+		if err := pw.writePage(out, i); err != nil {
+			return err
+		}
+		chunks[i] = chunkInfo{offset: offset, size: out.n - offset}
+	}
+
+	footer := pw.footer(chunks)
+	if _, err := out.Write(footer); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	if _, err := out.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := out.Write(magic)
+	return err
+}
+
+// writePage writes the page header and data page of column i
+func (pw *Writer) writePage(out io.Writer, i int) error {
+	var page bytes.Buffer
+
+	// Definition levels: length-prefixed RLE/bit-packed hybrid, as one
+	// bit-packed run of bit width 1
+	levels := packBits(pw.defs[i])
+	var header bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	header.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(levels))<<1|1)])
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(header.Len()+len(levels)))
+	page.Write(size[:])
+	page.Write(header.Bytes())
+	page.Write(levels)
+
+	if pw.columns[i].Kind == Boolean {
+		bits := make([]byte, len(pw.bools[i]))
+		for j, v := range pw.bools[i] {
+			if v {
+				bits[j] = 1
+			}
+		}
+		page.Write(packBits(bits))
+	} else {
+		page.Write(pw.values[i].Bytes())
+	}
+
+	t := &thriftWriter{}
+	t.beginStruct()
+	t.i32Field(1, pageTypeData)
+	t.i32Field(2, int32(page.Len()))
+	t.i32Field(3, int32(page.Len()))
+	t.structField(5)
+	t.i32Field(1, int32(pw.rows))
+	t.i32Field(2, encodingPlain)
+	t.i32Field(3, encodingRLE)
+	t.i32Field(4, encodingRLE)
+	t.endStruct()
+	t.endStruct()
+
+	if _, err := out.Write(t.buf.Bytes()); err != nil {
+		return err
+	}
+	_, err := out.Write(page.Bytes())
+	return err
+}
+
+// footer encodes the FileMetaData of the file
+func (pw *Writer) footer(chunks []chunkInfo) []byte {
+	t := &thriftWriter{}
+	t.beginStruct()
+	t.i32Field(1, 1)
+
+	// Schema: the root followed by the columns
+	t.listField(2, thriftStruct, len(pw.columns)+1)
+	t.beginStruct()
+	t.stringField(4, "schema")
+	t.i32Field(5, int32(len(pw.columns)))
+	t.endStruct()
+	for _, column := range pw.columns {
+		physical, converted := column.types()
+		t.beginStruct()
+		t.i32Field(1, physical)
+		t.i32Field(3, repetitionOptional)
+		t.stringField(4, column.Name)
+		if converted >= 0 {
+			t.i32Field(6, converted)
+		}
+		if column.Kind == Decimal {
+			t.i32Field(7, int32(column.Scale))
+			t.i32Field(8, int32(column.Precision))
+		}
+		t.endStruct()
+	}
+
+	t.i64Field(3, int64(pw.rows))
+
+	// One row group holding every column chunk
+	var totalSize int64
+	for _, chunk := range chunks {
+		totalSize += chunk.size
+	}
+	t.listField(4, thriftStruct, 1)
+	t.beginStruct()
+	t.listField(1, thriftStruct, len(pw.columns))
+	for i, column := range pw.columns {
+		physical, _ := column.types()
+		t.beginStruct()
+		t.i64Field(2, chunks[i].offset)
+		t.structField(3)
+		t.i32Field(1, physical)
+		t.listField(2, thriftI32, 2)
+		t.i32Elem(encodingPlain)
+		t.i32Elem(encodingRLE)
+		t.listField(3, thriftBinary, 1)
+		t.stringElem(column.Name)
+		t.i32Field(4, codecUncompressed)
+		t.i64Field(5, int64(pw.rows))
+		t.i64Field(6, chunks[i].size)
+		t.i64Field(7, chunks[i].size)
+		t.i64Field(9, chunks[i].offset)
+		t.endStruct()
+		t.endStruct()
+	}
+	t.i64Field(2, totalSize)
+	t.i64Field(3, int64(pw.rows))
+	t.endStruct()
+
+	t.stringField(6, "mastercard-backend")
+	t.endStruct()
+
+	return t.buf.Bytes()
+}
+
+// types returns the physical type and the converted type (-1 for none)
+func (c Column) types() (int32, int32) {
+	switch c.Kind {
+	case Boolean:
+		return typeBoolean, -1
+	case Int32:
+		return typeInt32, -1
+	case Int64:
+		return typeInt64, -1
+	case Double:
+		return typeDouble, -1
+	case Date:
+		return typeInt32, convertedDate
+	case TimestampMillis:
+		return typeInt64, convertedTimestampMillis
+	case Decimal:
+		return typeByteArray, convertedDecimal
+	case Bytes:
+		return typeByteArray, -1
+	}
+	return typeByteArray, convertedUTF8
+}
+
+// packBits packs 0/1 values eight to a byte, least significant bit first,
+// padding the last byte with zeros
+func packBits(bits []byte) []byte {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit != 0 {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
+
+// countingWriter tracks the file offset
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,215 @@
+package parquet
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// allColumns has one column of every kind
+var allColumns = []Column{
+	{Name: "flag", Kind: Boolean},
+	{Name: "count", Kind: Int32},
+	{Name: "total", Kind: Int64},
+	{Name: "ratio", Kind: Double},
+	{Name: "merchant", Kind: String},
+	{Name: "raw", Kind: Bytes},
+	{Name: "day", Kind: Date},
+	{Name: "at", Kind: TimestampMillis},
+	{Name: "amount", Kind: Decimal, Precision: 12, Scale: 2},
+}
+
+// writeFile writes rows to an in-memory file
+func writeFile(t *testing.T, columns []Column, rows [][]interface{}) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewWriter(&out, columns)
+	if err != nil {
+		t.Fatalf("NewWriter error: %v", err)
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	return out.Bytes()
+}
+
+// openFile opens data with a reader independent of this package
+func openFile(t *testing.T, data []byte) *reader.ParquetReader {
+	t.Helper()
+	pr, err := reader.NewParquetColumnReader(buffer.NewBufferFileFromBytes(data), 1)
+	if err != nil {
+		t.Fatalf("file does not open: %v", err)
+	}
+	t.Cleanup(pr.ReadStop)
+	return pr
+}
+
+// readColumn returns the values of column i, nil for NULL
+func readColumn(t *testing.T, pr *reader.ParquetReader, i int) []interface{} {
+	t.Helper()
+	values, _, defs, err := pr.ReadColumnByIndex(int64(i), pr.GetNumRows())
+	if err != nil {
+		t.Fatalf("column %d: %v", i, err)
+	}
+	for j, def := range defs {
+		if (def == 0) != (values[j] == nil) {
+			t.Errorf("column %d row %d: definition level %d with value %v", i, j, def, values[j])
+		}
+	}
+	return values
+}
+
+func TestRoundTripEveryKind(t *testing.T) {
+	rows := [][]interface{}{
+		{true, int32(-7), int64(1) << 40, 2.5, "Café", []byte{0, 0xff}, int32(19782), int64(1709251200123), "1234567890.12"},
+		{nil, nil, nil, nil, nil, nil, nil, nil, nil},
+		{false, int32(0), int64(-1), -0.125, "", []byte{}, int32(-1), int64(0), "-0.05"},
+	}
+	pr := openFile(t, writeFile(t, allColumns, rows))
+
+	if n := pr.GetNumRows(); n != int64(len(rows)) {
+		t.Fatalf("file has %d rows, want %d", n, len(rows))
+	}
+	for i, column := range allColumns {
+		want := make([]interface{}, len(rows))
+		for j, row := range rows {
+			want[j] = row[i]
+			// Byte arrays are read as strings
+			if v, ok := row[i].([]byte); ok {
+				want[j] = string(v)
+			}
+		}
+		if column.Kind == Decimal {
+			// Unscaled big-endian two's complement bytes
+			want = []interface{}{string([]byte{0x1c, 0xbe, 0x99, 0x1a, 0x14}), nil, string([]byte{0xfb})}
+		}
+		if got := readColumn(t, pr, i); !reflect.DeepEqual(got, want) {
+			t.Errorf("column %s = %#v, want %#v", column.Name, got, want)
+		}
+	}
+}
+
+func TestSchemaDescribesEveryKind(t *testing.T) {
+	pr := openFile(t, writeFile(t, allColumns, nil))
+	schema := pr.Footer.Schema
+	if len(schema) != len(allColumns)+1 || schema[0].GetNumChildren() != int32(len(allColumns)) {
+		t.Fatalf("schema has %d elements, want the root and %d columns", len(schema), len(allColumns))
+	}
+
+	tests := []struct {
+		physical  parquet.Type
+		converted *parquet.ConvertedType
+	}{
+		{parquet.Type_BOOLEAN, nil},
+		{parquet.Type_INT32, nil},
+		{parquet.Type_INT64, nil},
+		{parquet.Type_DOUBLE, nil},
+		{parquet.Type_BYTE_ARRAY, parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8)},
+		{parquet.Type_BYTE_ARRAY, nil},
+		{parquet.Type_INT32, parquet.ConvertedTypePtr(parquet.ConvertedType_DATE)},
+		{parquet.Type_INT64, parquet.ConvertedTypePtr(parquet.ConvertedType_TIMESTAMP_MILLIS)},
+		{parquet.Type_BYTE_ARRAY, parquet.ConvertedTypePtr(parquet.ConvertedType_DECIMAL)},
+	}
+	for i, tt := range tests {
+		element := schema[i+1]
+		// The reader renames the schema elements, but keeps the names
+		// of the file
+		if name := pr.SchemaHandler.Infos[i+1].ExName; name != allColumns[i].Name || element.GetType() != tt.physical ||
+			element.GetRepetitionType() != parquet.FieldRepetitionType_OPTIONAL {
+			t.Errorf("column %d = %s %v %v, want optional %s %v", i, name, element.GetRepetitionType(), element.GetType(), allColumns[i].Name, tt.physical)
+		}
+		if !reflect.DeepEqual(element.ConvertedType, tt.converted) {
+			t.Errorf("column %s converted type = %v, want %v", element.GetName(), element.ConvertedType, tt.converted)
+		}
+	}
+	if amount := schema[len(schema)-1]; amount.GetPrecision() != 12 || amount.GetScale() != 2 {
+		t.Errorf("decimal precision/scale = %d/%d, want 12/2", amount.GetPrecision(), amount.GetScale())
+	}
+}
+
+func TestRoundTripSpansBitPackedBytes(t *testing.T) {
+	// Boolean values and definition levels are packed eight to a byte
+	columns := []Column{{Name: "flag", Kind: Boolean}, {Name: "n", Kind: Int64}}
+	var rows [][]interface{}
+	var flags, numbers []interface{}
+	for i := 0; i < 21; i++ {
+		var flag, n interface{}
+		if i%3 != 0 {
+			flag = i%2 == 0
+		}
+		if i%5 != 0 {
+			n = int64(i)
+		}
+		rows = append(rows, []interface{}{flag, n})
+		flags = append(flags, flag)
+		numbers = append(numbers, n)
+	}
+	pr := openFile(t, writeFile(t, columns, rows))
+
+	if got := readColumn(t, pr, 0); !reflect.DeepEqual(got, flags) {
+		t.Errorf("flags = %v, want %v", got, flags)
+	}
+	if got := readColumn(t, pr, 1); !reflect.DeepEqual(got, numbers) {
+		t.Errorf("numbers = %v, want %v", got, numbers)
+	}
+}
+
+func TestDecimalBytes(t *testing.T) {
+	tests := []struct {
+		text  string
+		scale int
+		want  []byte
+	}{
+		{"0", 2, []byte{0}},
+		{"1.5", 2, []byte{0, 150}},
+		{"-1", 0, []byte{0xff}},
+		{"-128", 0, []byte{0x80}},
+		{"-129", 0, []byte{0xff, 0x7f}},
+		{"-0.01", 2, []byte{0xff}},
+		{"+12.3", 1, []byte{123}},
+	}
+	for _, tt := range tests {
+		got, err := decimalBytes(tt.text, tt.scale)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("decimalBytes(%q, %d) = %x, %v; want %x", tt.text, tt.scale, got, err, tt.want)
+		}
+	}
+
+	for _, text := range []string{"1.234", "abc", "", "-", "1.2.3"} {
+		if _, err := decimalBytes(text, 2); err == nil {
+			t.Errorf("decimalBytes(%q) accepted", text)
+		}
+	}
+}
+
+func TestWriterRejectsInvalidInput(t *testing.T) {
+	for _, columns := range [][]Column{
+		{{Name: ""}},
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "d", Kind: Decimal, Precision: 2, Scale: 3}},
+	} {
+		if _, err := NewWriter(&bytes.Buffer{}, columns); err == nil {
+			t.Errorf("NewWriter(%v) accepted", columns)
+		}
+	}
+
+	w, err := NewWriter(&bytes.Buffer{}, []Column{{Name: "n", Kind: Int64}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]interface{}{int32(1)}); err == nil {
+		t.Error("an int32 value of an Int64 column was accepted")
+	}
+	if err := w.Write([]interface{}{int64(1), int64(2)}); err == nil {
+		t.Error("a row of two values was accepted")
+	}
+}
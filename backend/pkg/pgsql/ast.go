@@ -0,0 +1,225 @@
+package pgsql
+
+// Node is implemented by every element of the syntax tree
+type Node interface {
+	node()
+}
+
+// Statement is a single top-level SQL statement
+type Statement struct {
+	// Keyword is the lowercased leading keyword (select, with, delete, copy, ...)
+	Keyword string
+	// Query is the parsed query, nil when the statement is not a query
+	Query *Query
+	Text  string
+}
+
+// Query is a SELECT statement including CTEs, set operations and the
+// trailing ORDER BY / LIMIT / locking clauses
+type Query struct {
+	With      []*CTE
+	Recursive bool
+	// Selects holds one entry per UNION / INTERSECT / EXCEPT branch
+	Selects []*Select
+	OrderBy []*Expr
+	Limit   *Expr
+	Offset  *Expr
+	// Locking holds row locking clauses such as "for update"
+	Locking []string
+	// Into is set when a SELECT ... INTO clause appears after the query body
+	Into *RelationRef
+}
+
+// CTE is a single WITH entry
+type CTE struct {
+	Name    string
+	Columns []string
+	// Kind is the lowercased leading keyword of the body (select, insert, delete, ...)
+	Kind string
+	// Query is the parsed body, nil when the body is not a query
+	Query *Query
+}
+
+// Select is one branch of a query
+type Select struct {
+	Distinct   bool
+	DistinctOn []*Expr
+	Targets    []*Target
+	// Into is set for SELECT ... INTO new_table
+	Into    *RelationRef
+	From    []*FromItem
+	Where   *Expr
+	GroupBy []*Expr
+	Having  *Expr
+	Windows []*Expr
+	// Values holds the rows of a VALUES branch
+	Values [][]*Expr
+	// Table is set for the TABLE name shorthand
+	Table *RelationRef
+	// Nested is set when the branch is a parenthesized query
+	Nested *Query
+}
+
+// Target is an entry of the select list
+type Target struct {
+	Expr  *Expr
+	Alias string
+	// Star is set for "*" and "qualifier.*"
+	Star          bool
+	StarQualifier string
+}
+
+// FromItem is a relation, subquery or table function in a FROM clause.
+// Joined items are flattened into the FROM list with their join condition.
+type FromItem struct {
+	Relation *RelationRef
+	// Sample holds the TABLESAMPLE arguments and REPEATABLE seed of a relation
+	Sample   []*Expr
+	Subquery *Query
+	// Funcs holds the table function, or each function of ROWS FROM (...)
+	Funcs         []*FuncCall
+	Alias         string
+	ColumnAliases []string
+	Lateral       bool
+	JoinType      string
+	On            *Expr
+	Using         []string
+}
+
+// RelationRef is a (possibly schema-qualified) table or view name
+type RelationRef struct {
+	Schema string
+	Name   string
+	Pos    int
+}
+
+// FuncCall is a function invocation
+type FuncCall struct {
+	Schema string
+	Name   string
+	Args   []*Expr
+	Pos    int
+}
+
+// ColumnRef is a reference to a column, optionally qualified by a table
+// name or alias
+type ColumnRef struct {
+	Qualifier string
+	Name      string
+	Pos       int
+}
+
+// Expr is a value expression. Only the parts relevant for analysis are
+// retained: column references, function calls and subqueries appearing
+// directly in the expression (nested ones live inside their FuncCall/Query).
+type Expr struct {
+	Columns    []*ColumnRef
+	Funcs      []*FuncCall
+	Subqueries []*Query
+	Text       string
+}
+
+func (*Statement) node()   {}
+func (*Query) node()       {}
+func (*CTE) node()         {}
+func (*Select) node()      {}
+func (*Target) node()      {}
+func (*FromItem) node()    {}
+func (*RelationRef) node() {}
+func (*FuncCall) node()    {}
+func (*ColumnRef) node()   {}
+func (*Expr) node()        {}
+
+// Inspect traverses the tree rooted at node in depth-first order, calling fn
+// for every node. If fn returns false, the children of that node are skipped.
+func Inspect(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Statement:
+		if n.Query != nil {
+			Inspect(n.Query, fn)
+		}
+	case *Query:
+		for _, cte := range n.With {
+			Inspect(cte, fn)
+		}
+		for _, sel := range n.Selects {
+			Inspect(sel, fn)
+		}
+		inspectExprs(n.OrderBy, fn)
+		inspectExpr(n.Limit, fn)
+		inspectExpr(n.Offset, fn)
+		if n.Into != nil {
+			Inspect(n.Into, fn)
+		}
+	case *CTE:
+		if n.Query != nil {
+			Inspect(n.Query, fn)
+		}
+	case *Select:
+		inspectExprs(n.DistinctOn, fn)
+		for _, t := range n.Targets {
+			Inspect(t, fn)
+		}
+		if n.Into != nil {
+			Inspect(n.Into, fn)
+		}
+		for _, f := range n.From {
+			Inspect(f, fn)
+		}
+		inspectExpr(n.Where, fn)
+		inspectExprs(n.GroupBy, fn)
+		inspectExpr(n.Having, fn)
+		inspectExprs(n.Windows, fn)
+		for _, row := range n.Values {
+			inspectExprs(row, fn)
+		}
+		if n.Table != nil {
+			Inspect(n.Table, fn)
+		}
+		if n.Nested != nil {
+			Inspect(n.Nested, fn)
+		}
+	case *Target:
+		inspectExpr(n.Expr, fn)
+	case *FromItem:
+		if n.Relation != nil {
+			Inspect(n.Relation, fn)
+		}
+		inspectExprs(n.Sample, fn)
+		if n.Subquery != nil {
+			Inspect(n.Subquery, fn)
+		}
+		for _, f := range n.Funcs {
+			Inspect(f, fn)
+		}
+		inspectExpr(n.On, fn)
+	case *FuncCall:
+		inspectExprs(n.Args, fn)
+	case *Expr:
+		for _, c := range n.Columns {
+			Inspect(c, fn)
+		}
+		for _, f := range n.Funcs {
+			Inspect(f, fn)
+		}
+		for _, q := range n.Subqueries {
+			Inspect(q, fn)
+		}
+	}
+}
+
+func inspectExpr(e *Expr, fn func(Node) bool) {
+	if e != nil {
+		Inspect(e, fn)
+	}
+}
+
+func inspectExprs(exprs []*Expr, fn func(Node) bool) {
+	for _, e := range exprs {
+		inspectExpr(e, fn)
+	}
+}
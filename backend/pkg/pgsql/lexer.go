@@ -0,0 +1,304 @@
+package pgsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenKind identifies the lexical class of a token
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenIdent
+	TokenString
+	TokenNumber
+	TokenParam
+	TokenOperator
+	TokenPunct
+)
+
+// Token is a single lexical element of a SQL string
+type Token struct {
+	Kind TokenKind
+	// Value is the lowercased name for unquoted identifiers, the unescaped
+	// name for quoted identifiers and the raw text for everything else
+	Value  string
+	Quoted bool
+	Pos    int
+	End    int
+}
+
+// ParseError is returned when SQL cannot be tokenized or parsed
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+const operatorChars = "+-*/<>=~!@#%^&|`?"
+
+// Tokenize splits a SQL string into tokens, dropping whitespace and comments
+func Tokenize(sql string) ([]Token, error) {
+	var tokens []Token
+	i := 0
+	n := len(sql)
+
+	for i < n {
+		c := sql[i]
+
+		switch {
+		case isSpace(c):
+			i++
+
+		case c == '-' && i+1 < n && sql[i+1] == '-':
+			for i < n && sql[i] != '\n' {
+				i++
+			}
+
+		case c == '/' && i+1 < n && sql[i+1] == '*':
+			end, err := skipBlockComment(sql, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+
+		case c == '\'':
+			end, err := scanQuoted(sql, i, '\'', false)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, Token{Kind: TokenString, Value: sql[i:end], Pos: i, End: end})
+			i = end
+
+		case c == '"':
+			end, err := scanQuoted(sql, i, '"', false)
+			if err != nil {
+				return nil, err
+			}
+			name := strings.ReplaceAll(sql[i+1:end-1], `""`, `"`)
+			tokens = append(tokens, Token{Kind: TokenIdent, Value: name, Quoted: true, Pos: i, End: end})
+			i = end
+
+		case c == '$':
+			if i+1 < n && isDigit(sql[i+1]) {
+				end := i + 1
+				for end < n && isDigit(sql[end]) {
+					end++
+				}
+				tokens = append(tokens, Token{Kind: TokenParam, Value: sql[i:end], Pos: i, End: end})
+				i = end
+				continue
+			}
+			end, err := scanDollarQuoted(sql, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, Token{Kind: TokenString, Value: sql[i:end], Pos: i, End: end})
+			i = end
+
+		case isDigit(c) || (c == '.' && i+1 < n && isDigit(sql[i+1])):
+			end := scanNumber(sql, i)
+			tokens = append(tokens, Token{Kind: TokenNumber, Value: sql[i:end], Pos: i, End: end})
+			i = end
+
+		case isIdentStart(c):
+			end := i + 1
+			for end < n && isIdentChar(sql[end]) {
+				end++
+			}
+			word := sql[i:end]
+
+			// String constants with a prefix: E'..', B'..', X'..', N'..', U&'..'
+			if end < n && sql[end] == '\'' && isStringPrefix(word) {
+				strEnd, err := scanQuoted(sql, end, '\'', strings.EqualFold(word, "e"))
+				if err != nil {
+					return nil, err
+				}
+				tokens = append(tokens, Token{Kind: TokenString, Value: sql[i:strEnd], Pos: i, End: strEnd})
+				i = strEnd
+				continue
+			}
+			if strings.EqualFold(word, "u") && end+1 < n && sql[end] == '&' && (sql[end+1] == '\'' || sql[end+1] == '"') {
+				quote := sql[end+1]
+				strEnd, err := scanQuoted(sql, end+1, quote, false)
+				if err != nil {
+					return nil, err
+				}
+				if quote == '"' {
+					tokens = append(tokens, Token{Kind: TokenIdent, Value: sql[end+2 : strEnd-1], Quoted: true, Pos: i, End: strEnd})
+				} else {
+					tokens = append(tokens, Token{Kind: TokenString, Value: sql[i:strEnd], Pos: i, End: strEnd})
+				}
+				i = strEnd
+				continue
+			}
+
+			tokens = append(tokens, Token{Kind: TokenIdent, Value: strings.ToLower(word), Pos: i, End: end})
+			i = end
+
+		case c == '(' || c == ')' || c == ',' || c == ';' || c == '[' || c == ']' || c == '.':
+			tokens = append(tokens, Token{Kind: TokenPunct, Value: string(c), Pos: i, End: i + 1})
+			i++
+
+		case c == ':':
+			if i+1 < n && sql[i+1] == ':' {
+				tokens = append(tokens, Token{Kind: TokenOperator, Value: "::", Pos: i, End: i + 2})
+				i += 2
+			} else {
+				tokens = append(tokens, Token{Kind: TokenOperator, Value: ":", Pos: i, End: i + 1})
+				i++
+			}
+
+		case strings.IndexByte(operatorChars, c) >= 0:
+			end := i
+			for end < n && strings.IndexByte(operatorChars, sql[end]) >= 0 {
+				// A comment start terminates the operator
+				if end > i && end+1 < n && ((sql[end] == '-' && sql[end+1] == '-') || (sql[end] == '/' && sql[end+1] == '*')) {
+					break
+				}
+				end++
+			}
+			tokens = append(tokens, Token{Kind: TokenOperator, Value: sql[i:end], Pos: i, End: end})
+			i = end
+
+		default:
+			return nil, &ParseError{Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+
+	tokens = append(tokens, Token{Kind: TokenEOF, Pos: n, End: n})
+	return tokens, nil
+}
+
+// skipBlockComment skips a (possibly nested) /* */ comment starting at i
+func skipBlockComment(sql string, i int) (int, error) {
+	depth := 0
+	start := i
+	for i < len(sql) {
+		if i+1 < len(sql) && sql[i] == '/' && sql[i+1] == '*' {
+			depth++
+			i += 2
+			continue
+		}
+		if i+1 < len(sql) && sql[i] == '*' && sql[i+1] == '/' {
+			depth--
+			i += 2
+			if depth == 0 {
+				return i, nil
+			}
+			continue
+		}
+		i++
+	}
+	return 0, &ParseError{Pos: start, Msg: "unterminated comment"}
+}
+
+// scanQuoted scans a quoted string or identifier starting at i, where doubled
+// quotes are escapes and, for E-prefixed strings, backslash escapes are honoured
+func scanQuoted(sql string, i int, quote byte, backslash bool) (int, error) {
+	start := i
+	i++
+	for i < len(sql) {
+		switch {
+		case backslash && sql[i] == '\\':
+			i += 2
+		case sql[i] == quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1, nil
+		default:
+			i++
+		}
+	}
+	return 0, &ParseError{Pos: start, Msg: "unterminated quoted string"}
+}
+
+// scanDollarQuoted scans a $tag$...$tag$ string starting at i
+func scanDollarQuoted(sql string, i int) (int, error) {
+	start := i
+	end := i + 1
+	for end < len(sql) && sql[end] != '$' {
+		if !isIdentChar(sql[end]) {
+			return 0, &ParseError{Pos: start, Msg: "unexpected character '$'"}
+		}
+		end++
+	}
+	if end >= len(sql) {
+		return 0, &ParseError{Pos: start, Msg: "unterminated dollar-quoted string"}
+	}
+
+	tag := sql[i : end+1]
+	closing := strings.Index(sql[end+1:], tag)
+	if closing < 0 {
+		return 0, &ParseError{Pos: start, Msg: "unterminated dollar-quoted string"}
+	}
+	return end + 1 + closing + len(tag), nil
+}
+
+func scanNumber(sql string, i int) int {
+	n := len(sql)
+	// Hexadecimal, octal and binary integers: 0x1F, 0o17, 0b1010
+	if sql[i] == '0' && i+2 < n && strings.IndexByte("xXoObB", sql[i+1]) >= 0 && isHexDigit(sql[i+2]) {
+		i += 2
+		for i < n && (isHexDigit(sql[i]) || sql[i] == '_') {
+			i++
+		}
+		return i
+	}
+	for i < n && (isDigit(sql[i]) || sql[i] == '_') {
+		i++
+	}
+	if i < n && sql[i] == '.' && !(i+1 < n && sql[i+1] == '.') {
+		i++
+		for i < n && isDigit(sql[i]) {
+			i++
+		}
+	}
+	if i < n && (sql[i] == 'e' || sql[i] == 'E') {
+		j := i + 1
+		if j < n && (sql[j] == '+' || sql[j] == '-') {
+			j++
+		}
+		if j < n && isDigit(sql[j]) {
+			i = j
+			for i < n && isDigit(sql[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+func isStringPrefix(word string) bool {
+	switch strings.ToLower(word) {
+	case "e", "b", "x", "n":
+		return true
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
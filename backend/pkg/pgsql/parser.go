@@ -0,0 +1,1674 @@
+// Package pgsql parses the subset of PostgreSQL needed to vet generated
+// queries: statement boundaries, the full SELECT / VALUES / TABLE grammar
+// with CTEs, set operations, joins, ROWS FROM, TABLESAMPLE and XMLTABLE,
+// and the relations, functions, columns and subqueries of every expression.
+//
+// It is not the server's grammar. Syntax it does not know fails closed:
+// either as a *ParseError, e.g. FETCH FIRST (expr) ROWS or JSON_TABLE, or
+// by reading keyword arguments as column references, e.g. the NAME of
+// xmlelement(NAME x) or the VALUE of json_object('a' VALUE 1), which the
+// table and column allowlist then rejects.
+package pgsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDepth bounds recursion so adversarial input cannot exhaust the stack
+const maxDepth = 128
+
+type exprMode int
+
+const (
+	// modeClause parses an expression inside a clause: it stops at clause
+	// keywords and at an implicit alias following a complete operand
+	modeClause exprMode = iota
+	// modeNested parses an expression inside parentheses or brackets: it
+	// only stops at ',' and the closing bracket
+	modeNested
+)
+
+// keywords are words that never denote a column or an implicit alias
+var keywords = toSet(
+	"all", "analyse", "analyze", "and", "any", "array", "as", "asc", "asymmetric", "at",
+	"between", "both", "by", "case", "check", "collate", "cross", "current",
+	"current_catalog", "current_date", "current_role", "current_schema", "current_time",
+	"current_timestamp", "current_user", "default", "desc", "distinct", "do", "else", "end",
+	"escape", "except", "exclude", "exists", "false", "fetch", "filter", "first", "following",
+	"for", "from", "full", "group", "groups", "having", "ilike", "in", "inner", "intersect",
+	"interval", "into", "is", "isnull", "join", "last", "lateral", "leading", "left", "like",
+	"limit", "localtime", "localtimestamp", "materialized", "natural", "next", "no", "not",
+	"notnull", "null", "nulls", "of", "offset", "on", "only", "or", "order", "ordinality",
+	"others", "outer", "over", "overlaps", "partition", "placing", "preceding", "range",
+	"recursive", "returning", "right", "row", "rows", "select", "session_user", "sets",
+	"similar", "some", "symmetric", "table", "tablesample", "then", "ties", "time", "to",
+	"trailing", "true", "unbounded", "union", "unknown", "user", "using", "values", "variadic",
+	"when", "where", "window", "with", "within", "without", "zone",
+)
+
+// valueKeywords are keywords that form a complete operand on their own
+var valueKeywords = toSet(
+	"null", "true", "false", "unknown", "default", "current_date", "current_time",
+	"current_timestamp", "localtime", "localtimestamp", "current_user", "session_user",
+	"user", "current_role", "current_catalog", "current_schema", "end",
+)
+
+// callableKeywords are keywords that are also function names
+var callableKeywords = toSet("left", "right", "current_schema", "current_catalog")
+
+// clauseKeywords terminate an expression parsed in modeClause
+var clauseKeywords = toSet(
+	"from", "where", "group", "having", "window", "order", "limit", "offset", "fetch", "for",
+	"union", "intersect", "except", "into", "on", "using", "join", "inner", "left", "right",
+	"full", "cross", "natural", "as", "returning", "row", "rows",
+)
+
+// selectEndKeywords terminate a select list
+var selectEndKeywords = toSet(
+	"from", "into", "where", "group", "having", "window", "order", "limit", "offset", "fetch",
+	"for", "union", "intersect", "except",
+)
+
+// typeContinuations may follow the first word of a multi-word type name
+var typeContinuations = toSet("precision", "varying", "with", "without", "time", "zone")
+
+// xmltableWords separate the parts of XMLTABLE(...)
+var xmltableWords = toSet("passing", "by", "columns", "path", "default", "not")
+
+// intervalFields may follow an interval literal
+var intervalFields = toSet("year", "month", "day", "hour", "minute", "second", "to")
+
+type parser struct {
+	sql    string
+	tokens []Token
+	pos    int
+	depth  int
+	// stops are words that end an expression at the current nesting level
+	stops map[string]bool
+}
+
+// Parse parses a SQL string into statements. Query statements (SELECT,
+// WITH ... SELECT, VALUES, TABLE) are fully parsed; any other statement is
+// returned with its leading keyword and a nil Query.
+func Parse(sql string) ([]*Statement, error) {
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{sql: sql, tokens: tokens}
+	var statements []*Statement
+
+	for {
+		for p.isPunct(";") {
+			p.next()
+		}
+		if p.peek().Kind == TokenEOF {
+			break
+		}
+
+		start := p.peek().Pos
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Text = strings.TrimSpace(sql[start:p.prevEnd()])
+		statements = append(statements, stmt)
+
+		if !p.isPunct(";") && p.peek().Kind != TokenEOF {
+			return nil, p.unexpected(p.peek())
+		}
+	}
+
+	return statements, nil
+}
+
+func (p *parser) parseStatement() (*Statement, error) {
+	keyword := p.leadingKeyword(p.pos)
+
+	if keyword == "with" {
+		// Look past the CTEs to find out whether the main statement is a query
+		saved := p.pos
+		if _, _, err := p.parseWith(); err != nil {
+			return nil, err
+		}
+		if !p.isQueryStartAt(p.pos) {
+			main := p.leadingKeyword(p.pos)
+			p.skipStatement()
+			return &Statement{Keyword: main}, nil
+		}
+		p.pos = saved
+	}
+
+	if !p.isQueryStartAt(p.pos) {
+		p.skipStatement()
+		return &Statement{Keyword: keyword}, nil
+	}
+
+	query, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	return &Statement{Keyword: keyword, Query: query}, nil
+}
+
+func (p *parser) parseQuery() (*Query, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	saved := p.stops
+	p.stops = nil
+	defer func() { p.stops = saved }()
+
+	q := &Query{}
+	if p.isKeyword("with") {
+		ctes, recursive, err := p.parseWith()
+		if err != nil {
+			return nil, err
+		}
+		q.With = ctes
+		q.Recursive = recursive
+	}
+
+	sel, err := p.parseSelectBranch()
+	if err != nil {
+		return nil, err
+	}
+	q.Selects = append(q.Selects, sel)
+
+	for p.isKeyword("union", "intersect", "except") {
+		p.next()
+		if p.isKeyword("all", "distinct") {
+			p.next()
+		}
+		sel, err := p.parseSelectBranch()
+		if err != nil {
+			return nil, err
+		}
+		q.Selects = append(q.Selects, sel)
+	}
+
+	for {
+		switch {
+		case p.isKeyword("order"):
+			exprs, err := p.parseOrderBy()
+			if err != nil {
+				return nil, err
+			}
+			q.OrderBy = exprs
+
+		case p.isKeyword("limit"):
+			p.next()
+			if p.isKeyword("all") {
+				p.next()
+				continue
+			}
+			e, err := p.parseExpr(modeClause)
+			if err != nil {
+				return nil, err
+			}
+			q.Limit = e
+
+		case p.isKeyword("offset"):
+			p.next()
+			e, err := p.parseExpr(modeClause)
+			if err != nil {
+				return nil, err
+			}
+			q.Offset = e
+			if p.isKeyword("row", "rows") {
+				p.next()
+			}
+
+		case p.isKeyword("fetch"):
+			e, err := p.parseFetch()
+			if err != nil {
+				return nil, err
+			}
+			q.Limit = e
+
+		case p.isKeyword("for"):
+			clause, err := p.parseLocking()
+			if err != nil {
+				return nil, err
+			}
+			q.Locking = append(q.Locking, clause)
+
+		case p.isKeyword("into"):
+			rel, err := p.parseInto()
+			if err != nil {
+				return nil, err
+			}
+			q.Into = rel
+
+		default:
+			return q, nil
+		}
+	}
+}
+
+// parseOrderBy parses ORDER BY items, which may name a sort operator with
+// USING in place of ASC/DESC
+func (p *parser) parseOrderBy() ([]*Expr, error) {
+	p.next() // ORDER
+	if err := p.expectKeyword("by"); err != nil {
+		return nil, err
+	}
+
+	var exprs []*Expr
+	for {
+		e, err := p.parseExpr(modeClause)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+
+		if p.isKeyword("using") {
+			p.next()
+			if err := p.parseOperator(); err != nil {
+				return nil, err
+			}
+			if p.isKeyword("nulls") {
+				p.next()
+				if err := p.expectKeyword("first", "last"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if !p.isPunct(",") {
+			return exprs, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseWith() ([]*CTE, bool, error) {
+	p.next() // WITH
+	recursive := false
+	if p.isKeyword("recursive") {
+		p.next()
+		recursive = true
+	}
+
+	var ctes []*CTE
+	for {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, false, err
+		}
+		cte := &CTE{Name: name}
+
+		if p.isPunct("(") {
+			cols, err := p.parseNameList()
+			if err != nil {
+				return nil, false, err
+			}
+			cte.Columns = cols
+		}
+
+		if err := p.expectKeyword("as"); err != nil {
+			return nil, false, err
+		}
+		if p.isKeyword("not") {
+			p.next()
+		}
+		if p.isKeyword("materialized") {
+			p.next()
+		}
+		if err := p.expectPunct("("); err != nil {
+			return nil, false, err
+		}
+
+		cte.Kind = p.leadingKeyword(p.pos)
+		if p.isQueryStartAt(p.pos) {
+			q, err := p.parseQuery()
+			if err != nil {
+				return nil, false, err
+			}
+			cte.Query = q
+		} else {
+			p.skipToClose()
+		}
+
+		if err := p.expectPunct(")"); err != nil {
+			return nil, false, err
+		}
+		ctes = append(ctes, cte)
+
+		if !p.isPunct(",") {
+			return ctes, recursive, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseSelectBranch() (*Select, error) {
+	switch {
+	case p.isPunct("("):
+		p.next()
+		nested, err := p.parseQuery()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &Select{Nested: nested}, nil
+
+	case p.isKeyword("select"):
+		return p.parseSelect()
+
+	case p.isKeyword("values"):
+		return p.parseValues()
+
+	case p.isKeyword("table"):
+		p.next()
+		rel, err := p.parseRelationName()
+		if err != nil {
+			return nil, err
+		}
+		return &Select{Table: rel}, nil
+	}
+
+	return nil, p.errorf(p.peek(), "expected SELECT")
+}
+
+func (p *parser) parseSelect() (*Select, error) {
+	p.next() // SELECT
+	sel := &Select{}
+
+	if p.isKeyword("all") {
+		p.next()
+	} else if p.isKeyword("distinct") {
+		p.next()
+		sel.Distinct = true
+		if p.isKeyword("on") {
+			p.next()
+			if err := p.expectPunct("("); err != nil {
+				return nil, err
+			}
+			exprs, err := p.parseNestedList(")")
+			if err != nil {
+				return nil, err
+			}
+			sel.DistinctOn = exprs
+		}
+	}
+
+	if !p.atSelectListEnd() {
+		for {
+			target, err := p.parseTarget()
+			if err != nil {
+				return nil, err
+			}
+			sel.Targets = append(sel.Targets, target)
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.isKeyword("into") {
+		rel, err := p.parseInto()
+		if err != nil {
+			return nil, err
+		}
+		sel.Into = rel
+	}
+
+	if p.isKeyword("from") {
+		p.next()
+		items, err := p.parseFromList()
+		if err != nil {
+			return nil, err
+		}
+		sel.From = items
+	}
+
+	if p.isKeyword("where") {
+		p.next()
+		e, err := p.parseExpr(modeClause)
+		if err != nil {
+			return nil, err
+		}
+		sel.Where = e
+	}
+
+	if p.isKeyword("group") {
+		p.next()
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		if p.isKeyword("all", "distinct") {
+			p.next()
+		}
+		exprs, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		sel.GroupBy = exprs
+	}
+
+	if p.isKeyword("having") {
+		p.next()
+		e, err := p.parseExpr(modeClause)
+		if err != nil {
+			return nil, err
+		}
+		sel.Having = e
+	}
+
+	if p.isKeyword("window") {
+		p.next()
+		for {
+			if _, err := p.expectName(); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("as"); err != nil {
+				return nil, err
+			}
+			e := &Expr{}
+			if err := p.parseParenGroup(e); err != nil {
+				return nil, err
+			}
+			sel.Windows = append(sel.Windows, e)
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	return sel, nil
+}
+
+func (p *parser) parseValues() (*Select, error) {
+	p.next() // VALUES
+	sel := &Select{}
+	for {
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		row, err := p.parseNestedList(")")
+		if err != nil {
+			return nil, err
+		}
+		sel.Values = append(sel.Values, row)
+		if !p.isPunct(",") {
+			return sel, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseTarget() (*Target, error) {
+	if p.isOperator("*") {
+		p.next()
+		return &Target{Star: true}, nil
+	}
+
+	// qualifier.* (possibly schema.table.*)
+	if p.peek().Kind == TokenIdent {
+		i := p.pos
+		var parts []string
+		for p.tokens[i].Kind == TokenIdent && p.tokens[i+1].Kind == TokenPunct && p.tokens[i+1].Value == "." {
+			parts = append(parts, p.tokens[i].Value)
+			i += 2
+			if p.tokens[i].Kind == TokenOperator && p.tokens[i].Value == "*" {
+				p.pos = i + 1
+				return &Target{Star: true, StarQualifier: strings.Join(parts, ".")}, nil
+			}
+		}
+	}
+
+	e, err := p.parseExpr(modeClause)
+	if err != nil {
+		return nil, err
+	}
+	target := &Target{Expr: e}
+
+	if p.isKeyword("as") {
+		p.next()
+		alias, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		target.Alias = alias
+	} else if p.isAliasAt(p.pos) {
+		target.Alias = p.next().Value
+	}
+
+	return target, nil
+}
+
+func (p *parser) parseInto() (*RelationRef, error) {
+	p.next() // INTO
+	if p.isKeyword("temporary", "temp", "unlogged") {
+		p.next()
+	}
+	if p.isKeyword("table") {
+		p.next()
+	}
+	return p.parseRelationName()
+}
+
+func (p *parser) parseFetch() (*Expr, error) {
+	p.next() // FETCH
+	if !p.isKeyword("first", "next") {
+		return nil, p.errorf(p.peek(), "expected FIRST or NEXT")
+	}
+	p.next()
+
+	var count *Expr
+	if !p.isKeyword("row", "rows") {
+		tok := p.peek()
+		if tok.Kind != TokenNumber && tok.Kind != TokenParam {
+			return nil, p.errorf(tok, "expected row count")
+		}
+		p.next()
+		count = &Expr{Text: tok.Value}
+	}
+
+	if err := p.expectKeyword("row", "rows"); err != nil {
+		return nil, err
+	}
+	if p.isKeyword("with") {
+		p.next()
+		if err := p.expectKeyword("ties"); err != nil {
+			return nil, err
+		}
+	} else if err := p.expectKeyword("only"); err != nil {
+		return nil, err
+	}
+
+	if count == nil {
+		count = &Expr{Text: "1"}
+	}
+	return count, nil
+}
+
+func (p *parser) parseLocking() (string, error) {
+	start := p.peek().Pos
+	p.next() // FOR
+
+	switch {
+	case p.isKeyword("update"), p.isKeyword("share"):
+		p.next()
+	case p.isKeyword("no"):
+		p.next()
+		if err := p.expectKeyword("key"); err != nil {
+			return "", err
+		}
+		if err := p.expectKeyword("update"); err != nil {
+			return "", err
+		}
+	case p.isKeyword("key"):
+		p.next()
+		if err := p.expectKeyword("share"); err != nil {
+			return "", err
+		}
+	default:
+		return "", p.errorf(p.peek(), "expected UPDATE or SHARE")
+	}
+
+	if p.isKeyword("of") {
+		p.next()
+		for {
+			if _, err := p.parseRelationName(); err != nil {
+				return "", err
+			}
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.isKeyword("nowait") {
+		p.next()
+	} else if p.isKeyword("skip") {
+		p.next()
+		if err := p.expectKeyword("locked"); err != nil {
+			return "", err
+		}
+	}
+
+	return strings.ToLower(p.sql[start:p.prevEnd()]), nil
+}
+
+func (p *parser) parseFromList() ([]*FromItem, error) {
+	var items []*FromItem
+	for {
+		joined, err := p.parseJoinedItems()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, joined...)
+		if !p.isPunct(",") {
+			return items, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseJoinedItems() ([]*FromItem, error) {
+	items, err := p.parseFromPrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		joinType, ok, err := p.parseJoinKeyword()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return items, nil
+		}
+
+		right, err := p.parseFromPrimary()
+		if err != nil {
+			return nil, err
+		}
+		right[0].JoinType = joinType
+
+		switch {
+		case p.isKeyword("on"):
+			p.next()
+			e, err := p.parseExpr(modeClause)
+			if err != nil {
+				return nil, err
+			}
+			right[0].On = e
+		case p.isKeyword("using"):
+			p.next()
+			cols, err := p.parseNameList()
+			if err != nil {
+				return nil, err
+			}
+			right[0].Using = cols
+			if p.isKeyword("as") {
+				p.next()
+				if _, err := p.expectName(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		items = append(items, right...)
+	}
+}
+
+func (p *parser) parseJoinKeyword() (string, bool, error) {
+	switch {
+	case p.isKeyword("join"):
+		p.next()
+		return "inner", true, nil
+
+	case p.isKeyword("cross"):
+		p.next()
+		return "cross", true, p.expectKeyword("join")
+
+	case p.isKeyword("inner"):
+		p.next()
+		return "inner", true, p.expectKeyword("join")
+
+	case p.isKeyword("left", "right", "full"):
+		joinType := p.next().Value
+		if p.isKeyword("outer") {
+			p.next()
+		}
+		return joinType, true, p.expectKeyword("join")
+
+	case p.isKeyword("natural"):
+		p.next()
+		joinType := "natural"
+		if p.isKeyword("inner", "left", "right", "full") {
+			joinType += " " + p.next().Value
+		}
+		if p.isKeyword("outer") {
+			p.next()
+		}
+		return joinType, true, p.expectKeyword("join")
+	}
+
+	return "", false, nil
+}
+
+func (p *parser) parseFromPrimary() ([]*FromItem, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	item := &FromItem{}
+	if p.isKeyword("lateral") {
+		p.next()
+		item.Lateral = true
+	}
+	if p.isKeyword("only") {
+		p.next()
+	}
+
+	tok := p.peek()
+	switch {
+	case p.isKeyword("rows") && p.isKeywordAt(p.pos+1, "from"):
+		fns, err := p.parseRowsFrom()
+		if err != nil {
+			return nil, err
+		}
+		item.Funcs = fns
+		if p.isKeyword("with") && p.isKeywordAt(p.pos+1, "ordinality") {
+			p.pos += 2
+		}
+
+	case p.isPunct("("):
+		if p.isQueryStartAt(p.pos + 1) {
+			p.next()
+			q, err := p.parseQuery()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			item.Subquery = q
+			break
+		}
+
+		// Parenthesized join
+		p.next()
+		inner, err := p.parseJoinedItems()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		if err := p.parseAlias(&FromItem{}); err != nil {
+			return nil, err
+		}
+		return inner, nil
+
+	case tok.Kind == TokenIdent && (tok.Quoted || !keywords[tok.Value] || callableKeywords[tok.Value]):
+		schema, name, pos := p.parseQualifiedName()
+		if p.isPunct("(") {
+			fn, err := p.parseFuncCall(schema, name, pos)
+			if err != nil {
+				return nil, err
+			}
+			item.Funcs = []*FuncCall{fn}
+			if p.isKeyword("with") && p.isKeywordAt(p.pos+1, "ordinality") {
+				p.pos += 2
+			}
+			break
+		}
+
+		item.Relation = &RelationRef{Schema: schema, Name: name, Pos: pos}
+
+	default:
+		return nil, p.errorf(tok, "expected table name or subquery")
+	}
+
+	if err := p.parseAlias(item); err != nil {
+		return nil, err
+	}
+	if item.Relation != nil && p.isKeyword("tablesample") {
+		sample, err := p.parseTableSample()
+		if err != nil {
+			return nil, err
+		}
+		item.Sample = sample
+	}
+	return []*FromItem{item}, nil
+}
+
+// parseRowsFrom parses ROWS FROM (f(...) [AS (column definitions)], ...)
+func (p *parser) parseRowsFrom() ([]*FuncCall, error) {
+	p.pos += 2 // ROWS FROM
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	var fns []*FuncCall
+	for {
+		if p.peek().Kind != TokenIdent {
+			return nil, p.errorf(p.peek(), "expected function call")
+		}
+		schema, name, pos := p.parseQualifiedName()
+		fn, err := p.parseFuncCall(schema, name, pos)
+		if err != nil {
+			return nil, err
+		}
+		fns = append(fns, fn)
+
+		if p.isKeyword("as") && p.isPunctAt(p.pos+1, "(") {
+			p.next()
+			if _, err := p.parseNameList(); err != nil {
+				return nil, err
+			}
+		}
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return fns, nil
+}
+
+// parseTableSample parses TABLESAMPLE method (args) [REPEATABLE (seed)] and
+// returns the argument and seed expressions
+func (p *parser) parseTableSample() ([]*Expr, error) {
+	p.next() // TABLESAMPLE
+	if p.peek().Kind != TokenIdent {
+		return nil, p.errorf(p.peek(), "expected sampling method")
+	}
+	p.parseQualifiedName()
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	args, err := p.parseNestedList(")")
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword("repeatable") {
+		p.next()
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		seed, err := p.parseNestedList(")")
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, seed...)
+	}
+	return args, nil
+}
+
+func (p *parser) parseAlias(item *FromItem) error {
+	if p.isKeyword("as") {
+		p.next()
+		name, err := p.expectName()
+		if err != nil {
+			return err
+		}
+		item.Alias = name
+	} else if p.isAliasAt(p.pos) {
+		item.Alias = p.next().Value
+	} else {
+		return nil
+	}
+
+	if p.isPunct("(") {
+		cols, err := p.parseNameList()
+		if err != nil {
+			return err
+		}
+		item.ColumnAliases = cols
+	}
+	return nil
+}
+
+func (p *parser) parseRelationName() (*RelationRef, error) {
+	tok := p.peek()
+	if tok.Kind != TokenIdent {
+		return nil, p.errorf(tok, "expected table name")
+	}
+	schema, name, pos := p.parseQualifiedName()
+	return &RelationRef{Schema: schema, Name: name, Pos: pos}, nil
+}
+
+// parseQualifiedName parses name ('.' name)* and splits off the last part
+func (p *parser) parseQualifiedName() (string, string, int) {
+	pos := p.peek().Pos
+	parts := []string{p.next().Value}
+	for p.isPunct(".") && p.peekAt(1).Kind == TokenIdent {
+		p.next()
+		parts = append(parts, p.next().Value)
+	}
+
+	name := parts[len(parts)-1]
+	schema := ""
+	if len(parts) > 1 {
+		schema = parts[len(parts)-2]
+	}
+	return schema, name, pos
+}
+
+// parseNameList parses a parenthesized list of names, ignoring anything
+// after each name (such as column types in a column definition list)
+func (p *parser) parseNameList() ([]string, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+
+		depth := 0
+		for {
+			tok := p.peek()
+			if tok.Kind == TokenEOF {
+				return nil, p.errorf(tok, "expected ')'")
+			}
+			if tok.Kind == TokenPunct {
+				if tok.Value == "(" {
+					depth++
+				} else if tok.Value == ")" {
+					if depth == 0 {
+						break
+					}
+					depth--
+				} else if tok.Value == "," && depth == 0 {
+					break
+				}
+			}
+			p.next()
+		}
+
+		if p.isPunct(")") {
+			p.next()
+			return names, nil
+		}
+		p.next() // ','
+	}
+}
+
+func (p *parser) parseExprList() ([]*Expr, error) {
+	var exprs []*Expr
+	for {
+		e, err := p.parseExpr(modeClause)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !p.isPunct(",") {
+			return exprs, nil
+		}
+		p.next()
+	}
+}
+
+// parseNestedList parses a comma separated expression list up to and
+// including the closing bracket
+func (p *parser) parseNestedList(closing string) ([]*Expr, error) {
+	saved := p.stops
+	p.stops = nil
+	defer func() { p.stops = saved }()
+
+	var exprs []*Expr
+	if p.isPunct(closing) {
+		p.next()
+		return exprs, nil
+	}
+
+	for {
+		e, err := p.parseExpr(modeNested)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+
+	if err := p.expectPunct(closing); err != nil {
+		return nil, err
+	}
+	return exprs, nil
+}
+
+func (p *parser) parseExpr(mode exprMode) (*Expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	e := &Expr{}
+	startPos := p.pos
+	operand := false
+	prevKeyword := ""
+
+loop:
+	for {
+		tok := p.peek()
+
+		switch tok.Kind {
+		case TokenEOF:
+			break loop
+
+		case TokenPunct:
+			switch tok.Value {
+			case ",", ")", "]", ";":
+				break loop
+			case "(":
+				if err := p.parseParenGroup(e); err != nil {
+					return nil, err
+				}
+			case "[":
+				p.next()
+				inner, err := p.parseNestedList("]")
+				if err != nil {
+					return nil, err
+				}
+				mergeExprs(e, inner)
+			case ".":
+				// Field selection such as (composite).field
+				p.next()
+				if p.peek().Kind == TokenIdent || p.isOperator("*") {
+					p.next()
+				}
+			}
+			operand = true
+
+		case TokenString, TokenNumber, TokenParam:
+			p.next()
+			operand = true
+
+		case TokenOperator:
+			p.next()
+			if tok.Value == "::" {
+				p.skipTypeName()
+				operand = true
+			} else {
+				operand = false
+			}
+
+		case TokenIdent:
+			if !tok.Quoted && p.stops[tok.Value] {
+				break loop
+			}
+			// OPERATOR(schema.op) is a binary or prefix operator
+			if !tok.Quoted && tok.Value == "operator" && p.isPunctAt(p.pos+1, "(") {
+				if err := p.parseOperator(); err != nil {
+					return nil, err
+				}
+				operand = false
+				prevKeyword = ""
+				continue
+			}
+			if mode == modeClause {
+				if p.isClauseStopAt(p.pos) {
+					break loop
+				}
+				if operand && p.isAliasAt(p.pos) {
+					break loop
+				}
+			}
+
+			if !tok.Quoted && keywords[tok.Value] && !(callableKeywords[tok.Value] && p.isPunctAt(p.pos+1, "(")) {
+				if err := p.parseKeyword(e, mode, prevKeyword); err != nil {
+					return nil, err
+				}
+				operand = valueKeywords[tok.Value] || tok.Value == "over" || tok.Value == "filter" ||
+					tok.Value == "collate" || tok.Value == "interval" || (mode == modeNested && tok.Value == "as")
+				prevKeyword = tok.Value
+				continue
+			}
+
+			if err := p.parseIdentOperand(e); err != nil {
+				return nil, err
+			}
+			operand = true
+		}
+		prevKeyword = ""
+	}
+
+	if p.pos == startPos {
+		return nil, p.unexpected(p.peek())
+	}
+	e.Text = strings.TrimSpace(p.sql[p.tokens[startPos].Pos:p.prevEnd()])
+	return e, nil
+}
+
+// parseKeyword consumes a keyword inside an expression together with any
+// syntax that belongs to it
+func (p *parser) parseKeyword(e *Expr, mode exprMode, prevKeyword string) error {
+	kw := p.next().Value
+
+	switch kw {
+	case "over":
+		if p.isPunct("(") {
+			return p.parseParenGroup(e)
+		}
+		if p.peek().Kind == TokenIdent {
+			p.next()
+		}
+
+	case "filter":
+		if p.isPunct("(") {
+			return p.parseParenGroup(e)
+		}
+
+	case "within":
+		if p.isKeyword("group") {
+			p.next()
+		}
+
+	case "collate":
+		if p.peek().Kind == TokenIdent {
+			p.parseQualifiedName()
+		}
+
+	case "distinct":
+		// IS [NOT] DISTINCT FROM
+		if (prevKeyword == "is" || prevKeyword == "not") && p.isKeyword("from") {
+			p.next()
+		}
+
+	case "as":
+		if mode == modeNested {
+			p.skipTypeName()
+		}
+
+	case "interval":
+		if p.peek().Kind == TokenString {
+			p.next()
+			for p.peek().Kind == TokenIdent && !p.peek().Quoted && intervalFields[p.peek().Value] {
+				p.next()
+			}
+		}
+
+	default:
+		if p.isPunct("(") {
+			return p.parseParenGroup(e)
+		}
+	}
+
+	return nil
+}
+
+// parseIdentOperand parses a column reference, function call or typed literal
+func (p *parser) parseIdentOperand(e *Expr) error {
+	tok := p.peek()
+
+	// Typed literal such as DATE '2024-01-01'
+	if !tok.Quoted && p.peekAt(1).Kind == TokenString {
+		p.next()
+		p.next()
+		return nil
+	}
+
+	// GROUPING SETS (...)
+	if !tok.Quoted && tok.Value == "grouping" && p.isKeywordAt(p.pos+1, "sets") {
+		p.pos += 2
+		return p.parseParenGroup(e)
+	}
+
+	pos := tok.Pos
+	parts := []string{p.next().Value}
+	for p.isPunct(".") {
+		next := p.peekAt(1)
+		if next.Kind == TokenIdent {
+			p.next()
+			parts = append(parts, p.next().Value)
+		} else if next.Kind == TokenOperator && next.Value == "*" {
+			p.next()
+			p.next()
+			parts = append(parts, "*")
+			break
+		} else {
+			break
+		}
+	}
+
+	name := parts[len(parts)-1]
+	qualifier := strings.Join(parts[:len(parts)-1], ".")
+
+	if name != "*" && p.isPunct("(") {
+		schema := ""
+		if len(parts) > 1 {
+			schema = parts[len(parts)-2]
+		}
+		fn, err := p.parseFuncCall(schema, name, pos)
+		if err != nil {
+			return err
+		}
+		e.Funcs = append(e.Funcs, fn)
+		return nil
+	}
+
+	e.Columns = append(e.Columns, &ColumnRef{Qualifier: qualifier, Name: name, Pos: pos})
+	return nil
+}
+
+func (p *parser) parseFuncCall(schema, name string, pos int) (*FuncCall, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	fn := &FuncCall{Schema: schema, Name: name, Pos: pos}
+
+	if schema == "" && name == "xmltable" {
+		return fn, p.parseXMLTable(fn)
+	}
+
+	// EXTRACT(field FROM source): the field is not a column
+	if name == "extract" && p.peek().Kind == TokenIdent && p.isKeywordAt(p.pos+1, "from") {
+		p.next()
+	}
+
+	args, err := p.parseNestedList(")")
+	if err != nil {
+		return nil, err
+	}
+	fn.Args = args
+	return fn, nil
+}
+
+// parseXMLTable parses the arguments of XMLTABLE up to the closing ')'.
+// The row and document expressions and the PATH and DEFAULT expressions of
+// the column definitions are kept as arguments.
+func (p *parser) parseXMLTable(fn *FuncCall) error {
+	saved := p.stops
+	p.stops = xmltableWords
+	defer func() { p.stops = saved }()
+
+	if p.isKeyword("xmlnamespaces") {
+		e, err := p.parseExpr(modeNested)
+		if err != nil {
+			return err
+		}
+		fn.Args = append(fn.Args, e)
+		if err := p.expectPunct(","); err != nil {
+			return err
+		}
+	}
+
+	row, err := p.parseExpr(modeNested)
+	if err != nil {
+		return err
+	}
+	fn.Args = append(fn.Args, row)
+
+	if err := p.expectKeyword("passing"); err != nil {
+		return err
+	}
+	p.skipPassingMechanism()
+	doc, err := p.parseExpr(modeNested)
+	if err != nil {
+		return err
+	}
+	fn.Args = append(fn.Args, doc)
+	p.skipPassingMechanism()
+
+	if err := p.expectKeyword("columns"); err != nil {
+		return err
+	}
+	for {
+		if _, err := p.expectName(); err != nil {
+			return err
+		}
+		if p.isKeyword("for") {
+			p.next()
+			if err := p.expectKeyword("ordinality"); err != nil {
+				return err
+			}
+		} else {
+			p.skipTypeName()
+		options:
+			for {
+				switch {
+				case p.isKeyword("path", "default"):
+					p.next()
+					e, err := p.parseExpr(modeNested)
+					if err != nil {
+						return err
+					}
+					fn.Args = append(fn.Args, e)
+				case p.isKeyword("not"):
+					p.next()
+					if err := p.expectKeyword("null"); err != nil {
+						return err
+					}
+				case p.isKeyword("null"):
+					p.next()
+				default:
+					break options
+				}
+			}
+		}
+
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+
+	return p.expectPunct(")")
+}
+
+// skipPassingMechanism consumes an optional BY REF or BY VALUE
+func (p *parser) skipPassingMechanism() {
+	if p.isKeyword("by") && p.isKeywordAt(p.pos+1, "ref", "value") {
+		p.pos += 2
+	}
+}
+
+// parseOperator parses an operator token or OPERATOR(schema.op)
+func (p *parser) parseOperator() error {
+	tok := p.peek()
+	if tok.Kind == TokenOperator {
+		p.next()
+		return nil
+	}
+	if !p.isKeyword("operator") || !p.isPunctAt(p.pos+1, "(") {
+		return p.errorf(tok, "expected operator")
+	}
+	p.pos += 2
+
+	for p.peek().Kind == TokenIdent && p.isPunctAt(p.pos+1, ".") {
+		p.pos += 2
+	}
+	if p.peek().Kind != TokenOperator {
+		return p.errorf(p.peek(), "expected operator")
+	}
+	p.next()
+	return p.expectPunct(")")
+}
+
+// parseParenGroup parses a parenthesized subquery or expression list and
+// merges what it finds into e
+func (p *parser) parseParenGroup(e *Expr) error {
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer p.leave()
+
+	if !p.isPunct("(") {
+		return p.errorf(p.peek(), "expected '('")
+	}
+
+	if p.isQueryStartAt(p.pos + 1) {
+		p.next()
+		q, err := p.parseQuery()
+		if err != nil {
+			return err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return err
+		}
+		e.Subqueries = append(e.Subqueries, q)
+		return nil
+	}
+
+	p.next()
+	inner, err := p.parseNestedList(")")
+	if err != nil {
+		return err
+	}
+	mergeExprs(e, inner)
+	return nil
+}
+
+// skipTypeName consumes a type name such as "numeric(15, 2)",
+// "timestamp with time zone" or "text[]"
+func (p *parser) skipTypeName() {
+	if p.peek().Kind != TokenIdent {
+		return
+	}
+	p.parseQualifiedName()
+
+	for {
+		tok := p.peek()
+		if tok.Kind != TokenIdent || tok.Quoted || !typeContinuations[tok.Value] {
+			break
+		}
+		if (tok.Value == "with" || tok.Value == "without") && !p.isKeywordAt(p.pos+1, "time") {
+			break
+		}
+		p.next()
+	}
+
+	if p.isPunct("(") {
+		p.next()
+		p.skipToClose()
+		p.next()
+	}
+	for p.isPunct("[") {
+		for !p.isPunct("]") && p.peek().Kind != TokenEOF {
+			p.next()
+		}
+		p.next()
+	}
+}
+
+// skipToClose advances to the ')' closing the current parenthesis level
+func (p *parser) skipToClose() {
+	depth := 0
+	for {
+		tok := p.peek()
+		if tok.Kind == TokenEOF {
+			return
+		}
+		if tok.Kind == TokenPunct {
+			if tok.Value == "(" {
+				depth++
+			} else if tok.Value == ")" {
+				if depth == 0 {
+					return
+				}
+				depth--
+			}
+		}
+		p.next()
+	}
+}
+
+// skipStatement advances to the ';' ending the current statement
+func (p *parser) skipStatement() {
+	depth := 0
+	for {
+		tok := p.peek()
+		if tok.Kind == TokenEOF {
+			return
+		}
+		if tok.Kind == TokenPunct {
+			switch tok.Value {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			case ";":
+				if depth <= 0 {
+					return
+				}
+			}
+		}
+		p.next()
+	}
+}
+
+func mergeExprs(dst *Expr, src []*Expr) {
+	for _, e := range src {
+		dst.Columns = append(dst.Columns, e.Columns...)
+		dst.Funcs = append(dst.Funcs, e.Funcs...)
+		dst.Subqueries = append(dst.Subqueries, e.Subqueries...)
+	}
+}
+
+// leadingKeyword returns the first word of the statement starting at i,
+// looking through any opening parentheses
+func (p *parser) leadingKeyword(i int) string {
+	for p.tokens[i].Kind == TokenPunct && p.tokens[i].Value == "(" {
+		i++
+	}
+	tok := p.tokens[i]
+	if tok.Kind == TokenIdent && !tok.Quoted {
+		return tok.Value
+	}
+	return tok.Value
+}
+
+func (p *parser) isQueryStartAt(i int) bool {
+	for p.tokens[i].Kind == TokenPunct && p.tokens[i].Value == "(" {
+		i++
+	}
+	return p.isKeywordAt(i, "select", "with", "values", "table")
+}
+
+func (p *parser) isClauseStopAt(i int) bool {
+	tok := p.tokens[i]
+	if tok.Kind != TokenIdent || tok.Quoted || !clauseKeywords[tok.Value] {
+		return false
+	}
+	// left(...), right(...) and row(...) are function-like
+	if (tok.Value == "left" || tok.Value == "right" || tok.Value == "row") && p.isPunctAt(i+1, "(") {
+		return false
+	}
+	return true
+}
+
+// isAliasAt reports whether token i can be an alias without AS
+func (p *parser) isAliasAt(i int) bool {
+	tok := p.tokens[i]
+	if tok.Kind != TokenIdent {
+		return false
+	}
+	return tok.Quoted || !keywords[tok.Value]
+}
+
+func (p *parser) atSelectListEnd() bool {
+	tok := p.peek()
+	if tok.Kind == TokenEOF {
+		return true
+	}
+	if tok.Kind == TokenPunct && (tok.Value == ";" || tok.Value == ")") {
+		return true
+	}
+	return tok.Kind == TokenIdent && !tok.Quoted && selectEndKeywords[tok.Value]
+}
+
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.errorf(p.peek(), "query is nested too deeply")
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(offset int) Token {
+	i := p.pos + offset
+	if i >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[i]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) prevEnd() int {
+	if p.pos == 0 {
+		return 0
+	}
+	return p.tokens[p.pos-1].End
+}
+
+func (p *parser) isKeyword(words ...string) bool {
+	return p.isKeywordAt(p.pos, words...)
+}
+
+func (p *parser) isKeywordAt(i int, words ...string) bool {
+	if i >= len(p.tokens) {
+		return false
+	}
+	tok := p.tokens[i]
+	if tok.Kind != TokenIdent || tok.Quoted {
+		return false
+	}
+	for _, w := range words {
+		if tok.Value == w {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) isPunct(value string) bool {
+	return p.isPunctAt(p.pos, value)
+}
+
+func (p *parser) isPunctAt(i int, value string) bool {
+	if i >= len(p.tokens) {
+		return false
+	}
+	return p.tokens[i].Kind == TokenPunct && p.tokens[i].Value == value
+}
+
+func (p *parser) isOperator(value string) bool {
+	tok := p.peek()
+	return tok.Kind == TokenOperator && tok.Value == value
+}
+
+func (p *parser) expectKeyword(words ...string) error {
+	if !p.isKeyword(words...) {
+		return p.errorf(p.peek(), "expected %s", strings.ToUpper(strings.Join(words, " or ")))
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expectPunct(value string) error {
+	if !p.isPunct(value) {
+		return p.errorf(p.peek(), "expected '%s'", value)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expectName() (string, error) {
+	tok := p.peek()
+	if tok.Kind != TokenIdent {
+		return "", p.errorf(tok, "expected name")
+	}
+	p.next()
+	return tok.Value, nil
+}
+
+func (p *parser) unexpected(tok Token) error {
+	if tok.Kind == TokenEOF {
+		return p.errorf(tok, "unexpected end of input")
+	}
+	return p.errorf(tok, "unexpected %q", p.sql[tok.Pos:tok.End])
+}
+
+func (p *parser) errorf(tok Token, format string, args ...interface{}) error {
+	return &ParseError{Pos: tok.Pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func toSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
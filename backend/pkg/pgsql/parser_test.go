@@ -0,0 +1,320 @@
+package pgsql
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"identifiers are lowercased", "SELECT Amount FROM T", []string{"select", "amount", "from", "t"}},
+		{"quoted identifier keeps case", `SELECT "Amount ""x""" FROM t`, []string{"select", `Amount "x"`, "from", "t"}},
+		{"line comment", "SELECT 1 -- ; DROP TABLE t\n", []string{"select", "1"}},
+		{"nested block comment", "SELECT /* a /* ; */ b */ 1", []string{"select", "1"}},
+		{"string with quote", "SELECT 'it''s; fine'", []string{"select", "'it''s; fine'"}},
+		{"escape string", `SELECT E'a\'; b'`, []string{"select", `E'a\'; b'`}},
+		{"dollar quoted", "SELECT $$ ; DROP $$", []string{"select", "$$ ; DROP $$"}},
+		{"tagged dollar quote", "SELECT $fn$ $$ ; $fn$", []string{"select", "$fn$ $$ ; $fn$"}},
+		{"parameter", "SELECT $1", []string{"select", "$1"}},
+		{"cast operator", "SELECT a::text", []string{"select", "a", "::", "text"}},
+		{"non-decimal integers", "SELECT 0x1F, 0o17, 0b1010", []string{"select", "0x1F", ",", "0o17", ",", "0b1010"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := Tokenize(tt.sql)
+			if err != nil {
+				t.Fatalf("Tokenize(%q) error: %v", tt.sql, err)
+			}
+			var got []string
+			for _, tok := range tokens {
+				if tok.Kind != TokenEOF {
+					got = append(got, tok.Value)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	for _, sql := range []string{
+		"SELECT 'unterminated",
+		`SELECT "unterminated`,
+		"SELECT /* unterminated",
+		"SELECT $$ unterminated",
+	} {
+		_, err := Tokenize(sql)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Tokenize(%q) error = %v, want *ParseError", sql, err)
+		}
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	tests := []struct {
+		sql      string
+		keywords []string
+		queries  []bool
+	}{
+		{"SELECT 1", []string{"select"}, []bool{true}},
+		{"SELECT 1;", []string{"select"}, []bool{true}},
+		{"SELECT 1; SELECT 2", []string{"select", "select"}, []bool{true, true}},
+		{"SELECT 1; DROP TABLE users", []string{"select", "drop"}, []bool{true, false}},
+		{"SELECT ';' AS a; -- ;", []string{"select"}, []bool{true}},
+		{"SELECT $$;$$", []string{"select"}, []bool{true}},
+		{"COPY transactions TO '/tmp/out'", []string{"copy"}, []bool{false}},
+		{"COPY (SELECT 1) TO PROGRAM 'sh'", []string{"copy"}, []bool{false}},
+		{"DELETE FROM transactions", []string{"delete"}, []bool{false}},
+		{"WITH d AS (SELECT 1) DELETE FROM transactions", []string{"delete"}, []bool{false}},
+		{"WITH d AS (SELECT 1) SELECT * FROM d", []string{"with"}, []bool{true}},
+		{"VALUES (1), (2)", []string{"values"}, []bool{true}},
+		{"TABLE transactions", []string{"table"}, []bool{true}},
+		{"(SELECT 1) UNION (SELECT 2)", []string{"select"}, []bool{true}},
+	}
+
+	for _, tt := range tests {
+		statements, err := Parse(tt.sql)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.sql, err)
+			continue
+		}
+		var keywords []string
+		var queries []bool
+		for _, stmt := range statements {
+			keywords = append(keywords, stmt.Keyword)
+			queries = append(queries, stmt.Query != nil)
+		}
+		if !reflect.DeepEqual(keywords, tt.keywords) || !reflect.DeepEqual(queries, tt.queries) {
+			t.Errorf("Parse(%q) = %v %v, want %v %v", tt.sql, keywords, queries, tt.keywords, tt.queries)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, sql := range []string{
+		"SELECT (1",
+		"SELECT 1)",
+		"SELECT * FROM",
+		"SELECT " + strings.Repeat("(", 1000) + "1" + strings.Repeat(")", 1000),
+	} {
+		_, err := Parse(sql)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Parse(%q) error = %v, want *ParseError", sql, err)
+		}
+	}
+}
+
+func TestParseAcceptsValidSQL(t *testing.T) {
+	for _, sql := range []string{
+		"SELECT * FROM ROWS FROM (generate_series(1, 3), unnest(ARRAY[1, 2])) WITH ORDINALITY AS t(a, b, n)",
+		"SELECT * FROM ROWS FROM (json_to_record('{}') AS (a int, b text)) r",
+		"SELECT 1 OPERATOR(pg_catalog.+) 2",
+		"SELECT OPERATOR(pg_catalog.-) amount FROM transactions",
+		"SELECT amount FROM transactions ORDER BY amount USING <, id USING OPERATOR(pg_catalog.>) NULLS FIRST",
+		"SELECT x.* FROM docs, XMLTABLE('/rows/row' PASSING BY VALUE docs.body COLUMNS id int PATH '@id', name text DEFAULT 'n/a' NOT NULL, n FOR ORDINALITY) AS x",
+		"SELECT * FROM XMLTABLE(XMLNAMESPACES('http://example.com' AS e), '/e:rows' PASSING '<rows/>' COLUMNS a text)",
+		"SELECT 0x1F + 1",
+		"SELECT amount FROM transactions t TABLESAMPLE SYSTEM (10) REPEATABLE (42)",
+		"SELECT amount FROM transactions AS t TABLESAMPLE BERNOULLI (5) WHERE amount > 0",
+	} {
+		parseQuery(t, sql)
+	}
+}
+
+// parseQuery parses a single query statement
+func parseQuery(t *testing.T, sql string) *Query {
+	t.Helper()
+	statements, err := Parse(sql)
+	if err != nil {
+		t.Fatalf("Parse(%q) error: %v", sql, err)
+	}
+	if len(statements) != 1 || statements[0].Query == nil {
+		t.Fatalf("Parse(%q) did not return a single query", sql)
+	}
+	return statements[0].Query
+}
+
+func TestParseClauses(t *testing.T) {
+	t.Run("select into", func(t *testing.T) {
+		q := parseQuery(t, "SELECT * INTO stolen FROM transactions")
+		if into := q.Selects[0].Into; into == nil || into.Name != "stolen" {
+			t.Errorf("Into = %+v, want stolen", into)
+		}
+	})
+
+	t.Run("locking", func(t *testing.T) {
+		for _, sql := range []string{
+			"SELECT * FROM transactions FOR UPDATE",
+			"SELECT * FROM transactions FOR NO KEY UPDATE NOWAIT",
+			"SELECT * FROM transactions FOR SHARE SKIP LOCKED",
+			"SELECT * FROM transactions LIMIT 1 FOR KEY SHARE OF transactions",
+		} {
+			if q := parseQuery(t, sql); len(q.Locking) == 0 {
+				t.Errorf("Parse(%q) Locking is empty", sql)
+			}
+		}
+	})
+
+	t.Run("data-modifying cte", func(t *testing.T) {
+		for sql, kind := range map[string]string{
+			"WITH d AS (DELETE FROM transactions RETURNING *) SELECT * FROM d":        "delete",
+			"WITH u AS (UPDATE users SET role_id = 1 RETURNING id) SELECT * FROM u":   "update",
+			"WITH i AS (INSERT INTO users (email) VALUES ('x') RETURNING id) TABLE i": "insert",
+		} {
+			q := parseQuery(t, sql)
+			if len(q.With) != 1 || q.With[0].Kind != kind || q.With[0].Query != nil {
+				t.Errorf("Parse(%q) CTE = %+v, want a %s body", sql, q.With[0], kind)
+			}
+		}
+	})
+
+	t.Run("joins", func(t *testing.T) {
+		q := parseQuery(t, "SELECT * FROM a NATURAL LEFT JOIN b JOIN c USING (id) CROSS JOIN d")
+		var joins []string
+		for _, item := range q.Selects[0].From {
+			joins = append(joins, item.JoinType)
+		}
+		if want := []string{"", "natural left", "inner", "cross"}; !reflect.DeepEqual(joins, want) {
+			t.Errorf("join types = %q, want %q", joins, want)
+		}
+		if using := q.Selects[0].From[2].Using; !reflect.DeepEqual(using, []string{"id"}) {
+			t.Errorf("Using = %q, want [id]", using)
+		}
+	})
+}
+
+// collect returns the function, relation and column names found in sql
+func collect(t *testing.T, sql string) (funcs, relations, columns []string) {
+	t.Helper()
+	Inspect(parseQuery(t, sql), func(node Node) bool {
+		switch n := node.(type) {
+		case *FuncCall:
+			funcs = append(funcs, qualified(n.Schema, n.Name))
+		case *RelationRef:
+			relations = append(relations, qualified(n.Schema, n.Name))
+		case *ColumnRef:
+			columns = append(columns, qualified(n.Qualifier, n.Name))
+		}
+		return true
+	})
+	return funcs, relations, columns
+}
+
+func qualified(qualifier, name string) string {
+	if qualifier == "" {
+		return name
+	}
+	return qualifier + "." + name
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInspectFindsFunctions(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT pg_sleep(10)", "pg_sleep"},
+		{"SELECT PG_SLEEP(10)", "pg_sleep"},
+		{`SELECT "pg_sleep"(10)`, "pg_sleep"},
+		{"SELECT pg_catalog.pg_sleep(10)", "pg_catalog.pg_sleep"},
+		{"SELECT 1 WHERE pg_sleep(10) IS NOT NULL", "pg_sleep"},
+		{"SELECT sum(pg_sleep(1)) FROM transactions", "pg_sleep"},
+		{"SELECT * FROM transactions WHERE id IN (SELECT pg_sleep(1))", "pg_sleep"},
+		{"SELECT CASE WHEN true THEN set_config('role', 'admin', false) END", "set_config"},
+		{"SELECT * FROM generate_series(1, 2), pg_read_file('/etc/passwd')", "pg_read_file"},
+		{"WITH s AS (SELECT set_config('a', 'b', false)) SELECT * FROM s", "set_config"},
+		{"SELECT amount FROM transactions ORDER BY pg_sleep(1)", "pg_sleep"},
+		{"SELECT amount FROM transactions LIMIT (SELECT pg_sleep(1))::int", "pg_sleep"},
+		{"SELECT amount FROM transactions TABLESAMPLE SYSTEM ((SELECT 100 FROM pg_sleep(20)))", "pg_sleep"},
+		{"SELECT amount FROM transactions t TABLESAMPLE SYSTEM (10) REPEATABLE (pg_backend_pid())", "pg_backend_pid"},
+		{"SELECT * FROM ROWS FROM (generate_series(1, 2), pg_ls_dir('/'))", "pg_ls_dir"},
+		{"SELECT * FROM XMLTABLE('/r' PASSING '<r/>' COLUMNS a text PATH pg_read_file('/etc/passwd'))", "pg_read_file"},
+		{"SELECT 1 OPERATOR(pg_catalog.+) pg_sleep(1)", "pg_sleep"},
+		{"SELECT amount FROM transactions ORDER BY amount USING <, pg_sleep(1)", "pg_sleep"},
+	}
+
+	for _, tt := range tests {
+		funcs, _, _ := collect(t, tt.sql)
+		if !contains(funcs, tt.want) {
+			t.Errorf("Inspect(%q) functions = %q, want %s", tt.sql, funcs, tt.want)
+		}
+	}
+}
+
+func TestInspectFindsRelations(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM pg_catalog.pg_authid", "pg_catalog.pg_authid"},
+		{"SELECT * FROM pg_shadow", "pg_shadow"},
+		{`SELECT * FROM "information_schema"."tables"`, "information_schema.tables"},
+		{"SELECT * FROM transactions t JOIN users u ON u.id = t.id", "users"},
+		{"SELECT (SELECT count(*) FROM users)", "users"},
+		{"SELECT * FROM transactions WHERE EXISTS (SELECT 1 FROM users)", "users"},
+		{"SELECT * FROM (SELECT * FROM users) AS u", "users"},
+		{"SELECT 1 UNION SELECT id FROM users", "users"},
+		{"WITH RECURSIVE r AS (SELECT 1 UNION ALL SELECT 1 FROM users) SELECT * FROM r", "users"},
+		{"TABLE users", "users"},
+		{"SELECT amount FROM transactions TABLESAMPLE SYSTEM ((SELECT 100 FROM pg_catalog.pg_authid))", "pg_catalog.pg_authid"},
+		{"SELECT amount FROM transactions TABLESAMPLE SYSTEM (10) REPEATABLE ((SELECT length(password_hash) FROM users LIMIT 1))", "users"},
+	}
+
+	for _, tt := range tests {
+		_, relations, _ := collect(t, tt.sql)
+		if !contains(relations, tt.want) {
+			t.Errorf("Inspect(%q) relations = %q, want %s", tt.sql, relations, tt.want)
+		}
+	}
+}
+
+func TestInspectFindsColumns(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT card_number FROM transactions", "card_number"},
+		{"SELECT t.card_number FROM transactions t", "t.card_number"},
+		{"SELECT 1 FROM transactions WHERE card_number LIKE '4%'", "card_number"},
+		{"SELECT count(DISTINCT card_number) FROM transactions", "card_number"},
+		{"SELECT 1 FROM transactions GROUP BY card_number HAVING count(*) > 1", "card_number"},
+		{"SELECT amount FROM transactions ORDER BY card_number", "card_number"},
+		{"SELECT card_number::text FROM transactions", "card_number"},
+		{"SELECT row_number() OVER (PARTITION BY card_number) FROM transactions", "card_number"},
+		{"SELECT amount FROM transactions ORDER BY card_number USING <", "card_number"},
+		{"SELECT x.a FROM t, XMLTABLE('/r' PASSING t.card_number COLUMNS a text)", "t.card_number"},
+	}
+
+	for _, tt := range tests {
+		_, _, columns := collect(t, tt.sql)
+		if !contains(columns, tt.want) {
+			t.Errorf("Inspect(%q) columns = %q, want %s", tt.sql, columns, tt.want)
+		}
+	}
+}
+
+func TestInspectSkipsXMLTableColumnDefinitions(t *testing.T) {
+	_, _, columns := collect(t, "SELECT x.id FROM docs, XMLTABLE('/rows/row' PASSING docs.body COLUMNS id int PATH '@id', n FOR ORDINALITY) AS x")
+	if want := []string{"x.id", "docs.body"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns = %q, want %q", columns, want)
+	}
+}
@@ -10,9 +10,10 @@ import {
   TableHeader,
   TableRow,
 } from "@/components/ui/table";
-import { BarChart, Bar, XAxis, YAxis, CartesianGrid, Tooltip, ResponsiveContainer, LineChart, Line, PieChart, Pie, Cell } from "recharts";
+import { BarChart, Bar, XAxis, YAxis, CartesianGrid, Tooltip, ResponsiveContainer, LineChart, Line, PieChart, Pie, Cell, ScatterChart, Scatter, Legend } from "recharts";
 import { useState } from "react";
 import { Dialog, DialogContent, DialogHeader, DialogTitle } from "@/components/ui/dialog";
+import type { ChartSpec, ResultSet } from "@/lib/api";
 
 interface ResultsViewerProps {
   results: {
@@ -22,9 +23,115 @@ interface ResultsViewerProps {
     result_data?: string;
     result_format?: "text" | "table" | "chart" | "error" | null;
     error_message?: string | null;
+    chart_spec?: string;
   };
 }
 
+// toRowObjects turns a ResultSet into row objects keyed by column name.
+// Decimals become numbers for display and charts; messages saved before the
+// typed envelope already hold row objects.
+const toRowObjects = (data: any): any[] => {
+  if (!data || Array.isArray(data) || !Array.isArray(data.columns)) {
+    return data || [];
+  }
+  const resultSet = data as ResultSet;
+  return resultSet.rows.map((row) => {
+    const obj: Record<string, unknown> = {};
+    resultSet.columns.forEach((column, i) => {
+      const value = row[i];
+      obj[column.name] =
+        column.kind === "decimal" && typeof value === "string" ? Number(value) : value;
+    });
+    return obj;
+  });
+};
+
+const seriesColor = (idx: number) => `hsl(${idx * 60}, 70%, 50%)`;
+
+// chartData applies the fold transform of a spec and, when color splits a
+// line or bar chart into series, pivots the rows to one key per series
+const chartData = (spec: ChartSpec, rows: any[]) => {
+  let data = rows;
+  for (const { fold, as } of spec.transform || []) {
+    data = data.flatMap((row) => fold.map((field) => ({ ...row, [as[0]]: field, [as[1]]: row[field] })));
+  }
+
+  const { x, y, color } = spec.encoding;
+  if (!x || !y || !color || spec.mark === "scatter") {
+    return { data, series: y ? [y.field] : [] };
+  }
+
+  const series: string[] = [];
+  const byX = new Map<string, Record<string, unknown>>();
+  for (const row of data) {
+    const key = String(row[color.field]);
+    if (!series.includes(key)) series.push(key);
+    const point = byX.get(String(row[x.field])) || { [x.field]: row[x.field] };
+    point[key] = row[y.field];
+    byX.set(String(row[x.field]), point);
+  }
+  return { data: Array.from(byX.values()), series };
+};
+
+const SpecChart = ({ spec, rows }: { spec: ChartSpec; rows: any[] }) => {
+  const { x, y, color, theta } = spec.encoding;
+  const { data, series } = chartData(spec, rows);
+
+  if (spec.mark === "pie" && theta && color) {
+    return (
+      <PieChart>
+        <Pie data={data} dataKey={theta.field} nameKey={color.field} label>
+          {data.map((_, idx) => (
+            <Cell key={idx} fill={seriesColor(idx)} />
+          ))}
+        </Pie>
+        <Tooltip />
+        <Legend />
+      </PieChart>
+    );
+  }
+
+  if (spec.mark === "scatter" && x && y) {
+    return (
+      <ScatterChart>
+        <CartesianGrid strokeDasharray="3 3" />
+        <XAxis type="number" dataKey={x.field} name={x.field} />
+        <YAxis type="number" dataKey={y.field} name={y.field} />
+        <Tooltip />
+        <Scatter data={data} fill={seriesColor(0)} />
+      </ScatterChart>
+    );
+  }
+
+  if (spec.mark === "line") {
+    return (
+      <LineChart data={data}>
+        <CartesianGrid strokeDasharray="3 3" />
+        <XAxis dataKey={x?.field} />
+        <YAxis />
+        <Tooltip />
+        {series.length > 1 && <Legend />}
+        {series.map((key, idx) => (
+          <Line key={key} type="monotone" dataKey={key} stroke={seriesColor(idx)} dot={false} />
+        ))}
+      </LineChart>
+    );
+  }
+
+  return (
+    <BarChart data={data}>
+      <CartesianGrid strokeDasharray="3 3" />
+      <XAxis dataKey={x?.field} />
+      <YAxis />
+      <Tooltip />
+      {series.length > 1 && <Legend />}
+      {series.map((key, idx) => (
+        <Bar key={key} dataKey={key} fill={seriesColor(idx)} />
+      ))}
+    </BarChart>
+  );
+};
+
 const ResultsViewer = ({ results }: ResultsViewerProps) => {
   const [showSQL, setShowSQL] = useState(false);
   
@@ -34,7 +141,7 @@ const ResultsViewer = ({ results }: ResultsViewerProps) => {
   
   if (results.result_data) {
     try {
-      parsedData = JSON.parse(results.result_data);
+      parsedData = toRowObjects(JSON.parse(results.result_data));
     } catch (e) {
       // If parsing fails, try to use data directly
       parsedData = results.data || [];
@@ -98,8 +205,19 @@ const ResultsViewer = ({ results }: ResultsViewerProps) => {
   // Get column names from first row
   const columns = parsedData.length > 0 ? Object.keys(parsedData[0]) : [];
 
+  // Use the backend's chart recommendation, falling back to a bar chart of
+  // the numeric columns
+  let chartSpec: ChartSpec | null = null;
+  if (results.chart_spec) {
+    try {
+      chartSpec = JSON.parse(results.chart_spec);
+    } catch (e) {
+      chartSpec = null;
+    }
+  }
+
   // Determine if we should show chart (if we have numeric data)
-  const hasNumericData = parsedData.some((row) =>
+  const hasNumericData = chartSpec !== null || parsedData.some((row) =>
     columns.some((col) => typeof row[col] === "number")
   );
 
@@ -136,7 +254,7 @@ const ResultsViewer = ({ results }: ResultsViewerProps) => {
         </div>
       </div>
 
-      <Tabs defaultValue="table" className="w-full">
+      <Tabs defaultValue={format === "chart" && chartSpec ? "chart" : "table"} className="w-full">
         <TabsList>
           <TabsTrigger value="table">Table</TabsTrigger>
             {hasNumericData && <TabsTrigger value="chart">Chart</TabsTrigger>}
@@ -172,6 +290,7 @@ const ResultsViewer = ({ results }: ResultsViewerProps) => {
           {hasNumericData && (
         <TabsContent value="chart" className="mt-4">
           <ResponsiveContainer width="100%" height={300}>
+                {chartSpec ? <SpecChart spec={chartSpec} rows={parsedData} /> : (
                 <BarChart data={parsedData}>
               <CartesianGrid strokeDasharray="3 3" />
                   <XAxis dataKey={columns[0]} />
@@ -185,6 +304,7 @@ const ResultsViewer = ({ results }: ResultsViewerProps) => {
                     return null;
                   })}
             </BarChart>
+                )}
           </ResponsiveContainer>
         </TabsContent>
           )}
@@ -1,11 +1,14 @@
 import { createContext, useContext, useState, useEffect, ReactNode } from 'react';
 import { useNavigate } from 'react-router-dom';
-import { api, User, LoginResponse } from '@/lib/api';
+import { api, User, LoginResponse, MFAChallengeResponse } from '@/lib/api';
 
 interface AuthContextType {
   user: User | null;
   loading: boolean;
-  login: (email: string, password: string) => Promise<void>;
+  // Resolves to the MFA challenge when the user must pass MFA
+  login: (email: string, password: string) => Promise<MFAChallengeResponse | null>;
+  // Resolves to the recovery codes when the login enrolled the user in MFA
+  completeMFALogin: (mfaToken: string, code: string) => Promise<string[] | undefined>;
   register: (email: string, password: string, fullName: string) => Promise<void>;
   logout: () => void;
   refreshAccessToken: () => Promise<void>;
@@ -54,13 +57,27 @@ export const AuthProvider = ({ children }: AuthProviderProps) => {
     }
   };
 
-  const login = async (email: string, password: string) => {
-    const response: LoginResponse = await api.login({ email, password });
+  const startSession = (response: LoginResponse) => {
     localStorage.setItem('access_token', response.access_token);
     localStorage.setItem('refresh_token', response.refresh_token);
     setUser(response.user);
   };
 
+  const login = async (email: string, password: string) => {
+    const response = await api.login({ email, password });
+    if ('mfa_required' in response) {
+      return response;
+    }
+    startSession(response);
+    return null;
+  };
+
+  const completeMFALogin = async (mfaToken: string, code: string) => {
+    const response = await api.loginMFA(mfaToken, code);
+    startSession(response);
+    return response.recovery_codes;
+  };
+
   const register = async (email: string, password: string, fullName: string) => {
     const response = await api.register({ email, password, full_name: fullName });
     // After registration, automatically log in
@@ -68,6 +85,11 @@ export const AuthProvider = ({ children }: AuthProviderProps) => {
   };
 
   const logout = () => {
+    const refreshToken = localStorage.getItem('refresh_token');
+    if (refreshToken) {
+      // Revoke the session server-side; local state is cleared regardless
+      api.logout(refreshToken).catch(() => {});
+    }
     localStorage.removeItem('access_token');
     localStorage.removeItem('refresh_token');
     setUser(null);
@@ -83,6 +105,7 @@ export const AuthProvider = ({ children }: AuthProviderProps) => {
     try {
       const response = await api.refreshToken(refreshToken);
       localStorage.setItem('access_token', response.access_token);
+      localStorage.setItem('refresh_token', response.refresh_token);
     } catch (error) {
       // Refresh failed, logout user
       logout();
@@ -96,6 +119,7 @@ export const AuthProvider = ({ children }: AuthProviderProps) => {
         user,
         loading,
         login,
+        completeMFALogin,
         register,
         logout,
         refreshAccessToken,
@@ -31,6 +31,19 @@ export interface LoginResponse {
   };
 }
 
+// Returned by login instead of tokens when the user must pass MFA
+export interface MFAChallengeResponse {
+  message: string;
+  mfa_required: true;
+  mfa_token: string;
+  mfa_enrollment_required: boolean;
+}
+
+export interface MFAEnrollment {
+  secret: string;
+  provisioning_uri: string;
+}
+
 export interface User {
   id: number;
   email: string;
@@ -43,6 +56,17 @@ export interface User {
   is_active: boolean;
 }
 
+export interface Session {
+  id: string;
+  device: string;
+  ip_address?: string;
+  user_agent?: string;
+  created_at: string;
+  last_seen_at: string;
+  expires_at: string;
+  current: boolean;
+}
+
 export interface Conversation {
   id: number;
   user_id: number;
@@ -62,11 +86,47 @@ export interface Message {
   result_data: string | null;
   result_format: 'text' | 'table' | 'chart' | 'error' | null;
   error_message: string | null;
+  chart_spec: string | null;
   analysis: string | null;
   execution_time_ms: number | null;
   created_at: string;
 }
 
+// result_data holds a ResultSet: ordered columns with their PostgreSQL type
+// and the rows as value arrays. Decimal values are exact strings.
+export interface ResultColumn {
+  name: string;
+  type: string;
+  kind: 'integer' | 'decimal' | 'float' | 'boolean' | 'date' | 'timestamp' | 'json' | 'binary' | 'text';
+  precision?: number;
+  scale?: number;
+  nullable: boolean;
+}
+
+export interface ResultSet {
+  columns: ResultColumn[];
+  rows: unknown[][];
+}
+
+// chart_spec holds a ChartSpec: a Vega-Lite style recommendation whose
+// fields refer to result columns, or to the "as" fields of a fold transform
+export interface ChartChannel {
+  field: string;
+  type: 'temporal' | 'quantitative' | 'nominal';
+}
+
+export interface ChartSpec {
+  mark: 'line' | 'bar' | 'pie' | 'scatter';
+  transform?: { fold: string[]; as: [string, string] }[];
+  encoding: {
+    x?: ChartChannel;
+    y?: ChartChannel;
+    color?: ChartChannel;
+    theta?: ChartChannel;
+  };
+  reason?: string;
+}
+
 export interface QueryRequest {
   query: string;
   conversation_id?: number;
@@ -118,20 +178,58 @@ class ApiClient {
     });
   }
 
-  async login(data: LoginRequest): Promise<LoginResponse> {
+  async login(data: LoginRequest): Promise<LoginResponse | MFAChallengeResponse> {
     return this.request('/auth/login', {
       method: 'POST',
       body: JSON.stringify(data),
     });
   }
 
-  async refreshToken(refreshToken: string): Promise<{ access_token: string }> {
+  async loginMFA(mfaToken: string, code: string): Promise<LoginResponse & { recovery_codes?: string[] }> {
+    return this.request('/auth/login/mfa', {
+      method: 'POST',
+      body: JSON.stringify({ mfa_token: mfaToken, code }),
+    });
+  }
+
+  async loginMFAEnroll(mfaToken: string): Promise<MFAEnrollment> {
+    return this.request('/auth/login/mfa/enroll', {
+      method: 'POST',
+      body: JSON.stringify({ mfa_token: mfaToken }),
+    });
+  }
+
+  // Refresh tokens are single-use: store the returned refresh_token
+  async refreshToken(refreshToken: string): Promise<{ access_token: string; refresh_token: string }> {
     return this.request('/auth/refresh', {
       method: 'POST',
       body: JSON.stringify({ refresh_token: refreshToken }),
     });
   }
 
+  async logout(refreshToken: string): Promise<{ message: string }> {
+    return this.request('/auth/logout', {
+      method: 'POST',
+      body: JSON.stringify({ refresh_token: refreshToken }),
+    });
+  }
+
+  async logoutAll(): Promise<{ message: string }> {
+    return this.request('/auth/logout-all', {
+      method: 'POST',
+    });
+  }
+
+  async getSessions(): Promise<{ sessions: Session[] }> {
+    return this.request('/auth/sessions');
+  }
+
+  async terminateSession(id: string): Promise<{ message: string }> {
+    return this.request(`/auth/sessions/${id}`, {
+      method: 'DELETE',
+    });
+  }
+
   async getProfile(): Promise<{ user: User }> {
     return this.request('/auth/profile');
   }
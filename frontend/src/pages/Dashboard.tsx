@@ -33,6 +33,7 @@ interface DisplayMessage {
     result_data?: string;
     result_format?: string | null;
     error_message?: string | null;
+    chart_spec?: string;
   };
 }
 
@@ -73,6 +74,7 @@ const Dashboard = () => {
             result_data: msg.result_data || undefined,
             result_format: msg.result_format || undefined,
             error_message: msg.error_message || undefined,
+            chart_spec: msg.chart_spec || undefined,
           },
         }));
 
@@ -7,28 +7,41 @@ import { useState, useEffect } from "react";
 import { MessageSquare } from "lucide-react";
 import { useToast } from "@/hooks/use-toast";
 import { useAuth } from "@/contexts/AuthContext";
+import { api, MFAChallengeResponse, MFAEnrollment } from "@/lib/api";
 
 const Login = () => {
   const navigate = useNavigate();
   const { toast } = useToast();
-  const { login, user } = useAuth();
+  const { login, completeMFALogin, user } = useAuth();
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
   const [loading, setLoading] = useState(false);
+  // Second step of a login for users who must pass MFA
+  const [challenge, setChallenge] = useState<MFAChallengeResponse | null>(null);
+  const [enrollment, setEnrollment] = useState<MFAEnrollment | null>(null);
+  const [code, setCode] = useState("");
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
 
-  // Redirect if already logged in
+  // Redirect if already logged in; the MFA step redirects itself
   useEffect(() => {
-    if (user) {
+    if (user && !challenge) {
       navigate("/dashboard");
     }
-  }, [user, navigate]);
+  }, [user, challenge, navigate]);
 
   const handleLogin = async (e: React.FormEvent) => {
     e.preventDefault();
     setLoading(true);
     
     try {
-      await login(email, password);
+      const mfaChallenge = await login(email, password);
+      if (mfaChallenge) {
+        setChallenge(mfaChallenge);
+        if (mfaChallenge.mfa_enrollment_required) {
+          setEnrollment(await api.loginMFAEnroll(mfaChallenge.mfa_token));
+        }
+        return;
+      }
         toast({
           title: "Welcome back!",
           description: "Successfully logged in",
@@ -45,6 +58,112 @@ const Login = () => {
     }
   };
 
+  const handleMFA = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!challenge) return;
+    setLoading(true);
+
+    try {
+      const codes = await completeMFALogin(challenge.mfa_token, code);
+      if (codes && codes.length > 0) {
+        setRecoveryCodes(codes);
+        return;
+      }
+      navigate("/dashboard");
+    } catch (error) {
+      toast({
+        title: "Verification failed",
+        description: error instanceof Error ? error.message : "Invalid code",
+        variant: "destructive",
+      });
+    } finally {
+      setCode("");
+      setLoading(false);
+    }
+  };
+
+  if (recoveryCodes) {
+    return (
+      <div className="min-h-screen bg-gradient-to-br from-background via-background to-muted flex items-center justify-center p-6">
+        <Card className="w-full max-w-md">
+          <CardHeader className="space-y-4 text-center">
+            <CardTitle className="text-2xl">Save Your Recovery Codes</CardTitle>
+            <CardDescription>
+              Each code logs you in once if you lose your authenticator. They are not shown again.
+            </CardDescription>
+          </CardHeader>
+          <CardContent className="space-y-4">
+            <div className="grid grid-cols-2 gap-2 font-mono text-sm">
+              {recoveryCodes.map((recoveryCode) => (
+                <div key={recoveryCode} className="rounded bg-muted px-3 py-2 text-center">
+                  {recoveryCode}
+                </div>
+              ))}
+            </div>
+            <Button className="w-full" onClick={() => navigate("/dashboard")}>
+              Continue
+            </Button>
+          </CardContent>
+        </Card>
+      </div>
+    );
+  }
+
+  if (challenge) {
+    return (
+      <div className="min-h-screen bg-gradient-to-br from-background via-background to-muted flex items-center justify-center p-6">
+        <Card className="w-full max-w-md">
+          <CardHeader className="space-y-4 text-center">
+            <CardTitle className="text-2xl">Two-Factor Authentication</CardTitle>
+            <CardDescription>
+              {challenge.mfa_enrollment_required
+                ? "Your role requires two-factor authentication. Add this account to your authenticator app, then enter the code it shows."
+                : "Enter the code from your authenticator app or a recovery code"}
+            </CardDescription>
+          </CardHeader>
+          <CardContent>
+            <form onSubmit={handleMFA} className="space-y-4">
+              {enrollment && (
+                <div className="space-y-2 text-sm">
+                  <Label>Secret key</Label>
+                  <div className="rounded bg-muted px-3 py-2 font-mono break-all">{enrollment.secret}</div>
+                  <a href={enrollment.provisioning_uri} className="text-primary underline">
+                    Open in authenticator app
+                  </a>
+                </div>
+              )}
+              <div className="space-y-2">
+                <Label htmlFor="code">Code</Label>
+                <Input
+                  id="code"
+                  autoComplete="one-time-code"
+                  placeholder="123456"
+                  value={code}
+                  onChange={(e) => setCode(e.target.value)}
+                  required
+                />
+              </div>
+              <Button type="submit" className="w-full" disabled={loading}>
+                {loading ? "Verifying..." : "Verify"}
+              </Button>
+              <Button
+                type="button"
+                variant="link"
+                className="w-full"
+                onClick={() => {
+                  setChallenge(null);
+                  setEnrollment(null);
+                }}
+              >
+                Back to login
+              </Button>
+            </form>
+          </CardContent>
+        </Card>
+      </div>
+    );
+  }
+
   return (
     <div className="min-h-screen bg-gradient-to-br from-background via-background to-muted flex items-center justify-center p-6">
       <Card className="w-full max-w-md">